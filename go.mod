@@ -8,11 +8,21 @@ require github.com/go-chi/chi/v5 v5.2.1
 
 require github.com/joho/godotenv v1.5.1
 
-require modernc.org/sqlite v1.37.0
+require (
+	github.com/mochi-mqtt/server/v2 v2.7.9
+	golang.org/x/crypto v0.31.0
+	modernc.org/sqlite v1.37.0
+)
+
+require (
+	github.com/rs/xid v1.4.0 // indirect
+	go.etcd.io/bbolt v1.3.5 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
 
 require (
-	github.com/gorilla/websocket v1.5.3 // indirect
-	golang.org/x/net v0.27.0 // indirect
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/net v0.33.0
 	golang.org/x/sync v0.12.0 // indirect
 )
 
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"iot-bridge/internal/iot"
 	"iot-bridge/internal/store"
@@ -36,8 +39,20 @@ func AddDeviceFromScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := iot.Adopt(found); err != nil {
+		writeDriverError(w, req.ID, err)
+		return
+	}
+
 	device := found.ToDevice(req.Name, req.Room)
 	deviceStore := factory.GetDeviceStore()
+	// Drivers may already have registered the device with its capabilities
+	// (zigbee does once the interview completes); only name and place it.
+	if existing, ok := deviceStore.Get(req.ID); ok {
+		existing.Name = req.Name
+		existing.Room = req.Room
+		device = existing
+	}
 	if err := deviceStore.Add(device); err != nil {
 		http.Error(w, "Failed to add device", http.StatusInternalServerError)
 		return
@@ -74,9 +89,13 @@ func GetDeviceByID(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 
-	driver := iot.GetDriverFor(device)
-	if state, err := driver.GetState(device); err == nil {
-		device.State = state
+	// Devices without a running driver are returned with their stored state.
+	if driver, err := iot.GetDriverFor(device); err == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), driverTimeout)
+		defer cancel()
+		if state, err := driver.GetState(ctx, device); err == nil {
+			device.State = state
+		}
 	}
 
 	json.NewEncoder(w).Encode(device)
@@ -97,7 +116,16 @@ func PatchDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name != "" {
+	if req.Name != "" && req.Name != device.Name {
+		driver, _ := iot.GetDriverFor(device)
+		if renamer, ok := driver.(iot.DeviceRenamer); ok {
+			ctx, cancel := context.WithTimeout(r.Context(), driverTimeout)
+			defer cancel()
+			if err := renamer.RenameDevice(ctx, device, req.Name); err != nil {
+				writeDriverError(w, id, fmt.Errorf("rename device: %w", err))
+				return
+			}
+		}
 		device.Name = req.Name
 	}
 	if req.Room != "" {
@@ -115,11 +143,28 @@ func PatchDevice(w http.ResponseWriter, r *http.Request) {
 func DeleteDevice(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	store := factory.GetDeviceStore()
-	_, ok := store.Get(id)
+	device, ok := store.Get(id)
 	if !ok {
 		http.Error(w, "Device not found", http.StatusNotFound)
 		return
 	}
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid force parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	driver, _ := iot.GetDriverFor(device)
+	if remover, ok := driver.(iot.DeviceRemover); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), driverTimeout)
+		defer cancel()
+		if err := remover.RemoveDevice(ctx, device, force); err != nil {
+			writeDriverError(w, id, fmt.Errorf("remove device: %w", err))
+			return
+		}
+	}
 	if err := store.Delete(id); err != nil {
 		http.Error(w, "Failed to delete device", http.StatusInternalServerError)
 		return
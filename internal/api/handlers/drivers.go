@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"iot-bridge/internal/iot"
+)
+
+// ListDrivers returns every registered protocol driver with its health.
+func ListDrivers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(iot.Drivers())
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+type healthDriver struct{ health iot.Health }
+
+func (d *healthDriver) Start() error       { return nil }
+func (d *healthDriver) Stop() error        { return nil }
+func (d *healthDriver) Health() iot.Health { return d.health }
+func (d *healthDriver) GetState(context.Context, store.Device) (store.State, error) {
+	return store.State{}, nil
+}
+func (d *healthDriver) SetState(context.Context, store.Device, store.State) error { return nil }
+
+func init() {
+	iot.Register("test-ok", func() iot.Driver { return &healthDriver{iot.Health{Status: iot.HealthOK}} })
+	iot.Register("test-degraded", func() iot.Driver {
+		return &healthDriver{iot.Health{Status: iot.HealthDegraded, Detail: "unreachable"}}
+	})
+	iot.Register("test-off", func() iot.Driver { return &healthDriver{} })
+}
+
+func TestListDrivers(t *testing.T) {
+	iot.StartDrivers([]string{"test-ok", "test-degraded"})
+	defer iot.StopDrivers()
+
+	w := httptest.NewRecorder()
+	ListDrivers(w, httptest.NewRequest("GET", "/drivers", nil))
+	var infos []iot.DriverInfo
+	if err := json.NewDecoder(w.Body).Decode(&infos); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]iot.DriverInfo{}
+	for _, info := range infos {
+		got[info.Protocol] = info
+	}
+	if info := got["test-degraded"]; !info.Enabled || info.Health.Status != iot.HealthDegraded || info.Health.Detail != "unreachable" {
+		t.Errorf("test-degraded = %+v", info)
+	}
+	if info := got["test-off"]; info.Enabled || info.Health.Status != iot.HealthDisabled {
+		t.Errorf("test-off = %+v", info)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		drivers []string
+		want    string
+	}{
+		{"all ok", []string{"test-ok"}, "ok"},
+		{"one degraded", []string{"test-ok", "test-degraded"}, "degraded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iot.StartDrivers(tt.drivers)
+			defer iot.StopDrivers()
+
+			w := httptest.NewRecorder()
+			Health(w, httptest.NewRequest("GET", "/health", nil))
+			var body struct {
+				Status     string            `json:"status"`
+				Components map[string]string `json:"components"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != tt.want {
+				t.Errorf("status = %q, want %q", body.Status, tt.want)
+			}
+			if len(body.Components) != len(tt.drivers) {
+				t.Errorf("components = %v, want only the started drivers", body.Components)
+			}
+			for _, name := range tt.drivers {
+				if body.Components[name] == "" {
+					t.Errorf("components = %v, missing %s", body.Components, name)
+				}
+			}
+		})
+	}
+}
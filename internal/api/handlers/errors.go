@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// driverTimeout bounds a driver call made on behalf of an API request.
+const driverTimeout = 10 * time.Second
+
+// APIError is the JSON body of error responses from device endpoints.
+type APIError struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`
+	DeviceID string `json:"device_id,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// State holds the values a device reported when they didn't match.
+	State store.State `json:"state,omitempty"`
+}
+
+// statusForCode maps iot.ErrorCode codes to HTTP statuses.
+var statusForCode = map[string]int{
+	string(iot.DeviceOffline):  http.StatusServiceUnavailable,
+	string(iot.Timeout):        http.StatusGatewayTimeout,
+	string(iot.Unsupported):    http.StatusUnprocessableEntity,
+	string(iot.InvalidValue):   http.StatusBadRequest,
+	string(iot.TransportError): http.StatusBadGateway,
+	string(iot.Mismatch):       http.StatusConflict,
+	string(iot.NotFound):       http.StatusNotFound,
+	"unknown_protocol":         http.StatusNotImplemented,
+	"driver_disabled":          http.StatusServiceUnavailable,
+	"internal_error":           http.StatusInternalServerError,
+}
+
+func writeError(w http.ResponseWriter, status int, body APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeDriverError reports an error from the driver layer with the status
+// matching its code: 501 for protocols without a driver, 503 for drivers
+// that aren't enabled, and the kind's status for errors from the driver
+// itself.
+func writeDriverError(w http.ResponseWriter, deviceID string, err error) {
+	status, body := driverErrorBody(deviceID, err)
+	writeError(w, status, body)
+}
+
+func driverErrorBody(deviceID string, err error) (int, APIError) {
+	body := APIError{Error: iot.ErrorCode(err), Message: err.Error(), DeviceID: deviceID}
+	var unknown *iot.UnknownProtocolError
+	var disabled *iot.DriverDisabledError
+	switch {
+	case errors.As(err, &unknown):
+		body.Protocol = unknown.Protocol
+	case errors.As(err, &disabled):
+		body.Protocol = disabled.Protocol
+	}
+	return statusForCode[body.Error], body
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"iot-bridge/internal/iot"
+)
+
+func TestDriverErrorBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		protocol   string
+	}{
+		{"device offline", iot.Errorf(iot.DeviceOffline, "gone"), http.StatusServiceUnavailable, "device_offline", ""},
+		{"timeout", iot.Errorf(iot.Timeout, "slow"), http.StatusGatewayTimeout, "timeout", ""},
+		{"expired context", fmt.Errorf("read: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout", ""},
+		{"unsupported", iot.Errorf(iot.Unsupported, "no"), http.StatusUnprocessableEntity, "unsupported", ""},
+		{"invalid value", iot.Errorf(iot.InvalidValue, "bad"), http.StatusBadRequest, "invalid_value", ""},
+		{"transport error", iot.Errorf(iot.TransportError, "broken"), http.StatusBadGateway, "transport_error", ""},
+		{"unclassified", errors.New("connection reset"), http.StatusBadGateway, "transport_error", ""},
+		{"mismatch", iot.Errorf(iot.Mismatch, "other"), http.StatusConflict, "state_mismatch", ""},
+		{"not found", iot.Errorf(iot.NotFound, "who"), http.StatusNotFound, "not_found", ""},
+		{"unknown protocol", &iot.UnknownProtocolError{Protocol: "x10"}, http.StatusNotImplemented, "unknown_protocol", "x10"},
+		{"driver disabled", &iot.DriverDisabledError{Protocol: "hue"}, http.StatusServiceUnavailable, "driver_disabled", "hue"},
+		{"not persisted", fmt.Errorf("%w: disk full", iot.ErrPersistState), http.StatusInternalServerError, "internal_error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := driverErrorBody("lamp", tt.err)
+			if status != tt.wantStatus || body.Error != tt.wantCode {
+				t.Errorf("driverErrorBody() = %d, %q, want %d, %q", status, body.Error, tt.wantStatus, tt.wantCode)
+			}
+			if body.DeviceID != "lamp" || body.Protocol != tt.protocol || body.Message != tt.err.Error() {
+				t.Errorf("body = %+v", body)
+			}
+		})
+	}
+}
+
+func TestEveryKindHasAStatus(t *testing.T) {
+	kinds := []iot.ErrorKind{iot.DeviceOffline, iot.Timeout, iot.Unsupported, iot.InvalidValue, iot.TransportError, iot.Mismatch, iot.NotFound}
+	for _, kind := range kinds {
+		if statusForCode[string(kind)] == 0 {
+			t.Errorf("no HTTP status for %q", kind)
+		}
+	}
+}
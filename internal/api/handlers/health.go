@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"iot-bridge/internal/broker"
+	"iot-bridge/internal/iot"
+)
+
+// Health reports whether the bridge is fully connected. The API keeps serving
+// while a driver's backend is unreachable, so that is reported as "degraded"
+// rather than as a failed check.
+func Health(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	components := map[string]string{}
+	for _, d := range iot.Drivers() {
+		if !d.Enabled {
+			continue
+		}
+		components[d.Protocol] = d.Health.Status
+		if d.Health.Status == iot.HealthDegraded || d.Health.Status == iot.HealthFailed {
+			status = "degraded"
+		}
+	}
+	if brokerStatus := broker.Status(); brokerStatus != "" {
+		components["broker"] = brokerStatus
+		if brokerStatus == broker.StatusFailed {
+			status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     status,
+		"components": components,
+	})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"iot-bridge/internal/iot/matter"
+	"iot-bridge/internal/store/factory"
+)
+
+type CommissionRequest struct {
+	Code string `json:"code"`
+	Name string `json:"name,omitempty"`
+	Room string `json:"room,omitempty"`
+}
+
+// CommissionMatterDevice commissions a device from its QR or manual pairing
+// code and returns the resulting bridge device.
+func CommissionMatterDevice(w http.ResponseWriter, r *http.Request) {
+	var req CommissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Invalid request, setup code required", http.StatusBadRequest)
+		return
+	}
+
+	driver := matter.GetDriver()
+	if driver == nil {
+		http.Error(w, "Matter driver not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	device, err := driver.Commission(r.Context(), req.Code)
+	if err != nil {
+		writeDriverError(w, "", fmt.Errorf("Commissioning failed: %w", err))
+		return
+	}
+
+	if req.Name != "" || req.Room != "" {
+		if req.Name != "" {
+			device.Name = req.Name
+		}
+		if req.Room != "" {
+			device.Room = req.Room
+		}
+		if err := factory.GetDeviceStore().Add(device); err != nil {
+			http.Error(w, "Failed to save device", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(device)
+}
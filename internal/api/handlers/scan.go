@@ -2,24 +2,59 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"iot-bridge/internal/iot"
 	"iot-bridge/internal/store/factory"
 )
 
+const defaultScanSeconds = 60
+
 func StartScan(w http.ResponseWriter, r *http.Request) {
 	var req ScanRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil || len(req.Protocols) == 0 {
 		req.Protocols = []string{"zigbee", "zwave"}
 	}
+	if req.Duration <= 0 {
+		req.Duration = defaultScanSeconds
+	}
+	duration := time.Duration(req.Duration) * time.Second
+
 	factory.GetScanStore().StartScan(req.Protocols)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
+	var started []string
+	failures := map[string]string{}
+	for _, protocol := range req.Protocols {
+		if err := iot.StartScan(protocol, duration); err != nil {
+			failures[protocol] = err.Error()
+			continue
+		}
+		started = append(started, protocol)
+	}
+
+	if len(started) == 0 {
+		var msgs []string
+		for protocol, msg := range failures {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", protocol, msg))
+		}
+		http.Error(w, "No scan could be started ("+strings.Join(msgs, "; ")+")", http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]any{
 		"status":    "scanning",
-		"protocols": req.Protocols,
-	})
+		"protocols": started,
+		"duration":  req.Duration,
+	}
+	if len(failures) > 0 {
+		resp["errors"] = failures
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 func GetScanResults(w http.ResponseWriter, r *http.Request) {
@@ -30,4 +65,5 @@ func GetScanResults(w http.ResponseWriter, r *http.Request) {
 
 type ScanRequest struct {
 	Protocols []string `json:"protocols"`
+	Duration  int      `json:"duration,omitempty"` // seconds, zigbee allows at most 254
 }
@@ -12,10 +12,7 @@ func NewRouter() http.Handler {
 	r := chi.NewRouter()
 
 	// Health check
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	r.Get("/health", handlers.Health)
 
 	// Scan-related APIs
 	r.Route("/scan", func(r chi.Router) {
@@ -38,6 +35,12 @@ func NewRouter() http.Handler {
 		r.Post("/{id}/capabilities/{capability}", handlers.InvokeCapability)
 	})
 
+	// Protocol drivers and their health
+	r.Get("/drivers", handlers.ListDrivers)
+
+	// Matter commissioning
+	r.Post("/matter/commission", handlers.CommissionMatterDevice)
+
 	// LLM interaction (POST for JSON, GET for UI)
 	r.Post("/llm", handlers.HandleLLMRequest)
 	r.Get("/llm", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+
+	"iot-bridge/internal/config"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/bolt"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// Status values reported for the embedded broker.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+var (
+	mu       sync.Mutex
+	server   *mochi.Server
+	startErr error
+)
+
+// Start runs the embedded MQTT 3.1.1/5 broker when MQTT_EMBEDDED_BROKER is
+// set. It listens on MQTT_BROKER_LISTEN (TLS with MQTT_BROKER_TLS_CERT/KEY)
+// and optionally MQTT_BROKER_WS_LISTEN for websockets. Without
+// MQTT_BROKER_AUTH_FILE every client is allowed; with
+// MQTT_BROKER_PERSIST_FILE sessions and retained messages survive restarts.
+func Start() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if !config.MQTTEmbeddedBroker || server != nil {
+		return nil
+	}
+	server, startErr = start()
+	return startErr
+}
+
+// start builds and runs a broker. On failure it closes whatever was already
+// opened, such as the persistence store or a listener.
+func start() (*mochi.Server, error) {
+	s := mochi.New(&mochi.Options{
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	if err := configureServer(s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	log.Printf("[Broker] Embedded MQTT broker listening on %s", config.MQTTBrokerListen)
+	return s, nil
+}
+
+// configureServer adds the hooks and listeners to server and serves.
+func configureServer(server *mochi.Server) error {
+	if err := addAuth(server); err != nil {
+		return err
+	}
+	if config.MQTTBrokerPersistFile != "" {
+		err := server.AddHook(new(bolt.Hook), &bolt.Options{Path: config.MQTTBrokerPersistFile})
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", config.MQTTBrokerPersistFile, err)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if config.MQTTBrokerTLSCert != "" || config.MQTTBrokerTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.MQTTBrokerTLSCert, config.MQTTBrokerTLSKey)
+		if err != nil {
+			return fmt.Errorf("loading broker certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: config.MQTTBrokerListen, TLSConfig: tlsConfig})
+	if err := server.AddListener(tcp); err != nil {
+		return fmt.Errorf("listening on %s: %w", config.MQTTBrokerListen, err)
+	}
+	if config.MQTTBrokerWSListen != "" {
+		ws := listeners.NewWebsocket(listeners.Config{ID: "ws", Address: config.MQTTBrokerWSListen, TLSConfig: tlsConfig})
+		if err := server.AddListener(ws); err != nil {
+			return fmt.Errorf("listening on %s: %w", config.MQTTBrokerWSListen, err)
+		}
+	}
+
+	return server.Serve()
+}
+
+// addAuth loads the auth ledger (users, auth rules and ACLs, as JSON or YAML)
+// from MQTT_BROKER_AUTH_FILE, or allows everyone when it is not set.
+func addAuth(server *mochi.Server) error {
+	if config.MQTTBrokerAuthFile == "" {
+		return server.AddHook(new(auth.AllowHook), nil)
+	}
+	data, err := os.ReadFile(config.MQTTBrokerAuthFile)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", config.MQTTBrokerAuthFile, err)
+	}
+	if err := server.AddHook(new(auth.Hook), &auth.Options{Data: data}); err != nil {
+		return fmt.Errorf("loading %s: %w", config.MQTTBrokerAuthFile, err)
+	}
+	return nil
+}
+
+// Stop shuts the embedded broker down, closing client connections and the
+// persistence store.
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+	if server == nil {
+		return
+	}
+	if err := server.Close(); err != nil {
+		log.Printf("[Broker] Failed to stop: %v", err)
+	}
+	server = nil
+	startErr = nil
+}
+
+// Status reports the embedded broker's status for /health, or "" when it is
+// not enabled. Start errors are logged by the caller.
+func Status() string {
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case !config.MQTTEmbeddedBroker:
+		return ""
+	case startErr != nil || server == nil:
+		return StatusFailed
+	}
+	return StatusOK
+}
@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/config"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const testLedger = `{"users": {"bridge": {"password": "secret"}}}`
+
+// configure enables the broker on a free local port with the given auth and
+// persistence files, and stops it after the test.
+func configure(t *testing.T, authFile, persistFile string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	config.MQTTEmbeddedBroker = true
+	config.MQTTBrokerListen = addr
+	config.MQTTBrokerAuthFile = authFile
+	config.MQTTBrokerPersistFile = persistFile
+	t.Cleanup(func() {
+		Stop()
+		config.MQTTEmbeddedBroker = false
+		config.MQTTBrokerListen = ""
+		config.MQTTBrokerAuthFile = ""
+		config.MQTTBrokerPersistFile = ""
+	})
+	return addr
+}
+
+func connect(t *testing.T, addr, id, username, password string) (paho.Client, error) {
+	t.Helper()
+	opts := paho.NewClientOptions().AddBroker("tcp://" + addr).SetClientID(id)
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetConnectTimeout(time.Second)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(2 * time.Second) {
+		t.Fatal("connect timed out")
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client, nil
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDisabled(t *testing.T) {
+	config.MQTTEmbeddedBroker = false
+	if err := Start(); err != nil {
+		t.Fatal(err)
+	}
+	if s := Status(); s != "" {
+		t.Errorf("Status() = %q, want empty when disabled", s)
+	}
+}
+
+func TestAuthAndDelivery(t *testing.T) {
+	addr := configure(t, writeFile(t, "auth.json", testLedger), "")
+	if err := Start(); err != nil {
+		t.Fatal(err)
+	}
+	if s := Status(); s != StatusOK {
+		t.Fatalf("Status() = %q, want %q", s, StatusOK)
+	}
+
+	if _, err := connect(t, addr, "intruder", "bridge", "wrong"); err == nil {
+		t.Error("connected with a wrong password")
+	}
+
+	sub, err := connect(t, addr, "sub", "bridge", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	sub.Subscribe("devices/+/state", 1, func(_ paho.Client, msg paho.Message) {
+		got <- string(msg.Payload())
+	}).Wait()
+
+	pub, err := connect(t, addr, "pub", "bridge", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Publish("devices/plug/state", 1, false, "on").Wait()
+
+	select {
+	case payload := <-got:
+		if payload != "on" {
+			t.Errorf("payload = %q, want on", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestRetainedMessagesPersist(t *testing.T) {
+	addr := configure(t, "", filepath.Join(t.TempDir(), "broker.db"))
+	if err := Start(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := connect(t, addr, "pub", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Publish("devices/plug/state", 1, true, "off").Wait()
+	pub.Disconnect(0)
+
+	Stop()
+	if err := Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := connect(t, addr, "sub", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	sub.Subscribe("devices/plug/state", 1, func(_ paho.Client, msg paho.Message) {
+		got <- string(msg.Payload())
+	}).Wait()
+	select {
+	case payload := <-got:
+		if payload != "off" {
+			t.Errorf("retained payload = %q, want off", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("retained message lost across restart")
+	}
+}
+
+func TestStartFailureIsReported(t *testing.T) {
+	configure(t, writeFile(t, "auth.json", "not a ledger"), "")
+	if err := Start(); err == nil {
+		t.Fatal("Start succeeded with an invalid auth file")
+	}
+	if s := Status(); s != StatusFailed {
+		t.Errorf("Status() = %q, want %q", s, StatusFailed)
+	}
+}
+
+func TestFailedStartReleasesResources(t *testing.T) {
+	addr := configure(t, "", filepath.Join(t.TempDir(), "broker.db"))
+	busy, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Start(); err == nil {
+		busy.Close()
+		t.Fatal("Start succeeded on a port in use")
+	}
+	busy.Close()
+
+	// A retry has to reopen the persistence store the failed start opened.
+	done := make(chan error, 1)
+	go func() { done <- Start() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("restart blocked on the persistence store")
+	}
+	if s := Status(); s != StatusOK {
+		t.Errorf("Status() = %q, want %q", s, StatusOK)
+	}
+	if _, err := connect(t, addr, "client", "", ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,14 +2,61 @@ package config
 
 import (
 	"log"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 var DemoMode bool
 var LLMMode string
+var MQTTBroker string
+var MQTTEmbeddedBroker bool
+var MQTTBrokerListen string
+var MQTTBrokerWSListen string
+var MQTTBrokerTLSCert string
+var MQTTBrokerTLSKey string
+var MQTTBrokerAuthFile string
+var MQTTBrokerPersistFile string
+var MQTTDevicesFile string
+var MQTTUsername string
+var MQTTPassword string
+var MQTTCACert string
+var MQTTClientCert string
+var MQTTClientKey string
+var MQTTMaxReconnectInterval time.Duration
+var MQTTRecordFile string
+var MQTTReplayFile string
+var MQTTReplaySpeed float64
+var ZigbeeBaseTopic string
+var ZigbeeQoS byte
+var ZigbeeClientID string
+var HassDiscovery bool
+var HassDiscoveryPrefix string
+var HassPublish bool
+var HassBaseTopic string
+var MQTTAPI bool
+var MQTTAPIBaseTopic string
+var ZWaveJSURL string
+var MatterServerURL string
+var Drivers []string
+var ConfirmCommands bool
+var SimDevicesFile string
+var SimSeed int64
+var SimTickInterval time.Duration
+var TasmotaMQTT bool
+var TasmotaDiscoveryPrefix string
+var TasmotaDevicesFile string
+var TasmotaPollInterval time.Duration
+var ShellyDevicesFile string
+var ESPHomeDevicesFile string
+var MDNSInterface string
+var HueBridgesFile string
+var ModbusDevicesFile string
+var ModbusPollInterval time.Duration
 
 func LoadSettings() {
 	_ = godotenv.Load(".env") // ignore error if .env does not exist
@@ -28,4 +75,207 @@ func LoadSettings() {
 		LLMMode = "mock"
 	}
 	log.Printf("LLMMode = %v\n", LLMMode)
+
+	// Run an MQTT broker in-process instead of using an external one
+	MQTTEmbeddedBroker, _ = strconv.ParseBool(os.Getenv("MQTT_EMBEDDED_BROKER"))
+	MQTTBrokerListen = os.Getenv("MQTT_BROKER_LISTEN")
+	if MQTTBrokerListen == "" {
+		MQTTBrokerListen = ":1883"
+	}
+	MQTTBrokerWSListen = os.Getenv("MQTT_BROKER_WS_LISTEN") // e.g. :8083, empty disables websockets
+	MQTTBrokerTLSCert = os.Getenv("MQTT_BROKER_TLS_CERT")
+	MQTTBrokerTLSKey = os.Getenv("MQTT_BROKER_TLS_KEY")
+	MQTTBrokerAuthFile = os.Getenv("MQTT_BROKER_AUTH_FILE")
+	MQTTBrokerPersistFile = os.Getenv("MQTT_BROKER_PERSIST_FILE")
+	if MQTTEmbeddedBroker {
+		log.Printf("Embedded broker: listen = %v, websocket = %v, auth file = %v, persist file = %v\n",
+			MQTTBrokerListen, MQTTBrokerWSListen, MQTTBrokerAuthFile, MQTTBrokerPersistFile)
+	}
+
+	MQTTBroker = os.Getenv("MQTT_BROKER")
+	if MQTTBroker == "" {
+		MQTTBroker = "tcp://localhost:1883"
+		if MQTTEmbeddedBroker {
+			MQTTBroker = embeddedBrokerURL()
+		}
+	}
+	log.Printf("MQTTBroker = %v\n", MQTTBroker)
+
+	MQTTUsername = os.Getenv("MQTT_USERNAME")
+	MQTTPassword = os.Getenv("MQTT_PASSWORD")
+	log.Printf("MQTTUsername = %v\n", MQTTUsername)
+
+	// TLS: CA bundle for verifying the broker, client cert/key for mutual TLS
+	MQTTCACert = os.Getenv("MQTT_CA_CERT")
+	MQTTClientCert = os.Getenv("MQTT_CLIENT_CERT")
+	MQTTClientKey = os.Getenv("MQTT_CLIENT_KEY")
+	log.Printf("MQTTCACert = %v, MQTTClientCert = %v\n", MQTTCACert, MQTTClientCert)
+
+	MQTTMaxReconnectInterval, err = time.ParseDuration(os.Getenv("MQTT_MAX_RECONNECT_INTERVAL"))
+	if err != nil || MQTTMaxReconnectInterval <= 0 {
+		MQTTMaxReconnectInterval = time.Minute
+	}
+	log.Printf("MQTTMaxReconnectInterval = %v\n", MQTTMaxReconnectInterval)
+
+	// Record MQTT traffic to a JSONL file, or replay such a file instead of
+	// connecting to the broker; speed 1 is real time, 0 as fast as possible
+	MQTTRecordFile = os.Getenv("MQTT_RECORD_FILE")
+	MQTTReplayFile = os.Getenv("MQTT_REPLAY_FILE")
+	MQTTReplaySpeed, err = strconv.ParseFloat(os.Getenv("MQTT_REPLAY_SPEED"), 64)
+	if err != nil || MQTTReplaySpeed < 0 {
+		MQTTReplaySpeed = 1
+	}
+	log.Printf("MQTTRecordFile = %v, MQTTReplayFile = %v, MQTTReplaySpeed = %v\n", MQTTRecordFile, MQTTReplayFile, MQTTReplaySpeed)
+
+	ZigbeeBaseTopic = os.Getenv("ZIGBEE_BASE_TOPIC")
+	if ZigbeeBaseTopic == "" {
+		ZigbeeBaseTopic = "zigbee2mqtt"
+	}
+	qos, err := strconv.Atoi(os.Getenv("ZIGBEE_QOS"))
+	if err != nil || qos < 0 || qos > 2 {
+		qos = 0
+	}
+	ZigbeeQoS = byte(qos)
+	ZigbeeClientID = os.Getenv("ZIGBEE_CLIENT_ID")
+	if ZigbeeClientID == "" {
+		ZigbeeClientID = "iot-bridge-zigbee"
+	}
+	log.Printf("Zigbee base topic = %q, QoS = %d, client ID = %q\n", ZigbeeBaseTopic, ZigbeeQoS, ZigbeeClientID)
+
+	MQTTDevicesFile = os.Getenv("MQTT_DEVICES_FILE")
+	if MQTTDevicesFile == "" {
+		MQTTDevicesFile = "mqtt-devices.json"
+	}
+
+	HassDiscovery, _ = strconv.ParseBool(os.Getenv("HASS_DISCOVERY"))
+	HassDiscoveryPrefix = os.Getenv("HASS_DISCOVERY_PREFIX")
+	if HassDiscoveryPrefix == "" {
+		HassDiscoveryPrefix = "homeassistant"
+	}
+	log.Printf("HassDiscovery = %v (prefix %q)\n", HassDiscovery, HassDiscoveryPrefix)
+
+	HassPublish, _ = strconv.ParseBool(os.Getenv("HASS_PUBLISH"))
+	HassBaseTopic = os.Getenv("HASS_BASE_TOPIC")
+	if HassBaseTopic == "" {
+		HassBaseTopic = "iot-bridge/hass"
+	}
+	log.Printf("HassPublish = %v (base topic %q)\n", HassPublish, HassBaseTopic)
+
+	// Northbound MQTT API: device state and commands under MQTT_API_BASE_TOPIC
+	MQTTAPI, _ = strconv.ParseBool(os.Getenv("MQTT_API"))
+	MQTTAPIBaseTopic = os.Getenv("MQTT_API_BASE_TOPIC")
+	if MQTTAPIBaseTopic == "" {
+		MQTTAPIBaseTopic = "iot-bridge"
+	}
+	log.Printf("MQTTAPI = %v (base topic %q)\n", MQTTAPI, MQTTAPIBaseTopic)
+
+	ZWaveJSURL = os.Getenv("ZWAVE_JS_URL") // e.g. ws://localhost:3000, empty disables Z-Wave
+	log.Printf("ZWaveJSURL = %v\n", ZWaveJSURL)
+
+	MatterServerURL = os.Getenv("MATTER_SERVER_URL") // e.g. ws://localhost:5580/ws, empty disables Matter
+	log.Printf("MatterServerURL = %v\n", MatterServerURL)
+
+	// Comma-separated protocols to enable, e.g. "zigbee,mqtt"; empty enables all
+	// but the simulator
+	Drivers = nil
+	for _, name := range strings.Split(os.Getenv("DRIVERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			Drivers = append(Drivers, name)
+		}
+	}
+	// Demo mode runs every device on the simulator unless DRIVERS says otherwise
+	if DemoMode && len(Drivers) == 0 {
+		Drivers = []string{"sim"}
+	}
+	log.Printf("Drivers = %v\n", Drivers)
+
+	// Wait for devices to report applied state where the driver supports it;
+	// requests can override this with ?confirm=true|false
+	ConfirmCommands, _ = strconv.ParseBool(os.Getenv("CONFIRM_COMMANDS"))
+	log.Printf("ConfirmCommands = %v\n", ConfirmCommands)
+
+	SimDevicesFile = os.Getenv("SIM_DEVICES_FILE")
+	if SimDevicesFile == "" {
+		SimDevicesFile = "sim-devices.json"
+	}
+	// A fixed seed makes simulated latency, failures and state deterministic;
+	// 0 picks a random one
+	SimSeed, _ = strconv.ParseInt(os.Getenv("SIM_SEED"), 10, 64)
+	SimTickInterval, err = time.ParseDuration(os.Getenv("SIM_TICK_INTERVAL"))
+	if err != nil || SimTickInterval <= 0 {
+		SimTickInterval = 5 * time.Second
+	}
+	log.Printf("SimDevicesFile = %v, SimSeed = %v, SimTickInterval = %v\n", SimDevicesFile, SimSeed, SimTickInterval)
+
+	// Tasmota devices are discovered over MQTT unless TASMOTA_MQTT=false;
+	// devices listed in TASMOTA_DEVICES_FILE are controlled over HTTP
+	TasmotaMQTT = true
+	if val := os.Getenv("TASMOTA_MQTT"); val != "" {
+		TasmotaMQTT, _ = strconv.ParseBool(val)
+	}
+	TasmotaDiscoveryPrefix = os.Getenv("TASMOTA_DISCOVERY_PREFIX")
+	if TasmotaDiscoveryPrefix == "" {
+		TasmotaDiscoveryPrefix = "tasmota/discovery"
+	}
+	TasmotaDevicesFile = os.Getenv("TASMOTA_DEVICES_FILE")
+	if TasmotaDevicesFile == "" {
+		TasmotaDevicesFile = "tasmota-devices.json"
+	}
+	TasmotaPollInterval, err = time.ParseDuration(os.Getenv("TASMOTA_POLL_INTERVAL"))
+	if err != nil || TasmotaPollInterval <= 0 {
+		TasmotaPollInterval = 30 * time.Second
+	}
+	log.Printf("TasmotaMQTT = %v (prefix %q), TasmotaDevicesFile = %v, TasmotaPollInterval = %v\n", TasmotaMQTT, TasmotaDiscoveryPrefix, TasmotaDevicesFile, TasmotaPollInterval)
+
+	ShellyDevicesFile = os.Getenv("SHELLY_DEVICES_FILE")
+	if ShellyDevicesFile == "" {
+		ShellyDevicesFile = "shelly-devices.json"
+	}
+	log.Printf("ShellyDevicesFile = %v\n", ShellyDevicesFile)
+
+	ESPHomeDevicesFile = os.Getenv("ESPHOME_DEVICES_FILE")
+	if ESPHomeDevicesFile == "" {
+		ESPHomeDevicesFile = "esphome-devices.json"
+	}
+	log.Printf("ESPHomeDevicesFile = %v\n", ESPHomeDevicesFile)
+
+	// Network interface wifi scans send mDNS queries on; empty uses the
+	// system default
+	MDNSInterface = os.Getenv("MDNS_INTERFACE")
+	log.Printf("MDNSInterface = %v\n", MDNSInterface)
+
+	// Hue bridges to connect to; the driver writes app keys back after pairing
+	HueBridgesFile = os.Getenv("HUE_BRIDGES_FILE")
+	if HueBridgesFile == "" {
+		HueBridgesFile = "hue-bridges.json"
+	}
+	log.Printf("HueBridgesFile = %v\n", HueBridgesFile)
+
+	// Modbus TCP devices and their register maps; devices may set their own
+	// poll_interval_ms
+	ModbusDevicesFile = os.Getenv("MODBUS_DEVICES_FILE")
+	if ModbusDevicesFile == "" {
+		ModbusDevicesFile = "modbus-devices.json"
+	}
+	ModbusPollInterval, err = time.ParseDuration(os.Getenv("MODBUS_POLL_INTERVAL"))
+	if err != nil || ModbusPollInterval <= 0 {
+		ModbusPollInterval = 10 * time.Second
+	}
+	log.Printf("ModbusDevicesFile = %v, ModbusPollInterval = %v\n", ModbusDevicesFile, ModbusPollInterval)
+}
+
+// embeddedBrokerURL is where the bridge's own clients reach the embedded broker.
+func embeddedBrokerURL() string {
+	scheme := "tcp"
+	if MQTTBrokerTLSCert != "" {
+		scheme = "ssl"
+	}
+	host, port, err := net.SplitHostPort(MQTTBrokerListen)
+	if err != nil {
+		return scheme + "://localhost:1883"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
 }
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// LoadJSONFile decodes the JSON file at path into v. It reports false and no
+// error when the file doesn't exist, as device files are optional.
+func LoadJSONFile(path string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// LoadJSONList reads a JSON array, such as a driver's device file. A missing
+// file yields no entries.
+func LoadJSONList[T any](path string) ([]T, error) {
+	var list []T
+	if _, err := LoadJSONFile(path, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
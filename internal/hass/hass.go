@@ -0,0 +1,376 @@
+package hass
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/iot/mqtt"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// commandTimeout bounds a driver call made for a Home Assistant command.
+const commandTimeout = 10 * time.Second
+
+// Publisher mirrors every device in the store into Home Assistant using MQTT
+// discovery, and turns Home Assistant commands into driver calls.
+type Publisher struct {
+	client paho.Client
+	prefix string // discovery prefix, e.g. "homeassistant"
+	base   string // bridge topic root, e.g. "iot-bridge/hass"
+
+	mu        sync.Mutex
+	published map[string][]string // device ID -> discovery config topics
+}
+
+// entity is one Home Assistant entity derived from a capability parameter.
+type entity struct {
+	component string
+	objectID  string
+	config    map[string]interface{}
+}
+
+var publisher *Publisher
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func Init() {
+	if !config.HassPublish {
+		return
+	}
+
+	publisher = &Publisher{
+		prefix:    strings.TrimSuffix(config.HassDiscoveryPrefix, "/"),
+		base:      strings.TrimSuffix(config.HassBaseTopic, "/"),
+		published: make(map[string][]string),
+	}
+
+	opts := mqtt.NewClientOptions("iot-bridge-hass", publisher.onConnect)
+	opts.SetWill(publisher.availabilityTopic(), "offline", 1, true)
+	publisher.client = mqtt.NewClientFromOptions(opts)
+
+	factory.OnDeviceEvent(publisher.handleEvent)
+
+	mqtt.Connect(publisher.client, "[HASS]")
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return p.base + "/status"
+}
+
+func (p *Publisher) stateTopic(deviceID string) string {
+	return fmt.Sprintf("%s/%s/state", p.base, deviceID)
+}
+
+func (p *Publisher) commandTopic(deviceID, capability, param string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/set", p.base, deviceID, capability, param)
+}
+
+func (p *Publisher) onConnect(c paho.Client) {
+	log.Println("[HASS] Connected to MQTT")
+
+	c.Publish(p.availabilityTopic(), 1, true, "online")
+
+	if token := c.Subscribe(p.base+"/+/+/+/set", 0, p.handleCommand); token.Wait() && token.Error() != nil {
+		log.Println("[HASS] Failed to subscribe to commands:", token.Error())
+	}
+	// Home Assistant announces "online" on restart; configs must be resent then.
+	c.Subscribe(p.prefix+"/status", 0, func(_ paho.Client, msg paho.Message) {
+		if string(msg.Payload()) == "online" {
+			p.publishAll()
+		}
+	})
+
+	p.publishAll()
+}
+
+func (p *Publisher) publishAll() {
+	for _, device := range factory.GetDeviceStore().GetAll() {
+		p.publishDevice(device)
+		p.publishState(device)
+	}
+}
+
+func (p *Publisher) handleEvent(event store.DeviceEvent) {
+	if !p.client.IsConnectionOpen() {
+		return // everything is republished on (re)connect
+	}
+	switch event.Kind {
+	case store.DeviceAdded, store.DeviceUpdated:
+		p.publishDevice(event.Device)
+		p.publishState(event.Device)
+	case store.DeviceStateChanged:
+		p.publishState(event.Device)
+	case store.DeviceRemoved:
+		p.unpublishDevice(event.Device.ID)
+	}
+}
+
+func (p *Publisher) publishDevice(device store.Device) {
+	entities := p.entitiesFor(device)
+
+	topics := make([]string, 0, len(entities))
+	for _, e := range entities {
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.prefix, e.component, mqtt.BridgeDiscoveryNode, e.objectID)
+		payload, _ := json.Marshal(e.config)
+		p.client.Publish(topic, 1, true, payload)
+		topics = append(topics, topic)
+	}
+
+	p.mu.Lock()
+	stale := p.published[device.ID]
+	p.published[device.ID] = topics
+	p.mu.Unlock()
+
+	// Capabilities that disappeared since the last publish are removed from HA.
+	for _, old := range stale {
+		if !slices.Contains(topics, old) {
+			p.client.Publish(old, 1, true, []byte{})
+		}
+	}
+}
+
+func (p *Publisher) unpublishDevice(deviceID string) {
+	p.mu.Lock()
+	topics := p.published[deviceID]
+	delete(p.published, deviceID)
+	p.mu.Unlock()
+
+	for _, topic := range topics {
+		p.client.Publish(topic, 1, true, []byte{})
+	}
+	p.client.Publish(p.stateTopic(deviceID), 1, true, []byte{})
+}
+
+func (p *Publisher) publishState(device store.Device) {
+	state := device.State
+	if state == nil {
+		state = store.State{}
+	}
+	payload, _ := json.Marshal(state)
+	p.client.Publish(p.stateTopic(device.ID), 0, true, payload)
+}
+
+// entitiesFor maps each capability parameter onto the closest HA component:
+// on/off -> switch, numbers -> number or sensor, enumerations -> select,
+// booleans -> binary_sensor, everything else read-only -> sensor.
+func (p *Publisher) entitiesFor(device store.Device) []entity {
+	var entities []entity
+	for _, cap := range device.Capabilities {
+		params := cap.Parameters
+		if len(params) == 0 && len(cap.Operations) > 0 {
+			// Legacy capabilities list their values only in Operations.
+			params = map[string]interface{}{
+				cap.Name: map[string]interface{}{"type": "string", "operations": cap.Operations},
+			}
+		}
+
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, param := range names {
+			spec, _ := params[param].(map[string]interface{})
+			if e, ok := p.entityFor(device, cap, param, spec); ok {
+				entities = append(entities, e)
+			}
+		}
+	}
+	return entities
+}
+
+func (p *Publisher) entityFor(device store.Device, cap store.Capability, param string, spec map[string]interface{}) (entity, bool) {
+	objectID := sanitize(device.ID + "_" + cap.Name)
+	name := cap.Name
+	if param != cap.Name {
+		objectID = sanitize(device.ID + "_" + cap.Name + "_" + param)
+		name = cap.Name + " " + param
+	}
+
+	cfg := map[string]interface{}{
+		"name":               strings.ReplaceAll(name, "_", " "),
+		"unique_id":          "iot_bridge_" + objectID,
+		"object_id":          objectID,
+		"state_topic":        p.stateTopic(device.ID),
+		"value_template":     fmt.Sprintf("{{ value_json.get(%s) }}", strconv.Quote(param)),
+		"availability_topic": p.availabilityTopic(),
+		"device": map[string]interface{}{
+			"identifiers":    []string{"iot_bridge_" + sanitize(device.ID)},
+			"name":           device.Name,
+			"model":          cmp.Or(device.Model, device.Type),
+			"manufacturer":   cmp.Or(device.Manufacturer, "iot-bridge ("+device.Protocol+")"),
+			"suggested_area": device.Room,
+		},
+	}
+
+	options := stringList(spec["operations"])
+	if len(options) == 0 {
+		options = cap.Operations
+	}
+	if slices.Contains(options, "set") {
+		options = nil
+	}
+	typ, _ := spec["type"].(string)
+	unit, _ := spec["unit"].(string)
+	if unit != "" {
+		cfg["unit_of_measurement"] = unit
+	}
+
+	var component string
+	switch {
+	case !cap.Writable && typ == "boolean":
+		component = "binary_sensor"
+		cfg["value_template"] = booleanTemplate(param)
+		cfg["payload_on"] = "true"
+		cfg["payload_off"] = "false"
+	case !cap.Writable:
+		component = "sensor"
+	case isOnOff(options):
+		component = "switch"
+		cfg["payload_on"] = "on"
+		cfg["payload_off"] = "off"
+		cfg["state_on"] = "on"
+		cfg["state_off"] = "off"
+	case typ == "boolean":
+		component = "switch"
+		cfg["value_template"] = booleanTemplate(param)
+		cfg["payload_on"] = "true"
+		cfg["payload_off"] = "false"
+		cfg["state_on"] = "true"
+		cfg["state_off"] = "false"
+	case typ == "integer" || typ == "number":
+		component = "number"
+		if lo, hi, ok := iot.RangeBounds(spec["range"]); ok {
+			cfg["min"] = lo
+			cfg["max"] = hi
+		}
+		if step, ok := spec["step"].(float64); ok {
+			cfg["step"] = step
+		}
+	case len(options) > 0:
+		component = "select"
+		cfg["options"] = options
+	case typ == "array":
+		return entity{}, false // no generic HA entity for arrays such as RGB
+	default:
+		component = "text"
+	}
+
+	if cap.Writable {
+		cfg["command_topic"] = p.commandTopic(device.ID, cap.Name, param)
+	}
+	return entity{component: component, objectID: objectID, config: cfg}, true
+}
+
+// handleCommand executes "<base>/<device>/<capability>/<param>/set".
+func (p *Publisher) handleCommand(_ paho.Client, msg paho.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), p.base+"/"), "/")
+	if len(parts) != 4 {
+		return
+	}
+	deviceID, capName, param := parts[0], parts[1], parts[2]
+	value := string(msg.Payload())
+
+	ds := factory.GetDeviceStore()
+	device, ok := ds.Get(deviceID)
+	if !ok {
+		log.Printf("[HASS] Command for unknown device %s", deviceID)
+		return
+	}
+	if !hasWritableCapability(device, capName) {
+		log.Printf("[HASS] Device %s has no writable capability %s", deviceID, capName)
+		return
+	}
+
+	// Home Assistant sends one parameter per topic; the capability's other
+	// parameters keep their current values.
+	input := map[string]interface{}{param: commandValue(device, capName, param, value)}
+	for _, cap := range device.Capabilities {
+		if cap.Name != capName {
+			continue
+		}
+		for name := range cap.Parameters {
+			if current, ok := device.State[name]; ok && name != param {
+				input[name] = store.ParseValue(store.FormatValue(current))
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if _, err := iot.Invoke(ctx, deviceID, capName, input, false); err != nil {
+		log.Printf("[HASS] Failed to set %s on %s: %v", param, deviceID, err)
+	}
+}
+
+// booleanTemplate renders a JSON boolean as "true"/"false"; Jinja would
+// otherwise print Python's "True"/"False".
+func booleanTemplate(param string) string {
+	return fmt.Sprintf("{{ value_json.get(%s) | string | lower }}", strconv.Quote(param))
+}
+
+// commandValue types a command payload after the parameter's spec: numbers,
+// booleans and arrays are decoded, everything else stays a string.
+func commandValue(device store.Device, capName, param, payload string) interface{} {
+	for _, cap := range device.Capabilities {
+		if cap.Name != capName {
+			continue
+		}
+		if spec, ok := cap.Parameters[param].(map[string]interface{}); ok {
+			switch spec["type"] {
+			case "integer", "number", "boolean", "array":
+				return store.ParseValue(payload)
+			}
+		}
+	}
+	return payload
+}
+
+func hasWritableCapability(device store.Device, name string) bool {
+	for _, cap := range device.Capabilities {
+		if cap.Name == name {
+			return cap.Writable
+		}
+	}
+	return false
+}
+
+func isOnOff(options []string) bool {
+	return len(options) == 2 && slices.Contains(options, "on") && slices.Contains(options, "off")
+}
+
+func stringList(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func sanitize(s string) string {
+	return unsafeChars.ReplaceAllString(s, "_")
+}
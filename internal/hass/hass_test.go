@@ -0,0 +1,136 @@
+package hass
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+// recordingDriver records the updates it is asked to apply.
+type recordingDriver struct {
+	mu      sync.Mutex
+	updates []store.State
+}
+
+func (d *recordingDriver) Start() error       { return nil }
+func (d *recordingDriver) Stop() error        { return nil }
+func (d *recordingDriver) Health() iot.Health { return iot.Health{Status: iot.HealthOK} }
+func (d *recordingDriver) GetState(context.Context, store.Device) (store.State, error) {
+	return store.State{}, nil
+}
+func (d *recordingDriver) SetState(_ context.Context, _ store.Device, updates store.State) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.updates = append(d.updates, updates)
+	return nil
+}
+
+type message struct {
+	topic   string
+	payload string
+}
+
+func (m message) Duplicate() bool   { return false }
+func (m message) Qos() byte         { return 0 }
+func (m message) Retained() bool    { return false }
+func (m message) Topic() string     { return m.topic }
+func (m message) MessageID() uint16 { return 0 }
+func (m message) Payload() []byte   { return []byte(m.payload) }
+func (m message) Ack()              {}
+
+func TestHandleCommand(t *testing.T) {
+	testutil.UseMemoryStore()
+
+	driver := &recordingDriver{}
+	iot.Register("hasstest", func() iot.Driver { return driver })
+	iot.StartDrivers([]string{"hasstest"})
+	defer iot.StopDrivers()
+
+	ds := factory.GetDeviceStore()
+	ds.Add(store.Device{
+		ID: "lamp", Name: "Lamp", Type: "light", Protocol: "hasstest", Room: "hall",
+		State: store.State{"state": "on", "brightness": 40},
+		Capabilities: []store.Capability{
+			{Name: "power", Operations: []string{"on", "off"}, Writable: true,
+				Parameters: map[string]interface{}{"state": map[string]interface{}{"type": "string"}}},
+			{Name: "brightness", Operations: []string{"set"}, Writable: true,
+				Parameters: map[string]interface{}{"brightness": map[string]interface{}{"type": "integer", "range": []int{0, 100}}}},
+			{Name: "temperature", Parameters: map[string]interface{}{"temperature": map[string]interface{}{"type": "number"}}},
+		},
+	})
+	p := &Publisher{base: "iot-bridge/hass", published: make(map[string][]string)}
+
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		applied store.State // nil when the command must be rejected
+	}{
+		{"power", "iot-bridge/hass/lamp/power/state/set", "off", store.State{"state": "off"}},
+		{"brightness", "iot-bridge/hass/lamp/brightness/brightness/set", "75", store.State{"brightness": 75}},
+		{"out of range", "iot-bridge/hass/lamp/brightness/brightness/set", "150", nil},
+		{"invalid operation", "iot-bridge/hass/lamp/power/state/set", "toggle", nil},
+		{"read-only", "iot-bridge/hass/lamp/temperature/temperature/set", "20", nil},
+		{"unknown device", "iot-bridge/hass/other/power/state/set", "on", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver.mu.Lock()
+			driver.updates = nil
+			driver.mu.Unlock()
+
+			p.handleCommand(nil, message{tt.topic, tt.payload})
+
+			driver.mu.Lock()
+			defer driver.mu.Unlock()
+			if tt.applied == nil {
+				if len(driver.updates) != 0 {
+					t.Fatalf("driver got %v, want no call", driver.updates)
+				}
+				return
+			}
+			if len(driver.updates) != 1 || store.FormatValue(driver.updates[0]) != store.FormatValue(tt.applied) {
+				t.Fatalf("driver got %v, want %v", driver.updates, tt.applied)
+			}
+			device, _ := ds.Get("lamp")
+			for k, v := range tt.applied {
+				if store.FormatValue(device.State[k]) != store.FormatValue(v) {
+					t.Errorf("stored %s = %v, want %v", k, device.State[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandValue(t *testing.T) {
+	device := store.Device{Capabilities: []store.Capability{
+		{Name: "brightness", Parameters: map[string]interface{}{"brightness": map[string]interface{}{"type": "integer"}}},
+		{Name: "color", Parameters: map[string]interface{}{"rgb": map[string]interface{}{"type": "array"}}},
+		{Name: "power", Parameters: map[string]interface{}{"state": map[string]interface{}{"type": "string"}}},
+	}}
+	tests := []struct {
+		capName, param, payload string
+		want                    string
+	}{
+		{"brightness", "brightness", "42", "42"},
+		{"color", "rgb", "[255,0,0]", "[255,0,0]"},
+		{"power", "state", "on", "on"},
+		{"power", "state", "1", "1"},
+	}
+	for _, tt := range tests {
+		got := commandValue(device, tt.capName, tt.param, tt.payload)
+		if store.FormatValue(got) != tt.want {
+			t.Errorf("commandValue(%s, %q) = %#v", tt.param, tt.payload, got)
+		}
+		if tt.param == "state" {
+			if _, ok := got.(string); !ok {
+				t.Errorf("commandValue(state, %q) = %T, want string", tt.payload, got)
+			}
+		}
+	}
+}
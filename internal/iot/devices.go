@@ -0,0 +1,52 @@
+package iot
+
+import (
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// RegisterDevice adds a device a driver found or was configured with to the
+// store. A device that is already stored keeps its name and room, which the
+// user may have edited; its type, capabilities, manufacturer and model are
+// refreshed and device.State is merged into its state. It reports whether
+// the device was new.
+func RegisterDevice(device store.Device) (bool, error) {
+	ds := factory.GetDeviceStore()
+	if existing, found := ds.Get(device.ID); found {
+		if device.Type != "" {
+			existing.Type = device.Type
+		}
+		existing.Capabilities = device.Capabilities
+		if device.Manufacturer != "" {
+			existing.Manufacturer = device.Manufacturer
+		}
+		if device.Model != "" {
+			existing.Model = device.Model
+		}
+		// Build a new map: existing.State is shared with the store and
+		// every other caller of Get.
+		state := make(store.State, len(existing.State)+len(device.State))
+		for k, v := range existing.State {
+			state[k] = v
+		}
+		for k, v := range device.State {
+			state[k] = v
+		}
+		existing.State = state
+		return false, ds.Add(existing)
+	}
+
+	if device.Name == "" {
+		device.Name = device.ID
+	}
+	if device.Room == "" {
+		device.Room = "unknown"
+	}
+	// Copy the state so the driver can keep updating its own map.
+	state := make(store.State, len(device.State))
+	for k, v := range device.State {
+		state[k] = v
+	}
+	device.State = state
+	return true, ds.Add(device)
+}
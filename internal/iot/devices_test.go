@@ -0,0 +1,58 @@
+package iot
+
+import (
+	"testing"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+func TestRegisterDeviceKeepsEditsAndMergesState(t *testing.T) {
+	testutil.UseMemoryStore()
+	ds := factory.GetDeviceStore()
+	ds.Add(store.Device{ID: "lamp", Name: "Desk lamp", Room: "Office", Type: "light", State: store.State{"state": "on"}})
+	before, _ := ds.Get("lamp")
+
+	added, err := RegisterDevice(store.Device{
+		ID: "lamp", Name: "lamp-1234", Room: "unknown", Type: "dimmer",
+		Capabilities: []store.Capability{{Name: "brightness"}},
+		State:        store.State{"brightness": 40},
+	})
+	if err != nil || added {
+		t.Fatalf("RegisterDevice() = %v, %v, want an update", added, err)
+	}
+
+	device, _ := ds.Get("lamp")
+	if device.Name != "Desk lamp" || device.Room != "Office" {
+		t.Errorf("name, room = %q, %q, want user edits kept", device.Name, device.Room)
+	}
+	if device.Type != "dimmer" || len(device.Capabilities) != 1 {
+		t.Errorf("type, capabilities not refreshed: %+v", device)
+	}
+	if device.State["state"] != "on" || device.State["brightness"] != 40 {
+		t.Errorf("state = %v, want both values", device.State)
+	}
+	if _, changed := before.State["brightness"]; changed {
+		t.Error("RegisterDevice changed a state map returned by an earlier Get")
+	}
+}
+
+func TestRegisterDeviceDefaults(t *testing.T) {
+	testutil.UseMemoryStore()
+
+	state := store.State{"state": "off"}
+	added, err := RegisterDevice(store.Device{ID: "plug", State: state})
+	if err != nil || !added {
+		t.Fatalf("RegisterDevice() = %v, %v, want a new device", added, err)
+	}
+	state["state"] = "on"
+
+	device, _ := factory.GetDeviceStore().Get("plug")
+	if device.Name != "plug" || device.Room != "unknown" {
+		t.Errorf("name, room = %q, %q, want defaults", device.Name, device.Room)
+	}
+	if device.State["state"] != "off" {
+		t.Error("the stored state shares the driver's map")
+	}
+}
@@ -1,20 +1,41 @@
 package iot
 
 import (
-	"iot-bridge/internal/iot/zigbee"
+	"context"
+	"time"
+
 	"iot-bridge/internal/store"
 )
 
+// DeviceDriver reads and writes device state. Implementations honour the
+// context's deadline and cancellation, and report failures as *Error where
+// the kind is known.
 type DeviceDriver interface {
-	GetState(device store.Device) (map[string]string, error)
-	SetState(device store.Device, updates map[string]string) error
+	GetState(ctx context.Context, device store.Device) (store.State, error)
+	SetState(ctx context.Context, device store.Device, updates store.State) error
+}
+
+// DeviceRenamer is implemented by drivers whose backend keeps its own device
+// names, so renames on the bridge can be pushed down.
+type DeviceRenamer interface {
+	RenameDevice(ctx context.Context, device store.Device, name string) error
+}
+
+// DeviceRemover is implemented by drivers that can remove a device from its
+// network. Force removes it even when the device does not respond.
+type DeviceRemover interface {
+	RemoveDevice(ctx context.Context, device store.Device, force bool) error
+}
+
+// StateConfirmer is implemented by drivers that can wait for a device to
+// report the state it applied. SetStateConfirmed returns the reported values
+// of the updated keys.
+type StateConfirmer interface {
+	SetStateConfirmed(ctx context.Context, device store.Device, updates store.State) (store.State, error)
 }
 
-func GetDriverFor(device store.Device) DeviceDriver {
-	switch device.Protocol {
-	case "zigbee":
-		return zigbee.GetDriver()
-		// Add other protocols here (zwave, matter, etc.) as needed
-	}
-	return nil // or panic/log
+// Scanner is implemented by drivers that can look for new devices, e.g. by
+// opening their network for joining. Results go to the scan store.
+type Scanner interface {
+	Scan(duration time.Duration) error
 }
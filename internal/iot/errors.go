@@ -0,0 +1,72 @@
+package iot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies driver errors so callers can react to them without
+// parsing messages.
+type ErrorKind string
+
+const (
+	DeviceOffline  ErrorKind = "device_offline"  // the device is known to be unreachable
+	Timeout        ErrorKind = "timeout"         // no answer before the deadline
+	Unsupported    ErrorKind = "unsupported"     // the device or driver can't do this
+	InvalidValue   ErrorKind = "invalid_value"   // the value was rejected
+	TransportError ErrorKind = "transport_error" // the backend connection failed
+	Mismatch       ErrorKind = "state_mismatch"  // the device reported other values than requested
+	NotFound       ErrorKind = "not_found"       // no such device
+)
+
+// Error is a driver error of a known kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errorf returns an *Error of the given kind with a formatted message. %w
+// wraps like fmt.Errorf.
+func Errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// KindOf classifies err. Expired contexts count as timeouts; errors drivers
+// didn't classify are treated as transport errors.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout
+	}
+	return TransportError
+}
+
+// ErrorCode is the code APIs report for err, in the "error" field of REST
+// responses and MQTT command results: the error's kind, or
+// unknown_protocol, driver_disabled or internal_error for errors from the
+// driver registry and the store.
+func ErrorCode(err error) string {
+	var unknown *UnknownProtocolError
+	var disabled *DriverDisabledError
+	switch {
+	case errors.As(err, &unknown):
+		return "unknown_protocol"
+	case errors.As(err, &disabled):
+		return "driver_disabled"
+	case errors.Is(err, ErrPersistState):
+		return "internal_error"
+	}
+	return string(KindOf(err))
+}
@@ -0,0 +1,55 @@
+package iot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{"device offline", Errorf(DeviceOffline, "gone"), DeviceOffline},
+		{"timeout", Errorf(Timeout, "slow"), Timeout},
+		{"unsupported", Errorf(Unsupported, "no"), Unsupported},
+		{"invalid value", Errorf(InvalidValue, "bad"), InvalidValue},
+		{"transport error", Errorf(TransportError, "broken"), TransportError},
+		{"mismatch", Errorf(Mismatch, "other"), Mismatch},
+		{"not found", Errorf(NotFound, "who"), NotFound},
+		{"wrapped", fmt.Errorf("set state: %w", Errorf(InvalidValue, "bad")), InvalidValue},
+		{"expired context", context.DeadlineExceeded, Timeout},
+		{"wrapped expired context", fmt.Errorf("read: %w", context.DeadlineExceeded), Timeout},
+		{"kind wins over context", Errorf(Unsupported, "%w", context.DeadlineExceeded), Unsupported},
+		{"unclassified", errors.New("connection reset"), TransportError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.want {
+				t.Errorf("KindOf(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{Errorf(Mismatch, "other"), "state_mismatch"},
+		{fmt.Errorf("read: %w", context.DeadlineExceeded), "timeout"},
+		{&UnknownProtocolError{Protocol: "x10"}, "unknown_protocol"},
+		{fmt.Errorf("lamp: %w", &DriverDisabledError{Protocol: "hue"}), "driver_disabled"},
+		{fmt.Errorf("%w: disk full", ErrPersistState), "internal_error"},
+		{errors.New("connection reset"), "transport_error"},
+	}
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("ErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
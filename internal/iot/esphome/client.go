@@ -0,0 +1,239 @@
+package esphome
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/iot"
+)
+
+const (
+	apiVersionMajor = 1
+	apiVersionMinor = 10
+
+	dialTimeout = 10 * time.Second
+	// The node drops clients that stay silent; ping well within its timeout.
+	pingInterval = 20 * time.Second
+)
+
+// ErrInvalidPassword is returned when the node rejects the API password.
+var ErrInvalidPassword = errors.New("invalid API password")
+
+// DeviceInfo is the part of DeviceInfoResponse the driver uses.
+type DeviceInfo struct {
+	Name           string
+	FriendlyName   string
+	MACAddress     string
+	Model          string
+	ESPHomeVersion string
+	SuggestedArea  string
+}
+
+// Client is a connection to one node's native API. Dial completes the
+// handshake and lists entities; subscribe then streams state messages.
+type Client struct {
+	conn *frameConn
+
+	Info     DeviceInfo
+	Entities []Entity
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// Dial connects to addr (host:port). A non-empty psk selects the Noise
+// transport.
+func Dial(addr string, psk []byte, password string) (*Client, error) {
+	raw, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: newFrameConn(raw), done: make(chan struct{})}
+	raw.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.handshake(psk, password); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *Client) handshake(psk []byte, password string) error {
+	if len(psk) > 0 {
+		if _, err := c.conn.clientHandshake(psk); err != nil {
+			return err
+		}
+	}
+
+	var hello pbWriter
+	hello.String(1, "iot-bridge")
+	hello.Uint(2, apiVersionMajor)
+	hello.Uint(3, apiVersionMinor)
+	if _, err := c.request(msgHelloRequest, hello.Bytes(), msgHelloResponse); err != nil {
+		return fmt.Errorf("hello: %w", err)
+	}
+
+	var login pbWriter
+	login.String(1, password)
+	res, err := c.request(msgConnectRequest, login.Bytes(), msgConnectResponse)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	if res.Bool(1) {
+		return ErrInvalidPassword
+	}
+
+	res, err = c.request(msgDeviceInfoRequest, nil, msgDeviceInfoResponse)
+	if err != nil {
+		return fmt.Errorf("device info: %w", err)
+	}
+	c.Info = DeviceInfo{
+		Name:           res.String(2),
+		MACAddress:     res.String(3),
+		ESPHomeVersion: res.String(4),
+		Model:          res.String(6),
+		FriendlyName:   res.String(13),
+		SuggestedArea:  res.String(16),
+	}
+
+	if err := c.conn.WriteMessage(msgListEntitiesRequest, nil); err != nil {
+		return err
+	}
+	for {
+		msgType, m, err := c.read()
+		if err != nil {
+			return fmt.Errorf("list entities: %w", err)
+		}
+		if msgType == msgListEntitiesDone {
+			return nil
+		}
+		if e, ok := decodeEntity(msgType, m); ok {
+			c.Entities = append(c.Entities, e)
+		}
+	}
+}
+
+// request sends a message and waits for the given response type, skipping
+// anything else.
+func (c *Client) request(msgType int, payload []byte, want int) (pbMessage, error) {
+	if err := c.conn.WriteMessage(msgType, payload); err != nil {
+		return nil, err
+	}
+	for {
+		got, m, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if got == want {
+			return m, nil
+		}
+	}
+}
+
+// read returns the next message, answering the node's own requests on the
+// way.
+func (c *Client) read() (int, pbMessage, error) {
+	for {
+		msgType, payload, err := c.conn.ReadMessage()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch msgType {
+		case msgPingRequest:
+			c.conn.WriteMessage(msgPingResponse, nil)
+			continue
+		case msgGetTimeRequest:
+			var w pbWriter
+			w.Fixed32(1, uint32(time.Now().Unix()))
+			c.conn.WriteMessage(msgGetTimeResponse, w.Bytes())
+			continue
+		case msgDisconnectRequest:
+			c.conn.WriteMessage(msgDisconnectResponse, nil)
+			return 0, nil, errors.New("node closed the connection")
+		}
+		m, err := decodeMessage(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		return msgType, m, nil
+	}
+}
+
+// subscribe requests state updates and delivers every message to onMessage
+// until the connection drops. The node sends the current state of every
+// entity first.
+func (c *Client) subscribe(onMessage func(msgType int, m pbMessage)) error {
+	if err := c.conn.WriteMessage(msgSubscribeStatesRequest, nil); err != nil {
+		return err
+	}
+	go c.keepalive()
+	go func() {
+		defer c.shutdown()
+		for {
+			// Pings keep messages coming; a node that stopped answering
+			// times out here.
+			c.conn.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+			msgType, m, err := c.read()
+			if err != nil {
+				return
+			}
+			onMessage(msgType, m)
+		}
+	}()
+	return nil
+}
+
+func (c *Client) keepalive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.WriteMessage(msgPingRequest, nil); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Send writes a command message.
+func (c *Client) Send(msgType int, payload []byte) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return iot.Errorf(iot.TransportError, "connection closed")
+	}
+	if err := c.conn.WriteMessage(msgType, payload); err != nil {
+		return iot.Errorf(iot.TransportError, "%w", err)
+	}
+	return nil
+}
+
+func (c *Client) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	c.conn.Close()
+}
+
+// Done is closed when the connection drops.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close says goodbye and closes the connection.
+func (c *Client) Close() error {
+	c.conn.WriteMessage(msgDisconnectRequest, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,444 @@
+package esphome
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// Entity kinds the driver maps.
+const (
+	KindSwitch       = "switch"
+	KindLight        = "light"
+	KindSensor       = "sensor"
+	KindBinarySensor = "binary_sensor"
+	KindTextSensor   = "text_sensor"
+	KindNumber       = "number"
+)
+
+var listTypes = map[int]string{
+	msgListEntitiesSwitch:       KindSwitch,
+	msgListEntitiesLight:        KindLight,
+	msgListEntitiesSensor:       KindSensor,
+	msgListEntitiesBinarySensor: KindBinarySensor,
+	msgListEntitiesTextSensor:   KindTextSensor,
+	msgListEntitiesNumber:       KindNumber,
+}
+
+// Light color mode capability bits.
+const (
+	colorModeBrightness       = 1 << 1
+	colorModeColorTemperature = 1 << 3
+	colorModeRGB              = 1 << 5
+)
+
+// Entity is one entity from the ListEntities responses.
+type Entity struct {
+	Kind             string
+	Key              uint32
+	ObjectID         string
+	Name             string
+	Unit             string
+	DeviceClass      string
+	AccuracyDecimals int
+	// Numbers
+	Min, Max, Step float32
+	// Lights
+	ColorModes           []uint32
+	MinMireds, MaxMireds float32
+}
+
+func (e Entity) colorMode(bit uint32) bool {
+	for _, m := range e.ColorModes {
+		if m&bit != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeEntity reads a ListEntities*Response. Every kind shares object_id
+// (1), key (2) and name (3).
+func decodeEntity(msgType int, m pbMessage) (Entity, bool) {
+	kind, ok := listTypes[msgType]
+	if !ok {
+		return Entity{}, false
+	}
+	e := Entity{Kind: kind, ObjectID: m.String(1), Key: m.Fixed32(2), Name: m.String(3)}
+	switch kind {
+	case KindSensor:
+		e.Unit = m.String(6)
+		e.AccuracyDecimals = m.Int(7)
+		e.DeviceClass = m.String(9)
+	case KindBinarySensor:
+		e.DeviceClass = m.String(5)
+	case KindNumber:
+		e.Min, e.Max, e.Step = m.Float(6), m.Float(7), m.Float(8)
+		e.Unit = m.String(11)
+	case KindLight:
+		e.ColorModes = m.Uints(12)
+		// Firmware before color modes only sets the legacy flags.
+		if len(e.ColorModes) == 0 {
+			var mode uint32 = 1
+			if m.Bool(5) {
+				mode |= colorModeBrightness
+			}
+			if m.Bool(6) {
+				mode |= colorModeRGB
+			}
+			if m.Bool(8) {
+				mode |= colorModeColorTemperature
+			}
+			e.ColorModes = []uint32{mode}
+		}
+		e.MinMireds, e.MaxMireds = m.Float(9), m.Float(10)
+	}
+	return e, true
+}
+
+// entitySet maps a node's entities to state keys. A node with a single
+// switch or light gets the usual "state"/"power" names; otherwise every key
+// is suffixed with the entity's object_id.
+type entitySet struct {
+	byKey  map[uint32]Entity
+	byName map[string]Entity // state key -> writable entity
+	single bool
+}
+
+func newEntitySet(entities []Entity) entitySet {
+	s := entitySet{byKey: make(map[uint32]Entity), byName: make(map[string]Entity)}
+	outputs := 0
+	for _, e := range entities {
+		s.byKey[e.Key] = e
+		if e.Kind == KindSwitch || e.Kind == KindLight {
+			outputs++
+		}
+	}
+	s.single = outputs == 1
+	for _, e := range entities {
+		for _, key := range s.writableKeys(e) {
+			s.byName[key] = e
+		}
+	}
+	return s
+}
+
+func (s entitySet) key(base string, e Entity) string {
+	if s.single {
+		return base
+	}
+	return base + "_" + e.ObjectID
+}
+
+func (s entitySet) writableKeys(e Entity) []string {
+	switch e.Kind {
+	case KindSwitch:
+		return []string{s.key("state", e)}
+	case KindLight:
+		keys := []string{s.key("state", e)}
+		if e.colorMode(colorModeBrightness) {
+			keys = append(keys, s.key("brightness", e))
+		}
+		if e.colorMode(colorModeRGB) {
+			keys = append(keys, s.key("rgb", e))
+		}
+		if e.colorMode(colorModeColorTemperature) {
+			keys = append(keys, s.key("color_temp", e))
+		}
+		return keys
+	case KindNumber:
+		return []string{e.ObjectID}
+	}
+	return nil
+}
+
+// sorted returns the entities ordered by kind and object_id.
+func (s entitySet) sorted() []Entity {
+	order := map[string]int{KindSwitch: 0, KindLight: 1, KindNumber: 2, KindSensor: 3, KindBinarySensor: 4, KindTextSensor: 5}
+	list := make([]Entity, 0, len(s.byKey))
+	for _, e := range s.byKey {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if order[a.Kind] != order[b.Kind] {
+			return order[a.Kind] < order[b.Kind]
+		}
+		return a.ObjectID < b.ObjectID
+	})
+	return list
+}
+
+func (s entitySet) capabilities() []store.Capability {
+	var caps []store.Capability
+	for _, e := range s.sorted() {
+		switch e.Kind {
+		case KindSwitch, KindLight:
+			key := s.key("state", e)
+			caps = append(caps, store.Capability{
+				Name:        strings.Replace(key, "state", "power", 1),
+				Description: e.Name + ": turn on or off",
+				Operations:  []string{"on", "off"},
+				Parameters: map[string]interface{}{
+					key: map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+				},
+				Writable: true,
+			})
+			if e.Kind != KindLight {
+				continue
+			}
+			if e.colorMode(colorModeBrightness) {
+				key := s.key("brightness", e)
+				caps = append(caps, store.Capability{
+					Name:        key,
+					Description: e.Name + ": adjust brightness (0-100)",
+					Operations:  []string{"set"},
+					Parameters: map[string]interface{}{
+						key: map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+					},
+					Writable: true,
+				})
+			}
+			if e.colorMode(colorModeRGB) {
+				key := s.key("rgb", e)
+				caps = append(caps, store.Capability{
+					Name:        strings.Replace(key, "rgb", "color", 1),
+					Description: e.Name + ": change color using RGB",
+					Operations:  []string{"set"},
+					Parameters: map[string]interface{}{
+						key: map[string]interface{}{"type": "array", "length": 3, "range": []int{0, 255}},
+					},
+					Writable: true,
+				})
+			}
+			if e.colorMode(colorModeColorTemperature) {
+				key := s.key("color_temp", e)
+				lo, hi := int(e.MinMireds), int(e.MaxMireds)
+				if hi <= lo {
+					lo, hi = 153, 500
+				}
+				caps = append(caps, store.Capability{
+					Name:        key,
+					Description: e.Name + ": set the white color temperature in mireds",
+					Operations:  []string{"set"},
+					Parameters: map[string]interface{}{
+						key: map[string]interface{}{"type": "integer", "range": []int{lo, hi}},
+					},
+					Writable: true,
+				})
+			}
+		case KindNumber:
+			spec := map[string]interface{}{"type": "number", "range": []float64{round(e.Min), round(e.Max)}}
+			if e.Step > 0 {
+				spec["step"] = round(e.Step)
+			}
+			if e.Unit != "" {
+				spec["unit"] = e.Unit
+			}
+			caps = append(caps, store.Capability{
+				Name:        e.ObjectID,
+				Description: fmt.Sprintf("%s (%g-%g)", e.Name, round(e.Min), round(e.Max)),
+				Operations:  []string{"set"},
+				Parameters:  map[string]interface{}{e.ObjectID: spec},
+				Writable:    true,
+			})
+		case KindSensor:
+			spec := map[string]interface{}{"type": "number"}
+			if e.Unit != "" {
+				spec["unit"] = e.Unit
+			}
+			caps = append(caps, store.Capability{
+				Name:        e.ObjectID,
+				Description: e.Name,
+				Parameters:  map[string]interface{}{e.ObjectID: spec},
+			})
+		case KindBinarySensor:
+			caps = append(caps, store.Capability{
+				Name:        e.ObjectID,
+				Description: e.Name,
+				Parameters:  map[string]interface{}{e.ObjectID: map[string]interface{}{"type": "boolean"}},
+			})
+		case KindTextSensor:
+			caps = append(caps, store.Capability{
+				Name:        e.ObjectID,
+				Description: e.Name,
+				Parameters:  map[string]interface{}{e.ObjectID: map[string]interface{}{"type": "string"}},
+			})
+		}
+	}
+	return caps
+}
+
+// state decodes a *StateResponse into state values.
+func (s entitySet) state(msgType int, m pbMessage) store.State {
+	e, ok := s.byKey[m.Fixed32(1)]
+	if !ok {
+		return nil
+	}
+	switch msgType {
+	case msgSwitchStateResponse:
+		return store.State{s.key("state", e): onOff(m.Bool(2))}
+	case msgBinarySensorStateResponse:
+		if m.Bool(3) {
+			return nil // missing_state
+		}
+		return store.State{e.ObjectID: m.Bool(2)}
+	case msgSensorStateResponse, msgNumberStateResponse:
+		if m.Bool(3) || math.IsNaN(float64(m.Float(2))) {
+			return nil
+		}
+		if e.Kind == KindSensor {
+			return store.State{e.ObjectID: roundTo(m.Float(2), e.AccuracyDecimals)}
+		}
+		return store.State{e.ObjectID: round(m.Float(2))}
+	case msgTextSensorStateResponse:
+		if m.Bool(3) {
+			return nil
+		}
+		return store.State{e.ObjectID: m.String(2)}
+	case msgLightStateResponse:
+		state := store.State{s.key("state", e): onOff(m.Bool(2))}
+		if e.colorMode(colorModeBrightness) {
+			state[s.key("brightness", e)] = int(math.Round(float64(m.Float(3)) * 100))
+		}
+		if e.colorMode(colorModeRGB) {
+			rgb := []interface{}{}
+			for _, field := range []int{4, 5, 6} {
+				rgb = append(rgb, float64(math.Round(float64(m.Float(field))*255)))
+			}
+			state[s.key("rgb", e)] = rgb
+		}
+		if e.colorMode(colorModeColorTemperature) && m.Float(8) > 0 {
+			state[s.key("color_temp", e)] = int(math.Round(float64(m.Float(8))))
+		}
+		return state
+	}
+	return nil
+}
+
+// command turns one state update into a command message.
+func (s entitySet) command(key string, value interface{}) (int, []byte, error) {
+	e, ok := s.byName[key]
+	if !ok {
+		return 0, nil, iot.UnsupportedKey(key)
+	}
+	var w pbWriter
+	w.Fixed32(1, e.Key)
+
+	switch {
+	case e.Kind == KindNumber:
+		n, ok := iot.Number(value)
+		if !ok {
+			return 0, nil, iot.Errorf(iot.InvalidValue, "invalid %s %v, expected a number", key, value)
+		}
+		if e.Max > e.Min && (n < float64(e.Min) || n > float64(e.Max)) {
+			return 0, nil, iot.Errorf(iot.InvalidValue, "%s %v out of range %g-%g", key, value, e.Min, e.Max)
+		}
+		w.Float(2, float32(n))
+		return msgNumberCommandRequest, w.Bytes(), nil
+
+	case key == s.key("state", e):
+		on, err := parseOnOff(value)
+		if err != nil {
+			return 0, nil, err
+		}
+		if e.Kind == KindSwitch {
+			w.Bool(2, on)
+			return msgSwitchCommandRequest, w.Bytes(), nil
+		}
+		w.Bool(2, true) // has_state
+		w.Bool(3, on)
+		return msgLightCommandRequest, w.Bytes(), nil
+
+	case key == s.key("brightness", e):
+		n, ok := iot.Number(value)
+		if !ok || n < 0 || n > 100 {
+			return 0, nil, iot.Errorf(iot.InvalidValue, "invalid brightness %v, expected 0-100", value)
+		}
+		w.Bool(4, true) // has_brightness
+		w.Float(5, float32(n/100))
+		return msgLightCommandRequest, w.Bytes(), nil
+
+	case key == s.key("rgb", e):
+		rgb, ok := iot.RGBValue(value)
+		if !ok {
+			return 0, nil, iot.Errorf(iot.InvalidValue, "invalid rgb %v, expected [r, g, b] in 0-255", value)
+		}
+		w.Bool(6, true) // has_rgb
+		w.Float(7, float32(rgb[0])/255)
+		w.Float(8, float32(rgb[1])/255)
+		w.Float(9, float32(rgb[2])/255)
+		return msgLightCommandRequest, w.Bytes(), nil
+
+	case key == s.key("color_temp", e):
+		n, ok := iot.Number(value)
+		if !ok || n <= 0 {
+			return 0, nil, iot.Errorf(iot.InvalidValue, "invalid color_temp %v", value)
+		}
+		w.Bool(12, true) // has_color_temperature
+		w.Float(13, float32(n))
+		return msgLightCommandRequest, w.Bytes(), nil
+	}
+	return 0, nil, iot.UnsupportedKey(key)
+}
+
+func (s entitySet) deviceType() string {
+	lights, switches, sensors := 0, 0, 0
+	color := false
+	for _, e := range s.byKey {
+		switch e.Kind {
+		case KindLight:
+			lights++
+			color = color || e.colorMode(colorModeRGB)
+		case KindSwitch:
+			switches++
+		case KindSensor, KindBinarySensor, KindTextSensor:
+			sensors++
+		}
+	}
+	switch {
+	case color:
+		return "bulb"
+	case lights > 0:
+		return "dimmer"
+	case switches > 0:
+		return "switch"
+	case sensors > 0:
+		return "sensor"
+	}
+	return "sensor"
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func parseOnOff(value interface{}) (bool, error) {
+	switch strings.ToLower(store.FormatValue(value)) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, iot.Errorf(iot.InvalidValue, "invalid power value %v, expected on/off", value)
+}
+
+// round drops float32 noise, e.g. 21.299999237060547 -> 21.3.
+func round(f float32) float64 {
+	return roundTo(f, 6)
+}
+
+func roundTo(f float32, decimals int) float64 {
+	if decimals < 0 || decimals > 6 {
+		decimals = 6
+	}
+	p := math.Pow(10, float64(decimals))
+	return math.Round(float64(f)*p) / p
+}
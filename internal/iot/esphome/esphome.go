@@ -0,0 +1,341 @@
+package esphome
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// DefaultPort is the native API port.
+const DefaultPort = "6053"
+
+// NodeConfig is an entry of ESPHOME_DEVICES_FILE. EncryptionKey is the
+// base64 api encryption key from the node's YAML; leave it empty for
+// plaintext nodes. Without an id, "esphome-<node name>" is used.
+type NodeConfig struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Room          string `json:"room"`
+	Host          string `json:"host"`
+	EncryptionKey string `json:"encryption_key"`
+	Password      string `json:"password"`
+}
+
+type node struct {
+	cfg  NodeConfig
+	addr string
+	psk  []byte
+	id   string // set once connected
+
+	entities entitySet
+	state    store.State
+	client   *Client
+}
+
+// ESPHomeDriver keeps a native API connection to every configured node,
+// mirrors entity states into the store and sends switch, light and number
+// commands.
+type ESPHomeDriver struct {
+	mu    sync.Mutex
+	nodes []*node
+	byID  map[string]*node
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var driver *ESPHomeDriver
+
+func init() {
+	iot.Register("esphome", func() iot.Driver {
+		driver = NewDriver()
+		return driver
+	})
+}
+
+func NewDriver() *ESPHomeDriver {
+	return &ESPHomeDriver{
+		byID: make(map[string]*node),
+		stop: make(chan struct{}),
+	}
+}
+
+func GetDriver() *ESPHomeDriver {
+	return driver
+}
+
+// Start connects to every node in ESPHOME_DEVICES_FILE in the background.
+func (d *ESPHomeDriver) Start() error {
+	nodes, err := config.LoadJSONList[NodeConfig](config.ESPHomeDevicesFile)
+	if err != nil {
+		log.Printf("[ESPHome] Failed to load %s: %v", config.ESPHomeDevicesFile, err)
+	}
+	for _, cfg := range nodes {
+		if err := d.AddNode(cfg); err != nil {
+			log.Printf("[ESPHome] Skipping node %q: %v", cfg.Host, err)
+		}
+	}
+	return nil
+}
+
+// AddNode starts keeping a connection to a node.
+func (d *ESPHomeDriver) AddNode(cfg NodeConfig) error {
+	if cfg.Host == "" {
+		return errors.New("no host")
+	}
+	n := &node{cfg: cfg, addr: cfg.Host, id: cfg.ID, state: store.State{}}
+	if _, _, err := net.SplitHostPort(cfg.Host); err != nil {
+		n.addr = net.JoinHostPort(cfg.Host, DefaultPort)
+	}
+	if cfg.EncryptionKey != "" {
+		psk, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+		if err != nil || len(psk) != 32 {
+			return errors.New("encryption_key must be 32 bytes of base64")
+		}
+		n.psk = psk
+	}
+	d.mu.Lock()
+	d.nodes = append(d.nodes, n)
+	d.mu.Unlock()
+	go d.run(n)
+	return nil
+}
+
+// Adopt connects to a node found by a wifi scan, unless it is already
+// configured. Nodes announcing api_encryption need their key, which only
+// ESPHOME_DEVICES_FILE can provide.
+func (d *ESPHomeDriver) Adopt(found store.DiscoveredDevice) error {
+	if found.Host == "" {
+		return iot.Errorf(iot.InvalidValue, "no address for %s", found.ID)
+	}
+	if _, encrypted := found.Metadata["api_encryption"]; encrypted {
+		return iot.Errorf(iot.Unsupported, "%s requires an encryption key, add it to %s", found.ID, config.ESPHomeDevicesFile)
+	}
+	port := DefaultPort
+	if found.Port != 0 {
+		port = strconv.Itoa(found.Port)
+	}
+	addr := net.JoinHostPort(found.Host, port)
+	d.mu.Lock()
+	for _, n := range d.nodes {
+		if n.addr == addr || n.id == found.ID {
+			d.mu.Unlock()
+			return nil
+		}
+	}
+	d.mu.Unlock()
+	return d.AddNode(NodeConfig{ID: found.ID, Host: addr})
+}
+
+// Stop ends the reconnect loops, which close the connections.
+func (d *ESPHomeDriver) Stop() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	return nil
+}
+
+func (d *ESPHomeDriver) Health() iot.Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.nodes) == 0 {
+		return iot.Health{Status: iot.HealthDisabled, Detail: "no ESPHome nodes configured"}
+	}
+	var down []string
+	for _, n := range d.nodes {
+		if n.client == nil {
+			down = append(down, n.addr)
+		}
+	}
+	if len(down) > 0 {
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + strings.Join(down, ", ")}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+// run keeps a connection to the node open, reconnecting with backoff. Nodes
+// on battery or deep sleep are expected to come and go.
+func (d *ESPHomeDriver) run(n *node) {
+	backoff := time.Second
+	for {
+		client, err := d.connect(n)
+		if err != nil {
+			log.Printf("[ESPHome] Connection to %s failed: %v (retrying in %s)", n.addr, err, backoff)
+			d.setAvailability(n, store.Offline)
+			select {
+			case <-time.After(backoff):
+			case <-d.stop:
+				return
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+		select {
+		case <-client.Done():
+			log.Printf("[ESPHome] Connection to %s lost", n.addr)
+			d.mu.Lock()
+			n.client = nil
+			d.mu.Unlock()
+			d.setAvailability(n, store.Offline)
+		case <-d.stop:
+			client.Close()
+			return
+		}
+	}
+}
+
+func (d *ESPHomeDriver) connect(n *node) (*Client, error) {
+	client, err := Dial(n.addr, n.psk, n.cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	if n.id == "" {
+		n.id = "esphome-" + client.Info.Name
+	}
+	n.entities = newEntitySet(client.Entities)
+	n.client = client
+	d.byID[n.id] = n
+	d.mu.Unlock()
+
+	log.Printf("[ESPHome] Connected to %s (%s, ESPHome %s, %d entities)",
+		n.addr, client.Info.Name, client.Info.ESPHomeVersion, len(client.Entities))
+	d.register(n, client.Info)
+
+	if err := client.subscribe(func(msgType int, m pbMessage) { d.handleState(n, msgType, m) }); err != nil {
+		client.Close()
+		return nil, err
+	}
+	d.setAvailability(n, store.Online)
+	return client, nil
+}
+
+// register adds the node to the store, or refreshes the stored device.
+func (d *ESPHomeDriver) register(n *node, info DeviceInfo) {
+	d.mu.Lock()
+	id, entities := n.id, n.entities
+	d.mu.Unlock()
+
+	name := n.cfg.Name
+	if name == "" {
+		name = info.FriendlyName
+	}
+	if name == "" {
+		name = info.Name
+	}
+	room := n.cfg.Room
+	if room == "" {
+		room = info.SuggestedArea
+	}
+	added, err := iot.RegisterDevice(store.Device{
+		ID:           id,
+		Name:         name,
+		Type:         entities.deviceType(),
+		Protocol:     "esphome",
+		Room:         room,
+		Capabilities: entities.capabilities(),
+	})
+	if err != nil {
+		log.Printf("[ESPHome] Failed to register device %s: %v", id, err)
+	} else if added {
+		log.Printf("[ESPHome] Registered %s (%s)", name, id)
+	}
+}
+
+func (d *ESPHomeDriver) handleState(n *node, msgType int, m pbMessage) {
+	d.mu.Lock()
+	id := n.id
+	updates := n.entities.state(msgType, m)
+	for k, v := range updates {
+		n.state[k] = v
+	}
+	d.mu.Unlock()
+	if len(updates) == 0 {
+		return
+	}
+
+	ds := factory.GetDeviceStore()
+	if err := ds.UpdateState(id, updates); err != nil {
+		log.Printf("[ESPHome] Failed to update state for %s: %v", id, err)
+	}
+	now := time.Now()
+	ds.UpdateStatus(id, store.DeviceStatus{Availability: store.Online, LastSeen: &now})
+}
+
+func (d *ESPHomeDriver) setAvailability(n *node, availability string) {
+	d.mu.Lock()
+	id := n.id
+	d.mu.Unlock()
+	if id != "" {
+		factory.GetDeviceStore().UpdateStatus(id, store.DeviceStatus{Availability: availability})
+	}
+}
+
+func (d *ESPHomeDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, ok := d.byID[device.ID]
+	if !ok {
+		return nil, iot.Errorf(iot.Unsupported, "unknown ESPHome node %s", device.ID)
+	}
+	state := make(store.State, len(n.state))
+	for k, v := range n.state {
+		state[k] = v
+	}
+	return state, nil
+}
+
+// SetState sends one command per update. The node answers with state
+// messages, which update the store.
+func (d *ESPHomeDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	d.mu.Lock()
+	n, ok := d.byID[device.ID]
+	var entities entitySet
+	var client *Client
+	if ok {
+		entities, client = n.entities, n.client
+	}
+	d.mu.Unlock()
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "unknown ESPHome node %s", device.ID)
+	}
+	if client == nil {
+		return iot.Errorf(iot.TransportError, "not connected to %s", device.ID)
+	}
+
+	type command struct {
+		msgType int
+		payload []byte
+	}
+	var commands []command
+	for key, value := range updates {
+		msgType, payload, err := entities.command(key, value)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, command{msgType, payload})
+	}
+	for _, c := range commands {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%s: %w", device.ID, err)
+		}
+		if err := client.Send(c.msgType, c.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package esphome
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+const nodeID = "esphome-desk-node"
+
+func testEntities() []Entity {
+	return []Entity{
+		{Kind: KindLight, Key: 1, ObjectID: "desk", Name: "Desk lamp", ColorModes: []uint32{1 | colorModeBrightness | colorModeRGB}},
+		{Kind: KindSwitch, Key: 2, ObjectID: "fan", Name: "Fan"},
+		{Kind: KindSensor, Key: 3, ObjectID: "temperature", Name: "Temperature", Unit: "°C", AccuracyDecimals: 1},
+		{Kind: KindNumber, Key: 4, ObjectID: "timer", Name: "Timer", Min: 0, Max: 60, Step: 1},
+	}
+}
+
+func testValues() map[uint32]interface{} {
+	return map[uint32]interface{}{
+		1: LightState{On: true, Brightness: 0.5, Red: 1},
+		2: false,
+		3: float32(21.34),
+		4: float32(10),
+	}
+}
+
+// startNode connects a driver to an encrypted fake node and waits for its
+// states to arrive.
+func startNode(t *testing.T) (*ESPHomeDriver, *FakeNode) {
+	t.Helper()
+	testutil.UseMemoryStore()
+
+	key := base64.StdEncoding.EncodeToString(testKey)
+	fake, err := NewFakeNode("desk-node", key, testEntities(), testValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+	d := NewDriver()
+	t.Cleanup(func() { d.Stop() })
+	if err := d.AddNode(NodeConfig{Host: fake.Addr(), Room: "Study", EncryptionKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, "states", func() bool { return testutil.StoredState(nodeID, "temperature") != nil })
+	return d, fake
+}
+
+func TestDiscoveryRegistersNode(t *testing.T) {
+	startNode(t)
+
+	device, _ := factory.GetDeviceStore().Get(nodeID)
+	if device.Name != "desk-node" || device.Room != "Study" || device.Type != "bulb" || device.Protocol != "esphome" {
+		t.Errorf("device = %+v", device)
+	}
+	if device.Availability != store.Online {
+		t.Errorf("availability = %q, want online", device.Availability)
+	}
+	caps := map[string]bool{}
+	for _, c := range device.Capabilities {
+		caps[c.Name] = true
+	}
+	for _, name := range []string{"power_desk", "brightness_desk", "color_desk", "power_fan", "temperature", "timer"} {
+		if !caps[name] {
+			t.Errorf("missing capability %s in %v", name, caps)
+		}
+	}
+	want := store.State{"state_desk": "on", "brightness_desk": 50, "state_fan": "off", "temperature": 21.3, "timer": float64(10)}
+	for k, v := range want {
+		if device.State[k] != v {
+			t.Errorf("state[%s] = %v (%T), want %v", k, device.State[k], device.State[k], v)
+		}
+	}
+}
+
+func TestSetStateRoundTrip(t *testing.T) {
+	d, fake := startNode(t)
+	device, _ := factory.GetDeviceStore().Get(nodeID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.SetState(ctx, device, store.State{"state_fan": "on", "brightness_desk": 80}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, "fan on", func() bool { return testutil.StoredState(nodeID, "state_fan") == "on" })
+	testutil.WaitFor(t, "brightness 80", func() bool { return testutil.StoredState(nodeID, "brightness_desk") == 80 })
+
+	fake.mu.Lock()
+	commands := len(fake.Commands)
+	fake.mu.Unlock()
+	if commands != 2 {
+		t.Errorf("node received %d commands, want 2", commands)
+	}
+
+	tests := []struct {
+		updates store.State
+		want    iot.ErrorKind
+	}{
+		{store.State{"temperature": 20}, iot.Unsupported},
+		{store.State{"timer": 90}, iot.InvalidValue},
+		{store.State{"state_fan": "dim"}, iot.InvalidValue},
+	}
+	for _, tt := range tests {
+		if err := d.SetState(ctx, device, tt.updates); iot.KindOf(err) != tt.want {
+			t.Errorf("SetState(%v) error = %v, want %s", tt.updates, err, tt.want)
+		}
+	}
+}
+
+func TestEventsUpdateStore(t *testing.T) {
+	_, fake := startNode(t)
+
+	fake.SetValue(3, float32(22.46))
+	testutil.WaitFor(t, "temperature 22.5", func() bool { return testutil.StoredState(nodeID, "temperature") == 22.5 })
+	fake.SetValue(1, LightState{On: false, Brightness: 0.5, Red: 1})
+	testutil.WaitFor(t, "desk off", func() bool { return testutil.StoredState(nodeID, "state_desk") == "off" })
+}
+
+func TestDiscoveryKeepsUserEdits(t *testing.T) {
+	testutil.UseMemoryStore()
+	factory.GetDeviceStore().Add(store.Device{ID: nodeID, Name: "Reading light", Room: "Bedroom", Protocol: "esphome"})
+
+	fake, err := NewFakeNode("desk-node", "", testEntities(), testValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	d := NewDriver()
+	defer d.Stop()
+	if err := d.AddNode(NodeConfig{Host: fake.Addr(), Room: "Study"}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, "capabilities refreshed", func() bool {
+		device, _ := factory.GetDeviceStore().Get(nodeID)
+		return len(device.Capabilities) > 0
+	})
+	device, _ := factory.GetDeviceStore().Get(nodeID)
+	if device.Name != "Reading light" || device.Room != "Bedroom" {
+		t.Errorf("name, room = %q, %q, want user edits kept", device.Name, device.Room)
+	}
+}
+
+func TestDialRejectsWrongPassword(t *testing.T) {
+	fake, err := NewFakeNode("desk-node", "", testEntities(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	fake.Password = "secret"
+
+	if _, err := Dial(fake.Addr(), nil, "guess"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Dial() error = %v, want %v", err, ErrInvalidPassword)
+	}
+	client, err := Dial(fake.Addr(), nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if len(client.Entities) != len(testEntities()) {
+		t.Errorf("listed %d entities, want %d", len(client.Entities), len(testEntities()))
+	}
+}
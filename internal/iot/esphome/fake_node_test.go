@@ -0,0 +1,348 @@
+package esphome
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync"
+)
+
+// LightState is a light's state in FakeNode. Brightness and colors are 0-1
+// like on the wire.
+type LightState struct {
+	On               bool
+	Brightness       float32
+	Red, Green, Blue float32
+	ColorTemperature float32
+}
+
+// FakeNode is a scripted ESPHome node for developing and testing the driver
+// without hardware. It speaks the native API in plaintext or, with a key,
+// over Noise; lists its entities, streams their states to subscribers and
+// applies switch, light and number commands, reporting the new state back
+// the way firmware does.
+type FakeNode struct {
+	Name     string
+	Password string
+
+	listener net.Listener
+	psk      []byte
+
+	mu       sync.Mutex
+	entities []Entity
+	values   map[uint32]interface{} // bool, float32, string or LightState
+	subs     map[*frameConn]bool
+	// Commands records the message type of every command received.
+	Commands []int
+}
+
+// NewFakeNode listens on a local port. encryptionKey is the base64 api key,
+// or empty for a plaintext node. values holds initial states by entity key.
+func NewFakeNode(name, encryptionKey string, entities []Entity, values map[uint32]interface{}) (*FakeNode, error) {
+	var psk []byte
+	if encryptionKey != "" {
+		var err error
+		if psk, err = base64.StdEncoding.DecodeString(encryptionKey); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[uint32]interface{}{}
+	}
+	f := &FakeNode{
+		Name:     name,
+		listener: l,
+		psk:      psk,
+		entities: entities,
+		values:   values,
+		subs:     make(map[*frameConn]bool),
+	}
+	go f.accept()
+	return f, nil
+}
+
+// Addr returns the host:port to configure the driver with.
+func (f *FakeNode) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Close stops listening and drops every connection.
+func (f *FakeNode) Close() {
+	f.listener.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.subs {
+		c.Close()
+	}
+}
+
+// SetValue changes an entity's state and pushes it to subscribers, like a
+// sensor reporting a new reading.
+func (f *FakeNode) SetValue(key uint32, value interface{}) {
+	f.mu.Lock()
+	f.values[key] = value
+	f.mu.Unlock()
+	f.publish(key)
+}
+
+func (f *FakeNode) accept() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.serve(newFrameConn(conn))
+	}
+}
+
+func (f *FakeNode) serve(c *frameConn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.subs, c)
+		f.mu.Unlock()
+		c.Close()
+	}()
+	if f.psk != nil {
+		if err := c.serverHandshake(f.psk, f.Name); err != nil {
+			return
+		}
+	}
+
+	for {
+		msgType, payload, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		m, err := decodeMessage(payload)
+		if err != nil {
+			return
+		}
+
+		switch msgType {
+		case msgHelloRequest:
+			var w pbWriter
+			w.Uint(1, apiVersionMajor)
+			w.Uint(2, apiVersionMinor)
+			w.String(3, f.Name+" (fake ESPHome)")
+			w.String(4, f.Name)
+			c.WriteMessage(msgHelloResponse, w.Bytes())
+		case msgConnectRequest:
+			var w pbWriter
+			w.Bool(1, m.String(1) != f.Password)
+			c.WriteMessage(msgConnectResponse, w.Bytes())
+			if m.String(1) != f.Password {
+				return
+			}
+		case msgDeviceInfoRequest:
+			var w pbWriter
+			w.Bool(1, f.Password != "")
+			w.String(2, f.Name)
+			w.String(3, "AA:BB:CC:DD:EE:FF")
+			w.String(4, "2024.6.0")
+			w.String(6, "esp32dev")
+			c.WriteMessage(msgDeviceInfoResponse, w.Bytes())
+		case msgListEntitiesRequest:
+			f.mu.Lock()
+			entities := append([]Entity{}, f.entities...)
+			f.mu.Unlock()
+			for _, e := range entities {
+				c.WriteMessage(encodeEntity(e))
+			}
+			c.WriteMessage(msgListEntitiesDone, nil)
+		case msgSubscribeStatesRequest:
+			f.mu.Lock()
+			f.subs[c] = true
+			var states [][]byte
+			var types []int
+			for _, e := range f.entities {
+				if t, p, ok := f.encodeState(e); ok {
+					types, states = append(types, t), append(states, p)
+				}
+			}
+			f.mu.Unlock()
+			for i := range states {
+				c.WriteMessage(types[i], states[i])
+			}
+		case msgPingRequest:
+			c.WriteMessage(msgPingResponse, nil)
+		case msgDisconnectRequest:
+			c.WriteMessage(msgDisconnectResponse, nil)
+			return
+		case msgSwitchCommandRequest, msgLightCommandRequest, msgNumberCommandRequest:
+			f.command(msgType, m)
+		}
+	}
+}
+
+func (f *FakeNode) command(msgType int, m pbMessage) {
+	key := m.Fixed32(1)
+	f.mu.Lock()
+	f.Commands = append(f.Commands, msgType)
+	switch msgType {
+	case msgSwitchCommandRequest:
+		f.values[key] = m.Bool(2)
+	case msgNumberCommandRequest:
+		f.values[key] = m.Float(2)
+	case msgLightCommandRequest:
+		light, _ := f.values[key].(LightState)
+		if m.Bool(2) {
+			light.On = m.Bool(3)
+		}
+		if m.Bool(4) {
+			light.Brightness = m.Float(5)
+		}
+		if m.Bool(6) {
+			light.Red, light.Green, light.Blue = m.Float(7), m.Float(8), m.Float(9)
+		}
+		if m.Bool(12) {
+			light.ColorTemperature = m.Float(13)
+		}
+		f.values[key] = light
+	}
+	f.mu.Unlock()
+	f.publish(key)
+}
+
+func (f *FakeNode) publish(key uint32) {
+	f.mu.Lock()
+	var msgType int
+	var payload []byte
+	ok := false
+	for _, e := range f.entities {
+		if e.Key == key {
+			msgType, payload, ok = f.encodeState(e)
+		}
+	}
+	subs := make([]*frameConn, 0, len(f.subs))
+	for c := range f.subs {
+		subs = append(subs, c)
+	}
+	f.mu.Unlock()
+	if !ok {
+		return
+	}
+	for _, c := range subs {
+		c.WriteMessage(msgType, payload)
+	}
+}
+
+// encodeState builds the state message for an entity; f.mu must be held.
+func (f *FakeNode) encodeState(e Entity) (int, []byte, bool) {
+	value, ok := f.values[e.Key]
+	var w pbWriter
+	w.Fixed32(1, e.Key)
+	switch e.Kind {
+	case KindSwitch:
+		b, _ := value.(bool)
+		w.Bool(2, b)
+		return msgSwitchStateResponse, w.Bytes(), true
+	case KindBinarySensor:
+		b, _ := value.(bool)
+		w.Bool(2, b)
+		w.Bool(3, !ok)
+		return msgBinarySensorStateResponse, w.Bytes(), true
+	case KindSensor, KindNumber:
+		n, _ := value.(float32)
+		w.Float(2, n)
+		w.Bool(3, !ok)
+		if e.Kind == KindSensor {
+			return msgSensorStateResponse, w.Bytes(), true
+		}
+		return msgNumberStateResponse, w.Bytes(), true
+	case KindTextSensor:
+		s, _ := value.(string)
+		w.String(2, s)
+		w.Bool(3, !ok)
+		return msgTextSensorStateResponse, w.Bytes(), true
+	case KindLight:
+		light, _ := value.(LightState)
+		w.Bool(2, light.On)
+		w.Float(3, light.Brightness)
+		w.Float(4, light.Red)
+		w.Float(5, light.Green)
+		w.Float(6, light.Blue)
+		w.Float(8, light.ColorTemperature)
+		return msgLightStateResponse, w.Bytes(), true
+	}
+	return 0, nil, false
+}
+
+// encodeEntity is the inverse of decodeEntity, for ListEntities responses.
+func encodeEntity(e Entity) (int, []byte) {
+	var w pbWriter
+	w.String(1, e.ObjectID)
+	w.Fixed32(2, e.Key)
+	w.String(3, e.Name)
+	w.String(4, e.ObjectID) // unique_id
+	msgType := 0
+	switch e.Kind {
+	case KindSwitch:
+		msgType = msgListEntitiesSwitch
+	case KindLight:
+		msgType = msgListEntitiesLight
+		w.Float(9, e.MinMireds)
+		w.Float(10, e.MaxMireds)
+		w.Packed(12, e.ColorModes)
+	case KindSensor:
+		msgType = msgListEntitiesSensor
+		w.String(6, e.Unit)
+		w.Uint(7, uint64(e.AccuracyDecimals))
+		w.String(9, e.DeviceClass)
+	case KindBinarySensor:
+		msgType = msgListEntitiesBinarySensor
+		w.String(5, e.DeviceClass)
+	case KindTextSensor:
+		msgType = msgListEntitiesTextSensor
+	case KindNumber:
+		msgType = msgListEntitiesNumber
+		w.Float(6, e.Min)
+		w.Float(7, e.Max)
+		w.Float(8, e.Step)
+		w.String(11, e.Unit)
+	}
+	return msgType, w.Bytes()
+}
+
+// serverHandshake runs the Noise handshake as the responder, answering
+// failures with an error frame the way firmware does.
+func (c *frameConn) serverHandshake(psk []byte, name string) error {
+	if _, err := c.readNoiseFrame(); err != nil { // client hello
+		if errors.Is(err, ErrNotEncrypted) {
+			// Like firmware, answer plaintext clients with a Noise frame.
+			c.writeNoiseFrame(append([]byte{0x01}, "Bad indicator byte"...))
+		}
+		return err
+	}
+	msg, err := c.readNoiseFrame()
+	if err != nil {
+		return err
+	}
+	if err := c.writeNoiseFrame(append([]byte{indicatorNoise}, name+"\x00"...)); err != nil {
+		return err
+	}
+
+	hs, err := newHandshake(psk, false)
+	if err != nil {
+		return err
+	}
+	if len(msg) == 0 || msg[0] != 0x00 {
+		return errors.New("noise: bad handshake message")
+	}
+	if err := hs.ReadMessage(msg[1:]); err != nil {
+		c.writeNoiseFrame(append([]byte{0x01}, "Handshake MAC failure"...))
+		return err
+	}
+	reply, err := hs.WriteMessage()
+	if err != nil {
+		return err
+	}
+	if err := c.writeNoiseFrame(append([]byte{0x00}, reply...)); err != nil {
+		return err
+	}
+	c.send, c.recv = hs.Split()
+	return nil
+}
@@ -0,0 +1,196 @@
+package esphome
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Frame indicator bytes.
+const (
+	indicatorPlaintext = 0x00
+	indicatorNoise     = 0x01
+)
+
+// maxFrame bounds a single message; ESPHome frames stay far below this.
+const maxFrame = 1 << 20
+
+var (
+	// ErrRequiresEncryption is returned when a node expects Noise but no
+	// encryption key is configured.
+	ErrRequiresEncryption = errors.New("node requires encryption, set encryption_key")
+	// ErrNotEncrypted is returned when an encryption key is configured but
+	// the node speaks plaintext.
+	ErrNotEncrypted = errors.New("node does not use encryption, remove encryption_key")
+)
+
+// frameConn reads and writes API messages over plaintext or Noise framing.
+type frameConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMu sync.Mutex
+	send    *cipherState // nil for plaintext
+	recv    *cipherState
+}
+
+func newFrameConn(conn net.Conn) *frameConn {
+	return &frameConn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// ReadMessage returns the next message's type and protobuf payload.
+func (c *frameConn) ReadMessage() (int, []byte, error) {
+	if c.recv == nil {
+		return c.readPlaintext()
+	}
+	frame, err := c.readNoiseFrame()
+	if err != nil {
+		return 0, nil, err
+	}
+	plain, err := c.recv.Decrypt(nil, frame)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(plain) < 4 {
+		return 0, nil, errMalformed
+	}
+	msgType := int(binary.BigEndian.Uint16(plain))
+	length := int(binary.BigEndian.Uint16(plain[2:]))
+	if len(plain)-4 < length {
+		return 0, nil, errMalformed
+	}
+	return msgType, plain[4 : 4+length], nil
+}
+
+func (c *frameConn) readPlaintext() (int, []byte, error) {
+	indicator, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if indicator == indicatorNoise {
+		return 0, nil, ErrRequiresEncryption
+	}
+	if indicator != indicatorPlaintext {
+		return 0, nil, fmt.Errorf("bad frame indicator 0x%02x", indicator)
+	}
+	length, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	msgType, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxFrame {
+		return 0, nil, errMalformed
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return int(msgType), payload, nil
+}
+
+func (c *frameConn) readNoiseFrame() ([]byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] == indicatorPlaintext {
+		return nil, ErrNotEncrypted
+	}
+	if header[0] != indicatorNoise {
+		return nil, fmt.Errorf("bad frame indicator 0x%02x", header[0])
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *frameConn) writeNoiseFrame(frame []byte) error {
+	if len(frame) > 0xffff {
+		return errMalformed
+	}
+	out := []byte{indicatorNoise, byte(len(frame) >> 8), byte(len(frame))}
+	_, err := c.conn.Write(append(out, frame...))
+	return err
+}
+
+// WriteMessage sends one message; it is safe for concurrent use.
+func (c *frameConn) WriteMessage(msgType int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.send == nil {
+		out := []byte{indicatorPlaintext}
+		out = binary.AppendUvarint(out, uint64(len(payload)))
+		out = binary.AppendUvarint(out, uint64(msgType))
+		_, err := c.conn.Write(append(out, payload...))
+		return err
+	}
+	plain := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint16(plain, uint16(msgType))
+	binary.BigEndian.PutUint16(plain[2:], uint16(len(payload)))
+	return c.writeNoiseFrame(c.send.Encrypt(nil, append(plain, payload...)))
+}
+
+// clientHandshake runs the Noise handshake as the initiator and returns the
+// node name announced in the server hello.
+func (c *frameConn) clientHandshake(psk []byte) (string, error) {
+	hs, err := newHandshake(psk, true)
+	if err != nil {
+		return "", err
+	}
+	msg, err := hs.WriteMessage()
+	if err != nil {
+		return "", err
+	}
+	// An empty client hello, then the first handshake message.
+	if err := c.writeNoiseFrame(nil); err != nil {
+		return "", err
+	}
+	if err := c.writeNoiseFrame(append([]byte{0x00}, msg...)); err != nil {
+		return "", err
+	}
+
+	hello, err := c.readNoiseFrame()
+	if errors.Is(err, io.EOF) {
+		// Plaintext nodes drop connections that start with a Noise frame.
+		return "", fmt.Errorf("%w (connection closed during handshake)", ErrNotEncrypted)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(hello) == 0 || hello[0] != indicatorNoise {
+		return "", errors.New("noise: unsupported protocol in server hello")
+	}
+	name, _, _ := strings.Cut(string(hello[1:]), "\x00")
+
+	reply, err := c.readNoiseFrame()
+	if err != nil {
+		return "", err
+	}
+	if len(reply) == 0 {
+		return "", errors.New("noise: empty handshake reply")
+	}
+	if reply[0] != 0x00 {
+		// The node explains the failure, e.g. "Handshake MAC failure".
+		return "", fmt.Errorf("noise: handshake rejected: %s", reply[1:])
+	}
+	if err := hs.ReadMessage(reply[1:]); err != nil {
+		return "", errNoiseDecrypt
+	}
+	c.send, c.recv = hs.Split()
+	return name, nil
+}
+
+func (c *frameConn) Close() error {
+	return c.conn.Close()
+}
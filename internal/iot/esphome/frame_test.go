@@ -0,0 +1,146 @@
+package esphome
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{0x42}, 32)
+	otherKey = bytes.Repeat([]byte{0x17}, 32)
+)
+
+// framePipe returns both ends of an in-memory connection.
+func framePipe(t *testing.T) (client, server *frameConn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	return newFrameConn(a), newFrameConn(b)
+}
+
+// runHandshake runs both sides of the Noise handshake and returns the client's
+// and the server's result.
+func runHandshake(client, server *frameConn, clientKey, serverKey []byte) (string, error, error) {
+	serverErr := make(chan error, 1)
+	go func() {
+		err := server.serverHandshake(serverKey, "kitchen-node")
+		if err != nil {
+			server.Close()
+		}
+		serverErr <- err
+	}()
+	name, err := client.clientHandshake(clientKey)
+	return name, err, <-serverErr
+}
+
+func TestPlaintextFraming(t *testing.T) {
+	client, server := framePipe(t)
+	payload := bytes.Repeat([]byte{0xab}, 300) // length needs a two-byte varint
+
+	go client.WriteMessage(msgSwitchCommandRequest, payload)
+	msgType, got, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != msgSwitchCommandRequest || !bytes.Equal(got, payload) {
+		t.Errorf("ReadMessage() = %d, %d bytes", msgType, len(got))
+	}
+}
+
+func TestNoiseFraming(t *testing.T) {
+	client, server := framePipe(t)
+	name, clientErr, serverErr := runHandshake(client, server, testKey, testKey)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake: client %v, server %v", clientErr, serverErr)
+	}
+	if name != "kitchen-node" {
+		t.Errorf("node name = %q, want kitchen-node", name)
+	}
+
+	// Several messages each way, so the nonces have to stay in step.
+	for i := 0; i < 3; i++ {
+		payload := []byte(strings.Repeat("x", i*100))
+		go client.WriteMessage(msgPingRequest, payload)
+		msgType, got, err := server.ReadMessage()
+		if err != nil || msgType != msgPingRequest || !bytes.Equal(got, payload) {
+			t.Fatalf("server read %d: %d, %q, %v", i, msgType, got, err)
+		}
+
+		go server.WriteMessage(msgPingResponse, payload)
+		msgType, got, err = client.ReadMessage()
+		if err != nil || msgType != msgPingResponse || !bytes.Equal(got, payload) {
+			t.Fatalf("client read %d: %d, %q, %v", i, msgType, got, err)
+		}
+	}
+}
+
+func TestNoiseRejectsTamperedFrames(t *testing.T) {
+	client, server := framePipe(t)
+	if _, clientErr, serverErr := runHandshake(client, server, testKey, testKey); clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake: client %v, server %v", clientErr, serverErr)
+	}
+
+	frame := client.send.Encrypt(nil, []byte{0, byte(msgPingRequest), 0, 0})
+	frame[0] ^= 0xff
+	go client.writeNoiseFrame(frame)
+	if _, _, err := server.ReadMessage(); !errors.Is(err, errNoiseDecrypt) {
+		t.Errorf("ReadMessage() error = %v, want %v", err, errNoiseDecrypt)
+	}
+}
+
+func TestNoiseWrongKey(t *testing.T) {
+	client, server := framePipe(t)
+	_, clientErr, serverErr := runHandshake(client, server, otherKey, testKey)
+	if serverErr == nil {
+		t.Error("server accepted a handshake with the wrong key")
+	}
+	if clientErr == nil || !strings.Contains(clientErr.Error(), "Handshake MAC failure") {
+		t.Errorf("client error = %v, want the node's MAC failure", clientErr)
+	}
+}
+
+func TestEncryptionMismatch(t *testing.T) {
+	t.Run("plaintext client, encrypted node", func(t *testing.T) {
+		client, server := framePipe(t)
+		go server.serverHandshake(testKey, "kitchen-node")
+		go client.WriteMessage(msgHelloRequest, nil)
+		if _, _, err := client.ReadMessage(); !errors.Is(err, ErrRequiresEncryption) {
+			t.Errorf("ReadMessage() error = %v, want %v", err, ErrRequiresEncryption)
+		}
+	})
+
+	t.Run("encrypted client, plaintext node", func(t *testing.T) {
+		// Over TCP the client's second handshake frame is still written
+		// after the node hung up; a pipe would fail that write instead.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		raw, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer raw.Close()
+		accepted, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer accepted.Close()
+		client, server := newFrameConn(raw), newFrameConn(accepted)
+		go func() {
+			_, _, err := server.ReadMessage()
+			if errors.Is(err, ErrRequiresEncryption) {
+				// Like firmware, hang up; closing only our side keeps unread
+				// input from turning the close into a reset.
+				accepted.(*net.TCPConn).CloseWrite()
+			}
+		}()
+		if _, err := client.clientHandshake(testKey); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("clientHandshake() error = %v, want %v", err, ErrNotEncrypted)
+		}
+	})
+}
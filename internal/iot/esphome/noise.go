@@ -0,0 +1,198 @@
+package esphome
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encrypted connections use Noise_NNpsk0_25519_ChaChaPoly_SHA256 with the
+// node's 32-byte api encryption key as the pre-shared key. Only the parts of
+// the Noise framework this pattern needs are implemented.
+
+const noiseProtocol = "Noise_NNpsk0_25519_ChaChaPoly_SHA256"
+
+var noisePrologue = []byte("NoiseAPIInit\x00\x00")
+
+var errNoiseDecrypt = errors.New("noise: decryption failed (wrong encryption key?)")
+
+// cipherState encrypts with a key and an incrementing nonce.
+type cipherState struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newCipherState(key []byte) *cipherState {
+	aead, _ := chacha20poly1305.New(key) // key is always 32 bytes
+	return &cipherState{aead: aead}
+}
+
+func (c *cipherState) nextNonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], c.nonce)
+	c.nonce++
+	return nonce
+}
+
+func (c *cipherState) Encrypt(ad, plaintext []byte) []byte {
+	return c.aead.Seal(nil, c.nextNonce(), plaintext, ad)
+}
+
+func (c *cipherState) Decrypt(ad, ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.aead.Open(nil, c.nextNonce(), ciphertext, ad)
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	return plaintext, nil
+}
+
+// handshake runs the two NNpsk0 messages: "psk, e" from the initiator and
+// "e, ee" back from the responder.
+type handshake struct {
+	initiator bool
+	ck, h     []byte
+	cipher    *cipherState
+	e         *ecdh.PrivateKey
+	re        *ecdh.PublicKey
+}
+
+func newHandshake(psk []byte, initiator bool) (*handshake, error) {
+	if len(psk) != 32 {
+		return nil, errors.New("noise: encryption key must be 32 bytes")
+	}
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	// The protocol name is longer than the hash, so h starts as its hash.
+	h := sha256.Sum256([]byte(noiseProtocol))
+	hs := &handshake{initiator: initiator, ck: h[:], h: h[:], e: e}
+	hs.mixHash(noisePrologue)
+	hs.mixKeyAndHash(psk)
+	return hs, nil
+}
+
+func (hs *handshake) mixHash(data []byte) {
+	sum := sha256.Sum256(append(append([]byte{}, hs.h...), data...))
+	hs.h = sum[:]
+}
+
+func (hs *handshake) mixKey(ikm []byte) {
+	out := hkdf(hs.ck, ikm, 2)
+	hs.ck = out[0]
+	hs.cipher = newCipherState(out[1])
+}
+
+func (hs *handshake) mixKeyAndHash(ikm []byte) {
+	out := hkdf(hs.ck, ikm, 3)
+	hs.ck = out[0]
+	hs.mixHash(out[1])
+	hs.cipher = newCipherState(out[2])
+}
+
+func (hs *handshake) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := hs.cipher.Encrypt(hs.h, plaintext)
+	hs.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (hs *handshake) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := hs.cipher.Decrypt(hs.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// writeE sends the local ephemeral key; in psk handshakes it is also mixed
+// into the key.
+func (hs *handshake) writeE() []byte {
+	pub := hs.e.PublicKey().Bytes()
+	hs.mixHash(pub)
+	hs.mixKey(pub)
+	return pub
+}
+
+func (hs *handshake) readE(msg []byte) ([]byte, error) {
+	if len(msg) < 32 {
+		return nil, errors.New("noise: short handshake message")
+	}
+	re, err := ecdh.X25519().NewPublicKey(msg[:32])
+	if err != nil {
+		return nil, err
+	}
+	hs.re = re
+	hs.mixHash(msg[:32])
+	hs.mixKey(msg[:32])
+	return msg[32:], nil
+}
+
+func (hs *handshake) mixDH() error {
+	shared, err := hs.e.ECDH(hs.re)
+	if err != nil {
+		return err
+	}
+	hs.mixKey(shared)
+	return nil
+}
+
+// WriteMessage produces the next handshake message with an empty payload.
+func (hs *handshake) WriteMessage() ([]byte, error) {
+	msg := hs.writeE()
+	if !hs.initiator {
+		if err := hs.mixDH(); err != nil {
+			return nil, err
+		}
+	}
+	return append(msg, hs.encryptAndHash(nil)...), nil
+}
+
+// ReadMessage consumes the peer's handshake message.
+func (hs *handshake) ReadMessage(msg []byte) error {
+	rest, err := hs.readE(msg)
+	if err != nil {
+		return err
+	}
+	if hs.initiator {
+		if err := hs.mixDH(); err != nil {
+			return err
+		}
+	}
+	_, err = hs.decryptAndHash(rest)
+	return err
+}
+
+// Split returns the send and receive ciphers once the handshake is done.
+func (hs *handshake) Split() (send, recv *cipherState) {
+	out := hkdf(hs.ck, nil, 2)
+	c1, c2 := newCipherState(out[0]), newCipherState(out[1])
+	if hs.initiator {
+		return c1, c2
+	}
+	return c2, c1
+}
+
+// hkdf is the Noise HKDF: HMAC-SHA256 with ck as the salt, n outputs.
+func hkdf(ck, ikm []byte, n int) [][]byte {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+
+	var out [][]byte
+	var prev []byte
+	for i := 1; i <= n; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(prev)
+		mac.Write([]byte{byte(i)})
+		prev = mac.Sum(nil)
+		out = append(out, prev)
+	}
+	return out
+}
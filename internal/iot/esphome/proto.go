@@ -0,0 +1,218 @@
+package esphome
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The native API is protobuf over a framed stream. The driver only needs a
+// handful of messages, so they are encoded and decoded by field number here
+// rather than through generated code. Field numbers follow ESPHome's api.proto.
+
+// Message types.
+const (
+	msgHelloRequest              = 1
+	msgHelloResponse             = 2
+	msgConnectRequest            = 3
+	msgConnectResponse           = 4
+	msgDisconnectRequest         = 5
+	msgDisconnectResponse        = 6
+	msgPingRequest               = 7
+	msgPingResponse              = 8
+	msgDeviceInfoRequest         = 9
+	msgDeviceInfoResponse        = 10
+	msgListEntitiesRequest       = 11
+	msgListEntitiesBinarySensor  = 12
+	msgListEntitiesLight         = 15
+	msgListEntitiesSensor        = 16
+	msgListEntitiesSwitch        = 17
+	msgListEntitiesTextSensor    = 18
+	msgListEntitiesDone          = 19
+	msgSubscribeStatesRequest    = 20
+	msgBinarySensorStateResponse = 21
+	msgLightStateResponse        = 24
+	msgSensorStateResponse       = 25
+	msgSwitchStateResponse       = 26
+	msgTextSensorStateResponse   = 27
+	msgLightCommandRequest       = 32
+	msgSwitchCommandRequest      = 33
+	msgGetTimeRequest            = 36
+	msgGetTimeResponse           = 37
+	msgListEntitiesNumber        = 49
+	msgNumberStateResponse       = 50
+	msgNumberCommandRequest      = 51
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformed = errors.New("malformed protobuf message")
+
+// pbWriter builds a message. Like proto3, zero values are left out.
+type pbWriter struct {
+	buf []byte
+}
+
+func (w *pbWriter) tag(field, wire int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (w *pbWriter) Uint(field int, v uint64) {
+	if v != 0 {
+		w.tag(field, wireVarint)
+		w.buf = binary.AppendUvarint(w.buf, v)
+	}
+}
+
+func (w *pbWriter) Bool(field int, v bool) {
+	if v {
+		w.Uint(field, 1)
+	}
+}
+
+func (w *pbWriter) String(field int, s string) {
+	if s != "" {
+		w.tag(field, wireBytes)
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+		w.buf = append(w.buf, s...)
+	}
+}
+
+func (w *pbWriter) Fixed32(field int, v uint32) {
+	if v != 0 {
+		w.tag(field, wireFixed32)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+	}
+}
+
+func (w *pbWriter) Float(field int, f float32) {
+	w.Fixed32(field, math.Float32bits(f))
+}
+
+// Packed writes a packed repeated varint field.
+func (w *pbWriter) Packed(field int, values []uint32) {
+	if len(values) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	w.tag(field, wireBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(packed)))
+	w.buf = append(w.buf, packed...)
+}
+
+func (w *pbWriter) Bytes() []byte {
+	return w.buf
+}
+
+type pbField struct {
+	wire  int
+	value uint64 // varint and fixed values
+	data  []byte // length-delimited values
+}
+
+// pbMessage is a decoded message: field number -> occurrences.
+type pbMessage map[int][]pbField
+
+func decodeMessage(data []byte) (pbMessage, error) {
+	m := pbMessage{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		data = data[n:]
+		f := pbField{wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errMalformed
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errMalformed
+			}
+			f.value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errMalformed
+			}
+			f.value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, errMalformed
+			}
+			f.data, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return nil, errMalformed
+		}
+		field := int(key >> 3)
+		m[field] = append(m[field], f)
+	}
+	return m, nil
+}
+
+func (m pbMessage) last(field int) (pbField, bool) {
+	fs := m[field]
+	if len(fs) == 0 {
+		return pbField{}, false
+	}
+	return fs[len(fs)-1], true
+}
+
+func (m pbMessage) Uint(field int) uint64 {
+	f, _ := m.last(field)
+	return f.value
+}
+
+func (m pbMessage) Int(field int) int {
+	return int(int32(m.Uint(field)))
+}
+
+func (m pbMessage) Bool(field int) bool {
+	return m.Uint(field) != 0
+}
+
+func (m pbMessage) Fixed32(field int) uint32 {
+	return uint32(m.Uint(field))
+}
+
+func (m pbMessage) Float(field int) float32 {
+	return math.Float32frombits(m.Fixed32(field))
+}
+
+func (m pbMessage) String(field int) string {
+	f, _ := m.last(field)
+	return string(f.data)
+}
+
+// Uints reads a repeated varint field, packed or not.
+func (m pbMessage) Uints(field int) []uint32 {
+	var values []uint32
+	for _, f := range m[field] {
+		if f.wire != wireBytes {
+			values = append(values, uint32(f.value))
+			continue
+		}
+		data := f.data
+		for len(data) > 0 {
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				break
+			}
+			values = append(values, uint32(v))
+			data = data[n:]
+		}
+	}
+	return values
+}
@@ -0,0 +1,238 @@
+package hue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"iot-bridge/internal/iot"
+)
+
+// callTimeout bounds a single request to the bridge.
+const callTimeout = 10 * time.Second
+
+// errLinkButton is returned by Pair until the bridge's link button has been
+// pressed.
+var errLinkButton = errors.New("link button not pressed")
+
+// Client talks to one bridge over HTTPS. Bridges present a certificate
+// signed by Signify's own CA for their bridge ID, which the system roots
+// don't include, so the certificate is not verified.
+type Client struct {
+	base   string
+	appKey string
+	http   *http.Client
+}
+
+func NewClient(host, appKey string) *Client {
+	transport := &http.Transport{
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		MaxIdleConnsPerHost: 4,
+	}
+	return &Client{
+		base:   "https://" + host,
+		appKey: appKey,
+		http:   &http.Client{Transport: transport},
+	}
+}
+
+// BridgeInfo is the unauthenticated part of the bridge configuration.
+type BridgeInfo struct {
+	Name     string `json:"name"`
+	BridgeID string `json:"bridgeid"`
+	ModelID  string `json:"modelid"`
+	SWVer    string `json:"swversion"`
+}
+
+// Config reads the bridge's name and ID, which needs no app key.
+func (c *Client) Config(ctx context.Context) (BridgeInfo, error) {
+	var cfg BridgeInfo
+	err := c.do(ctx, http.MethodGet, "/api/0/config", nil, &cfg)
+	if err == nil && cfg.BridgeID == "" {
+		err = errors.New("bridge did not report its ID")
+	}
+	return cfg, err
+}
+
+// Pair creates an app key. It fails with errLinkButton until the link
+// button on the bridge is pressed; the bridge then accepts pairing for 30
+// seconds.
+func (c *Client) Pair(ctx context.Context, deviceType string) (string, error) {
+	body := map[string]interface{}{"devicetype": deviceType, "generateclientkey": true}
+	var resp []struct {
+		Success *struct {
+			Username string `json:"username"`
+		} `json:"success"`
+		Error *struct {
+			Type        int    `json:"type"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api", body, &resp); err != nil {
+		return "", err
+	}
+	for _, r := range resp {
+		switch {
+		case r.Success != nil && r.Success.Username != "":
+			return r.Success.Username, nil
+		case r.Error != nil && r.Error.Type == 101:
+			return "", errLinkButton
+		case r.Error != nil:
+			return "", errors.New(r.Error.Description)
+		}
+	}
+	return "", errors.New("unexpected pairing response")
+}
+
+// Resources reads every CLIP v2 resource.
+func (c *Client) Resources(ctx context.Context) ([]*resource, error) {
+	var resp struct {
+		Data   []*resource `json:"data"`
+		Errors []apiError  `json:"errors"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/clip/v2/resource", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Put changes a resource, e.g. a light's on and dimming state.
+func (c *Client) Put(ctx context.Context, rtype, id string, body interface{}) error {
+	var resp struct {
+		Errors []apiError `json:"errors"`
+	}
+	if err := c.do(ctx, http.MethodPut, "/clip/v2/resource/"+rtype+"/"+id, body, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return iot.Errorf(iot.InvalidValue, "%s", resp.Errors[0].Description)
+	}
+	return nil
+}
+
+type apiError struct {
+	Description string `json:"description"`
+}
+
+// Events reads the event stream until ctx ends or the bridge closes it,
+// calling handle for every event. Bridges send a batch of events at most
+// once a second.
+func (c *Client) Events(ctx context.Context, handle func(event)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/eventstream/clip/v2", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("hue-application-key", c.appKey)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return iot.Errorf(iot.TransportError, "%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	// Server-sent events: "data:" lines up to a blank line form one message,
+	// a JSON array of events.
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue // id:, event: and comment lines
+		}
+		var events []event
+		if err := json.Unmarshal([]byte(data.String()), &events); err == nil {
+			for _, ev := range events {
+				handle(ev)
+			}
+		}
+		data.Reset()
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return iot.Errorf(iot.TransportError, "event stream: %v", err)
+	}
+	return errors.New("event stream closed")
+}
+
+// event is one entry of the event stream. Data holds the changed fields of
+// each resource for updates, whole resources for adds and IDs for deletes.
+type event struct {
+	Type string            `json:"type"` // add, update, delete or error
+	Data []json.RawMessage `json:"data"`
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.appKey != "" {
+		req.Header.Set("hue-application-key", c.appKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return iot.Errorf(iot.Timeout, "%s %s: %v", method, path, err)
+		}
+		return iot.Errorf(iot.TransportError, "%v", err)
+	}
+	defer resp.Body.Close()
+	// CLIP v2 answers 207 when some changes of a PUT were applied.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMultiStatus {
+		return statusError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusError maps an HTTP error status to an error kind, using the first
+// CLIP v2 error description when there is one.
+func statusError(resp *http.Response) error {
+	msg := resp.Status
+	var body struct {
+		Errors []apiError `json:"errors"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body) == nil && len(body.Errors) > 0 {
+		msg = body.Errors[0].Description
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return iot.Errorf(iot.NotFound, "%s", msg)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return iot.Errorf(iot.TransportError, "app key rejected: %s", msg)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return iot.Errorf(iot.TransportError, "%s", msg)
+	default:
+		return iot.Errorf(iot.InvalidValue, "%s", msg)
+	}
+}
@@ -0,0 +1,269 @@
+package hue
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeBridge is a scripted Hue bridge for developing and testing the driver
+// without hardware. It serves the config and pairing endpoints of the v1 API
+// and the CLIP v2 resource and event stream endpoints over HTTPS, applies
+// PUTs to its resources and reports every change on the event stream the
+// way a real bridge does.
+type FakeBridge struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	bridgeID  string
+	appKey    string
+	linked    bool
+	resources []map[string]interface{}
+	streams   map[chan []byte]bool
+	// Puts records the path and body of every PUT received.
+	Puts []string
+}
+
+// NewFakeBridge starts a bridge with the given CLIP v2 resources, e.g.
+// {"id": "...", "type": "light", "on": {"on": true}}. Pairing fails until
+// PressLinkButton is called.
+func NewFakeBridge(bridgeID string, resources []map[string]interface{}) *FakeBridge {
+	f := &FakeBridge{
+		bridgeID:  bridgeID,
+		appKey:    "fake-app-key-" + strings.ToLower(bridgeID),
+		resources: resources,
+		streams:   make(map[chan []byte]bool),
+	}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Host returns the host:port to configure the driver with.
+func (f *FakeBridge) Host() string {
+	return strings.TrimPrefix(f.Server.URL, "https://")
+}
+
+// AppKey is the key the bridge hands out when paired.
+func (f *FakeBridge) AppKey() string {
+	return f.appKey
+}
+
+// PressLinkButton lets the next pairing request succeed.
+func (f *FakeBridge) PressLinkButton() {
+	f.mu.Lock()
+	f.linked = true
+	f.mu.Unlock()
+}
+
+// Update merges fields into a resource and sends them as an update event,
+// like a bridge reporting a change made by a switch or the Hue app.
+func (f *FakeBridge) Update(id string, fields map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.find(id)
+	if r == nil {
+		return
+	}
+	merge(r, fields)
+	f.emit("update", r["type"].(string), id, fields)
+}
+
+// Add adds a resource and sends an add event.
+func (f *FakeBridge) Add(r map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resources = append(f.resources, r)
+	f.broadcast([]map[string]interface{}{{
+		"id": fmt.Sprint(time.Now().UnixNano()), "type": "add",
+		"creationtime": time.Now().UTC().Format(time.RFC3339), "data": []interface{}{r},
+	}})
+}
+
+// Close ends the event streams and stops the server.
+func (f *FakeBridge) Close() {
+	f.mu.Lock()
+	for ch := range f.streams {
+		close(ch)
+		delete(f.streams, ch)
+	}
+	f.mu.Unlock()
+	f.Server.CloseClientConnections()
+	f.Server.Close()
+}
+
+func (f *FakeBridge) serve(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/0/config":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name": "Fake Hue Bridge", "bridgeid": f.bridgeID, "modelid": "BSB002",
+			"swversion": "1967054020", "apiversion": "1.67.0",
+		})
+	case r.URL.Path == "/api" && r.Method == http.MethodPost:
+		f.pair(w)
+	case r.Header.Get("hue-application-key") != f.appKey:
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"data": []interface{}{}, "errors": []interface{}{map[string]string{"description": "unauthorized user"}},
+		})
+	case r.URL.Path == "/clip/v2/resource" && r.Method == http.MethodGet:
+		f.mu.Lock()
+		data, _ := json.Marshal(map[string]interface{}{"data": f.resources, "errors": []interface{}{}})
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case strings.HasPrefix(r.URL.Path, "/clip/v2/resource/") && r.Method == http.MethodPut:
+		f.put(w, r)
+	case r.URL.Path == "/eventstream/clip/v2":
+		f.stream(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeBridge) pair(w http.ResponseWriter) {
+	f.mu.Lock()
+	linked := f.linked
+	f.mu.Unlock()
+	if !linked {
+		writeJSON(w, http.StatusOK, []interface{}{map[string]interface{}{
+			"error": map[string]interface{}{"type": 101, "address": "", "description": "link button not pressed"},
+		}})
+		return
+	}
+	writeJSON(w, http.StatusOK, []interface{}{map[string]interface{}{
+		"success": map[string]string{"username": f.appKey, "clientkey": "00000000000000000000000000000000"},
+	}})
+}
+
+func (f *FakeBridge) put(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/clip/v2/resource/"), "/")
+	var fields map[string]interface{}
+	if len(parts) != 2 || json.NewDecoder(r.Body).Decode(&fields) != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"data": []interface{}{}, "errors": []interface{}{map[string]string{"description": "invalid body"}},
+		})
+		return
+	}
+	rtype, id := parts[0], parts[1]
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := json.Marshal(fields)
+	f.Puts = append(f.Puts, r.URL.Path+" "+string(body))
+	res := f.find(id)
+	if res == nil || res["type"] != rtype {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"data": []interface{}{}, "errors": []interface{}{map[string]string{"description": "Not Found"}},
+		})
+		return
+	}
+	// Setting a color temperature makes it valid, setting a color ends it.
+	if _, ok := fields["color_temperature"]; ok {
+		merge(fields, map[string]interface{}{"color_temperature": map[string]interface{}{"mirek_valid": true}})
+	}
+	if _, ok := fields["color"]; ok {
+		fields["color_temperature"] = map[string]interface{}{"mirek": nil, "mirek_valid": false}
+	}
+	merge(res, fields)
+	f.emit("update", rtype, id, fields)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": []interface{}{map[string]string{"rid": id, "rtype": rtype}}, "errors": []interface{}{},
+	})
+}
+
+// stream serves the event stream until the client goes away or the bridge
+// is closed.
+func (f *FakeBridge) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan []byte, 16)
+	f.mu.Lock()
+	f.streams[ch] = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		if f.streams[ch] {
+			delete(f.streams, ch)
+			close(ch)
+		}
+		f.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": hi\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "id: %d:0\ndata: %s\n\n", time.Now().Unix(), msg)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// emit sends an event for one resource. The caller holds f.mu.
+func (f *FakeBridge) emit(kind, rtype, id string, fields map[string]interface{}) {
+	data := map[string]interface{}{"id": id, "type": rtype}
+	for k, v := range fields {
+		data[k] = v
+	}
+	if r := f.find(id); r != nil && r["owner"] != nil {
+		data["owner"] = r["owner"]
+	}
+	f.broadcast([]map[string]interface{}{{
+		"id": fmt.Sprint(time.Now().UnixNano()), "type": kind,
+		"creationtime": time.Now().UTC().Format(time.RFC3339), "data": []interface{}{data},
+	}})
+}
+
+// broadcast sends a message to every stream. The caller holds f.mu.
+func (f *FakeBridge) broadcast(events []map[string]interface{}) {
+	msg, _ := json.Marshal(events)
+	for ch := range f.streams {
+		select {
+		case ch <- msg:
+		default: // slow reader; a real bridge drops events too
+		}
+	}
+}
+
+// find returns the resource with the given ID. The caller holds f.mu.
+func (f *FakeBridge) find(id string) map[string]interface{} {
+	for _, r := range f.resources {
+		if r["id"] == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// merge deep-merges src into dst.
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sub, ok := v.(map[string]interface{})
+		existing, isMap := dst[k].(map[string]interface{})
+		if ok && isMap {
+			merge(existing, sub)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,544 @@
+package hue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// pairInterval is how often pairing is retried while waiting for the link
+// button; the bridge accepts pairing for 30 seconds after it is pressed.
+const pairInterval = 2 * time.Second
+
+// BridgeConfig is an entry of HUE_BRIDGES_FILE. Without an app_key the
+// driver pairs with the bridge once its link button is pressed and writes
+// the new key back to the file.
+type BridgeConfig struct {
+	Host   string `json:"host"`
+	AppKey string `json:"app_key,omitempty"`
+}
+
+type bridge struct {
+	cfg    BridgeConfig
+	client *Client
+	id     string // store ID of the bridge, set once it answered
+	paired bool
+	online bool
+
+	resources map[string]*resource
+	devices   map[string]mapped      // by store ID
+	states    map[string]store.State // last state written per store ID
+}
+
+// HueDriver keeps every configured bridge's event stream open, mirrors
+// lights, room and zone groups and sensors into the store and sends light
+// commands as CLIP v2 PUT requests.
+type HueDriver struct {
+	mu       sync.Mutex
+	bridges  []*bridge
+	byDevice map[string]*bridge // store ID -> bridge, including the bridge itself
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup // the connection loops
+}
+
+var driver *HueDriver
+
+func init() {
+	iot.Register("hue", func() iot.Driver {
+		driver = NewDriver()
+		return driver
+	})
+}
+
+func NewDriver() *HueDriver {
+	return &HueDriver{
+		byDevice: make(map[string]*bridge),
+		stop:     make(chan struct{}),
+	}
+}
+
+func GetDriver() *HueDriver {
+	return driver
+}
+
+// Start connects to every bridge in HUE_BRIDGES_FILE in the background.
+func (d *HueDriver) Start() error {
+	bridges, err := config.LoadJSONList[BridgeConfig](config.HueBridgesFile)
+	if err != nil {
+		log.Printf("[Hue] Failed to load %s: %v", config.HueBridgesFile, err)
+	}
+	for _, cfg := range bridges {
+		d.AddBridge(cfg)
+	}
+	return nil
+}
+
+// AddBridge starts keeping a connection to a bridge, pairing first if cfg
+// has no app key.
+func (d *HueDriver) AddBridge(cfg BridgeConfig) {
+	if cfg.Host == "" {
+		log.Printf("[Hue] Skipping bridge without host")
+		return
+	}
+	b := &bridge{cfg: cfg, client: NewClient(cfg.Host, cfg.AppKey), paired: cfg.AppKey != ""}
+	d.mu.Lock()
+	d.bridges = append(d.bridges, b)
+	d.mu.Unlock()
+	d.wg.Add(1)
+	go d.run(b)
+}
+
+// Adopt pairs with a bridge found by a wifi scan, unless it is already
+// configured. Pairing completes once the bridge's link button is pressed.
+func (d *HueDriver) Adopt(found store.DiscoveredDevice) error {
+	if found.Host == "" {
+		return iot.Errorf(iot.InvalidValue, "no address for %s", found.ID)
+	}
+	host := found.Host
+	// mDNS reports the HTTPS port; SSDP the port of the description.
+	if found.Port != 0 && found.Port != 80 && found.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(found.Port))
+	}
+	d.mu.Lock()
+	for _, b := range d.bridges {
+		if b.cfg.Host == host || b.id == found.ID {
+			d.mu.Unlock()
+			return nil
+		}
+	}
+	d.mu.Unlock()
+	d.AddBridge(BridgeConfig{Host: host})
+	d.saveBridges()
+	return nil
+}
+
+// Stop ends the connection loops and waits for them to return.
+func (d *HueDriver) Stop() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	d.wg.Wait()
+	return nil
+}
+
+func (d *HueDriver) Health() iot.Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.bridges) == 0 {
+		return iot.Health{Status: iot.HealthDisabled, Detail: "no Hue bridges configured"}
+	}
+	var unpaired, down []string
+	for _, b := range d.bridges {
+		switch {
+		case !b.paired:
+			unpaired = append(unpaired, b.cfg.Host)
+		case !b.online:
+			down = append(down, b.cfg.Host)
+		}
+	}
+	var details []string
+	if len(unpaired) > 0 {
+		details = append(details, "press the link button to pair "+strings.Join(unpaired, ", "))
+	}
+	if len(down) > 0 {
+		details = append(details, "not connected to "+strings.Join(down, ", "))
+	}
+	if len(details) > 0 {
+		return iot.Health{Status: iot.HealthDegraded, Detail: strings.Join(details, "; ")}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+// saveBridges writes the bridges and their app keys to HUE_BRIDGES_FILE.
+func (d *HueDriver) saveBridges() {
+	d.mu.Lock()
+	bridges := make([]BridgeConfig, 0, len(d.bridges))
+	for _, b := range d.bridges {
+		bridges = append(bridges, b.cfg)
+	}
+	d.mu.Unlock()
+
+	data, _ := json.MarshalIndent(bridges, "", "  ")
+	if err := os.WriteFile(config.HueBridgesFile, append(data, '\n'), 0600); err != nil {
+		log.Printf("[Hue] Failed to save %s: %v", config.HueBridgesFile, err)
+	}
+}
+
+// run keeps the bridge's event stream open, pairing and importing its
+// resources first, and reconnects with backoff.
+func (d *HueDriver) run(b *bridge) {
+	defer d.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-d.stop
+		cancel()
+	}()
+
+	backoff := time.Second
+	for {
+		err := d.connect(ctx, b)
+		if err == nil {
+			backoff = time.Second
+			log.Printf("[Hue] Connected to %s", b.cfg.Host)
+			err = b.client.Events(ctx, func(ev event) { d.handleEvent(b, ev) })
+			d.setOnline(b, false)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := backoff
+		if errors.Is(err, errLinkButton) {
+			wait = pairInterval
+		} else {
+			log.Printf("[Hue] Connection to %s failed: %v (retrying in %s)", b.cfg.Host, err, backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// connect identifies and if needed pairs with the bridge, then imports its
+// resources.
+func (d *HueDriver) connect(ctx context.Context, b *bridge) error {
+	d.mu.Lock()
+	id, paired := b.id, b.paired
+	d.mu.Unlock()
+
+	if id == "" {
+		info, err := b.client.Config(ctx)
+		if err != nil {
+			return err
+		}
+		id = "hue-" + strings.ToLower(info.BridgeID)
+		d.mu.Lock()
+		b.id = id
+		d.byDevice[id] = b
+		d.mu.Unlock()
+		d.registerBridge(b, info)
+	}
+
+	if !paired {
+		appKey, err := b.client.Pair(ctx, deviceType())
+		if errors.Is(err, errLinkButton) {
+			log.Printf("[Hue] Press the link button on the bridge at %s to pair", b.cfg.Host)
+		}
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		b.cfg.AppKey = appKey
+		b.client = NewClient(b.cfg.Host, appKey)
+		b.paired = true
+		d.mu.Unlock()
+		log.Printf("[Hue] Paired with %s", b.cfg.Host)
+		d.saveBridges()
+		factory.GetDeviceStore().UpdateState(id, store.State{"paired": true})
+	}
+
+	list, err := b.client.Resources(ctx)
+	if err != nil {
+		return err
+	}
+	resources := make(map[string]*resource, len(list))
+	for _, r := range list {
+		resources[r.ID] = r
+	}
+	d.mu.Lock()
+	b.resources = resources
+	d.mu.Unlock()
+	d.rebuild(b)
+	d.setOnline(b, true)
+	return nil
+}
+
+// deviceType names the app key, "<app>#<device>" with at most 19
+// characters for the device.
+func deviceType() string {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "bridge"
+	}
+	if len(host) > 19 {
+		host = host[:19]
+	}
+	return "iot-bridge#" + host
+}
+
+// registerBridge adds the bridge itself to the store, so it can be found
+// and its pairing followed.
+func (d *HueDriver) registerBridge(b *bridge, info BridgeInfo) {
+	d.mu.Lock()
+	id, paired := b.id, b.paired
+	d.mu.Unlock()
+
+	ds := factory.GetDeviceStore()
+	if _, found := ds.Get(id); !found {
+		name := info.Name
+		if name == "" {
+			name = "Hue Bridge"
+		}
+		device := store.Device{
+			ID:           id,
+			Name:         name,
+			Type:         "bridge",
+			Protocol:     "hue",
+			Room:         "unknown",
+			Manufacturer: "Signify",
+			Model:        info.ModelID,
+			State:        store.State{},
+		}
+		if err := ds.Add(device); err != nil {
+			log.Printf("[Hue] Failed to add bridge %s: %v", id, err)
+			return
+		}
+		log.Printf("[Hue] Registered bridge %s (%s)", name, id)
+	}
+	ds.UpdateState(id, store.State{"paired": paired})
+}
+
+// rebuild maps the cached resources to store devices and registers them.
+// It runs after the import and whenever devices, rooms or zones change.
+func (d *HueDriver) rebuild(b *bridge) {
+	d.mu.Lock()
+	devices := buildDevices(b.resources)
+	previous := b.devices
+	b.devices = make(map[string]mapped, len(devices))
+	if b.states == nil {
+		b.states = make(map[string]store.State)
+	}
+	for _, m := range devices {
+		b.devices[m.device.ID] = m
+		d.byDevice[m.device.ID] = b
+	}
+	d.mu.Unlock()
+
+	for _, m := range devices {
+		old, known := previous[m.device.ID]
+		d.register(m, known && old.device.Room != m.device.Room)
+		d.updateState(b, m)
+	}
+	d.updateAvailability(b)
+}
+
+// register adds the device to the store, or refreshes the stored one. Rooms
+// changed on the bridge while connected replace the stored room.
+func (d *HueDriver) register(m mapped, roomChanged bool) {
+	added, err := iot.RegisterDevice(m.device)
+	if err != nil {
+		log.Printf("[Hue] Failed to register device %s: %v", m.device.ID, err)
+		return
+	}
+	if added {
+		log.Printf("[Hue] Registered %s (%s, %s)", m.device.Name, m.device.ID, m.device.Room)
+		return
+	}
+	if roomChanged {
+		ds := factory.GetDeviceStore()
+		if existing, found := ds.Get(m.device.ID); found {
+			existing.Room = m.device.Room
+			if err := ds.Add(existing); err != nil {
+				log.Printf("[Hue] Failed to move device %s: %v", m.device.ID, err)
+			}
+		}
+	}
+}
+
+// updateState writes the state values of a device that changed.
+func (d *HueDriver) updateState(b *bridge, m mapped) {
+	d.mu.Lock()
+	state := m.state(b.resources)
+	last := b.states[m.device.ID]
+	changes := store.State{}
+	for k, v := range state {
+		if last == nil || fmt.Sprint(last[k]) != fmt.Sprint(v) {
+			changes[k] = v
+		}
+	}
+	b.states[m.device.ID] = state
+	d.mu.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+	if err := factory.GetDeviceStore().UpdateState(m.device.ID, changes); err != nil {
+		log.Printf("[Hue] Failed to update state for %s: %v", m.device.ID, err)
+	}
+}
+
+// updateAvailability reports every device of the bridge online or offline:
+// offline while the bridge is unreachable, otherwise from the Zigbee
+// connectivity of the Hue device behind it.
+func (d *HueDriver) updateAvailability(b *bridge) {
+	d.mu.Lock()
+	online := b.online
+	connectivity := map[string]string{} // Hue device ID -> status
+	for _, r := range b.resources {
+		if r.Type == "zigbee_connectivity" && r.Owner != nil {
+			connectivity[r.Owner.RID] = r.Status
+		}
+	}
+	availability := make(map[string]string, len(b.devices))
+	for id, m := range b.devices {
+		status, tracked := connectivity[m.owner]
+		switch {
+		case !online:
+			availability[id] = store.Offline
+		case tracked && status != "connected":
+			availability[id] = store.Offline
+		default:
+			availability[id] = store.Online
+		}
+	}
+	d.mu.Unlock()
+
+	ds := factory.GetDeviceStore()
+	for id, a := range availability {
+		ds.UpdateStatus(id, store.DeviceStatus{Availability: a})
+	}
+}
+
+func (d *HueDriver) setOnline(b *bridge, online bool) {
+	d.mu.Lock()
+	b.online = online
+	d.mu.Unlock()
+	d.updateAvailability(b)
+}
+
+// handleEvent applies an event from the stream. Updates carry only the
+// changed fields and are merged into the cached resources; adds, deletes and
+// changes to devices, rooms and zones rebuild the device mapping.
+func (d *HueDriver) handleEvent(b *bridge, ev event) {
+	structural := false
+	changed := map[string]bool{}
+	connectivity := false
+
+	d.mu.Lock()
+	for _, raw := range ev.Data {
+		var head struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(raw, &head) != nil || head.ID == "" {
+			continue
+		}
+		switch ev.Type {
+		case "add":
+			r := &resource{}
+			if json.Unmarshal(raw, r) == nil {
+				b.resources[r.ID] = r
+				structural = true
+			}
+		case "delete":
+			delete(b.resources, head.ID)
+			structural = true
+		case "update":
+			r := b.resources[head.ID]
+			if r == nil || json.Unmarshal(raw, r) != nil {
+				continue
+			}
+			changed[head.ID] = true
+			switch r.Type {
+			case "device", "room", "zone":
+				structural = true
+			case "zigbee_connectivity":
+				connectivity = true
+			}
+		}
+	}
+	var affected []mapped
+	for _, m := range b.devices {
+		for _, id := range m.sources {
+			if changed[id] {
+				affected = append(affected, m)
+				break
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	if structural {
+		d.rebuild(b)
+		return
+	}
+	for _, m := range affected {
+		d.updateState(b, m)
+		now := time.Now()
+		factory.GetDeviceStore().UpdateStatus(m.device.ID, store.DeviceStatus{LastSeen: &now})
+	}
+	if connectivity {
+		d.updateAvailability(b)
+	}
+}
+
+func (d *HueDriver) lookup(id string) (*bridge, mapped, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.byDevice[id]
+	if !ok {
+		return nil, mapped{}, false
+	}
+	return b, b.devices[id], true
+}
+
+func (d *HueDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	b, m, ok := d.lookup(device.ID)
+	if !ok {
+		return nil, iot.Errorf(iot.Unsupported, "unknown Hue device %s", device.ID)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if device.ID == b.id {
+		return store.State{"paired": b.paired}, nil
+	}
+	return m.state(b.resources), nil
+}
+
+// SetState sends the updates as one PUT to the light or group. The bridge
+// reports the new state on the event stream, which updates the store.
+func (d *HueDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	b, m, ok := d.lookup(device.ID)
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "unknown Hue device %s", device.ID)
+	}
+	d.mu.Lock()
+	r, client, online := b.resources[m.rid], b.client, b.online
+	d.mu.Unlock()
+	if m.rtype == "" || r == nil {
+		return iot.Errorf(iot.Unsupported, "%s is read-only", device.ID)
+	}
+	if !online {
+		return iot.Errorf(iot.TransportError, "not connected to the bridge at %s", b.cfg.Host)
+	}
+
+	d.mu.Lock()
+	body, err := command(r, updates)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := client.Put(ctx, m.rtype, m.rid, body); err != nil {
+		return fmt.Errorf("%s: %w", device.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package hue
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+const bridgeDevice = "hue-001788fffe000001"
+
+// testResources is a bridge with a color lamp and a motion sensor in the
+// living room, plus the room's group and the group of all lights.
+func testResources() []map[string]interface{} {
+	var resources []map[string]interface{}
+	err := json.Unmarshal([]byte(`[
+		{"id": "dev-lamp", "type": "device",
+		 "metadata": {"name": "Lamp"},
+		 "product_data": {"manufacturer_name": "Signify Netherlands B.V.", "product_name": "Hue color lamp", "model_id": "LCA001"},
+		 "services": [{"rid": "light-1", "rtype": "light"}, {"rid": "zc-1", "rtype": "zigbee_connectivity"}]},
+		{"id": "light-1", "type": "light", "owner": {"rid": "dev-lamp", "rtype": "device"},
+		 "metadata": {"name": "Sofa lamp"},
+		 "on": {"on": true}, "dimming": {"brightness": 50},
+		 "color": {"xy": {"x": 0.4, "y": 0.4}},
+		 "color_temperature": {"mirek": 300, "mirek_valid": true, "mirek_schema": {"mirek_minimum": 153, "mirek_maximum": 454}}},
+		{"id": "zc-1", "type": "zigbee_connectivity", "owner": {"rid": "dev-lamp", "rtype": "device"}, "status": "connected"},
+		{"id": "dev-motion", "type": "device",
+		 "metadata": {"name": "Hallway sensor"},
+		 "product_data": {"manufacturer_name": "Signify Netherlands B.V.", "product_name": "Hue motion sensor", "model_id": "SML001"},
+		 "services": [{"rid": "mo-1", "rtype": "motion"}, {"rid": "temp-1", "rtype": "temperature"}, {"rid": "pow-1", "rtype": "device_power"}]},
+		{"id": "mo-1", "type": "motion", "owner": {"rid": "dev-motion", "rtype": "device"}, "motion": {"motion": false, "motion_valid": true}},
+		{"id": "temp-1", "type": "temperature", "owner": {"rid": "dev-motion", "rtype": "device"}, "temperature": {"temperature": 21.46, "temperature_valid": true}},
+		{"id": "pow-1", "type": "device_power", "owner": {"rid": "dev-motion", "rtype": "device"}, "power_state": {"battery_level": 90}},
+		{"id": "room-1", "type": "room", "metadata": {"name": "Living room"},
+		 "children": [{"rid": "dev-lamp", "rtype": "device"}, {"rid": "dev-motion", "rtype": "device"}]},
+		{"id": "gl-1", "type": "grouped_light", "owner": {"rid": "room-1", "rtype": "room"}, "on": {"on": true}, "dimming": {"brightness": 50}},
+		{"id": "gl-home", "type": "grouped_light", "owner": {"rid": "home-1", "rtype": "bridge_home"}, "on": {"on": true}}
+	]`), &resources)
+	if err != nil {
+		panic(err)
+	}
+	return resources
+}
+
+// startDriver connects a driver to a fake bridge and waits for the lamp.
+// Without an app key the driver has to pair first; it then waits for the
+// bridge only.
+func startDriver(t *testing.T, withKey bool) (*HueDriver, *FakeBridge) {
+	t.Helper()
+	testutil.UseMemoryStore()
+	saved := config.HueBridgesFile
+	config.HueBridgesFile = filepath.Join(t.TempDir(), "hue-bridges.json")
+	t.Cleanup(func() { config.HueBridgesFile = saved })
+
+	fake := NewFakeBridge("001788FFFE000001", testResources())
+	t.Cleanup(fake.Close)
+	d := NewDriver()
+	cfg := BridgeConfig{Host: fake.Host()}
+	wait := bridgeDevice
+	if withKey {
+		cfg.AppKey = fake.AppKey()
+		wait = "hue-light-1"
+	}
+	d.AddBridge(cfg)
+	t.Cleanup(func() { d.Stop() })
+
+	testutil.WaitFor(t, wait+" registered", func() bool {
+		_, ok := factory.GetDeviceStore().Get(wait)
+		return ok
+	})
+	return d, fake
+}
+
+func TestDiscoveryRegistersDevices(t *testing.T) {
+	startDriver(t, true)
+	ds := factory.GetDeviceStore()
+	testutil.WaitFor(t, "sensor registered", func() bool {
+		_, ok := ds.Get("hue-dev-motion")
+		return ok
+	})
+
+	lamp, _ := ds.Get("hue-light-1")
+	if lamp.Name != "Sofa lamp" || lamp.Type != "light" || lamp.Room != "Living room" ||
+		lamp.Manufacturer != "Signify Netherlands B.V." || lamp.Model != "Hue color lamp" {
+		t.Errorf("lamp = %+v", lamp)
+	}
+	if lamp.State["state"] != "on" || lamp.State["brightness"] != 50 || lamp.State["color_temp"] != 300 {
+		t.Errorf("lamp state = %v", lamp.State)
+	}
+	if lamp.Availability != store.Online {
+		t.Errorf("lamp availability = %q", lamp.Availability)
+	}
+
+	group, _ := ds.Get("hue-gl-1")
+	if group.Name != "Living room" || group.Type != "light_group" || group.Room != "Living room" {
+		t.Errorf("group = %+v", group)
+	}
+	if _, ok := ds.Get("hue-gl-home"); ok {
+		t.Error("the group of all lights was registered")
+	}
+
+	sensor, _ := ds.Get("hue-dev-motion")
+	if sensor.Type != "sensor" || sensor.Room != "Living room" || sensor.Model != "Hue motion sensor" {
+		t.Errorf("sensor = %+v", sensor)
+	}
+	if sensor.State["motion"] != false || sensor.State["temperature"] != 21.5 || sensor.State["battery"] != 90 {
+		t.Errorf("sensor state = %v", sensor.State)
+	}
+
+	bridge, _ := ds.Get(bridgeDevice)
+	if bridge.Type != "bridge" || bridge.State["paired"] != true {
+		t.Errorf("bridge = %+v", bridge)
+	}
+}
+
+func TestPairingWaitsForLinkButton(t *testing.T) {
+	_, fake := startDriver(t, false)
+	ds := factory.GetDeviceStore()
+
+	if got := testutil.StoredState(bridgeDevice, "paired"); got != false {
+		t.Errorf("paired = %v before the link button was pressed", got)
+	}
+	if _, ok := ds.Get("hue-light-1"); ok {
+		t.Error("lights were imported before pairing")
+	}
+
+	fake.PressLinkButton()
+	// Allow for one pairing retry.
+	testutil.WaitForWithin(t, 2*pairInterval+time.Second, "lamp registered", func() bool {
+		_, ok := ds.Get("hue-light-1")
+		return ok
+	})
+	if got := testutil.StoredState(bridgeDevice, "paired"); got != true {
+		t.Errorf("paired = %v after pairing", got)
+	}
+
+	data, err := os.ReadFile(config.HueBridgesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []BridgeConfig
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].Host != fake.Host() || saved[0].AppKey != fake.AppKey() {
+		t.Errorf("saved bridges = %+v", saved)
+	}
+}
+
+func TestDiscoveryKeepsUserEdits(t *testing.T) {
+	testutil.UseMemoryStore()
+	factory.GetDeviceStore().Add(store.Device{ID: "hue-light-1", Name: "Reading lamp", Room: "Office", Protocol: "hue"})
+
+	fake := NewFakeBridge("001788FFFE000001", testResources())
+	defer fake.Close()
+	d := NewDriver()
+	d.AddBridge(BridgeConfig{Host: fake.Host(), AppKey: fake.AppKey()})
+	defer d.Stop()
+
+	testutil.WaitFor(t, "capabilities refreshed", func() bool {
+		device, _ := factory.GetDeviceStore().Get("hue-light-1")
+		return len(device.Capabilities) > 0
+	})
+	device, _ := factory.GetDeviceStore().Get("hue-light-1")
+	if device.Name != "Reading lamp" || device.Room != "Office" {
+		t.Errorf("name, room = %q, %q, want user edits kept", device.Name, device.Room)
+	}
+	if device.Type != "light" {
+		t.Errorf("type = %q, want light", device.Type)
+	}
+}
+
+func TestSetStateRoundTrip(t *testing.T) {
+	d, fake := startDriver(t, true)
+	ds := factory.GetDeviceStore()
+	lamp, _ := ds.Get("hue-light-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.SetState(ctx, lamp, store.State{"brightness": 80, "rgb": []interface{}{255, 0, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	puts := append([]string(nil), fake.Puts...)
+	fake.mu.Unlock()
+	if len(puts) != 1 || !strings.HasPrefix(puts[0], "/clip/v2/resource/light/light-1 ") ||
+		!strings.Contains(puts[0], `"dimming":{"brightness":80}`) || !strings.Contains(puts[0], `"color":{"xy":`) {
+		t.Fatalf("PUTs = %v", puts)
+	}
+	testutil.WaitFor(t, "brightness 80", func() bool { return testutil.StoredState("hue-light-1", "brightness") == 80 })
+	testutil.WaitFor(t, "rgb replaces color_temp", func() bool {
+		rgb, ok := testutil.StoredState("hue-light-1", "rgb").([]interface{})
+		return ok && rgb[0] == 255
+	})
+
+	group, _ := ds.Get("hue-gl-1")
+	if err := d.SetState(ctx, group, store.State{"state": "off"}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, "group off", func() bool { return testutil.StoredState("hue-gl-1", "state") == "off" })
+
+	if err := d.SetState(ctx, lamp, store.State{"color_temp": 100}); iot.KindOf(err) != iot.InvalidValue {
+		t.Errorf("out of range color_temp: err = %v, want invalid value", err)
+	}
+	sensor, _ := ds.Get("hue-dev-motion")
+	if err := d.SetState(ctx, sensor, store.State{"motion": true}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("writing a sensor: err = %v, want unsupported", err)
+	}
+}
+
+func TestEventsUpdateStore(t *testing.T) {
+	_, fake := startDriver(t, true)
+	ds := factory.GetDeviceStore()
+
+	fake.Update("mo-1", map[string]interface{}{"motion": map[string]interface{}{"motion": true, "motion_valid": true}})
+	testutil.WaitFor(t, "motion", func() bool { return testutil.StoredState("hue-dev-motion", "motion") == true })
+
+	fake.Update("zc-1", map[string]interface{}{"status": "connectivity_issue"})
+	testutil.WaitFor(t, "lamp offline", func() bool {
+		device, _ := ds.Get("hue-light-1")
+		return device.Availability == store.Offline
+	})
+
+	fake.Update("room-1", map[string]interface{}{"metadata": map[string]interface{}{"name": "Lounge"}})
+	testutil.WaitFor(t, "lamp moved", func() bool {
+		device, _ := ds.Get("hue-light-1")
+		return device.Room == "Lounge"
+	})
+
+	fake.Add(map[string]interface{}{
+		"id": "light-2", "type": "light", "metadata": map[string]interface{}{"name": "Desk lamp"},
+		"on": map[string]interface{}{"on": false},
+	})
+	testutil.WaitFor(t, "new light registered", func() bool { return testutil.StoredState("hue-light-2", "state") == "off" })
+}
+
+func TestCommand(t *testing.T) {
+	var lamp, plug *resource
+	for _, raw := range testResources() {
+		data, _ := json.Marshal(raw)
+		r := &resource{}
+		json.Unmarshal(data, r)
+		if r.ID == "light-1" {
+			lamp = r
+		}
+	}
+	plug = &resource{ID: "light-3", Type: "light", On: &onState{}}
+
+	tests := []struct {
+		name    string
+		r       *resource
+		updates store.State
+		want    string
+		kind    iot.ErrorKind
+	}{
+		{"on", lamp, store.State{"state": "on"}, `{"on":{"on":true}}`, ""},
+		{"brightness zero turns off", lamp, store.State{"brightness": 0}, `{"on":{"on":false}}`, ""},
+		{"color_temp", lamp, store.State{"color_temp": 250.4}, `{"color_temperature":{"mirek":250}}`, ""},
+		{"color_temp out of range", lamp, store.State{"color_temp": 500}, "", iot.InvalidValue},
+		{"brightness out of range", lamp, store.State{"brightness": 101}, "", iot.InvalidValue},
+		{"bad state", lamp, store.State{"state": "dim"}, "", iot.InvalidValue},
+		{"no dimming", plug, store.State{"brightness": 10}, "", iot.Unsupported},
+		{"no color", plug, store.State{"rgb": []interface{}{1, 2, 3}}, "", iot.Unsupported},
+		{"unknown key", lamp, store.State{"speed": 1}, "", iot.Unsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := command(tt.r, tt.updates)
+			if tt.want == "" {
+				if err == nil || iot.KindOf(err) != tt.kind {
+					t.Fatalf("err = %v, want kind %v", err, tt.kind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := json.Marshal(body); string(got) != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorRoundTrip(t *testing.T) {
+	for _, rgb := range [][3]int{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 255}} {
+		x, y := rgbToXY(rgb[0], rgb[1], rgb[2])
+		got := xyToRGB(x, y)
+		for i := range rgb {
+			if diff := got[i] - rgb[i]; diff < -8 || diff > 8 {
+				t.Errorf("xyToRGB(rgbToXY(%v)) = %v", rgb, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package hue
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// ref points at another resource.
+type ref struct {
+	RID   string `json:"rid"`
+	RType string `json:"rtype"`
+}
+
+// resource is a CLIP v2 resource. Only the fields the driver maps are
+// decoded; updates from the event stream are unmarshaled onto the cached
+// resource, which merges them.
+type resource struct {
+	ID          string       `json:"id"`
+	Type        string       `json:"type"`
+	Owner       *ref         `json:"owner,omitempty"`
+	Metadata    *metadata    `json:"metadata,omitempty"`
+	ProductData *productData `json:"product_data,omitempty"`
+	Services    []ref        `json:"services,omitempty"`
+	Children    []ref        `json:"children,omitempty"`
+
+	On               *onState          `json:"on,omitempty"`
+	Dimming          *dimming          `json:"dimming,omitempty"`
+	Color            *color            `json:"color,omitempty"`
+	ColorTemperature *colorTemperature `json:"color_temperature,omitempty"`
+
+	Motion        *motion        `json:"motion,omitempty"`
+	Temperature   *temperature   `json:"temperature,omitempty"`
+	Light         *lightLevel    `json:"light,omitempty"`
+	PowerState    *powerState    `json:"power_state,omitempty"`
+	ContactReport *contactReport `json:"contact_report,omitempty"`
+	Status        string         `json:"status,omitempty"` // zigbee_connectivity
+}
+
+type metadata struct {
+	Name      string `json:"name"`
+	Archetype string `json:"archetype,omitempty"`
+}
+
+type productData struct {
+	ManufacturerName string `json:"manufacturer_name"`
+	ProductName      string `json:"product_name"`
+	ModelID          string `json:"model_id"`
+}
+
+type onState struct {
+	On bool `json:"on"`
+}
+
+type dimming struct {
+	Brightness float64 `json:"brightness"` // 0-100
+}
+
+type xy struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type color struct {
+	XY xy `json:"xy"`
+}
+
+type colorTemperature struct {
+	Mirek       *int `json:"mirek"`
+	MirekValid  bool `json:"mirek_valid"`
+	MirekSchema *struct {
+		Minimum int `json:"mirek_minimum"`
+		Maximum int `json:"mirek_maximum"`
+	} `json:"mirek_schema,omitempty"`
+}
+
+type motion struct {
+	Motion      bool `json:"motion"`
+	MotionValid bool `json:"motion_valid"`
+}
+
+type temperature struct {
+	Temperature      float64 `json:"temperature"`
+	TemperatureValid bool    `json:"temperature_valid"`
+}
+
+type lightLevel struct {
+	LightLevel      int  `json:"light_level"`
+	LightLevelValid bool `json:"light_level_valid"`
+}
+
+type powerState struct {
+	BatteryLevel *int `json:"battery_level"`
+}
+
+type contactReport struct {
+	State string `json:"state"` // contact or no_contact
+}
+
+func (r *resource) name() string {
+	if r.Metadata != nil {
+		return r.Metadata.Name
+	}
+	return ""
+}
+
+// sensorTypes are the services that make a Hue device a sensor.
+var sensorTypes = map[string]bool{
+	"motion": true, "temperature": true, "light_level": true, "device_power": true, "contact": true,
+}
+
+// mapped is a store device built from bridge resources: a light, a room's
+// or zone's grouped_light, or a sensor device with its sensor services.
+type mapped struct {
+	device     store.Device
+	rtype, rid string   // resource commands go to, empty for sensors
+	owner      string   // Hue device whose connectivity is the device's availability
+	sources    []string // resources the state is read from
+}
+
+// deviceID is the store ID for a resource.
+func deviceID(id string) string {
+	return "hue-" + id
+}
+
+// buildDevices maps the resources to store devices, sorted by ID.
+func buildDevices(resources map[string]*resource) []mapped {
+	rooms := map[string]string{} // Hue device ID -> room name
+	for _, r := range resources {
+		if r.Type != "room" {
+			continue
+		}
+		for _, child := range r.Children {
+			if child.RType == "device" {
+				rooms[child.RID] = r.name()
+			}
+		}
+	}
+	room := func(deviceID string) string {
+		if name := rooms[deviceID]; name != "" {
+			return name
+		}
+		return "unknown"
+	}
+
+	var devices []mapped
+	for _, r := range resources {
+		switch r.Type {
+		case "light":
+			m := mapped{rtype: "light", rid: r.ID, sources: []string{r.ID}}
+			m.device = store.Device{
+				ID:       deviceID(r.ID),
+				Name:     r.name(),
+				Type:     "light",
+				Protocol: "hue",
+				Room:     "unknown",
+			}
+			if r.Owner != nil {
+				m.owner = r.Owner.RID
+				m.device.Room = room(r.Owner.RID)
+				if owner := resources[r.Owner.RID]; owner != nil {
+					if m.device.Name == "" {
+						m.device.Name = owner.name()
+					}
+					if owner.ProductData != nil {
+						m.device.Manufacturer = owner.ProductData.ManufacturerName
+						m.device.Model = owner.ProductData.ProductName
+					}
+				}
+			}
+			m.device.Capabilities = lightCapabilities(r)
+			devices = append(devices, m)
+
+		case "grouped_light":
+			if r.Owner == nil || (r.Owner.RType != "room" && r.Owner.RType != "zone") {
+				continue // the bridge_home group of all lights
+			}
+			group := resources[r.Owner.RID]
+			if group == nil {
+				continue
+			}
+			m := mapped{rtype: "grouped_light", rid: r.ID, sources: []string{r.ID}}
+			m.device = store.Device{
+				ID:           deviceID(r.ID),
+				Name:         group.name(),
+				Type:         "light_group",
+				Protocol:     "hue",
+				Room:         group.name(),
+				Capabilities: lightCapabilities(r),
+			}
+			devices = append(devices, m)
+
+		case "device":
+			m := mapped{owner: r.ID}
+			isLight := false
+			for _, s := range r.Services {
+				if s.RType == "light" {
+					isLight = true
+				}
+				if sensorTypes[s.RType] && resources[s.RID] != nil {
+					m.sources = append(m.sources, s.RID)
+				}
+			}
+			if isLight || len(m.sources) == 0 {
+				continue
+			}
+			m.device = store.Device{
+				ID:       deviceID(r.ID),
+				Name:     r.name(),
+				Type:     "sensor",
+				Protocol: "hue",
+				Room:     room(r.ID),
+			}
+			if r.ProductData != nil {
+				m.device.Manufacturer = r.ProductData.ManufacturerName
+				m.device.Model = r.ProductData.ProductName
+			}
+			for _, id := range m.sources {
+				m.device.Capabilities = append(m.device.Capabilities, sensorCapabilities(resources[id])...)
+			}
+			devices = append(devices, m)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].device.ID < devices[j].device.ID })
+	return devices
+}
+
+func lightCapabilities(r *resource) []store.Capability {
+	caps := []store.Capability{{
+		Name:        "power",
+		Description: "Turn the light on or off",
+		Operations:  []string{"on", "off"},
+		Parameters: map[string]interface{}{
+			"state": map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+		},
+		Writable: true,
+	}}
+	if r.Dimming != nil {
+		caps = append(caps, store.Capability{
+			Name:        "brightness",
+			Description: "Adjust brightness (0-100)",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"brightness": map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+			},
+			Writable: true,
+		})
+	}
+	if r.Color != nil {
+		caps = append(caps, store.Capability{
+			Name:        "color",
+			Description: "Change color using RGB",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"rgb": map[string]interface{}{"type": "array", "length": 3, "range": []int{0, 255}},
+			},
+			Writable: true,
+		})
+	}
+	if r.ColorTemperature != nil {
+		lo, hi := mirekRange(r)
+		caps = append(caps, store.Capability{
+			Name:        "color_temp",
+			Description: "Set the white color temperature in mireds",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"color_temp": map[string]interface{}{"type": "integer", "range": []int{lo, hi}},
+			},
+			Writable: true,
+		})
+	}
+	return caps
+}
+
+func mirekRange(r *resource) (int, int) {
+	if ct := r.ColorTemperature; ct != nil && ct.MirekSchema != nil && ct.MirekSchema.Maximum > ct.MirekSchema.Minimum {
+		return ct.MirekSchema.Minimum, ct.MirekSchema.Maximum
+	}
+	return 153, 500
+}
+
+func sensorCapabilities(r *resource) []store.Capability {
+	number := func(key, description, unit string) store.Capability {
+		return store.Capability{
+			Name:        key,
+			Description: description,
+			Parameters:  map[string]interface{}{key: map[string]interface{}{"type": "number", "unit": unit}},
+		}
+	}
+	boolean := func(key, description string) store.Capability {
+		return store.Capability{
+			Name:        key,
+			Description: description,
+			Parameters:  map[string]interface{}{key: map[string]interface{}{"type": "boolean"}},
+		}
+	}
+	switch r.Type {
+	case "motion":
+		return []store.Capability{boolean("motion", "Motion detected")}
+	case "temperature":
+		return []store.Capability{number("temperature", "Temperature", "°C")}
+	case "light_level":
+		return []store.Capability{number("illuminance", "Illuminance", "lx")}
+	case "device_power":
+		return []store.Capability{number("battery", "Battery level", "%")}
+	case "contact":
+		return []store.Capability{boolean("contact", "Contact closed")}
+	}
+	return nil
+}
+
+// state is the store state of a mapped device.
+func (m mapped) state(resources map[string]*resource) store.State {
+	state := store.State{}
+	for _, id := range m.sources {
+		if r := resources[id]; r != nil {
+			for k, v := range resourceState(r) {
+				state[k] = v
+			}
+		}
+	}
+	return state
+}
+
+func resourceState(r *resource) store.State {
+	state := store.State{}
+	switch r.Type {
+	case "light", "grouped_light":
+		if r.On != nil {
+			state["state"] = onOff(r.On.On)
+		}
+		if r.Dimming != nil {
+			state["brightness"] = int(math.Round(r.Dimming.Brightness))
+		}
+		ct := r.ColorTemperature
+		if ct != nil && ct.MirekValid && ct.Mirek != nil {
+			state["color_temp"] = *ct.Mirek
+		} else if r.Color != nil {
+			rgb := xyToRGB(r.Color.XY.X, r.Color.XY.Y)
+			state["rgb"] = []interface{}{rgb[0], rgb[1], rgb[2]}
+		}
+	case "motion":
+		if r.Motion != nil && r.Motion.MotionValid {
+			state["motion"] = r.Motion.Motion
+		}
+	case "temperature":
+		if r.Temperature != nil && r.Temperature.TemperatureValid {
+			state["temperature"] = math.Round(r.Temperature.Temperature*10) / 10
+		}
+	case "light_level":
+		if r.Light != nil && r.Light.LightLevelValid {
+			// light_level is 10000*log10(lux)+1.
+			state["illuminance"] = math.Round(math.Pow(10, float64(r.Light.LightLevel-1)/10000))
+		}
+	case "device_power":
+		if r.PowerState != nil && r.PowerState.BatteryLevel != nil {
+			state["battery"] = *r.PowerState.BatteryLevel
+		}
+	case "contact":
+		if r.ContactReport != nil {
+			state["contact"] = r.ContactReport.State == "contact"
+		}
+	}
+	return state
+}
+
+// command builds the PUT body for state updates to a light or grouped_light.
+func command(r *resource, updates store.State) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	for _, key := range iot.SortedKeys(updates) {
+		value := updates[key]
+		switch key {
+		case "state":
+			on, err := parseOnOff(value)
+			if err != nil {
+				return nil, err
+			}
+			body["on"] = map[string]interface{}{"on": on}
+		case "brightness":
+			n, ok := iot.Number(value)
+			if !ok || n < 0 || n > 100 || r.Dimming == nil {
+				return nil, invalidOrUnsupported(r.Dimming != nil, key, value, "expected 0-100")
+			}
+			if n == 0 {
+				body["on"] = map[string]interface{}{"on": false}
+				continue
+			}
+			body["dimming"] = map[string]interface{}{"brightness": n}
+		case "rgb":
+			rgb, ok := iot.RGBValue(value)
+			if !ok || r.Color == nil {
+				return nil, invalidOrUnsupported(r.Color != nil, key, value, "expected [r, g, b] in 0-255")
+			}
+			x, y := rgbToXY(rgb[0], rgb[1], rgb[2])
+			body["color"] = map[string]interface{}{"xy": map[string]interface{}{"x": x, "y": y}}
+		case "color_temp":
+			n, ok := iot.Number(value)
+			lo, hi := mirekRange(r)
+			if !ok || r.ColorTemperature == nil || n < float64(lo) || n > float64(hi) {
+				return nil, invalidOrUnsupported(r.ColorTemperature != nil, key, value, fmt.Sprintf("expected %d-%d", lo, hi))
+			}
+			body["color_temperature"] = map[string]interface{}{"mirek": int(math.Round(n))}
+		default:
+			return nil, iot.UnsupportedKey(key)
+		}
+	}
+	return body, nil
+}
+
+func invalidOrUnsupported(supported bool, key string, value interface{}, expected string) error {
+	if !supported {
+		return iot.UnsupportedKey(key)
+	}
+	return iot.Errorf(iot.InvalidValue, "invalid %s %v, %s", key, value, expected)
+}
+
+// Hue lights take colors as CIE xy. The conversions use the wide gamut
+// matrices from Philips' developer documentation with sRGB gamma; the
+// bridge clamps xy to each light's gamut.
+
+func rgbToXY(r, g, b int) (float64, float64) {
+	lin := func(c int) float64 {
+		v := float64(c) / 255
+		if v > 0.04045 {
+			return math.Pow((v+0.055)/1.055, 2.4)
+		}
+		return v / 12.92
+	}
+	rl, gl, bl := lin(r), lin(g), lin(b)
+	x := rl*0.664511 + gl*0.154324 + bl*0.162028
+	y := rl*0.283881 + gl*0.668433 + bl*0.047685
+	z := rl*0.000088 + gl*0.072310 + bl*0.986039
+	sum := x + y + z
+	if sum == 0 {
+		return 0.3127, 0.329 // black has no chromaticity; use white
+	}
+	return round4(x / sum), round4(y / sum)
+}
+
+func xyToRGB(x, y float64) [3]int {
+	if y == 0 {
+		return [3]int{255, 255, 255}
+	}
+	bigY := 1.0
+	bigX := bigY / y * x
+	bigZ := bigY / y * (1 - x - y)
+	rgb := [3]float64{
+		bigX*1.656492 - bigY*0.354851 - bigZ*0.255038,
+		-bigX*0.707196 + bigY*1.655397 + bigZ*0.036152,
+		bigX*0.051713 - bigY*0.121364 + bigZ*1.011530,
+	}
+	max := 0.0
+	for i, v := range rgb {
+		if v < 0 {
+			rgb[i] = 0
+		}
+		max = math.Max(max, rgb[i])
+	}
+	var out [3]int
+	for i, v := range rgb {
+		if max > 1 {
+			v /= max
+		}
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		out[i] = int(math.Round(math.Min(1, v) * 255))
+	}
+	return out
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func parseOnOff(value interface{}) (bool, error) {
+	switch strings.ToLower(store.FormatValue(value)) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, iot.Errorf(iot.InvalidValue, "invalid power value %v, expected on/off", value)
+}
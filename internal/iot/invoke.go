@@ -0,0 +1,267 @@
+package iot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// ErrPersistState is returned by Invoke when the device accepted the command
+// but its new state couldn't be stored.
+var ErrPersistState = errors.New("failed to persist device state")
+
+// InvokeResult is what Invoke applied. In confirmed mode State holds the
+// values the device reported rather than the requested ones.
+type InvokeResult struct {
+	State     store.State
+	Confirmed bool
+}
+
+// Invoke validates input against one of the device's capabilities, sends it
+// to the device's driver and stores the applied state. It is the single path
+// for commands from the REST and MQTT APIs. With confirm set, drivers that
+// implement StateConfirmer wait for the device to report the new state; on a
+// mismatch the reported values are returned along with the error.
+func Invoke(ctx context.Context, deviceID, capabilityName string, input map[string]interface{}, confirm bool) (InvokeResult, error) {
+	ds := factory.GetDeviceStore()
+	device, ok := ds.Get(deviceID)
+	if !ok {
+		return InvokeResult{}, Errorf(NotFound, "Device not found")
+	}
+
+	var selectedCap *store.Capability
+	for _, cap := range device.Capabilities {
+		if cap.Name == capabilityName {
+			selectedCap = &cap
+			break
+		}
+	}
+	if selectedCap == nil {
+		return InvokeResult{}, Errorf(Unsupported, "Unsupported capability")
+	}
+
+	validated, err := ValidateParameters(*selectedCap, input)
+	if err != nil {
+		return InvokeResult{}, err
+	}
+
+	if device.Availability == store.Offline {
+		return InvokeResult{}, Errorf(DeviceOffline, "Device %s is offline", deviceID)
+	}
+	driver, err := GetDriverFor(device)
+	if err != nil {
+		return InvokeResult{}, err
+	}
+
+	// In confirmed mode only the values the device reported are stored.
+	result := InvokeResult{State: validated}
+	confirmer, canConfirm := driver.(StateConfirmer)
+	if canConfirm && confirm {
+		result.Confirmed = true
+		applied, err := confirmer.SetStateConfirmed(ctx, device, validated)
+		result.State = applied
+		if err != nil {
+			// Whatever the device did report is still its current state.
+			if len(applied) > 0 {
+				if perr := ds.UpdateState(deviceID, applied); perr != nil {
+					log.Printf("[IoT] Failed to store the state %s reported: %v", deviceID, perr)
+				}
+			}
+			return result, fmt.Errorf("Failed to confirm device state: %w", err)
+		}
+	} else if err := driver.SetState(ctx, device, validated); err != nil {
+		return InvokeResult{}, fmt.Errorf("Failed to communicate with device: %w", err)
+	}
+
+	if err := ds.UpdateState(deviceID, result.State); err != nil {
+		return result, fmt.Errorf("%w: %v", ErrPersistState, err)
+	}
+	return result, nil
+}
+
+// ValidateParameters checks input against a capability's parameter specs and
+// returns the values with their JSON type kept: integers, numbers, booleans
+// and arrays are passed on as such. Failures are InvalidValue errors.
+func ValidateParameters(cap store.Capability, input map[string]interface{}) (store.State, error) {
+	validated := store.State{}
+	for paramName, paramSpecRaw := range cap.Parameters {
+		spec, _ := paramSpecRaw.(map[string]interface{})
+
+		value, ok := input[paramName]
+		if !ok {
+			return nil, Errorf(InvalidValue, "Missing required parameter: %s", paramName)
+		}
+
+		switch spec["type"] {
+		case "integer", "number":
+			num, ok := value.(float64)
+			if !ok {
+				return nil, Errorf(InvalidValue, "Parameter '%s' must be a number", paramName)
+			}
+			if min, max, ok := RangeBounds(spec["range"]); ok && (num < min || num > max) {
+				return nil, Errorf(InvalidValue, "Parameter '%s' out of range", paramName)
+			}
+			if spec["type"] == "integer" {
+				validated[paramName] = int(num)
+			} else {
+				validated[paramName] = num
+			}
+
+		case "boolean":
+			switch v := value.(type) {
+			case bool:
+				validated[paramName] = v
+			case string:
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, Errorf(InvalidValue, "Parameter '%s' must be a boolean", paramName)
+				}
+				validated[paramName] = b
+			default:
+				return nil, Errorf(InvalidValue, "Parameter '%s' must be a boolean", paramName)
+			}
+
+		case "array":
+			arr, ok := value.([]interface{})
+			if !ok {
+				return nil, Errorf(InvalidValue, "Parameter '%s' must be an array", paramName)
+			}
+			if length, ok := specInt(spec["length"]); ok && len(arr) != length {
+				return nil, Errorf(InvalidValue, "Parameter '%s' must be an array of length %d", paramName, length)
+			}
+			min, max, hasRange := RangeBounds(spec["range"])
+			for _, el := range arr {
+				num, isNum := el.(float64)
+				if hasRange && (!isNum || num < min || num > max) {
+					return nil, Errorf(InvalidValue, "Parameter '%s' out of range", paramName)
+				}
+			}
+			validated[paramName] = arr
+
+		default:
+			strVal := fmt.Sprintf("%v", value)
+			if len(cap.Operations) > 0 {
+				valid := false
+				for _, op := range cap.Operations {
+					if strVal == op {
+						valid = true
+						break
+					}
+				}
+				if !valid {
+					return nil, Errorf(InvalidValue, "Invalid value for '%s'", paramName)
+				}
+			}
+			validated[paramName] = strVal
+		}
+	}
+	return validated, nil
+}
+
+// RangeBounds reads a [min, max] range. Capabilities built in code use
+// []int, []int64 or []float64; ones loaded from JSON use []interface{}.
+func RangeBounds(raw interface{}) (float64, float64, bool) {
+	var bounds []float64
+	switch r := raw.(type) {
+	case []int:
+		for _, v := range r {
+			bounds = append(bounds, float64(v))
+		}
+	case []int64:
+		for _, v := range r {
+			bounds = append(bounds, float64(v))
+		}
+	case []float64:
+		bounds = r
+	case []interface{}:
+		for _, v := range r {
+			f, ok := v.(float64)
+			if !ok {
+				return 0, 0, false
+			}
+			bounds = append(bounds, f)
+		}
+	}
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	return bounds[0], bounds[1], true
+}
+
+func specInt(raw interface{}) (int, bool) {
+	switch v := raw.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// Number reads a numeric state value. Values decoded from JSON are float64,
+// ones built in code may be any int or float type, and values parsed from
+// device payloads or query strings may still be text.
+func Number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// RGBValue reads an [r, g, b] color with every channel in 0-255.
+func RGBValue(v interface{}) ([]int, bool) {
+	var values []interface{}
+	switch arr := v.(type) {
+	case []interface{}:
+		values = arr
+	case []int:
+		for _, n := range arr {
+			values = append(values, n)
+		}
+	}
+	if len(values) != 3 {
+		return nil, false
+	}
+	rgb := make([]int, 3)
+	for i, el := range values {
+		n, ok := Number(el)
+		if !ok || n < 0 || n > 255 {
+			return nil, false
+		}
+		rgb[i] = int(n)
+	}
+	return rgb, true
+}
+
+// SortedKeys returns the keys of m in order, so drivers send multi-key
+// updates to devices in a stable order.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnsupportedKey is the error drivers return for a state key the device
+// can't set.
+func UnsupportedKey(key string) error {
+	return Errorf(Unsupported, "unsupported state key %q", key)
+}
@@ -0,0 +1,138 @@
+package iot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+// confirmingDriver reports the state given in reported instead of the
+// requested one, and mismatch as its error.
+type confirmingDriver struct {
+	reported store.State
+	mismatch bool
+}
+
+func (d *confirmingDriver) Start() error   { return nil }
+func (d *confirmingDriver) Stop() error    { return nil }
+func (d *confirmingDriver) Health() Health { return Health{Status: HealthOK} }
+func (d *confirmingDriver) GetState(context.Context, store.Device) (store.State, error) {
+	return store.State{}, nil
+}
+func (d *confirmingDriver) SetState(context.Context, store.Device, store.State) error { return nil }
+func (d *confirmingDriver) SetStateConfirmed(context.Context, store.Device, store.State) (store.State, error) {
+	if d.mismatch {
+		return d.reported, Errorf(Mismatch, "device reported %v", d.reported)
+	}
+	return d.reported, nil
+}
+
+func TestInvokeConfirmedWritesOnce(t *testing.T) {
+	testutil.UseMemoryStore()
+
+	driver := &confirmingDriver{}
+	registerForTest(t, "invoketest", driver, false)
+	StartDrivers([]string{"invoketest"})
+
+	var writes []store.State
+	factory.OnDeviceEvent(func(ev store.DeviceEvent) {
+		if ev.Kind == store.DeviceStateChanged {
+			writes = append(writes, ev.Changes)
+		}
+	})
+	factory.GetDeviceStore().Add(store.Device{
+		ID: "dimmer", Protocol: "invoketest", State: store.State{},
+		Capabilities: []store.Capability{{
+			Name: "brightness", Writable: true,
+			Parameters: map[string]interface{}{
+				"brightness": map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+			},
+		}},
+	})
+
+	tests := []struct {
+		name     string
+		confirm  bool
+		reported store.State
+		mismatch bool
+		want     store.State
+		wantErr  bool
+	}{
+		{name: "unconfirmed", want: store.State{"brightness": 50}},
+		{name: "confirmed", confirm: true, reported: store.State{"brightness": 49}, want: store.State{"brightness": 49}},
+		{name: "mismatch", confirm: true, reported: store.State{"brightness": 10}, mismatch: true, want: store.State{"brightness": 10}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writes = nil
+			driver.reported, driver.mismatch = tt.reported, tt.mismatch
+			result, err := Invoke(context.Background(), "dimmer", "brightness", map[string]interface{}{"brightness": float64(50)}, tt.confirm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Invoke() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.Confirmed != tt.confirm {
+				t.Errorf("Confirmed = %v, want %v", result.Confirmed, tt.confirm)
+			}
+			if len(writes) != 1 {
+				t.Fatalf("state written %d times (%v), want once", len(writes), writes)
+			}
+			if writes[0]["brightness"] != tt.want["brightness"] {
+				t.Errorf("stored %v, want %v", writes[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want float64
+		ok   bool
+	}{
+		{float64(1.5), 1.5, true},
+		{float32(0.5), 0.5, true},
+		{7, 7, true},
+		{int64(-3), -3, true},
+		{"21.5", 21.5, true},
+		{"warm", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := Number(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("Number(%#v) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRGBValue(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want []int
+	}{
+		{"from JSON", []interface{}{float64(255), float64(128), float64(0)}, []int{255, 128, 0}},
+		{"from code", []int{1, 2, 3}, []int{1, 2, 3}},
+		{"too short", []interface{}{float64(1), float64(2)}, nil},
+		{"out of range", []int{0, 256, 0}, nil},
+		{"not numbers", []interface{}{"red", "green", "blue"}, nil},
+		{"not an array", "255,0,0", nil},
+	}
+	for _, tt := range tests {
+		got, ok := RGBValue(tt.in)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: RGBValue() = %v, %v, want %v", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	got := SortedKeys(store.State{"state": "on", "brightness": 10, "color": nil})
+	if !reflect.DeepEqual(got, []string{"brightness", "color", "state"}) {
+		t.Errorf("SortedKeys() = %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package matter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"iot-bridge/internal/iot/wsrpc"
+
+	"github.com/gorilla/websocket"
+)
+
+// Node is a commissioned node as reported by python-matter-server. Attributes
+// are keyed by "endpoint/cluster/attribute", e.g. "1/6/0" for OnOff.
+type Node struct {
+	NodeID     int                    `json:"node_id"`
+	Available  bool                   `json:"available"`
+	IsBridge   bool                   `json:"is_bridge"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// ServerInfo is the banner the server sends when a client connects.
+type ServerInfo struct {
+	FabricID      int64  `json:"fabric_id"`
+	SchemaVersion int    `json:"schema_version"`
+	SDKVersion    string `json:"sdk_version"`
+}
+
+// Event is a pushed server message such as attribute_updated or node_added.
+type Event struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+type message struct {
+	MessageID string          `json:"message_id,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	ErrorCode *int            `json:"error_code,omitempty"`
+	Details   string          `json:"details,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// codec is python-matter-server's message format: command arguments go in
+// "args" and failed commands carry an error_code.
+type codec struct{}
+
+func (codec) Request(id, command string, args map[string]interface{}) interface{} {
+	req := map[string]interface{}{"message_id": id, "command": command}
+	if args != nil {
+		req["args"] = args
+	}
+	return req
+}
+
+func (codec) Reply(raw []byte) (wsrpc.Reply, bool) {
+	var msg message
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Event != "" {
+		return wsrpc.Reply{}, false
+	}
+	reply := wsrpc.Reply{ID: msg.MessageID, Result: msg.Result}
+	if msg.ErrorCode != nil {
+		reply.Err = fmt.Errorf("error %d %s", *msg.ErrorCode, msg.Details)
+	}
+	return reply, true
+}
+
+// Client is a python-matter-server websocket client.
+type Client struct {
+	*wsrpc.Client
+	Info ServerInfo
+}
+
+// Dial connects and reads the server info banner.
+func Dial(url string, onEvent func(Event)) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info ServerInfo
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.ReadJSON(&info); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no server info from server: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	client := wsrpc.New(conn, codec{}, func(raw []byte) {
+		var msg message
+		if err := json.Unmarshal(raw, &msg); err == nil && msg.Event != "" && onEvent != nil {
+			onEvent(Event{Event: msg.Event, Data: msg.Data})
+		}
+	})
+	return &Client{Client: client, Info: info}, nil
+}
@@ -0,0 +1,248 @@
+package matter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/store"
+)
+
+// Clusters and attributes the driver maps onto capabilities.
+const (
+	clusterBasicInformation       = 0x0028
+	clusterOnOff                  = 0x0006
+	clusterLevelControl           = 0x0008
+	clusterColorControl           = 0x0300
+	clusterTemperatureMeasurement = 0x0402
+
+	attrVendorName  = 1
+	attrProductName = 3
+	attrNodeLabel   = 5
+
+	attrCurrentHue        = 0x0000
+	attrCurrentSaturation = 0x0001
+	attrColorTempMireds   = 0x0007
+	attrColorTempMin      = 0x400b
+	attrColorTempMax      = 0x400c
+)
+
+// attrBinding ties a bridge state key to one cluster attribute on an endpoint.
+type attrBinding struct {
+	key       string
+	endpoint  int
+	cluster   int
+	attribute int
+}
+
+func (b attrBinding) path() string {
+	return fmt.Sprintf("%d/%d/%d", b.endpoint, b.cluster, b.attribute)
+}
+
+// parsePath splits "endpoint/cluster/attribute".
+func parsePath(path string) (endpoint, cluster, attribute int, ok bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		nums[i] = n
+	}
+	return nums[0], nums[1], nums[2], true
+}
+
+// bindingsForNode finds the supported attributes on a node. The lowest
+// endpoint carrying a cluster gets plain keys; further endpoints get an
+// "_<endpoint>" suffix.
+func bindingsForNode(node Node) map[string]attrBinding {
+	type ref struct{ endpoint, cluster, attribute int }
+	var refs []ref
+	for path := range node.Attributes {
+		ep, cl, at, ok := parsePath(path)
+		if ok && ep != 0 {
+			refs = append(refs, ref{ep, cl, at})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].endpoint != refs[j].endpoint {
+			return refs[i].endpoint < refs[j].endpoint
+		}
+		return refs[i].cluster < refs[j].cluster
+	})
+
+	names := map[[2]int]string{
+		{clusterOnOff, 0}:                            "state",
+		{clusterLevelControl, 0}:                     "brightness",
+		{clusterColorControl, attrCurrentHue}:        "hue",
+		{clusterColorControl, attrCurrentSaturation}: "saturation",
+		{clusterColorControl, attrColorTempMireds}:   "color_temp",
+		{clusterTemperatureMeasurement, 0}:           "temperature",
+	}
+
+	firstEndpoint := map[int]int{}
+	bindings := make(map[string]attrBinding)
+	for _, r := range refs {
+		name, ok := names[[2]int{r.cluster, r.attribute}]
+		if !ok {
+			continue
+		}
+		first, seen := firstEndpoint[r.cluster]
+		if !seen {
+			firstEndpoint[r.cluster] = r.endpoint
+			first = r.endpoint
+		}
+		key := name
+		if r.endpoint != first {
+			key = fmt.Sprintf("%s_%d", name, r.endpoint)
+		}
+		bindings[key] = attrBinding{key: key, endpoint: r.endpoint, cluster: r.cluster, attribute: r.attribute}
+	}
+	return bindings
+}
+
+func capabilitiesFor(node Node, bindings map[string]attrBinding) []store.Capability {
+	keys := make([]string, 0, len(bindings))
+	for k := range bindings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var caps []store.Capability
+	for _, key := range keys {
+		b := bindings[key]
+		switch {
+		case b.cluster == clusterOnOff:
+			caps = append(caps, store.Capability{
+				Name:        strings.Replace(key, "state", "power", 1),
+				Description: "Turn the device on or off",
+				Operations:  []string{"on", "off"},
+				Writable:    true,
+				Parameters: map[string]interface{}{
+					key: map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+				},
+			})
+		case b.cluster == clusterLevelControl:
+			caps = append(caps, rangeCapability(key, "Adjust brightness (0-254)", 0, 254))
+		case b.cluster == clusterColorControl && b.attribute == attrCurrentHue:
+			caps = append(caps, rangeCapability(key, "Set hue (0-254)", 0, 254))
+		case b.cluster == clusterColorControl && b.attribute == attrCurrentSaturation:
+			caps = append(caps, rangeCapability(key, "Set saturation (0-254)", 0, 254))
+		case b.cluster == clusterColorControl && b.attribute == attrColorTempMireds:
+			min := intAttr(node, b.endpoint, clusterColorControl, attrColorTempMin, 153)
+			max := intAttr(node, b.endpoint, clusterColorControl, attrColorTempMax, 500)
+			caps = append(caps, rangeCapability(key, fmt.Sprintf("Set color temperature in mireds (%d-%d)", min, max), min, max))
+		case b.cluster == clusterTemperatureMeasurement:
+			caps = append(caps, store.Capability{
+				Name:        key,
+				Description: "Measured temperature",
+				Writable:    false,
+				Parameters: map[string]interface{}{
+					key: map[string]interface{}{"type": "number", "unit": "°C"},
+				},
+			})
+		}
+	}
+	return caps
+}
+
+func rangeCapability(key, description string, min, max int) store.Capability {
+	return store.Capability{
+		Name:        key,
+		Description: description,
+		Operations:  []string{"set"},
+		Writable:    true,
+		Parameters: map[string]interface{}{
+			key: map[string]interface{}{"type": "integer", "range": []int{min, max}},
+		},
+	}
+}
+
+func intAttr(node Node, endpoint, cluster, attribute, def int) int {
+	if v, ok := node.Attributes[fmt.Sprintf("%d/%d/%d", endpoint, cluster, attribute)].(float64); ok {
+		return int(v)
+	}
+	return def
+}
+
+func stringAttr(node Node, path string) string {
+	s, _ := node.Attributes[path].(string)
+	return s
+}
+
+func deviceType(bindings map[string]attrBinding) string {
+	has := map[int]bool{}
+	for _, b := range bindings {
+		has[b.cluster] = true
+	}
+	switch {
+	case has[clusterColorControl] || has[clusterLevelControl]:
+		return "bulb"
+	case has[clusterOnOff]:
+		return "switch"
+	case has[clusterTemperatureMeasurement]:
+		return "sensor"
+	}
+	return "matter"
+}
+
+// toState converts an attribute value into the bridge's state value.
+func (b attrBinding) toState(v interface{}) interface{} {
+	switch val := v.(type) {
+	case bool:
+		if b.cluster == clusterOnOff {
+			if val {
+				return "on"
+			}
+			return "off"
+		}
+	case float64:
+		if b.cluster == clusterTemperatureMeasurement {
+			return val / 100 // MeasuredValue is in 0.01 °C
+		}
+	}
+	return v
+}
+
+// command builds the cluster command that sets b to the requested value.
+func (b attrBinding) command(v interface{}) (string, map[string]interface{}, error) {
+	value := store.FormatValue(v)
+	if b.cluster == clusterOnOff {
+		switch strings.ToLower(value) {
+		case "on", "true", "1":
+			return "On", map[string]interface{}{}, nil
+		case "off", "false", "0":
+			return "Off", map[string]interface{}{}, nil
+		case "toggle":
+			return "Toggle", map[string]interface{}{}, nil
+		}
+		return "", nil, fmt.Errorf("invalid value %q for %s, expected on/off", value, b.key)
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid number %q for %s", value, b.key)
+	}
+	base := map[string]interface{}{"transitionTime": 0, "optionsMask": 0, "optionsOverride": 0}
+	switch {
+	case b.cluster == clusterLevelControl:
+		base["level"] = n
+		return "MoveToLevelWithOnOff", base, nil
+	case b.cluster == clusterColorControl && b.attribute == attrCurrentHue:
+		base["hue"] = n
+		base["direction"] = 0
+		return "MoveToHue", base, nil
+	case b.cluster == clusterColorControl && b.attribute == attrCurrentSaturation:
+		base["saturation"] = n
+		return "MoveToSaturation", base, nil
+	case b.cluster == clusterColorControl && b.attribute == attrColorTempMireds:
+		base["colorTemperatureMireds"] = n
+		return "MoveToColorTemperature", base, nil
+	}
+	return "", nil, fmt.Errorf("%s is read-only", b.key)
+}
@@ -0,0 +1,101 @@
+package matter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindingsForNode(t *testing.T) {
+	node := Node{NodeID: 3, Attributes: map[string]interface{}{
+		"0/40/1":   "Eve", // root endpoint is skipped
+		"1/6/0":    true,  // OnOff
+		"1/8/0":    float64(100),
+		"1/768/0":  float64(20),
+		"1/768/1":  float64(200),
+		"1/768/7":  float64(300),
+		"1/768/16": float64(1), // unsupported attribute
+		"2/6/0":    false,      // second relay
+		"3/1026/0": float64(2150),
+		"bad/path": true,
+	}}
+	got := bindingsForNode(node)
+	want := map[string]attrBinding{
+		"state":       {key: "state", endpoint: 1, cluster: clusterOnOff},
+		"state_2":     {key: "state_2", endpoint: 2, cluster: clusterOnOff},
+		"brightness":  {key: "brightness", endpoint: 1, cluster: clusterLevelControl},
+		"hue":         {key: "hue", endpoint: 1, cluster: clusterColorControl, attribute: attrCurrentHue},
+		"saturation":  {key: "saturation", endpoint: 1, cluster: clusterColorControl, attribute: attrCurrentSaturation},
+		"color_temp":  {key: "color_temp", endpoint: 1, cluster: clusterColorControl, attribute: attrColorTempMireds},
+		"temperature": {key: "temperature", endpoint: 3, cluster: clusterTemperatureMeasurement},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bindingsForNode() = %v, want %v", got, want)
+	}
+	if dt := deviceType(got); dt != "bulb" {
+		t.Errorf("deviceType() = %q, want bulb", dt)
+	}
+}
+
+func TestToState(t *testing.T) {
+	tests := []struct {
+		name string
+		b    attrBinding
+		in   interface{}
+		want interface{}
+	}{
+		{"on", attrBinding{cluster: clusterOnOff}, true, "on"},
+		{"off", attrBinding{cluster: clusterOnOff}, false, "off"},
+		{"temperature", attrBinding{cluster: clusterTemperatureMeasurement}, float64(2150), 21.5},
+		{"level", attrBinding{cluster: clusterLevelControl}, float64(128), float64(128)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.toState(tt.in); got != tt.want {
+				t.Errorf("toState(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	onOff := attrBinding{key: "state", cluster: clusterOnOff}
+	level := attrBinding{key: "brightness", cluster: clusterLevelControl}
+	mireds := attrBinding{key: "color_temp", cluster: clusterColorControl, attribute: attrColorTempMireds}
+	temp := attrBinding{key: "temperature", cluster: clusterTemperatureMeasurement}
+
+	tests := []struct {
+		name     string
+		b        attrBinding
+		in       interface{}
+		wantName string
+		wantArg  string
+		wantVal  interface{}
+		wantErr  bool
+	}{
+		{name: "on", b: onOff, in: "on", wantName: "On"},
+		{name: "false", b: onOff, in: false, wantName: "Off"},
+		{name: "toggle", b: onOff, in: "toggle", wantName: "Toggle"},
+		{name: "bad power", b: onOff, in: "dim", wantErr: true},
+		{name: "level", b: level, in: float64(128), wantName: "MoveToLevelWithOnOff", wantArg: "level", wantVal: 128},
+		{name: "mireds", b: mireds, in: 300, wantName: "MoveToColorTemperature", wantArg: "colorTemperatureMireds", wantVal: 300},
+		{name: "not a number", b: level, in: "bright", wantErr: true},
+		{name: "read-only", b: temp, in: 20, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, payload, err := tt.b.command(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("command(%v) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.wantName {
+				t.Errorf("command(%v) = %q, want %q", tt.in, name, tt.wantName)
+			}
+			if tt.wantArg != "" && payload[tt.wantArg] != tt.wantVal {
+				t.Errorf("payload[%s] = %v, want %v", tt.wantArg, payload[tt.wantArg], tt.wantVal)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package matter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// FakeServer is a scripted python-matter-server for developing and testing
+// the driver without a fabric. It answers start_listening, commission_with_code
+// and device_command, and reports the effect of each command back as
+// attribute_updated events the way a real node would.
+type FakeServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	nodes []Node
+	conns map[*websocket.Conn]*sync.Mutex
+	// Commissionable maps setup codes to the node they commission.
+	Commissionable map[string]Node
+	// Commands records the args of every device_command request received.
+	Commands []map[string]interface{}
+}
+
+func NewFakeServer(nodes []Node) *FakeServer {
+	f := &FakeServer{
+		nodes:          nodes,
+		conns:          make(map[*websocket.Conn]*sync.Mutex),
+		Commissionable: make(map[string]Node),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveWS))
+	return f
+}
+
+// URL returns the ws:// address to pass to the driver.
+func (f *FakeServer) URL() string {
+	return "ws" + strings.TrimPrefix(f.Server.URL, "http") + "/ws"
+}
+
+// EmitAttributeUpdate stores value on the node and pushes an attribute_updated
+// event to every connected client.
+func (f *FakeServer) EmitAttributeUpdate(nodeID int, path string, value interface{}) {
+	f.mu.Lock()
+	for i := range f.nodes {
+		if f.nodes[i].NodeID == nodeID {
+			f.nodes[i].Attributes[path] = value
+		}
+	}
+	f.mu.Unlock()
+
+	f.broadcast(map[string]interface{}{
+		"event": "attribute_updated",
+		"data":  []interface{}{nodeID, path, value},
+	})
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func (f *FakeServer) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMu := &sync.Mutex{}
+	f.mu.Lock()
+	f.conns[conn] = writeMu
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	send := func(v interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.WriteJSON(v)
+	}
+	fail := func(id string, code int, details string) {
+		send(map[string]interface{}{"message_id": id, "error_code": code, "details": details})
+	}
+
+	send(map[string]interface{}{
+		"fabric_id":                    1,
+		"compressed_fabric_id":         1,
+		"schema_version":               11,
+		"min_supported_schema_version": 9,
+		"sdk_version":                  "fake",
+		"wifi_credentials_set":         false,
+		"thread_credentials_set":       false,
+		"bluetooth_enabled":            false,
+	})
+
+	for {
+		var req struct {
+			MessageID string                 `json:"message_id"`
+			Command   string                 `json:"command"`
+			Args      map[string]interface{} `json:"args"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		switch req.Command {
+		case "start_listening":
+			f.mu.Lock()
+			nodes := append([]Node{}, f.nodes...)
+			f.mu.Unlock()
+			send(map[string]interface{}{"message_id": req.MessageID, "result": nodes})
+		case "commission_with_code":
+			code, _ := req.Args["code"].(string)
+			f.mu.Lock()
+			node, ok := f.Commissionable[code]
+			if ok {
+				delete(f.Commissionable, code)
+				f.nodes = append(f.nodes, node)
+			}
+			f.mu.Unlock()
+			if !ok {
+				fail(req.MessageID, 1, fmt.Sprintf("commissioning failed for code %s", code))
+				continue
+			}
+			send(map[string]interface{}{"message_id": req.MessageID, "result": node})
+			f.broadcast(map[string]interface{}{"event": "node_added", "data": node})
+		case "device_command":
+			f.handleDeviceCommand(req.MessageID, req.Args, send, fail)
+		default:
+			fail(req.MessageID, 9, fmt.Sprintf("invalid command %q", req.Command))
+		}
+	}
+}
+
+func (f *FakeServer) handleDeviceCommand(id string, args map[string]interface{}, send func(interface{}), fail func(string, int, string)) {
+	f.mu.Lock()
+	f.Commands = append(f.Commands, args)
+	f.mu.Unlock()
+
+	nodeID := int(toFloat(args["node_id"]))
+	endpoint := int(toFloat(args["endpoint_id"]))
+	cluster := int(toFloat(args["cluster_id"]))
+	name, _ := args["command_name"].(string)
+	payload, _ := args["payload"].(map[string]interface{})
+
+	type change struct {
+		cluster, attribute int
+		value              interface{}
+	}
+	var changes []change
+	switch name {
+	case "On":
+		changes = []change{{cluster, 0, true}}
+	case "Off":
+		changes = []change{{cluster, 0, false}}
+	case "MoveToLevelWithOnOff":
+		level := toFloat(payload["level"])
+		changes = []change{{cluster, 0, level}, {clusterOnOff, 0, level > 0}}
+	case "MoveToHue":
+		changes = []change{{cluster, attrCurrentHue, toFloat(payload["hue"])}}
+	case "MoveToSaturation":
+		changes = []change{{cluster, attrCurrentSaturation, toFloat(payload["saturation"])}}
+	case "MoveToColorTemperature":
+		changes = []change{{cluster, attrColorTempMireds, toFloat(payload["colorTemperatureMireds"])}}
+	default:
+		fail(id, 1, fmt.Sprintf("unsupported command %q", name))
+		return
+	}
+
+	send(map[string]interface{}{"message_id": id, "result": nil})
+	for _, c := range changes {
+		path := fmt.Sprintf("%d/%d/%d", endpoint, c.cluster, c.attribute)
+		if f.hasAttribute(nodeID, path) {
+			f.EmitAttributeUpdate(nodeID, path, c.value)
+		}
+	}
+}
+
+func (f *FakeServer) hasAttribute(nodeID int, path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, n := range f.nodes {
+		if n.NodeID == nodeID {
+			_, ok := n.Attributes[path]
+			return ok
+		}
+	}
+	return false
+}
+
+func (f *FakeServer) broadcast(v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn, writeMu := range f.conns {
+		writeMu.Lock()
+		conn.WriteJSON(v)
+		writeMu.Unlock()
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	n, _ := v.(float64)
+	return n
+}
@@ -1 +1,341 @@
 package matter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/iot/wsrpc"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+const (
+	commandTimeout = 10 * time.Second
+	// Commissioning includes the device's PASE/CASE handshakes and network
+	// join, which routinely takes well over a minute.
+	commissionTimeout = 3 * time.Minute
+)
+
+type MatterDriver struct {
+	url      string
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.RWMutex
+	client *Client
+	nodes  map[int]map[string]attrBinding
+	states map[string]store.State
+}
+
+var driver *MatterDriver
+
+func init() {
+	iot.Register("matter", func() iot.Driver {
+		driver = NewDriver(config.MatterServerURL)
+		return driver
+	})
+}
+
+// Start connects in the background. Without MATTER_SERVER_URL the driver stays idle.
+func (m *MatterDriver) Start() error {
+	if m.url == "" {
+		log.Println("[Matter] MATTER_SERVER_URL not set, driver disabled")
+		return nil
+	}
+	go m.Run()
+	return nil
+}
+
+// Stop ends the reconnect loop, which closes the current connection.
+func (m *MatterDriver) Stop() error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	return nil
+}
+
+func (m *MatterDriver) Health() iot.Health {
+	if m.url == "" {
+		return iot.Health{Status: iot.HealthDisabled, Detail: "MATTER_SERVER_URL not set"}
+	}
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+	if client == nil || !client.Connected() {
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + m.url}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+func GetDriver() *MatterDriver {
+	return driver
+}
+
+func NewDriver(url string) *MatterDriver {
+	return &MatterDriver{
+		url:    url,
+		stop:   make(chan struct{}),
+		nodes:  make(map[int]map[string]attrBinding),
+		states: make(map[string]store.State),
+	}
+}
+
+// DeviceID is the bridge device ID used for a Matter node.
+func DeviceID(nodeID int) string {
+	return fmt.Sprintf("matter-%d", nodeID)
+}
+
+// Run keeps a connection to python-matter-server open, reconnecting with backoff.
+func (m *MatterDriver) Run() {
+	wsrpc.Reconnect(m.stop, "[Matter]", m.url, func() (*wsrpc.Client, error) {
+		client, err := m.Connect()
+		if err != nil {
+			return nil, err
+		}
+		return client.Client, nil
+	})
+}
+
+// Connect dials the server, starts listening and registers every node found.
+func (m *MatterDriver) Connect() (*Client, error) {
+	client, err := Dial(m.url, m.handleEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	raw, err := client.Command(ctx, "start_listening", nil)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	var nodes []Node
+	if err := json.Unmarshal(raw, &nodes); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("decode start_listening result: %w", err)
+	}
+
+	m.mu.Lock()
+	m.client = client
+	m.mu.Unlock()
+
+	log.Printf("[Matter] Connected to %s (schema %d), %d nodes", m.url, client.Info.SchemaVersion, len(nodes))
+	for _, node := range nodes {
+		m.registerNode(node)
+	}
+	return client, nil
+}
+
+// Commission adds a device to the fabric using its QR ("MT:...") or manual
+// pairing code and registers it in the device store. It gives up after
+// commissionTimeout unless ctx ends earlier.
+func (m *MatterDriver) Commission(ctx context.Context, code string) (store.Device, error) {
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+	if client == nil {
+		return store.Device{}, iot.Errorf(iot.TransportError, "not connected to matter server")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commissionTimeout)
+	defer cancel()
+	raw, err := client.Command(ctx, "commission_with_code", map[string]interface{}{
+		"code":         code,
+		"network_only": false,
+	})
+	if err != nil {
+		return store.Device{}, err
+	}
+	var node Node
+	if err := json.Unmarshal(raw, &node); err != nil {
+		return store.Device{}, fmt.Errorf("decode commissioned node: %w", err)
+	}
+
+	m.registerNode(node)
+	device, ok := factory.GetDeviceStore().Get(DeviceID(node.NodeID))
+	if !ok {
+		return store.Device{}, fmt.Errorf("node %d commissioned but exposes no supported clusters", node.NodeID)
+	}
+	return device, nil
+}
+
+func (m *MatterDriver) registerNode(node Node) {
+	bindings := bindingsForNode(node)
+	if len(bindings) == 0 {
+		return
+	}
+
+	deviceID := DeviceID(node.NodeID)
+	state := make(store.State)
+	for key, b := range bindings {
+		if v, ok := node.Attributes[b.path()]; ok {
+			state[key] = b.toState(v)
+		}
+	}
+
+	m.mu.Lock()
+	m.nodes[node.NodeID] = bindings
+	m.states[deviceID] = state
+	m.mu.Unlock()
+
+	name := stringAttr(node, fmt.Sprintf("0/%d/%d", clusterBasicInformation, attrNodeLabel))
+	if name == "" {
+		name = strings.TrimSpace(stringAttr(node, fmt.Sprintf("0/%d/%d", clusterBasicInformation, attrVendorName)) + " " +
+			stringAttr(node, fmt.Sprintf("0/%d/%d", clusterBasicInformation, attrProductName)))
+	}
+	added, err := iot.RegisterDevice(store.Device{
+		ID:           deviceID,
+		Name:         name,
+		Type:         deviceType(bindings),
+		Protocol:     "matter",
+		State:        state,
+		Capabilities: capabilitiesFor(node, bindings),
+	})
+	if err != nil {
+		log.Printf("[Matter] Failed to register device %s: %v", deviceID, err)
+	} else if added {
+		log.Printf("[Matter] Registered node %d as %s", node.NodeID, deviceID)
+	}
+}
+
+func (m *MatterDriver) handleEvent(ev Event) {
+	switch ev.Event {
+	case "attribute_updated":
+		// data is [node_id, "endpoint/cluster/attribute", value]
+		var data []interface{}
+		if err := json.Unmarshal(ev.Data, &data); err != nil || len(data) != 3 {
+			return
+		}
+		nodeID, ok1 := data[0].(float64)
+		path, ok2 := data[1].(string)
+		if ok1 && ok2 {
+			m.applyAttribute(int(nodeID), path, data[2])
+		}
+	case "node_added", "node_updated":
+		var node Node
+		if err := json.Unmarshal(ev.Data, &node); err == nil {
+			m.registerNode(node)
+			availability := store.Offline
+			if node.Available {
+				availability = store.Online
+			}
+			m.setAvailability(node.NodeID, availability)
+		}
+	case "node_removed":
+		var nodeID int
+		if err := json.Unmarshal(ev.Data, &nodeID); err == nil {
+			// Keep the device so its name and room survive recommissioning,
+			// but report it offline as it can no longer be reached.
+			m.setAvailability(nodeID, store.Offline)
+			m.mu.Lock()
+			delete(m.nodes, nodeID)
+			delete(m.states, DeviceID(nodeID))
+			m.mu.Unlock()
+			log.Printf("[Matter] Node %d removed from fabric", nodeID)
+		}
+	}
+}
+
+func (m *MatterDriver) setAvailability(nodeID int, availability string) {
+	deviceID := DeviceID(nodeID)
+	ds := factory.GetDeviceStore()
+	if _, found := ds.Get(deviceID); !found {
+		return
+	}
+	if err := ds.UpdateStatus(deviceID, store.DeviceStatus{Availability: availability}); err != nil {
+		log.Printf("[Matter] Failed to update status for %s: %v", deviceID, err)
+	}
+}
+
+func (m *MatterDriver) applyAttribute(nodeID int, path string, value interface{}) {
+	deviceID := DeviceID(nodeID)
+	updates := make(store.State)
+
+	m.mu.Lock()
+	for key, b := range m.nodes[nodeID] {
+		if b.path() == path {
+			updates[key] = b.toState(value)
+		}
+	}
+	if len(updates) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	state := m.states[deviceID]
+	for k, v := range updates {
+		state[k] = v
+	}
+	m.mu.Unlock()
+
+	if err := factory.GetDeviceStore().UpdateState(deviceID, updates); err != nil {
+		log.Printf("[Matter] Failed to update state for %s: %v", deviceID, err)
+	}
+}
+
+func (m *MatterDriver) lookup(deviceID string) (int, map[string]attrBinding, bool) {
+	if !strings.HasPrefix(deviceID, "matter-") {
+		return 0, nil, false
+	}
+	nodeID, err := strconv.Atoi(strings.TrimPrefix(deviceID, "matter-"))
+	if err != nil {
+		return 0, nil, false
+	}
+	bindings, ok := m.nodes[nodeID]
+	return nodeID, bindings, ok
+}
+
+func (m *MatterDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.states[device.ID]
+	if !ok {
+		return nil, fmt.Errorf("no state for device %s", device.ID)
+	}
+	copied := make(store.State, len(s))
+	for k, v := range s {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (m *MatterDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	m.mu.RLock()
+	client := m.client
+	nodeID, bindings, ok := m.lookup(device.ID)
+	m.mu.RUnlock()
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "device %s is not a known Matter node", device.ID)
+	}
+	if client == nil {
+		return iot.Errorf(iot.TransportError, "not connected to matter server")
+	}
+
+	for key, value := range updates {
+		b, ok := bindings[key]
+		if !ok {
+			return iot.Errorf(iot.Unsupported, "%s is not supported on %s", key, device.ID)
+		}
+		name, payload, err := b.command(value)
+		if err != nil {
+			return iot.Errorf(iot.InvalidValue, "%w", err)
+		}
+		_, err = client.Command(ctx, "device_command", map[string]interface{}{
+			"node_id":      nodeID,
+			"endpoint_id":  b.endpoint,
+			"cluster_id":   b.cluster,
+			"command_name": name,
+			"payload":      payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package matter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+func testNodes() []Node {
+	return []Node{{
+		NodeID:    4,
+		Available: true,
+		Attributes: map[string]interface{}{
+			"0/40/1": "Nanoleaf",
+			"0/40/3": "Essentials Bulb",
+			"1/6/0":  true,
+			"1/8/0":  float64(100),
+		},
+	}}
+}
+
+// startDriver connects a driver to a fake server and waits for node 4.
+func startDriver(t *testing.T) (*MatterDriver, *FakeServer) {
+	t.Helper()
+	testutil.UseMemoryStore()
+
+	fake := NewFakeServer(testNodes())
+	t.Cleanup(fake.Close)
+	m := NewDriver(fake.URL())
+	go m.Run()
+	t.Cleanup(func() { m.Stop() })
+
+	testutil.WaitFor(t, "node 4 registered", func() bool {
+		_, ok := factory.GetDeviceStore().Get(DeviceID(4))
+		return ok
+	})
+	return m, fake
+}
+
+func TestDiscoveryRegistersNodes(t *testing.T) {
+	m, _ := startDriver(t)
+
+	device, _ := factory.GetDeviceStore().Get(DeviceID(4))
+	if device.Name != "Nanoleaf Essentials Bulb" || device.Type != "bulb" || device.Room != "unknown" {
+		t.Errorf("device = %+v", device)
+	}
+	if device.State["state"] != "on" || device.State["brightness"] != float64(100) {
+		t.Errorf("state = %v", device.State)
+	}
+	testutil.WaitFor(t, "healthy", func() bool { return m.Health().Status == iot.HealthOK })
+}
+
+func TestSetStateRoundTrip(t *testing.T) {
+	m, fake := startDriver(t)
+	device, _ := factory.GetDeviceStore().Get(DeviceID(4))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.SetState(ctx, device, store.State{"brightness": float64(20)}); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	sent := fake.Commands
+	fake.mu.Unlock()
+	if len(sent) != 1 || sent[0]["command_name"] != "MoveToLevelWithOnOff" {
+		t.Fatalf("device_command requests = %v", sent)
+	}
+	testutil.WaitFor(t, "brightness 20", func() bool { return testutil.StoredState(DeviceID(4), "brightness") == float64(20) })
+
+	if err := m.SetState(ctx, device, store.State{"hue": 10}); err == nil {
+		t.Error("setting an unsupported key succeeded")
+	}
+}
+
+func TestEventsUpdateStore(t *testing.T) {
+	_, fake := startDriver(t)
+
+	fake.EmitAttributeUpdate(4, "1/6/0", false)
+	testutil.WaitFor(t, "state off", func() bool { return testutil.StoredState(DeviceID(4), "state") == "off" })
+
+	fake.broadcast(map[string]interface{}{"event": "node_removed", "data": 4})
+	testutil.WaitFor(t, "device offline", func() bool {
+		device, _ := factory.GetDeviceStore().Get(DeviceID(4))
+		return device.Availability == store.Offline
+	})
+}
+
+func TestCommission(t *testing.T) {
+	m, fake := startDriver(t)
+	fake.mu.Lock()
+	fake.Commissionable["MT:Y.K9042C00KA0648G00"] = Node{NodeID: 9, Available: true, Attributes: map[string]interface{}{
+		"1/1026/0": float64(1925),
+	}}
+	fake.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	device, err := m.Commission(ctx, "MT:Y.K9042C00KA0648G00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.ID != DeviceID(9) || device.Type != "sensor" || device.State["temperature"] != 19.25 {
+		t.Errorf("commissioned device = %+v", device)
+	}
+
+	if _, err := m.Commission(ctx, "MT:unknown"); err == nil {
+		t.Error("commissioning with an unknown code succeeded")
+	}
+}
@@ -0,0 +1,254 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/iot"
+)
+
+// callTimeout bounds a single request when ctx has no earlier deadline.
+const callTimeout = 5 * time.Second
+
+// Function codes.
+const (
+	ReadCoils              = 1
+	ReadDiscreteInputs     = 2
+	ReadHoldingRegisters   = 3
+	ReadInputRegisters     = 4
+	WriteSingleCoil        = 5
+	WriteSingleRegister    = 6
+	WriteMultipleCoils     = 15
+	WriteMultipleRegisters = 16
+)
+
+// Request limits from the Modbus application protocol.
+const (
+	maxReadBits      = 2000
+	maxReadRegisters = 125
+)
+
+// Client is a Modbus TCP connection to one host. Gateways to RS-485 buses
+// serve several unit IDs, so the unit is given per request. Requests are
+// sent one at a time, as many devices handle only one transaction at once.
+type Client struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	txID uint16
+}
+
+// NewClient returns a client for host, "host" or "host:port" with port 502
+// by default. It connects on first use and again after a failure.
+func NewClient(host string) *Client {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "502")
+	}
+	return &Client{addr: host}
+}
+
+// Exception is an exception response from a device.
+type Exception struct {
+	Function byte
+	Code     byte
+}
+
+func (e *Exception) Error() string {
+	names := map[byte]string{
+		1:  "illegal function",
+		2:  "illegal data address",
+		3:  "illegal data value",
+		4:  "server device failure",
+		5:  "acknowledge",
+		6:  "server device busy",
+		10: "gateway path unavailable",
+		11: "gateway target device failed to respond",
+	}
+	name := names[e.Code]
+	if name == "" {
+		name = "unknown exception"
+	}
+	return fmt.Sprintf("function %d: %s (%d)", e.Function, name, e.Code)
+}
+
+// ReadBits reads coils or discrete inputs.
+func (c *Client) ReadBits(ctx context.Context, unit byte, function byte, addr, count uint16) ([]bool, error) {
+	resp, err := c.call(ctx, unit, function, readRequest(addr, count))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 1 || int(resp[0]) != (int(count)+7)/8 || len(resp) != 1+int(resp[0]) {
+		return nil, iot.Errorf(iot.TransportError, "malformed response to function %d", function)
+	}
+	bits := make([]bool, count)
+	for i := range bits {
+		bits[i] = resp[1+i/8]&(1<<(i%8)) != 0
+	}
+	return bits, nil
+}
+
+// ReadRegisters reads holding or input registers.
+func (c *Client) ReadRegisters(ctx context.Context, unit byte, function byte, addr, count uint16) ([]uint16, error) {
+	resp, err := c.call(ctx, unit, function, readRequest(addr, count))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 1 || int(resp[0]) != 2*int(count) || len(resp) != 1+int(resp[0]) {
+		return nil, iot.Errorf(iot.TransportError, "malformed response to function %d", function)
+	}
+	words := make([]uint16, count)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(resp[1+2*i:])
+	}
+	return words, nil
+}
+
+// WriteCoil sets a single coil.
+func (c *Client) WriteCoil(ctx context.Context, unit byte, addr uint16, on bool) error {
+	value := uint16(0x0000)
+	if on {
+		value = 0xFF00
+	}
+	_, err := c.call(ctx, unit, WriteSingleCoil, readRequest(addr, value))
+	return err
+}
+
+// WriteRegisters writes one register with function 6, or several with
+// function 16.
+func (c *Client) WriteRegisters(ctx context.Context, unit byte, addr uint16, words []uint16) error {
+	if len(words) == 1 {
+		_, err := c.call(ctx, unit, WriteSingleRegister, readRequest(addr, words[0]))
+		return err
+	}
+	req := make([]byte, 5+2*len(words))
+	binary.BigEndian.PutUint16(req, addr)
+	binary.BigEndian.PutUint16(req[2:], uint16(len(words)))
+	req[4] = byte(2 * len(words))
+	for i, w := range words {
+		binary.BigEndian.PutUint16(req[5+2*i:], w)
+	}
+	_, err := c.call(ctx, unit, WriteMultipleRegisters, req)
+	return err
+}
+
+// Close drops the connection; the next request reconnects.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop()
+}
+
+func readRequest(addr, value uint16) []byte {
+	req := make([]byte, 4)
+	binary.BigEndian.PutUint16(req, addr)
+	binary.BigEndian.PutUint16(req[2:], value)
+	return req
+}
+
+// call sends a request PDU and returns the response data after the
+// function code. The connection is dropped on any transport error, so a
+// late answer can't be taken for the next request's.
+func (c *Client) call(ctx context.Context, unit, function byte, data []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > callTimeout {
+		deadline = time.Now().Add(callTimeout)
+	}
+	if c.conn == nil {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return nil, c.transportError(ctx, err)
+		}
+		c.conn = conn
+	}
+	conn := c.conn
+	conn.SetDeadline(deadline)
+	// Unblock the read when ctx is canceled before the deadline.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c.txID++
+	frame := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint16(frame, c.txID)
+	binary.BigEndian.PutUint16(frame[2:], 0) // protocol ID
+	binary.BigEndian.PutUint16(frame[4:], uint16(2+len(data)))
+	frame[6] = unit
+	frame[7] = function
+	copy(frame[8:], data)
+	if _, err := c.conn.Write(frame); err != nil {
+		c.drop()
+		return nil, c.transportError(ctx, err)
+	}
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			c.drop()
+			return nil, c.transportError(ctx, err)
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 || length > 254 {
+			c.drop()
+			return nil, iot.Errorf(iot.TransportError, "invalid frame length %d from %s", length, c.addr)
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, pdu); err != nil {
+			c.drop()
+			return nil, c.transportError(ctx, err)
+		}
+		if binary.BigEndian.Uint16(header) != c.txID {
+			continue // answer to an earlier, timed out request
+		}
+		if pdu[0] == function|0x80 && len(pdu) >= 2 {
+			return nil, exceptionError(&Exception{Function: function, Code: pdu[1]})
+		}
+		if pdu[0] != function {
+			c.drop()
+			return nil, iot.Errorf(iot.TransportError, "response for function %d to function %d", pdu[0], function)
+		}
+		return pdu[1:], nil
+	}
+}
+
+func (c *Client) drop() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *Client) transportError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return iot.Errorf(iot.Timeout, "%s did not answer in time", c.addr)
+	}
+	return iot.Errorf(iot.TransportError, "%v", err)
+}
+
+// exceptionError maps an exception to an error kind: addressing and value
+// errors are the register map's or the caller's fault, gateway exceptions
+// mean the device behind the gateway is unreachable.
+func exceptionError(e *Exception) error {
+	switch e.Code {
+	case 1:
+		return iot.Errorf(iot.Unsupported, "%w", e)
+	case 2, 3:
+		return iot.Errorf(iot.InvalidValue, "%w", e)
+	case 10, 11:
+		return iot.Errorf(iot.DeviceOffline, "%w", e)
+	}
+	return iot.Errorf(iot.TransportError, "%w", e)
+}
@@ -0,0 +1,236 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// FakeServer is a Modbus TCP simulator for developing and testing the
+// driver without hardware. It serves coils, discrete inputs, holding and
+// input registers for any unit ID and answers reads of unmapped addresses
+// with an illegal data address exception, like a real meter does.
+type FakeServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	bits  map[byte]map[uint16]bool   // function 1 or 2 -> address -> value
+	words map[byte]map[uint16]uint16 // function 3 or 4 -> address -> value
+	conns map[net.Conn]bool
+	// Requests records the function code of every request received.
+	Requests []byte
+}
+
+// NewFakeServer starts a simulator on a free local port.
+func NewFakeServer() (*FakeServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeServer{
+		listener: l,
+		bits:     map[byte]map[uint16]bool{ReadCoils: {}, ReadDiscreteInputs: {}},
+		words:    map[byte]map[uint16]uint16{ReadHoldingRegisters: {}, ReadInputRegisters: {}},
+		conns:    make(map[net.Conn]bool),
+	}
+	go f.accept()
+	return f, nil
+}
+
+// Host returns the host:port to configure the driver with.
+func (f *FakeServer) Host() string {
+	return f.listener.Addr().String()
+}
+
+// SetRegisters maps holding (3) or input (4) registers starting at addr.
+func (f *FakeServer) SetRegisters(function byte, addr uint16, values ...uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, v := range values {
+		f.words[function][addr+uint16(i)] = v
+	}
+}
+
+// SetBits maps coils (1) or discrete inputs (2) starting at addr.
+func (f *FakeServer) SetBits(function byte, addr uint16, values ...bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, v := range values {
+		f.bits[function][addr+uint16(i)] = v
+	}
+}
+
+// Registers returns count holding (3) or input (4) registers at addr.
+func (f *FakeServer) Registers(function byte, addr uint16, count int) []uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]uint16, count)
+	for i := range values {
+		values[i] = f.words[function][addr+uint16(i)]
+	}
+	return values
+}
+
+// Bit returns a coil (1) or discrete input (2).
+func (f *FakeServer) Bit(function byte, addr uint16) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bits[function][addr]
+}
+
+// Close stops the simulator and drops its connections.
+func (f *FakeServer) Close() error {
+	err := f.listener.Close()
+	f.mu.Lock()
+	for c := range f.conns {
+		c.Close()
+	}
+	f.mu.Unlock()
+	return err
+}
+
+func (f *FakeServer) accept() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = true
+		f.mu.Unlock()
+		go f.serve(conn)
+	}
+}
+
+func (f *FakeServer) serve(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 || length > 254 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		resp := f.handle(pdu[0], pdu[1:])
+		frame := make([]byte, 7+len(resp))
+		copy(frame, header[:4]) // transaction and protocol ID
+		binary.BigEndian.PutUint16(frame[4:], uint16(1+len(resp)))
+		frame[6] = header[6]
+		copy(frame[7:], resp)
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// errException is an exception code to answer with.
+type errException byte
+
+func (e errException) Error() string { return "exception" }
+
+// handle executes a request and returns the response PDU.
+func (f *FakeServer) handle(function byte, data []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, function)
+
+	resp, err := f.execute(function, data)
+	var code errException
+	if errors.As(err, &code) {
+		return []byte{function | 0x80, byte(code)}
+	}
+	return append([]byte{function}, resp...)
+}
+
+func (f *FakeServer) execute(function byte, data []byte) ([]byte, error) {
+	const (
+		illegalFunction = errException(1)
+		illegalAddress  = errException(2)
+		illegalValue    = errException(3)
+	)
+	if len(data) < 4 {
+		return nil, illegalValue
+	}
+	addr := binary.BigEndian.Uint16(data)
+	value := binary.BigEndian.Uint16(data[2:])
+
+	switch function {
+	case ReadCoils, ReadDiscreteInputs:
+		if value == 0 || value > maxReadBits {
+			return nil, illegalValue
+		}
+		resp := make([]byte, 1+(value+7)/8)
+		resp[0] = byte((value + 7) / 8)
+		for i := uint16(0); i < value; i++ {
+			on, ok := f.bits[function][addr+i]
+			if !ok {
+				return nil, illegalAddress
+			}
+			if on {
+				resp[1+i/8] |= 1 << (i % 8)
+			}
+		}
+		return resp, nil
+
+	case ReadHoldingRegisters, ReadInputRegisters:
+		if value == 0 || value > maxReadRegisters {
+			return nil, illegalValue
+		}
+		resp := make([]byte, 1+2*value)
+		resp[0] = byte(2 * value)
+		for i := uint16(0); i < value; i++ {
+			w, ok := f.words[function][addr+i]
+			if !ok {
+				return nil, illegalAddress
+			}
+			binary.BigEndian.PutUint16(resp[1+2*i:], w)
+		}
+		return resp, nil
+
+	case WriteSingleCoil:
+		if value != 0xFF00 && value != 0x0000 {
+			return nil, illegalValue
+		}
+		if _, ok := f.bits[ReadCoils][addr]; !ok {
+			return nil, illegalAddress
+		}
+		f.bits[ReadCoils][addr] = value == 0xFF00
+		return data[:4], nil
+
+	case WriteSingleRegister:
+		if _, ok := f.words[ReadHoldingRegisters][addr]; !ok {
+			return nil, illegalAddress
+		}
+		f.words[ReadHoldingRegisters][addr] = value
+		return data[:4], nil
+
+	case WriteMultipleRegisters:
+		if value == 0 || len(data) != 5+2*int(value) || int(data[4]) != 2*int(value) {
+			return nil, illegalValue
+		}
+		for i := uint16(0); i < value; i++ {
+			if _, ok := f.words[ReadHoldingRegisters][addr+i]; !ok {
+				return nil, illegalAddress
+			}
+		}
+		for i := uint16(0); i < value; i++ {
+			f.words[ReadHoldingRegisters][addr+i] = binary.BigEndian.Uint16(data[5+2*i:])
+		}
+		return data[:4], nil
+	}
+	return nil, illegalFunction
+}
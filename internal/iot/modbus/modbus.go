@@ -0,0 +1,428 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// DeviceConfig is an entry of MODBUS_DEVICES_FILE: a device at a host, or a
+// unit behind a Modbus TCP gateway, and its register map.
+type DeviceConfig struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Room   string `json:"room"`
+	Host   string `json:"host"` // host or host:port, port 502 by default
+	UnitID byte   `json:"unit_id"`
+	// PollIntervalMS defaults to MODBUS_POLL_INTERVAL.
+	PollIntervalMS int        `json:"poll_interval_ms,omitempty"`
+	Registers      []Register `json:"registers"`
+}
+
+// block is one read request covering one or more registers.
+type block struct {
+	function byte
+	address  uint16
+	count    uint16
+	regs     []*Register
+}
+
+type modbusDevice struct {
+	cfg      DeviceConfig
+	client   *Client
+	blocks   []block
+	interval time.Duration
+
+	state  store.State
+	online bool
+	err    error // last poll error, logged once until the next success
+}
+
+// ModbusDriver polls Modbus TCP devices such as energy meters and inverters
+// into the store and writes coils and holding registers from SetState. What
+// to read is configured per device as a register map.
+type ModbusDriver struct {
+	mu      sync.Mutex
+	devices map[string]*modbusDevice
+	clients map[string]*Client // by host, shared by units behind one gateway
+
+	// ctx is canceled by Stop, ending the poll loops and requests in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var driver *ModbusDriver
+
+func init() {
+	iot.Register("modbus", func() iot.Driver {
+		driver = NewDriver()
+		return driver
+	})
+}
+
+func NewDriver() *ModbusDriver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ModbusDriver{
+		devices: make(map[string]*modbusDevice),
+		clients: make(map[string]*Client),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func GetDriver() *ModbusDriver {
+	return driver
+}
+
+// Start registers the devices in MODBUS_DEVICES_FILE and starts polling
+// them.
+func (d *ModbusDriver) Start() error {
+	devices, err := config.LoadJSONList[DeviceConfig](config.ModbusDevicesFile)
+	if err != nil {
+		log.Printf("[Modbus] Failed to load %s: %v", config.ModbusDevicesFile, err)
+	}
+	for _, cfg := range devices {
+		if err := d.AddDevice(cfg); err != nil {
+			log.Printf("[Modbus] Skipping device %q: %v", cfg.ID, err)
+		}
+	}
+	if len(devices) == 0 {
+		log.Println("[Modbus] No Modbus devices configured")
+	}
+	return nil
+}
+
+// AddDevice checks a device's register map, registers the device and starts
+// polling it.
+func (d *ModbusDriver) AddDevice(cfg DeviceConfig) error {
+	if cfg.ID == "" || cfg.Host == "" {
+		return errors.New("id and host are required")
+	}
+	if len(cfg.Registers) == 0 {
+		return errors.New("no registers")
+	}
+	seen := map[string]bool{}
+	for i := range cfg.Registers {
+		r := &cfg.Registers[i]
+		if err := r.normalize(); err != nil {
+			return err
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("register %s is mapped twice", r.Name)
+		}
+		seen[r.Name] = true
+	}
+	for _, r := range cfg.Registers {
+		if r.power() && seen["power"] {
+			return errors.New("a state coil and a register named power would both be the power capability")
+		}
+	}
+	interval := config.ModbusPollInterval
+	if cfg.PollIntervalMS > 0 {
+		interval = time.Duration(cfg.PollIntervalMS) * time.Millisecond
+	}
+
+	d.mu.Lock()
+	if _, exists := d.devices[cfg.ID]; exists {
+		d.mu.Unlock()
+		return fmt.Errorf("device %s is configured twice", cfg.ID)
+	}
+	client, ok := d.clients[cfg.Host]
+	if !ok {
+		client = NewClient(cfg.Host)
+		d.clients[cfg.Host] = client
+	}
+	dev := &modbusDevice{
+		cfg:      cfg,
+		client:   client,
+		blocks:   planReads(cfg.Registers),
+		interval: interval,
+		state:    store.State{},
+	}
+	d.devices[cfg.ID] = dev
+	d.mu.Unlock()
+
+	d.register(dev)
+	d.wg.Add(1)
+	go d.pollLoop(dev)
+	return nil
+}
+
+func (d *ModbusDriver) Stop() error {
+	d.cancel()
+	d.wg.Wait()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.clients {
+		c.Close()
+	}
+	return nil
+}
+
+func (d *ModbusDriver) Health() iot.Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.devices) == 0 {
+		return iot.Health{Status: iot.HealthDisabled, Detail: "no Modbus devices configured"}
+	}
+	var down []string
+	for id, dev := range d.devices {
+		if !dev.online {
+			down = append(down, id)
+		}
+	}
+	if len(down) > 0 {
+		sort.Strings(down)
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not reachable: " + strings.Join(down, ", ")}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+// planReads groups adjacent registers into as few read requests as the
+// protocol limits allow. Gaps are not bridged, as many devices answer reads
+// of unmapped addresses with an exception.
+func planReads(regs []Register) []block {
+	sorted := make([]*Register, len(regs))
+	for i := range regs {
+		sorted[i] = &regs[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Function != sorted[j].Function {
+			return sorted[i].Function < sorted[j].Function
+		}
+		return sorted[i].Address < sorted[j].Address
+	})
+
+	var blocks []block
+	for _, r := range sorted {
+		limit := maxReadRegisters
+		if r.bits() {
+			limit = maxReadBits
+		}
+		end := int(r.Address) + r.count()
+		if n := len(blocks); n > 0 {
+			b := &blocks[n-1]
+			blockEnd := int(b.address) + int(b.count)
+			if b.function == r.Function && int(r.Address) <= blockEnd && end-int(b.address) <= limit {
+				if end > blockEnd {
+					b.count = uint16(end - int(b.address))
+				}
+				b.regs = append(b.regs, r)
+				continue
+			}
+		}
+		blocks = append(blocks, block{function: r.Function, address: r.Address, count: uint16(r.count()), regs: []*Register{r}})
+	}
+	return blocks
+}
+
+// register adds the device to the store, or refreshes the stored one.
+func (d *ModbusDriver) register(dev *modbusDevice) {
+	cfg := dev.cfg
+	caps := make([]store.Capability, 0, len(cfg.Registers))
+	for i := range cfg.Registers {
+		caps = append(caps, cfg.Registers[i].capability())
+	}
+	deviceType := cfg.Type
+	if deviceType == "" {
+		deviceType = "sensor"
+	}
+
+	added, err := iot.RegisterDevice(store.Device{
+		ID:           cfg.ID,
+		Name:         cfg.Name,
+		Type:         deviceType,
+		Protocol:     "modbus",
+		Room:         cfg.Room,
+		Capabilities: caps,
+	})
+	if err != nil {
+		log.Printf("[Modbus] Failed to register device %s: %v", cfg.ID, err)
+	} else if added {
+		log.Printf("[Modbus] Registered %s", cfg.ID)
+	}
+}
+
+func (d *ModbusDriver) pollLoop(dev *modbusDevice) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(dev.interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(d.ctx, dev.interval)
+		d.poll(ctx, dev)
+		cancel()
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads every block of the device and stores the values that changed.
+// A failed poll marks the device offline.
+func (d *ModbusDriver) poll(ctx context.Context, dev *modbusDevice) (store.State, error) {
+	state, err := d.read(ctx, dev)
+	if err != nil && d.ctx.Err() != nil {
+		return nil, err // stopping
+	}
+	ds := factory.GetDeviceStore()
+	id := dev.cfg.ID
+
+	d.mu.Lock()
+	wasOnline, lastErr := dev.online, dev.err
+	dev.online, dev.err = err == nil, err
+	changes := store.State{}
+	if err == nil {
+		for k, v := range state {
+			if old, ok := dev.state[k]; !ok || fmt.Sprint(old) != fmt.Sprint(v) {
+				changes[k] = v
+			}
+			dev.state[k] = v
+		}
+	}
+	d.mu.Unlock()
+
+	if err != nil {
+		if lastErr == nil || lastErr.Error() != err.Error() {
+			log.Printf("[Modbus] Failed to poll %s: %v", id, err)
+		}
+		if wasOnline || lastErr == nil {
+			ds.UpdateStatus(id, store.DeviceStatus{Availability: store.Offline})
+		}
+		return nil, err
+	}
+	if !wasOnline && lastErr != nil {
+		log.Printf("[Modbus] %s is reachable again", id)
+	}
+	if len(changes) > 0 {
+		if err := ds.UpdateState(id, changes); err != nil {
+			log.Printf("[Modbus] Failed to update state for %s: %v", id, err)
+		}
+	}
+	now := time.Now()
+	ds.UpdateStatus(id, store.DeviceStatus{Availability: store.Online, LastSeen: &now})
+	return state, nil
+}
+
+// read reads all blocks and decodes the registers in them.
+func (d *ModbusDriver) read(ctx context.Context, dev *modbusDevice) (store.State, error) {
+	state := store.State{}
+	unit := dev.cfg.UnitID
+	for _, b := range dev.blocks {
+		if b.function == ReadCoils || b.function == ReadDiscreteInputs {
+			bits, err := dev.client.ReadBits(ctx, unit, b.function, b.address, b.count)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range b.regs {
+				state[r.Name] = r.decode(bits[r.Address-b.address], nil)
+			}
+			continue
+		}
+		words, err := dev.client.ReadRegisters(ctx, unit, b.function, b.address, b.count)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range b.regs {
+			offset := int(r.Address - b.address)
+			if v := r.decode(false, words[offset:offset+r.count()]); v != nil {
+				state[r.Name] = v
+			}
+		}
+	}
+	return state, nil
+}
+
+func (d *ModbusDriver) known(id string) (*modbusDevice, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dev, ok := d.devices[id]
+	return dev, ok
+}
+
+// GetState reads the device now rather than returning the last poll.
+func (d *ModbusDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	dev, ok := d.known(device.ID)
+	if !ok {
+		return nil, iot.Errorf(iot.Unsupported, "unknown Modbus device %s", device.ID)
+	}
+	return d.poll(ctx, dev)
+}
+
+// SetState writes each update to its coil or holding register, in address
+// order. Nothing is written unless every update is valid.
+func (d *ModbusDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	dev, ok := d.known(device.ID)
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "unknown Modbus device %s", device.ID)
+	}
+
+	type write struct {
+		reg   *Register
+		on    bool
+		words []uint16
+	}
+	var writes []write
+	for key := range updates {
+		var reg *Register
+		for i := range dev.cfg.Registers {
+			if dev.cfg.Registers[i].Name == key {
+				reg = &dev.cfg.Registers[i]
+			}
+		}
+		if reg == nil || !reg.Writable {
+			return iot.Errorf(iot.Unsupported, "%s is not writable on %s", key, device.ID)
+		}
+		w := write{reg: reg}
+		var err error
+		if reg.bits() {
+			w.on, err = parseOnOff(updates[key])
+		} else {
+			w.words, err = reg.encode(updates[key])
+		}
+		if err != nil {
+			return err
+		}
+		writes = append(writes, w)
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].reg.Address < writes[j].reg.Address })
+
+	applied := store.State{}
+	for _, w := range writes {
+		var err error
+		if w.reg.bits() {
+			err = dev.client.WriteCoil(ctx, dev.cfg.UnitID, w.reg.Address, w.on)
+		} else {
+			err = dev.client.WriteRegisters(ctx, dev.cfg.UnitID, w.reg.Address, w.words)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", w.reg.Name, err)
+		}
+		if w.reg.bits() {
+			applied[w.reg.Name] = w.reg.decode(w.on, nil)
+		} else {
+			applied[w.reg.Name] = w.reg.decode(false, w.words)
+		}
+	}
+
+	// Keep the next poll from reporting the written values as changes.
+	d.mu.Lock()
+	for k, v := range applied {
+		dev.state[k] = v
+	}
+	d.mu.Unlock()
+	return nil
+}
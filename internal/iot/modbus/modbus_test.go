@@ -0,0 +1,170 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+func testDevice(host string) DeviceConfig {
+	return DeviceConfig{
+		ID:             "meter",
+		Name:           "Heat pump",
+		Type:           "thermostat",
+		Room:           "Basement",
+		Host:           host,
+		UnitID:         3,
+		PollIntervalMS: 20,
+		Registers: []Register{
+			{Name: "state", Function: ReadCoils, Address: 0, Writable: true},
+			{Name: "alarm", Function: ReadDiscreteInputs, Address: 4},
+			{Name: "voltage", Function: ReadInputRegisters, Address: 0, Type: "float32", Unit: "V"},
+			{Name: "energy", Function: ReadInputRegisters, Address: 2, Type: "uint32", Scale: 0.01, Unit: "kWh"},
+			{Name: "setpoint", Address: 100, Type: "int16", Scale: 0.1, Unit: "°C", Writable: true},
+			{Name: "mode", Address: 101, Writable: true},
+		},
+	}
+}
+
+// startDriver polls a device on a fake server and waits for its first
+// values.
+func startDriver(t *testing.T) (*ModbusDriver, *FakeServer) {
+	t.Helper()
+	testutil.UseMemoryStore()
+
+	fake, err := NewFakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	fake.SetBits(ReadCoils, 0, true)
+	fake.SetBits(ReadDiscreteInputs, 4, false)
+	fake.SetRegisters(ReadInputRegisters, 0, 0x4366, 0x199A, 0x0001, 0x0000)
+	fake.SetRegisters(ReadHoldingRegisters, 100, 205, 2)
+
+	d := NewDriver()
+	if err := d.AddDevice(testDevice(fake.Host())); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Stop() })
+
+	testutil.WaitFor(t, "first poll", func() bool { return testutil.StoredState("meter", "mode") != nil })
+	return d, fake
+}
+
+func TestPollRegistersDevice(t *testing.T) {
+	startDriver(t)
+
+	device, _ := factory.GetDeviceStore().Get("meter")
+	if device.Name != "Heat pump" || device.Room != "Basement" || device.Type != "thermostat" || device.Protocol != "modbus" {
+		t.Errorf("device = %+v", device)
+	}
+	if len(device.Capabilities) != 6 {
+		t.Errorf("capabilities = %+v", device.Capabilities)
+	}
+	want := store.State{"state": "on", "alarm": false, "voltage": 230.1, "energy": 655.36, "setpoint": 20.5, "mode": int64(2)}
+	for k, v := range want {
+		if device.State[k] != v {
+			t.Errorf("state[%s] = %#v, want %#v", k, device.State[k], v)
+		}
+	}
+	if device.Availability != store.Online {
+		t.Errorf("availability = %q", device.Availability)
+	}
+}
+
+func TestPollUpdatesStore(t *testing.T) {
+	_, fake := startDriver(t)
+
+	fake.SetRegisters(ReadHoldingRegisters, 101, 7)
+	fake.SetBits(ReadDiscreteInputs, 4, true)
+	testutil.WaitFor(t, "mode 7", func() bool { return testutil.StoredState("meter", "mode") == int64(7) })
+	testutil.WaitFor(t, "alarm", func() bool { return testutil.StoredState("meter", "alarm") == true })
+}
+
+func TestSetStateRoundTrip(t *testing.T) {
+	d, fake := startDriver(t)
+	device, _ := factory.GetDeviceStore().Get("meter")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.SetState(ctx, device, store.State{"state": "off", "setpoint": 21.5}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Bit(ReadCoils, 0) {
+		t.Error("coil still on")
+	}
+	if got := fake.Registers(ReadHoldingRegisters, 100, 1)[0]; got != 215 {
+		t.Errorf("setpoint register = %d, want 215", got)
+	}
+
+	state, err := d.GetState(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["state"] != "off" || state["setpoint"] != 21.5 {
+		t.Errorf("GetState() = %v", state)
+	}
+}
+
+func TestSetStateRejects(t *testing.T) {
+	d, fake := startDriver(t)
+	device, _ := factory.GetDeviceStore().Get("meter")
+	ctx := context.Background()
+
+	if err := d.SetState(ctx, device, store.State{"voltage": 1}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("writing an input register: err = %v, want unsupported", err)
+	}
+	// One invalid update keeps the valid ones from being written too.
+	if err := d.SetState(ctx, device, store.State{"mode": 3, "setpoint": 4000}); iot.KindOf(err) != iot.InvalidValue {
+		t.Errorf("out of range setpoint: err = %v, want invalid value", err)
+	}
+	if got := fake.Registers(ReadHoldingRegisters, 101, 1)[0]; got != 2 {
+		t.Errorf("mode register = %d, want it unchanged", got)
+	}
+	if _, err := d.GetState(ctx, store.Device{ID: "other"}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("unknown device: err = %v, want unsupported", err)
+	}
+}
+
+func TestUnreachableDeviceGoesOffline(t *testing.T) {
+	d, fake := startDriver(t)
+	fake.Close()
+
+	testutil.WaitFor(t, "device offline", func() bool {
+		device, _ := factory.GetDeviceStore().Get("meter")
+		return device.Availability == store.Offline
+	})
+	if h := d.Health(); h.Status != iot.HealthDegraded {
+		t.Errorf("health = %+v, want degraded", h)
+	}
+}
+
+func TestAddDeviceRejects(t *testing.T) {
+	d := NewDriver()
+	defer d.Stop()
+
+	noHost := testDevice("")
+	twice := testDevice("127.0.0.1:1")
+	twice.Registers = append(twice.Registers, Register{Name: "mode", Address: 200})
+	power := testDevice("127.0.0.1:1")
+	power.Registers = append(power.Registers, Register{Name: "power", Address: 200})
+	empty := testDevice("127.0.0.1:1")
+	empty.Registers = nil
+
+	for name, cfg := range map[string]DeviceConfig{
+		"no host":                noHost,
+		"register mapped twice":  twice,
+		"two power capabilities": power,
+		"no registers":           empty,
+	} {
+		if err := d.AddDevice(cfg); err == nil {
+			t.Errorf("%s: AddDevice succeeded", name)
+		}
+	}
+}
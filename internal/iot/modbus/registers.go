@@ -0,0 +1,297 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// Register maps one value of a device to a state key.
+//
+// Function is the read function: 1 coils, 2 discrete inputs, 3 holding
+// registers (the default) or 4 input registers. Coils and holding registers
+// can be made writable. Type is bool for coils and discrete inputs and one
+// of int16, uint16, int32, uint32, float32, int64, uint64 or float64 for
+// registers. Values wider than one register are read big-endian within a
+// register and with the most significant register first, unless WordOrder
+// is "little". The stored value is raw*Scale+Offset.
+type Register struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Address     uint16  `json:"address"`
+	Function    byte    `json:"function,omitempty"`
+	Type        string  `json:"type,omitempty"`
+	WordOrder   string  `json:"word_order,omitempty"` // big or little
+	Scale       float64 `json:"scale,omitempty"`
+	Offset      float64 `json:"offset,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Writable    bool    `json:"writable,omitempty"`
+}
+
+// registerWords is the number of registers each register type spans.
+var registerWords = map[string]int{
+	"int16": 1, "uint16": 1,
+	"int32": 2, "uint32": 2, "float32": 2,
+	"int64": 4, "uint64": 4, "float64": 4,
+}
+
+// normalize fills in defaults and checks the register against its function.
+func (r *Register) normalize() error {
+	if r.Name == "" {
+		return fmt.Errorf("register at address %d has no name", r.Address)
+	}
+	if r.Function == 0 {
+		r.Function = ReadHoldingRegisters
+	}
+	if r.Type == "" {
+		r.Type = "uint16"
+		if r.bits() {
+			r.Type = "bool"
+		}
+	}
+	r.Type = strings.ToLower(r.Type)
+	r.WordOrder = strings.ToLower(r.WordOrder)
+	if r.Scale == 0 {
+		r.Scale = 1
+	}
+
+	switch r.Function {
+	case ReadCoils, ReadDiscreteInputs:
+		if r.Type != "bool" {
+			return fmt.Errorf("register %s: function %d reads bits, type must be bool", r.Name, r.Function)
+		}
+	case ReadHoldingRegisters, ReadInputRegisters:
+		if registerWords[r.Type] == 0 {
+			return fmt.Errorf("register %s: unknown type %q", r.Name, r.Type)
+		}
+	default:
+		return fmt.Errorf("register %s: unsupported function %d", r.Name, r.Function)
+	}
+	if r.Writable && r.Function != ReadCoils && r.Function != ReadHoldingRegisters {
+		return fmt.Errorf("register %s: only coils and holding registers are writable", r.Name)
+	}
+	if r.WordOrder != "" && r.WordOrder != "big" && r.WordOrder != "little" {
+		return fmt.Errorf("register %s: word_order must be big or little", r.Name)
+	}
+	if int(r.Address)+r.count() > 0x10000 {
+		return fmt.Errorf("register %s: address out of range", r.Name)
+	}
+	return nil
+}
+
+func (r *Register) bits() bool {
+	return r.Function == ReadCoils || r.Function == ReadDiscreteInputs
+}
+
+// count is the number of bits or registers the value spans.
+func (r *Register) count() int {
+	if r.bits() {
+		return 1
+	}
+	return registerWords[r.Type]
+}
+
+// power reports whether the register is a device's on/off switch, stored as
+// "on"/"off" under "state" like other drivers' switches.
+func (r *Register) power() bool {
+	return r.Function == ReadCoils && r.Name == "state"
+}
+
+// decode converts the bit or registers read for r to its state value.
+// Integers stay int64 unless scaled, everything else is a float64.
+func (r *Register) decode(bit bool, words []uint16) interface{} {
+	if r.bits() {
+		if r.power() {
+			return onOff(bit)
+		}
+		return bit
+	}
+
+	var raw uint64
+	for _, w := range r.ordered(words) {
+		raw = raw<<16 | uint64(w)
+	}
+	var value float64
+	var integer int64
+	isInteger := true
+	switch r.Type {
+	case "int16":
+		integer = int64(int16(raw))
+	case "uint16", "uint32":
+		integer = int64(raw)
+	case "int32":
+		integer = int64(int32(raw))
+	case "int64":
+		integer = int64(raw)
+	case "uint64":
+		if raw > math.MaxInt64 {
+			isInteger = false
+			value = float64(raw)
+		} else {
+			integer = int64(raw)
+		}
+	case "float32":
+		isInteger = false
+		// Format with float32 precision, so 230.1 doesn't read as
+		// 230.10000610351562.
+		value, _ = strconv.ParseFloat(strconv.FormatFloat(float64(math.Float32frombits(uint32(raw))), 'g', -1, 32), 64)
+	case "float64":
+		isInteger = false
+		value = math.Float64frombits(raw)
+	}
+
+	if isInteger && r.Scale == 1 && r.Offset == 0 {
+		return integer
+	}
+	if isInteger {
+		value = float64(integer)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return round(value*r.Scale + r.Offset)
+}
+
+// encode converts a state value to the registers to write for r.
+func (r *Register) encode(value interface{}) ([]uint16, error) {
+	n, ok := iot.Number(value)
+	if !ok {
+		return nil, iot.Errorf(iot.InvalidValue, "invalid value %v for %s, expected a number", value, r.Name)
+	}
+	raw := (n - r.Offset) / r.Scale
+
+	var bits uint64
+	switch r.Type {
+	case "float32":
+		if math.Abs(raw) > math.MaxFloat32 {
+			return nil, r.outOfRange(value)
+		}
+		bits = uint64(math.Float32bits(float32(raw)))
+	case "float64":
+		bits = math.Float64bits(raw)
+	default:
+		raw = math.Round(raw)
+		min, max := integerRange(r.Type)
+		if raw < min || raw > max {
+			return nil, r.outOfRange(value)
+		}
+		if raw < 0 {
+			bits = uint64(int64(raw))
+		} else {
+			bits = uint64(raw)
+		}
+	}
+
+	words := make([]uint16, r.count())
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = uint16(bits)
+		bits >>= 16
+	}
+	return r.ordered(words), nil
+}
+
+func (r *Register) outOfRange(value interface{}) error {
+	return iot.Errorf(iot.InvalidValue, "value %v out of range for %s (%s)", value, r.Name, r.Type)
+}
+
+// ordered swaps between the device's word order and most significant
+// register first. The swap is its own inverse.
+func (r *Register) ordered(words []uint16) []uint16 {
+	if r.WordOrder != "little" {
+		return words
+	}
+	out := make([]uint16, len(words))
+	for i, w := range words {
+		out[len(words)-1-i] = w
+	}
+	return out
+}
+
+func integerRange(typ string) (float64, float64) {
+	switch typ {
+	case "int16":
+		return math.MinInt16, math.MaxInt16
+	case "uint16":
+		return 0, math.MaxUint16
+	case "int32":
+		return math.MinInt32, math.MaxInt32
+	case "uint32":
+		return 0, math.MaxUint32
+	case "int64":
+		return math.MinInt64, math.MaxInt64
+	}
+	return 0, math.MaxUint64
+}
+
+// capability describes r: on/off for a "state" coil, a boolean for other
+// bits and a number with its unit for registers.
+func (r *Register) capability() store.Capability {
+	description := r.Description
+	if description == "" {
+		description = strings.ReplaceAll(r.Name, "_", " ")
+	}
+	if r.power() {
+		if r.Description == "" {
+			description = "Turn the device on or off"
+		}
+		return store.Capability{
+			Name:        "power",
+			Description: description,
+			Operations:  []string{"on", "off"},
+			Parameters: map[string]interface{}{
+				"state": map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+			},
+			Writable: r.Writable,
+		}
+	}
+	param := map[string]interface{}{"type": "number"}
+	switch {
+	case r.bits():
+		param["type"] = "boolean"
+	case r.Scale == 1 && r.Offset == 0 && !strings.HasPrefix(r.Type, "float"):
+		param["type"] = "integer"
+		if r.Type != "int64" && r.Type != "uint64" {
+			min, max := integerRange(r.Type)
+			param["range"] = []int64{int64(min), int64(max)}
+		}
+	}
+	if r.Unit != "" {
+		param["unit"] = r.Unit
+	}
+	c := store.Capability{
+		Name:        r.Name,
+		Description: description,
+		Parameters:  map[string]interface{}{r.Name: param},
+		Writable:    r.Writable,
+	}
+	if r.Writable {
+		c.Operations = []string{"set"}
+	}
+	return c
+}
+
+// round drops the float noise that scaling adds, e.g. 2301*0.1.
+func round(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func parseOnOff(value interface{}) (bool, error) {
+	switch strings.ToLower(store.FormatValue(value)) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, iot.Errorf(iot.InvalidValue, "invalid value %v, expected on/off", value)
+}
@@ -0,0 +1,178 @@
+package modbus
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"iot-bridge/internal/iot"
+)
+
+func normalized(t *testing.T, r Register) *Register {
+	t.Helper()
+	if err := r.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	return &r
+}
+
+func TestDecode(t *testing.T) {
+	nan := math.Float64bits(math.NaN())
+	tests := []struct {
+		name  string
+		reg   Register
+		bit   bool
+		words []uint16
+		want  interface{}
+	}{
+		{"state coil", Register{Name: "state", Function: ReadCoils}, true, nil, "on"},
+		{"discrete input", Register{Name: "alarm", Function: ReadDiscreteInputs}, true, nil, true},
+		{"uint16", Register{Name: "v"}, false, []uint16{0xFFFF}, int64(65535)},
+		{"int16", Register{Name: "v", Type: "int16"}, false, []uint16{0xFFFF}, int64(-1)},
+		{"uint32", Register{Name: "v", Type: "uint32"}, false, []uint16{0xFFFF, 0xFFFF}, int64(math.MaxUint32)},
+		{"int32", Register{Name: "v", Type: "int32"}, false, []uint16{0xFFFF, 0xFFFE}, int64(-2)},
+		{"int32 little", Register{Name: "v", Type: "int32", WordOrder: "little"}, false, []uint16{0x0000, 0x0001}, int64(65536)},
+		{"int64", Register{Name: "v", Type: "int64"}, false, []uint16{0x8000, 0, 0, 0}, int64(math.MinInt64)},
+		{"uint64 beyond int64", Register{Name: "v", Type: "uint64"}, false, []uint16{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF}, float64(math.MaxUint64)},
+		{"float32", Register{Name: "v", Type: "float32"}, false, []uint16{0x4366, 0x199A}, 230.1},
+		{"float64 NaN", Register{Name: "v", Type: "float64"}, false, []uint16{uint16(nan >> 48), uint16(nan >> 32), uint16(nan >> 16), uint16(nan)}, nil},
+		{"scaled", Register{Name: "v", Type: "int16", Scale: 0.1}, false, []uint16{2301}, 230.1},
+		{"offset", Register{Name: "v", Type: "int16", Scale: 0.5, Offset: -40}, false, []uint16{100}, 10.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := normalized(t, tt.reg)
+			if got := r.decode(tt.bit, tt.words); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		reg   Register
+		value interface{}
+		want  []uint16
+		kind  iot.ErrorKind // set when encoding fails
+	}{
+		{"uint16", Register{Name: "v"}, 1234, []uint16{1234}, ""},
+		{"int16 negative", Register{Name: "v", Type: "int16"}, -2, []uint16{0xFFFE}, ""},
+		{"uint32 string", Register{Name: "v", Type: "uint32"}, "65536", []uint16{1, 0}, ""},
+		{"int32 little", Register{Name: "v", Type: "int32", WordOrder: "little"}, int64(-2), []uint16{0xFFFE, 0xFFFF}, ""},
+		{"float32", Register{Name: "v", Type: "float32"}, 230.1, []uint16{0x4366, 0x199A}, ""},
+		{"scaled rounds", Register{Name: "v", Type: "int16", Scale: 0.1}, 21.46, []uint16{215}, ""},
+		{"uint16 too large", Register{Name: "v"}, 70000, nil, iot.InvalidValue},
+		{"int16 too small", Register{Name: "v", Type: "int16"}, -40000, nil, iot.InvalidValue},
+		{"uint32 negative", Register{Name: "v", Type: "uint32"}, -1, nil, iot.InvalidValue},
+		{"not a number", Register{Name: "v"}, "warm", nil, iot.InvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := normalized(t, tt.reg)
+			got, err := r.encode(tt.value)
+			if tt.kind != "" {
+				if err == nil || iot.KindOf(err) != tt.kind {
+					t.Fatalf("encode() err = %v, want %s", err, tt.kind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("encode() = %#04x, want %#04x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapability(t *testing.T) {
+	tests := []struct {
+		name      string
+		reg       Register
+		paramType string
+		lo, hi    float64
+		hasRange  bool
+	}{
+		{"int16", Register{Name: "v", Type: "int16"}, "integer", math.MinInt16, math.MaxInt16, true},
+		{"uint32", Register{Name: "v", Type: "uint32"}, "integer", 0, math.MaxUint32, true},
+		{"int32", Register{Name: "v", Type: "int32"}, "integer", math.MinInt32, math.MaxInt32, true},
+		{"int64", Register{Name: "v", Type: "int64"}, "integer", 0, 0, false},
+		{"scaled", Register{Name: "v", Type: "int16", Scale: 0.1}, "number", 0, 0, false},
+		{"float32", Register{Name: "v", Type: "float32"}, "number", 0, 0, false},
+		{"discrete input", Register{Name: "v", Function: ReadDiscreteInputs}, "boolean", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := normalized(t, tt.reg).capability()
+			param := c.Parameters["v"].(map[string]interface{})
+			if param["type"] != tt.paramType {
+				t.Errorf("type = %v, want %s", param["type"], tt.paramType)
+			}
+			lo, hi, ok := iot.RangeBounds(param["range"])
+			if ok != tt.hasRange || lo != tt.lo || hi != tt.hi {
+				t.Errorf("range = %v (%v, %v, %v), want %v, %v, %v", param["range"], lo, hi, ok, tt.lo, tt.hi, tt.hasRange)
+			}
+		})
+	}
+
+	power := normalized(t, Register{Name: "state", Function: ReadCoils, Writable: true}).capability()
+	if power.Name != "power" || !power.Writable || !reflect.DeepEqual(power.Operations, []string{"on", "off"}) {
+		t.Errorf("state coil capability = %+v", power)
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		reg  Register
+	}{
+		{"no name", Register{Address: 1}},
+		{"bits with register type", Register{Name: "v", Function: ReadCoils, Type: "uint16"}},
+		{"unknown type", Register{Name: "v", Type: "int8"}},
+		{"unknown function", Register{Name: "v", Function: 5}},
+		{"writable input register", Register{Name: "v", Function: ReadInputRegisters, Writable: true}},
+		{"bad word order", Register{Name: "v", Type: "int32", WordOrder: "middle"}},
+		{"past the last address", Register{Name: "v", Type: "uint32", Address: 0xFFFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.reg.normalize(); err == nil {
+				t.Error("normalize() accepted the register")
+			}
+		})
+	}
+}
+
+func TestPlanReads(t *testing.T) {
+	regs := []Register{
+		{Name: "b", Address: 1},
+		{Name: "a", Address: 0},
+		{Name: "wide", Address: 2, Type: "uint32"},
+		{Name: "gap", Address: 10},
+		{Name: "input", Address: 0, Function: ReadInputRegisters},
+		{Name: "state", Address: 0, Function: ReadCoils},
+	}
+	for i := range regs {
+		if err := regs[i].normalize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, b := range planReads(regs) {
+		names := ""
+		for _, r := range b.regs {
+			names += r.Name + " "
+		}
+		got = append(got, names)
+		if b.function == ReadHoldingRegisters && b.address == 0 && b.count != 4 {
+			t.Errorf("first holding block count = %d, want 4", b.count)
+		}
+	}
+	want := []string{"state ", "a b wide ", "gap ", "input "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("blocks = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,619 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// discoveryConfig is the subset of a Home Assistant MQTT discovery payload the
+// bridge understands, after abbreviations and "~" have been expanded.
+type discoveryConfig struct {
+	Name          string      `json:"name"`
+	StateTopic    string      `json:"state_topic"`
+	CommandTopic  string      `json:"command_topic"`
+	ValueTemplate string      `json:"value_template"`
+	PayloadOn     interface{} `json:"payload_on"`
+	PayloadOff    interface{} `json:"payload_off"`
+	StateOn       interface{} `json:"state_on"`
+	StateOff      interface{} `json:"state_off"`
+	Schema        string      `json:"schema"`
+
+	BrightnessCommandTopic  string   `json:"brightness_command_topic"`
+	BrightnessStateTopic    string   `json:"brightness_state_topic"`
+	BrightnessValueTemplate string   `json:"brightness_value_template"`
+	BrightnessScale         *float64 `json:"brightness_scale"`
+	Brightness              bool     `json:"brightness"`
+	RGBCommandTopic         string   `json:"rgb_command_topic"`
+	RGBStateTopic           string   `json:"rgb_state_topic"`
+	ColorTempCommandTopic   string   `json:"color_temp_command_topic"`
+	ColorTempStateTopic     string   `json:"color_temp_state_topic"`
+	MinMireds               *float64 `json:"min_mireds"`
+	MaxMireds               *float64 `json:"max_mireds"`
+
+	UnitOfMeasurement string   `json:"unit_of_measurement"`
+	DeviceClass       string   `json:"device_class"`
+	Min               *float64 `json:"min"`
+	Max               *float64 `json:"max"`
+	Step              *float64 `json:"step"`
+	Options           []string `json:"options"`
+
+	ModeCommandTopic         string   `json:"mode_command_topic"`
+	ModeStateTopic           string   `json:"mode_state_topic"`
+	Modes                    []string `json:"modes"`
+	TemperatureCommandTopic  string   `json:"temperature_command_topic"`
+	TemperatureStateTopic    string   `json:"temperature_state_topic"`
+	CurrentTemperatureTopic  string   `json:"current_temperature_topic"`
+	CurrentTemperatureTmpl   string   `json:"current_temperature_template"`
+	MinTemp                  *float64 `json:"min_temp"`
+	MaxTemp                  *float64 `json:"max_temp"`
+	TempStep                 *float64 `json:"temp_step"`
+	TemperatureStateTemplate string   `json:"temperature_state_template"`
+
+	PayloadOpen      string   `json:"payload_open"`
+	PayloadClose     string   `json:"payload_close"`
+	PayloadStop      string   `json:"payload_stop"`
+	PositionTopic    string   `json:"position_topic"`
+	SetPositionTopic string   `json:"set_position_topic"`
+	PositionOpen     *float64 `json:"position_open"`
+	PositionClosed   *float64 `json:"position_closed"`
+
+	Device struct {
+		Name          string `json:"name"`
+		Manufacturer  string `json:"manufacturer"`
+		Model         string `json:"model"`
+		SuggestedArea string `json:"suggested_area"`
+	} `json:"device"`
+}
+
+// hassAbbreviations covers the abbreviated keys commonly sent by firmwares
+// such as Tasmota, ESPHome and zigbee2mqtt.
+var hassAbbreviations = map[string]string{
+	"uniq_id":         "unique_id",
+	"stat_t":          "state_topic",
+	"cmd_t":           "command_topic",
+	"val_tpl":         "value_template",
+	"pl_on":           "payload_on",
+	"pl_off":          "payload_off",
+	"stat_on":         "state_on",
+	"stat_off":        "state_off",
+	"bri_cmd_t":       "brightness_command_topic",
+	"bri_stat_t":      "brightness_state_topic",
+	"bri_val_tpl":     "brightness_value_template",
+	"bri_scl":         "brightness_scale",
+	"rgb_cmd_t":       "rgb_command_topic",
+	"rgb_stat_t":      "rgb_state_topic",
+	"clr_temp_cmd_t":  "color_temp_command_topic",
+	"clr_temp_stat_t": "color_temp_state_topic",
+	"min_mirs":        "min_mireds",
+	"max_mirs":        "max_mireds",
+	"unit_of_meas":    "unit_of_measurement",
+	"dev_cla":         "device_class",
+	"ops":             "options",
+	"mode_cmd_t":      "mode_command_topic",
+	"mode_stat_t":     "mode_state_topic",
+	"temp_cmd_t":      "temperature_command_topic",
+	"temp_stat_t":     "temperature_state_topic",
+	"temp_stat_tpl":   "temperature_state_template",
+	"curr_temp_t":     "current_temperature_topic",
+	"curr_temp_tpl":   "current_temperature_template",
+	"temp_step":       "temp_step",
+	"pl_open":         "payload_open",
+	"pl_cls":          "payload_close",
+	"pl_stop":         "payload_stop",
+	"pos_t":           "position_topic",
+	"set_pos_t":       "set_position_topic",
+	"pos_open":        "position_open",
+	"pos_clsd":        "position_closed",
+	"dev":             "device",
+	"mf":              "manufacturer",
+	"mdl":             "model",
+	"sa":              "suggested_area",
+}
+
+var hassComponentTypes = map[string]string{
+	"light":         "bulb",
+	"switch":        "switch",
+	"sensor":        "sensor",
+	"binary_sensor": "sensor",
+	"climate":       "thermostat",
+	"cover":         "cover",
+	"number":        "number",
+	"select":        "select",
+}
+
+// BridgeDiscoveryNode is the node_id the bridge uses when publishing its own
+// devices to Home Assistant.
+const BridgeDiscoveryNode = "iot_bridge"
+
+// EnableDiscovery makes the driver ingest Home Assistant discovery messages
+// published under prefix (usually "homeassistant").
+func (d *Driver) EnableDiscovery(prefix string) {
+	d.discoveryPrefix = strings.TrimSuffix(prefix, "/")
+	if d.client != nil && d.client.IsConnectionOpen() {
+		d.subscribeDiscovery(d.client)
+	}
+}
+
+func (d *Driver) subscribeDiscovery(c paho.Client) {
+	filters := map[string]byte{
+		d.discoveryPrefix + "/+/+/config":   0,
+		d.discoveryPrefix + "/+/+/+/config": 0,
+	}
+	token := c.SubscribeMultiple(filters, func(_ paho.Client, msg paho.Message) {
+		d.handleDiscovery(msg.Topic(), msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Println("[MQTT] Failed to subscribe to discovery:", token.Error())
+	}
+}
+
+func (d *Driver) handleDiscovery(topic string, payload []byte) {
+	parts := strings.Split(strings.TrimPrefix(topic, d.discoveryPrefix+"/"), "/")
+	var component, nodeID, objectID string
+	switch len(parts) {
+	case 3:
+		component, objectID = parts[0], parts[1]
+	case 4:
+		component, nodeID, objectID = parts[0], parts[1], parts[2]
+	default:
+		return
+	}
+
+	// Entities the bridge publishes itself (see internal/hass) are not devices to ingest.
+	if nodeID == BridgeDiscoveryNode {
+		return
+	}
+
+	id := discoveryDeviceID(nodeID, objectID)
+
+	// An empty retained payload is how Home Assistant removes an entity.
+	if len(payload) == 0 {
+		d.RemoveDevice(id)
+		if err := factory.GetDeviceStore().Delete(id); err != nil {
+			log.Printf("[MQTT] Failed to remove discovered device %s: %v", id, err)
+		}
+		log.Printf("[MQTT] Discovery removed %s", id)
+		return
+	}
+
+	cfg, err := DeviceFromDiscovery(component, id, payload)
+	if err != nil {
+		log.Printf("[MQTT] Ignoring discovery on %s: %v", topic, err)
+		return
+	}
+	if err := d.AddDevice(cfg); err != nil {
+		log.Printf("[MQTT] Failed to add discovered device %s: %v", cfg.ID, err)
+		return
+	}
+	log.Printf("[MQTT] Discovered %s %s", component, cfg.ID)
+}
+
+func discoveryDeviceID(nodeID, objectID string) string {
+	if nodeID == "" {
+		return objectID
+	}
+	return nodeID + "_" + objectID
+}
+
+// DeviceFromDiscovery turns a Home Assistant discovery payload into a generic
+// device config with capabilities derived from the component type.
+func DeviceFromDiscovery(component, id string, payload []byte) (DeviceConfig, error) {
+	deviceType, ok := hassComponentTypes[component]
+	if !ok {
+		return DeviceConfig{}, fmt.Errorf("unsupported component %q", component)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return DeviceConfig{}, err
+	}
+	expanded, _ := json.Marshal(expandDiscovery(raw))
+	var dc discoveryConfig
+	if err := json.Unmarshal(expanded, &dc); err != nil {
+		return DeviceConfig{}, err
+	}
+
+	cfg := DeviceConfig{
+		ID:            id,
+		Name:          discoveryName(dc, id),
+		Type:          deviceType,
+		Room:          dc.Device.SuggestedArea,
+		StateTopic:    dc.StateTopic,
+		CommandTopic:  dc.CommandTopic,
+		StateMap:      map[string]string{},
+		StateTopics:   map[string]string{},
+		StateValues:   map[string]map[string]string{},
+		Commands:      map[string]string{},
+		CommandTopics: map[string]string{},
+	}
+
+	switch component {
+	case "light":
+		buildLight(&cfg, dc)
+	case "switch":
+		buildSwitch(&cfg, dc)
+	case "sensor":
+		buildSensor(&cfg, dc)
+	case "binary_sensor":
+		buildBinarySensor(&cfg, dc)
+	case "climate":
+		buildClimate(&cfg, dc)
+	case "cover":
+		buildCover(&cfg, dc)
+	case "number":
+		buildNumber(&cfg, dc)
+	case "select":
+		buildSelect(&cfg, dc)
+	}
+
+	if len(cfg.Capabilities) == 0 {
+		return DeviceConfig{}, fmt.Errorf("%s %s exposes no usable topics", component, id)
+	}
+	return cfg, nil
+}
+
+// expandDiscovery resolves abbreviated keys and the "~" base topic.
+func expandDiscovery(raw map[string]interface{}) map[string]interface{} {
+	base, _ := raw["~"].(string)
+	out := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		if full, ok := hassAbbreviations[k]; ok {
+			k = full
+		}
+		if nested, ok := v.(map[string]interface{}); ok && k == "device" {
+			v = expandDiscovery(nested)
+		}
+		if s, ok := v.(string); ok && base != "" && strings.HasSuffix(k, "_topic") {
+			if strings.HasPrefix(s, "~") {
+				s = base + s[1:]
+			} else if strings.HasSuffix(s, "~") {
+				s = s[:len(s)-1] + base
+			}
+			v = s
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func discoveryName(dc discoveryConfig, id string) string {
+	switch {
+	case dc.Device.Name != "" && dc.Name != "":
+		return dc.Device.Name + " " + dc.Name
+	case dc.Name != "":
+		return dc.Name
+	case dc.Device.Name != "":
+		return dc.Device.Name
+	}
+	return id
+}
+
+var (
+	valueJSONPattern = regexp.MustCompile(`^value_json((?:\.\w+|\[\s*(?:'[^']*'|"[^"]*"|\d+)\s*\])*)$`)
+	quotedIndex      = regexp.MustCompile(`\[\s*['"]([^'"]*)['"]\s*\]`)
+	numericIndex     = regexp.MustCompile(`\[\s*(\d+)\s*\]`)
+)
+
+// templatePath converts simple Jinja value templates such as
+// "{{ value_json.temperature }}" into a JSON path. Anything more elaborate
+// falls back to the raw payload.
+func templatePath(tpl string) string {
+	tpl = strings.TrimSpace(tpl)
+	if tpl == "" {
+		return "$"
+	}
+	tpl = strings.TrimSuffix(strings.TrimPrefix(tpl, "{{"), "}}")
+	if i := strings.Index(tpl, "|"); i >= 0 {
+		tpl = tpl[:i]
+	}
+	tpl = strings.TrimSpace(tpl)
+	if tpl == "value" {
+		return "$"
+	}
+
+	m := valueJSONPattern.FindStringSubmatch(tpl)
+	if m == nil {
+		log.Printf("[MQTT] Unsupported value_template %q, using raw payload", tpl)
+		return "$"
+	}
+	path := quotedIndex.ReplaceAllString(m[1], ".$1")
+	path = numericIndex.ReplaceAllString(path, "[$1]")
+	return "$" + path
+}
+
+func payloadString(v interface{}, def string) string {
+	if v == nil {
+		return def
+	}
+	return store.FormatValue(v)
+}
+
+// onOffTemplate renders "on"/"off" requests as the entity's own payloads.
+func onOffTemplate(on, off string) string {
+	return fmt.Sprintf(`{{if eq (lower .Value) "on"}}{{%s}}{{else}}{{%s}}{{end}}`, strconv.Quote(on), strconv.Quote(off))
+}
+
+func number(v *float64, def float64) float64 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+func powerCapability(description string) store.Capability {
+	return store.Capability{
+		Name:        "power",
+		Description: description,
+		Operations:  []string{"on", "off"},
+		Writable:    true,
+		Parameters: map[string]interface{}{
+			"state": map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+		},
+	}
+}
+
+// bindOnOff maps the entity's on/off payloads onto "on"/"off" in both directions.
+func bindOnOff(cfg *DeviceConfig, dc discoveryConfig, key, path string) {
+	on := payloadString(dc.PayloadOn, "ON")
+	off := payloadString(dc.PayloadOff, "OFF")
+	cfg.StateMap[key] = path
+	cfg.StateValues[key] = map[string]string{
+		on:                              "on",
+		off:                             "off",
+		payloadString(dc.StateOn, on):   "on",
+		payloadString(dc.StateOff, off): "off",
+	}
+	if cfg.CommandTopic != "" {
+		cfg.Commands[key] = onOffTemplate(on, off)
+	}
+}
+
+func buildLight(cfg *DeviceConfig, dc discoveryConfig) {
+	scale := number(dc.BrightnessScale, 255)
+
+	if dc.Schema == "json" {
+		bindOnOff(cfg, dc, "state", "$.state")
+		cfg.Commands["state"] = `{"state": ` + onOffTemplate(`"ON"`, `"OFF"`) + `}`
+		cfg.Capabilities = append(cfg.Capabilities, powerCapability("Turn the light on or off"))
+		if dc.Brightness {
+			cfg.StateMap["brightness"] = "$.brightness"
+			cfg.Commands["brightness"] = `{"state": "ON", "brightness": {{.Value}}}`
+			cfg.Capabilities = append(cfg.Capabilities, integerCapability("brightness", "brightness",
+				"Adjust brightness", 0, scale, true))
+		}
+		return
+	}
+
+	if dc.StateTopic != "" || dc.CommandTopic != "" {
+		bindOnOff(cfg, dc, "state", templatePath(dc.ValueTemplate))
+		cfg.Capabilities = append(cfg.Capabilities, powerCapability("Turn the light on or off"))
+	}
+	if dc.BrightnessCommandTopic != "" {
+		cfg.CommandTopics["brightness"] = dc.BrightnessCommandTopic
+		if dc.BrightnessStateTopic != "" {
+			cfg.StateTopics["brightness"] = dc.BrightnessStateTopic
+			cfg.StateMap["brightness"] = templatePath(dc.BrightnessValueTemplate)
+		}
+		cfg.Capabilities = append(cfg.Capabilities, integerCapability("brightness", "brightness",
+			"Adjust brightness", 0, scale, true))
+	}
+	if dc.RGBCommandTopic != "" {
+		cfg.CommandTopics["rgb"] = dc.RGBCommandTopic
+		cfg.Commands["rgb"] = "{{csv .Value}}"
+		if dc.RGBStateTopic != "" {
+			cfg.StateTopics["rgb"] = dc.RGBStateTopic
+		}
+		cfg.Capabilities = append(cfg.Capabilities, store.Capability{
+			Name:        "color",
+			Description: "Change light color using RGB",
+			Operations:  []string{"set"},
+			Writable:    true,
+			Parameters: map[string]interface{}{
+				"rgb": map[string]interface{}{"type": "array", "length": 3, "range": []int{0, 255}},
+			},
+		})
+	}
+	if dc.ColorTempCommandTopic != "" {
+		cfg.CommandTopics["color_temp"] = dc.ColorTempCommandTopic
+		if dc.ColorTempStateTopic != "" {
+			cfg.StateTopics["color_temp"] = dc.ColorTempStateTopic
+		}
+		cfg.Capabilities = append(cfg.Capabilities, integerCapability("color_temp", "color_temp",
+			"Set color temperature in mireds", number(dc.MinMireds, 153), number(dc.MaxMireds, 500), true))
+	}
+}
+
+func buildSwitch(cfg *DeviceConfig, dc discoveryConfig) {
+	bindOnOff(cfg, dc, "state", templatePath(dc.ValueTemplate))
+	cap := powerCapability("Turn the switch on or off")
+	cap.Writable = dc.CommandTopic != ""
+	cfg.Capabilities = append(cfg.Capabilities, cap)
+}
+
+func buildSensor(cfg *DeviceConfig, dc discoveryConfig) {
+	key := dc.DeviceClass
+	if key == "" {
+		key = "value"
+	}
+	cfg.StateMap[key] = templatePath(dc.ValueTemplate)
+
+	spec := map[string]interface{}{"type": "number"}
+	if dc.UnitOfMeasurement != "" {
+		spec["unit"] = dc.UnitOfMeasurement
+	} else {
+		spec["type"] = "string"
+	}
+	cfg.Capabilities = append(cfg.Capabilities, store.Capability{
+		Name:        key,
+		Description: fmt.Sprintf("Reported %s", strings.ReplaceAll(key, "_", " ")),
+		Writable:    false,
+		Parameters:  map[string]interface{}{key: spec},
+	})
+}
+
+func buildBinarySensor(cfg *DeviceConfig, dc discoveryConfig) {
+	key := dc.DeviceClass
+	if key == "" {
+		key = "state"
+	}
+	on := payloadString(dc.PayloadOn, "ON")
+	off := payloadString(dc.PayloadOff, "OFF")
+	cfg.StateMap[key] = templatePath(dc.ValueTemplate)
+	cfg.StateValues[key] = map[string]string{on: "on", off: "off"}
+	cfg.Capabilities = append(cfg.Capabilities, store.Capability{
+		Name:        key,
+		Description: fmt.Sprintf("Reported %s (on/off)", strings.ReplaceAll(key, "_", " ")),
+		Writable:    false,
+		Parameters: map[string]interface{}{
+			key: map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+		},
+	})
+}
+
+func buildClimate(cfg *DeviceConfig, dc discoveryConfig) {
+	if dc.ModeCommandTopic != "" || dc.ModeStateTopic != "" {
+		modes := dc.Modes
+		if len(modes) == 0 {
+			modes = []string{"auto", "off", "cool", "heat", "dry", "fan_only"}
+		}
+		if dc.ModeCommandTopic != "" {
+			cfg.CommandTopics["mode"] = dc.ModeCommandTopic
+		}
+		if dc.ModeStateTopic != "" {
+			cfg.StateTopics["mode"] = dc.ModeStateTopic
+		}
+		cfg.Capabilities = append(cfg.Capabilities, enumCapability("mode", "mode",
+			"Set the HVAC mode", modes, dc.ModeCommandTopic != ""))
+	}
+	if dc.TemperatureCommandTopic != "" || dc.TemperatureStateTopic != "" {
+		if dc.TemperatureCommandTopic != "" {
+			cfg.CommandTopics["target_temperature"] = dc.TemperatureCommandTopic
+		}
+		if dc.TemperatureStateTopic != "" {
+			cfg.StateTopics["target_temperature"] = dc.TemperatureStateTopic
+			cfg.StateMap["target_temperature"] = templatePath(dc.TemperatureStateTemplate)
+		}
+		cap := numberCapability("target_temperature", "target_temperature", "Set the target temperature",
+			number(dc.MinTemp, 7), number(dc.MaxTemp, 35), number(dc.TempStep, 0.5), dc.TemperatureCommandTopic != "")
+		cfg.Capabilities = append(cfg.Capabilities, cap)
+	}
+	if dc.CurrentTemperatureTopic != "" {
+		cfg.StateTopics["current_temperature"] = dc.CurrentTemperatureTopic
+		cfg.StateMap["current_temperature"] = templatePath(dc.CurrentTemperatureTmpl)
+		cfg.Capabilities = append(cfg.Capabilities, store.Capability{
+			Name:        "current_temperature",
+			Description: "Measured room temperature",
+			Writable:    false,
+			Parameters: map[string]interface{}{
+				"current_temperature": map[string]interface{}{"type": "number"},
+			},
+		})
+	}
+}
+
+func buildCover(cfg *DeviceConfig, dc discoveryConfig) {
+	if dc.CommandTopic != "" {
+		open := orDefault(dc.PayloadOpen, "OPEN")
+		closeCmd := orDefault(dc.PayloadClose, "CLOSE")
+		stop := orDefault(dc.PayloadStop, "STOP")
+		cfg.Commands["command"] = fmt.Sprintf(
+			`{{if eq (lower .Value) "open"}}{{%s}}{{else if eq (lower .Value) "close"}}{{%s}}{{else}}{{%s}}{{end}}`,
+			strconv.Quote(open), strconv.Quote(closeCmd), strconv.Quote(stop))
+		cfg.Capabilities = append(cfg.Capabilities, enumCapability("cover", "command",
+			"Open, close or stop the cover", []string{"open", "close", "stop"}, true))
+	}
+	if dc.StateTopic != "" {
+		cfg.StateMap["state"] = templatePath(dc.ValueTemplate)
+		cfg.StateValues["state"] = map[string]string{
+			"open": "open", "closed": "closed", "opening": "opening", "closing": "closing", "stopped": "stopped",
+			"OPEN": "open", "CLOSED": "closed", "OPENING": "opening", "CLOSING": "closing", "STOPPED": "stopped",
+		}
+	}
+	if dc.SetPositionTopic != "" || dc.PositionTopic != "" {
+		if dc.SetPositionTopic != "" {
+			cfg.CommandTopics["position"] = dc.SetPositionTopic
+		}
+		if dc.PositionTopic != "" {
+			cfg.StateTopics["position"] = dc.PositionTopic
+		}
+		lo, hi := number(dc.PositionClosed, 0), number(dc.PositionOpen, 100)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		cfg.Capabilities = append(cfg.Capabilities, integerCapability("position", "position",
+			"Move the cover to a position", lo, hi, dc.SetPositionTopic != ""))
+	}
+}
+
+func buildNumber(cfg *DeviceConfig, dc discoveryConfig) {
+	cfg.StateMap["value"] = templatePath(dc.ValueTemplate)
+	if dc.CommandTopic != "" {
+		cfg.CommandTopics["value"] = dc.CommandTopic
+	}
+	cap := numberCapability("value", "value", "Set the value",
+		number(dc.Min, 1), number(dc.Max, 100), number(dc.Step, 1), dc.CommandTopic != "")
+	if dc.UnitOfMeasurement != "" {
+		cap.Parameters["value"].(map[string]interface{})["unit"] = dc.UnitOfMeasurement
+	}
+	cfg.Capabilities = append(cfg.Capabilities, cap)
+}
+
+func buildSelect(cfg *DeviceConfig, dc discoveryConfig) {
+	cfg.StateMap["option"] = templatePath(dc.ValueTemplate)
+	if dc.CommandTopic != "" {
+		cfg.CommandTopics["option"] = dc.CommandTopic
+	}
+	cfg.Capabilities = append(cfg.Capabilities, enumCapability("option", "option",
+		"Select an option", dc.Options, dc.CommandTopic != ""))
+}
+
+func integerCapability(name, param, description string, min, max float64, writable bool) store.Capability {
+	return store.Capability{
+		Name:        name,
+		Description: fmt.Sprintf("%s (%g-%g)", description, min, max),
+		Operations:  []string{"set"},
+		Writable:    writable,
+		Parameters: map[string]interface{}{
+			param: map[string]interface{}{"type": "integer", "range": []int{int(min), int(max)}},
+		},
+	}
+}
+
+func numberCapability(name, param, description string, min, max, step float64, writable bool) store.Capability {
+	return store.Capability{
+		Name:        name,
+		Description: fmt.Sprintf("%s (%g-%g)", description, min, max),
+		Writable:    writable,
+		Parameters: map[string]interface{}{
+			param: map[string]interface{}{"type": "number", "range": []float64{min, max}, "step": step},
+		},
+	}
+}
+
+func enumCapability(name, param, description string, options []string, writable bool) store.Capability {
+	return store.Capability{
+		Name:        name,
+		Description: description,
+		Operations:  options,
+		Writable:    writable,
+		Parameters: map[string]interface{}{
+			param: map[string]interface{}{"type": "string", "operations": options},
+		},
+	}
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+package mqtt
+
+import (
+	"reflect"
+	"testing"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+func TestDeviceFromDiscovery(t *testing.T) {
+	tests := []struct {
+		name          string
+		component     string
+		payload       string
+		wantType      string
+		caps          []string
+		stateMap      map[string]string
+		stateTopics   map[string]string
+		commandTopics map[string]string
+	}{
+		{
+			name:      "plain light",
+			component: "light",
+			payload: `{"name": "Lamp", "stat_t": "lamp/state", "cmd_t": "lamp/set",
+				"bri_cmd_t": "lamp/bri/set", "bri_stat_t": "lamp/bri", "rgb_cmd_t": "lamp/rgb/set"}`,
+			wantType:      "bulb",
+			caps:          []string{"power", "brightness", "color"},
+			stateMap:      map[string]string{"state": "$", "brightness": "$"},
+			stateTopics:   map[string]string{"brightness": "lamp/bri"},
+			commandTopics: map[string]string{"brightness": "lamp/bri/set", "rgb": "lamp/rgb/set"},
+		},
+		{
+			name:      "json schema light",
+			component: "light",
+			payload:   `{"schema": "json", "stat_t": "lamp/state", "cmd_t": "lamp/set", "brightness": true}`,
+			wantType:  "bulb",
+			caps:      []string{"power", "brightness"},
+			stateMap:  map[string]string{"state": "$.state", "brightness": "$.brightness"},
+		},
+		{
+			name:      "switch",
+			component: "switch",
+			payload:   `{"stat_t": "plug/state", "cmd_t": "plug/set", "val_tpl": "{{ value_json.POWER }}"}`,
+			wantType:  "switch",
+			caps:      []string{"power"},
+			stateMap:  map[string]string{"state": "$.POWER"},
+		},
+		{
+			name:      "sensor",
+			component: "sensor",
+			payload:   `{"stat_t": "room/sensor", "dev_cla": "temperature", "unit_of_meas": "°C", "val_tpl": "{{ value_json['temp'] }}"}`,
+			wantType:  "sensor",
+			caps:      []string{"temperature"},
+			stateMap:  map[string]string{"temperature": "$.temp"},
+		},
+		{
+			name:      "binary sensor",
+			component: "binary_sensor",
+			payload:   `{"stat_t": "door/contact", "dev_cla": "door"}`,
+			wantType:  "sensor",
+			caps:      []string{"door"},
+			stateMap:  map[string]string{"door": "$"},
+		},
+		{
+			name:      "climate",
+			component: "climate",
+			payload: `{"mode_cmd_t": "hvac/mode/set", "mode_stat_t": "hvac/mode", "temp_cmd_t": "hvac/temp/set",
+				"temp_stat_t": "hvac/temp", "curr_temp_t": "hvac/current", "curr_temp_tpl": "{{ value_json.t }}"}`,
+			wantType:      "thermostat",
+			caps:          []string{"mode", "target_temperature", "current_temperature"},
+			stateMap:      map[string]string{"target_temperature": "$", "current_temperature": "$.t"},
+			stateTopics:   map[string]string{"mode": "hvac/mode", "target_temperature": "hvac/temp", "current_temperature": "hvac/current"},
+			commandTopics: map[string]string{"mode": "hvac/mode/set", "target_temperature": "hvac/temp/set"},
+		},
+		{
+			name:          "cover",
+			component:     "cover",
+			payload:       `{"stat_t": "blind/state", "cmd_t": "blind/set", "pos_t": "blind/pos", "set_pos_t": "blind/pos/set"}`,
+			wantType:      "cover",
+			caps:          []string{"cover", "position"},
+			stateMap:      map[string]string{"state": "$"},
+			stateTopics:   map[string]string{"position": "blind/pos"},
+			commandTopics: map[string]string{"position": "blind/pos/set"},
+		},
+		{
+			name:          "number",
+			component:     "number",
+			payload:       `{"stat_t": "fan/speed", "cmd_t": "fan/speed/set", "min": 0, "max": 5}`,
+			wantType:      "number",
+			caps:          []string{"value"},
+			stateMap:      map[string]string{"value": "$"},
+			commandTopics: map[string]string{"value": "fan/speed/set"},
+		},
+		{
+			name:          "select",
+			component:     "select",
+			payload:       `{"stat_t": "fan/preset", "cmd_t": "fan/preset/set", "ops": ["low", "high"]}`,
+			wantType:      "select",
+			caps:          []string{"option"},
+			stateMap:      map[string]string{"option": "$"},
+			commandTopics: map[string]string{"option": "fan/preset/set"},
+		},
+		{
+			name:      "base topic",
+			component: "switch",
+			payload:   `{"~": "tasmota/plug", "stat_t": "~/POWER", "cmd_t": "cmnd/~"}`,
+			wantType:  "switch",
+			caps:      []string{"power"},
+			stateMap:  map[string]string{"state": "$"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := DeviceFromDiscovery(tt.component, "dev", []byte(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ID != "dev" || cfg.Type != tt.wantType {
+				t.Errorf("id, type = %q, %q, want dev, %s", cfg.ID, cfg.Type, tt.wantType)
+			}
+			var caps []string
+			for _, c := range cfg.Capabilities {
+				caps = append(caps, c.Name)
+			}
+			if !reflect.DeepEqual(caps, tt.caps) {
+				t.Errorf("capabilities = %v, want %v", caps, tt.caps)
+			}
+			if !reflect.DeepEqual(cfg.StateMap, tt.stateMap) {
+				t.Errorf("state map = %v, want %v", cfg.StateMap, tt.stateMap)
+			}
+			if tt.stateTopics == nil {
+				tt.stateTopics = map[string]string{}
+			}
+			if !reflect.DeepEqual(cfg.StateTopics, tt.stateTopics) {
+				t.Errorf("state topics = %v, want %v", cfg.StateTopics, tt.stateTopics)
+			}
+			if tt.commandTopics == nil {
+				tt.commandTopics = map[string]string{}
+			}
+			if !reflect.DeepEqual(cfg.CommandTopics, tt.commandTopics) {
+				t.Errorf("command topics = %v, want %v", cfg.CommandTopics, tt.commandTopics)
+			}
+		})
+	}
+}
+
+func TestDeviceFromDiscoveryRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		component string
+		payload   string
+	}{
+		{"unsupported component", "vacuum", `{"cmd_t": "vac/set"}`},
+		{"no usable topics", "light", `{"name": "Lamp"}`},
+		{"bad json", "switch", `{"cmd_t":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeviceFromDiscovery(tt.component, "dev", []byte(tt.payload)); err == nil {
+				t.Error("DeviceFromDiscovery() accepted the payload")
+			}
+		})
+	}
+}
+
+func TestDiscoveryNameAndTopics(t *testing.T) {
+	cfg, err := DeviceFromDiscovery("switch", "plug", []byte(
+		`{"~": "tasmota/plug", "name": "Relay", "stat_t": "~/POWER", "cmd_t": "cmnd/~",
+		  "dev": {"name": "Kitchen plug", "sa": "Kitchen"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "Kitchen plug Relay" || cfg.Room != "Kitchen" {
+		t.Errorf("name, room = %q, %q", cfg.Name, cfg.Room)
+	}
+	if cfg.StateTopic != "tasmota/plug/POWER" || cfg.CommandTopic != "cmnd/tasmota/plug" {
+		t.Errorf("topics = %q, %q, want ~ expanded", cfg.StateTopic, cfg.CommandTopic)
+	}
+}
+
+func TestBinarySensorStateMatchesCapability(t *testing.T) {
+	cfg, err := DeviceFromDiscovery("binary_sensor", "door", []byte(
+		`{"stat_t": "door/contact", "dev_cla": "door", "pl_on": "open", "pl_off": "closed"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"open": "on", "closed": "off"}; !reflect.DeepEqual(cfg.StateValues["door"], want) {
+		t.Errorf("state values = %v, want %v", cfg.StateValues["door"], want)
+	}
+	spec := cfg.Capabilities[0].Parameters["door"].(map[string]interface{})
+	for _, stored := range cfg.StateValues["door"] {
+		if _, err := iot.ValidateParameters(cfg.Capabilities[0], store.State{"door": stored}); err != nil {
+			t.Errorf("stored value %q does not match the capability: %v", stored, err)
+		}
+		if !contains(spec["operations"].([]string), stored) {
+			t.Errorf("stored value %q not among the declared values %v", stored, spec["operations"])
+		}
+	}
+}
+
+func TestTemplatePath(t *testing.T) {
+	tests := []struct {
+		tpl  string
+		want string
+	}{
+		{"", "$"},
+		{"{{ value }}", "$"},
+		{"{{ value_json.temperature }}", "$.temperature"},
+		{"{{ value_json['temp'] }}", "$.temp"},
+		{`{{ value_json["ENERGY"].Power }}`, "$.ENERGY.Power"},
+		{"{{ value_json.values[ 2 ] }}", "$.values[2]"},
+		{"{{ value_json.state | lower }}", "$.state"},
+		{"{{ value_json.a if value_json.b else 0 }}", "$"},
+	}
+	for _, tt := range tests {
+		if got := templatePath(tt.tpl); got != tt.want {
+			t.Errorf("templatePath(%q) = %q, want %q", tt.tpl, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoveryRemoval(t *testing.T) {
+	testutil.UseMemoryStore()
+
+	d := NewDriver(nil)
+	d.EnableDiscovery("homeassistant")
+	d.handleDiscovery("homeassistant/switch/plug/relay/config", []byte(`{"stat_t": "plug/state", "cmd_t": "plug/set"}`))
+	if _, ok := factory.GetDeviceStore().Get("plug_relay"); !ok {
+		t.Fatal("discovered device not stored")
+	}
+
+	d.handleDiscovery("homeassistant/switch/plug/relay/config", nil)
+	if _, ok := factory.GetDeviceStore().Get("plug_relay"); ok {
+		t.Error("device still stored after an empty discovery payload")
+	}
+	d.mu.Lock()
+	_, known := d.devices["plug_relay"]
+	d.mu.Unlock()
+	if known {
+		t.Error("driver still knows the removed device")
+	}
+
+	d.handleDiscovery("homeassistant/switch/"+BridgeDiscoveryNode+"/lamp/config", []byte(`{"cmd_t": "bridge/set"}`))
+	if _, ok := factory.GetDeviceStore().Get(BridgeDiscoveryNode + "_lamp"); ok {
+		t.Error("the bridge's own discovery entity was ingested")
+	}
+}
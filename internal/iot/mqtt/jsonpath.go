@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupPath resolves a simple JSON path such as "$.sensors.temp" or
+// "color[0]" against a decoded JSON document. "$" or "" returns the whole document.
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return doc, true
+	}
+
+	segments, err := splitPath(path)
+	if err != nil {
+		return nil, false
+	}
+
+	current := doc
+	for _, seg := range segments {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// splitPath turns "a.b[2].c" into ["a", "b", "2", "c"].
+func splitPath(path string) ([]string, error) {
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return nil, fmt.Errorf("empty segment in path %q", path)
+		}
+		for part != "" {
+			open := strings.Index(part, "[")
+			if open < 0 {
+				segments = append(segments, part)
+				break
+			}
+			if open > 0 {
+				segments = append(segments, part[:open])
+			}
+			end := strings.Index(part, "]")
+			if end < open {
+				return nil, fmt.Errorf("unterminated index in path %q", path)
+			}
+			segments = append(segments, part[open+1:end])
+			part = part[end+1:]
+		}
+	}
+	return segments, nil
+}
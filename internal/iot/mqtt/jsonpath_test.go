@@ -0,0 +1,62 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLookupPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"state":"ON","sensors":{"temp":21.5},"color":[255,128,0],"list":[{"id":"a"}]}`), &doc)
+
+	tests := []struct {
+		path string
+		want interface{}
+		ok   bool
+	}{
+		{"$", doc, true},
+		{"", doc, true},
+		{"$.state", "ON", true},
+		{"state", "ON", true},
+		{"$.sensors.temp", 21.5, true},
+		{"color[1]", 128.0, true},
+		{"list[0].id", "a", true},
+		{"color[3]", nil, false},
+		{"color[-1]", nil, false},
+		{"sensors.missing", nil, false},
+		{"state.inner", nil, false},
+		{"sensors..temp", nil, false},
+		{"color[1", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := lookupPath(doc, tt.path)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookupPath(%q) = %v, %v; want %v, %v", tt.path, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{"a", []string{"a"}, false},
+		{"a.b[2].c", []string{"a", "b", "2", "c"}, false},
+		{"a[0][1]", []string{"a", "0", "1"}, false},
+		{"a..b", nil, true},
+		{"a]b[", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := splitPath(tt.path)
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPath(%q) = %v, %v; want %v, error %v", tt.path, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
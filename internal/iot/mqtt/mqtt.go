@@ -1 +1,693 @@
 package mqtt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DeviceConfig describes how a generic MQTT device maps onto topics and payloads.
+type DeviceConfig struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Room         string `json:"room"`
+	StateTopic   string `json:"state_topic"`
+	CommandTopic string `json:"command_topic"`
+
+	StateMap    map[string]string            `json:"state_map"`              // state key -> JSON path into the state payload
+	StateTopics map[string]string            `json:"state_topics,omitempty"` // state key -> topic, when not StateTopic
+	StateValues map[string]map[string]string `json:"state_values,omitempty"` // state key -> raw value -> stored value
+
+	Commands      map[string]string `json:"commands"`                 // update key -> payload template for SetState
+	CommandTopics map[string]string `json:"command_topics,omitempty"` // update key -> topic, when not CommandTopic
+
+	Capabilities []store.Capability `json:"capabilities"`
+	QoS          byte               `json:"qos"`
+	Retain       bool               `json:"retain"`
+}
+
+// CommandData is what payload templates are executed against. Value is the
+// requested value as text ("on", "42", "[255,0,0]"); Typed keeps its JSON
+// type, e.g. for {{json .Typed}}.
+type CommandData struct {
+	Key     string
+	Value   string
+	Typed   interface{}
+	Device  store.Device
+	Updates store.State
+}
+
+type stateBinding struct {
+	key  string // empty means "every field of a JSON object payload"
+	path string
+}
+
+type deviceEntry struct {
+	cfg       DeviceConfig
+	templates map[string]*template.Template
+	bindings  map[string][]stateBinding // topic -> bindings
+}
+
+type Driver struct {
+	client     paho.Client
+	mu         sync.RWMutex
+	devices    map[string]*deviceEntry
+	states     map[string]store.State
+	subscribed map[string]bool
+
+	discoveryPrefix string
+}
+
+var driver *Driver
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// csv turns the "[r,g,b]" form used for array parameters into "r,g,b".
+	"csv": func(s string) string {
+		return strings.Trim(strings.ReplaceAll(s, " ", ""), "[]")
+	},
+}
+
+func init() {
+	iot.Register("mqtt", func() iot.Driver {
+		driver = NewDriver(nil)
+		return driver
+	})
+}
+
+// Start loads the configured devices and connects when there is anything to
+// serve, i.e. devices from MQTT_DEVICES_FILE or Home Assistant discovery.
+func (d *Driver) Start() error {
+	devices, err := config.LoadJSONList[DeviceConfig](config.MQTTDevicesFile)
+	if err != nil {
+		log.Printf("[MQTT] Failed to load %s: %v", config.MQTTDevicesFile, err)
+	}
+	for _, cfg := range devices {
+		if err := d.AddDevice(cfg); err != nil {
+			log.Printf("[MQTT] Skipping device %s: %v", cfg.ID, err)
+		}
+	}
+	if config.HassDiscovery {
+		d.EnableDiscovery(config.HassDiscoveryPrefix)
+	}
+
+	if len(devices) == 0 && !config.HassDiscovery {
+		log.Println("[MQTT] No generic MQTT devices configured")
+		return nil
+	}
+
+	d.client = NewClient("iot-bridge-mqtt", d.onConnect)
+	Connect(d.client, "[MQTT]")
+	return nil
+}
+
+func (d *Driver) Stop() error {
+	if d.client != nil {
+		d.client.Disconnect(250)
+	}
+	return nil
+}
+
+func (d *Driver) Health() iot.Health {
+	switch {
+	case d.client == nil:
+		return iot.Health{Status: iot.HealthDisabled, Detail: "no MQTT devices configured"}
+	case !d.client.IsConnectionOpen():
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + config.MQTTBroker}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+func GetDriver() *Driver {
+	return driver
+}
+
+// NewClient builds a paho client for the configured broker. onConnect runs on
+// every (re)connect, so subscriptions made there survive broker restarts.
+func NewClient(clientID string, onConnect paho.OnConnectHandler) paho.Client {
+	return NewClientFromOptions(NewClientOptions(clientID, onConnect))
+}
+
+// NewClientFromOptions builds a client from NewClientOptions. With
+// MQTT_REPLAY_FILE set it returns a ReplayClient instead of connecting to the
+// broker, and with MQTT_RECORD_FILE set the client's traffic is recorded.
+func NewClientFromOptions(opts *paho.ClientOptions) paho.Client {
+	var rc *recordingClient
+	if rec := recorder(); rec != nil {
+		rc = &recordingClient{id: opts.ClientID, rec: rec}
+		if onConnect := opts.OnConnect; onConnect != nil {
+			opts.SetOnConnectHandler(func(paho.Client) { onConnect(rc) })
+		}
+	}
+
+	var client paho.Client
+	if recording := replayRecording(); recording != nil {
+		client = NewReplayClient(opts, recording, config.MQTTReplaySpeed)
+	} else {
+		client = paho.NewClient(opts)
+	}
+	if rc != nil {
+		rc.Client = client
+		return rc
+	}
+	return client
+}
+
+var (
+	recorderOnce    sync.Once
+	sharedRecorder  *Recorder
+	replayOnce      sync.Once
+	sharedRecording []RecordedMessage
+)
+
+// recorder opens MQTT_RECORD_FILE once for all clients.
+func recorder() *Recorder {
+	recorderOnce.Do(func() {
+		if config.MQTTRecordFile == "" {
+			return
+		}
+		rec, err := OpenRecorder(config.MQTTRecordFile)
+		if err != nil {
+			log.Printf("[MQTT] Not recording, failed to open %s: %v", config.MQTTRecordFile, err)
+			return
+		}
+		log.Printf("[MQTT] Recording traffic to %s", config.MQTTRecordFile)
+		sharedRecorder = rec
+	})
+	return sharedRecorder
+}
+
+// CloseRecorder flushes and closes the MQTT_RECORD_FILE recording, if any.
+// Traffic after it is no longer recorded.
+func CloseRecorder() error {
+	recorderOnce.Do(func() {}) // nothing is recorded after shutdown
+	if sharedRecorder == nil {
+		return nil
+	}
+	return sharedRecorder.Close()
+}
+
+// replayRecording loads MQTT_REPLAY_FILE once for all clients.
+func replayRecording() []RecordedMessage {
+	replayOnce.Do(func() {
+		if config.MQTTReplayFile == "" {
+			return
+		}
+		recording, err := LoadRecording(config.MQTTReplayFile)
+		if err != nil {
+			log.Printf("[MQTT] Not replaying, failed to load %s: %v", config.MQTTReplayFile, err)
+			return
+		}
+		log.Printf("[MQTT] Replaying %s at speed %v instead of connecting to %s", config.MQTTReplayFile, config.MQTTReplaySpeed, config.MQTTBroker)
+		sharedRecording = recording
+		if sharedRecording == nil {
+			sharedRecording = []RecordedMessage{}
+		}
+	})
+	return sharedRecording
+}
+
+// NewClientOptions is NewClient for callers that need to adjust the options,
+// e.g. to set a last-will message. Credentials, TLS and reconnect backoff come
+// from the MQTT_* settings. The initial connection is retried too, so a
+// broker that is down at startup doesn't stop the bridge.
+func NewClientOptions(clientID string, onConnect paho.OnConnectHandler) *paho.ClientOptions {
+	opts := paho.NewClientOptions().AddBroker(config.MQTTBroker)
+	opts.SetClientID(clientID)
+	if config.MQTTUsername != "" {
+		opts.SetUsername(config.MQTTUsername)
+		opts.SetPassword(config.MQTTPassword)
+	}
+	if tlsConfig, err := TLSConfig(); err != nil {
+		log.Printf("[MQTT] Invalid TLS settings, connecting without them: %v", err)
+	} else if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(config.MQTTMaxReconnectInterval)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(5 * time.Second)
+	opts.OnConnect = onConnect
+	return opts
+}
+
+// TLSConfig builds the client TLS configuration from MQTT_CA_CERT,
+// MQTT_CLIENT_CERT and MQTT_CLIENT_KEY. It returns nil when none are set.
+func TLSConfig() (*tls.Config, error) {
+	if config.MQTTCACert == "" && config.MQTTClientCert == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.MQTTCACert != "" {
+		pem, err := os.ReadFile(config.MQTTCACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.MQTTCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.MQTTClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.MQTTClientCert, config.MQTTClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Connect starts connecting client without blocking startup on an
+// unreachable broker; paho keeps retrying in the background.
+func Connect(client paho.Client, logPrefix string) {
+	token := client.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		log.Printf("%s Broker %s not reachable yet, retrying in the background", logPrefix, config.MQTTBroker)
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.Printf("%s MQTT connection error: %v", logPrefix, err)
+	}
+}
+
+// NewDriver returns a driver bound to client. The client may be nil and set
+// later; devices can be added before the connection is established.
+func NewDriver(client paho.Client) *Driver {
+	return &Driver{
+		client:     client,
+		devices:    make(map[string]*deviceEntry),
+		states:     make(map[string]store.State),
+		subscribed: make(map[string]bool),
+	}
+}
+
+// AddDevice registers a device with the driver and the device store, and
+// subscribes to its state topics if the client is already connected. Adding
+// an ID that is already known replaces its configuration.
+func (d *Driver) AddDevice(cfg DeviceConfig) error {
+	if cfg.ID == "" {
+		return errors.New("missing id")
+	}
+	if cfg.StateTopic == "" && cfg.CommandTopic == "" && len(cfg.StateTopics) == 0 && len(cfg.CommandTopics) == 0 {
+		return errors.New("at least one state or command topic is required")
+	}
+	if cfg.QoS > 2 {
+		return fmt.Errorf("invalid qos %d", cfg.QoS)
+	}
+
+	entry := &deviceEntry{
+		cfg:       cfg,
+		templates: make(map[string]*template.Template),
+		bindings:  stateBindings(cfg),
+	}
+	for key, text := range cfg.Commands {
+		tmpl, err := template.New(key).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("command template %q: %w", key, err)
+		}
+		entry.templates[key] = tmpl
+	}
+
+	d.mu.Lock()
+	old := d.devices[cfg.ID]
+	d.devices[cfg.ID] = entry
+	d.mu.Unlock()
+
+	registerDevice(cfg)
+
+	if old != nil {
+		d.releaseTopics(old)
+	}
+	return d.subscribeTopics(entry)
+}
+
+// RemoveDevice forgets a device and drops subscriptions no other device needs.
+// It does not touch the device store.
+func (d *Driver) RemoveDevice(id string) {
+	d.mu.Lock()
+	entry, ok := d.devices[id]
+	delete(d.devices, id)
+	delete(d.states, id)
+	d.mu.Unlock()
+
+	if ok {
+		d.releaseTopics(entry)
+	}
+}
+
+func stateBindings(cfg DeviceConfig) map[string][]stateBinding {
+	bindings := make(map[string][]stateBinding)
+	if len(cfg.StateMap) == 0 && cfg.StateTopic != "" {
+		bindings[cfg.StateTopic] = append(bindings[cfg.StateTopic], stateBinding{})
+	}
+	for key, path := range cfg.StateMap {
+		topic := cfg.StateTopic
+		if t, ok := cfg.StateTopics[key]; ok {
+			topic = t
+		}
+		if topic != "" {
+			bindings[topic] = append(bindings[topic], stateBinding{key: key, path: path})
+		}
+	}
+	for key, topic := range cfg.StateTopics {
+		if _, mapped := cfg.StateMap[key]; !mapped {
+			bindings[topic] = append(bindings[topic], stateBinding{key: key, path: "$"})
+		}
+	}
+	return bindings
+}
+
+// registerDevice adds a configured device to the store. Capabilities come
+// from the config, or are derived from its state and command keys.
+func registerDevice(cfg DeviceConfig) {
+	device := store.Device{
+		ID:           cfg.ID,
+		Name:         cfg.Name,
+		Type:         cfg.Type,
+		Protocol:     "mqtt",
+		Room:         cfg.Room,
+		Capabilities: cfg.Capabilities,
+	}
+	if device.Type == "" {
+		device.Type = "mqtt"
+	}
+	if len(device.Capabilities) == 0 {
+		device.Capabilities = capabilitiesFromConfig(cfg)
+	}
+
+	added, err := iot.RegisterDevice(device)
+	if err != nil {
+		log.Printf("[MQTT] Failed to register device %s: %v", cfg.ID, err)
+	} else if added {
+		log.Printf("[MQTT] Registered device: %s", cfg.ID)
+	}
+}
+
+// capabilitiesFromConfig derives plain string capabilities when the config does
+// not declare any: command keys become writable, state-only keys read-only.
+func capabilitiesFromConfig(cfg DeviceConfig) []store.Capability {
+	var caps []store.Capability
+	for _, key := range iot.SortedKeys(cfg.Commands) {
+		caps = append(caps, store.Capability{
+			Name:        key,
+			Description: fmt.Sprintf("Publishes '%s' to %s", key, cfg.commandTopic(key)),
+			Writable:    true,
+			Parameters: map[string]interface{}{
+				key: map[string]interface{}{"type": "string"},
+			},
+		})
+	}
+	for _, key := range iot.SortedKeys(cfg.StateMap) {
+		if _, ok := cfg.Commands[key]; ok {
+			continue
+		}
+		caps = append(caps, store.Capability{
+			Name:        key,
+			Description: fmt.Sprintf("Reported on %s", cfg.StateTopic),
+			Writable:    false,
+			Parameters: map[string]interface{}{
+				key: map[string]interface{}{"type": "string"},
+			},
+		})
+	}
+	return caps
+}
+
+func (cfg DeviceConfig) commandTopic(key string) string {
+	if t, ok := cfg.CommandTopics[key]; ok {
+		return t
+	}
+	return cfg.CommandTopic
+}
+
+func (d *Driver) onConnect(c paho.Client) {
+	log.Println("[MQTT] Connected to broker")
+
+	d.mu.Lock()
+	topics := make([]string, 0, len(d.subscribed))
+	for topic := range d.subscribed {
+		topics = append(topics, topic)
+	}
+	for _, e := range d.devices {
+		for topic := range e.bindings {
+			if !d.subscribed[topic] {
+				d.subscribed[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := d.subscribe(c, topic); err != nil {
+			log.Printf("[MQTT] Failed to subscribe to %s: %v", topic, err)
+		}
+	}
+
+	if d.discoveryPrefix != "" {
+		d.subscribeDiscovery(c)
+	}
+}
+
+func (d *Driver) subscribeTopics(e *deviceEntry) error {
+	var pending []string
+	d.mu.Lock()
+	for topic := range e.bindings {
+		if !d.subscribed[topic] {
+			d.subscribed[topic] = true
+			pending = append(pending, topic)
+		}
+	}
+	d.mu.Unlock()
+
+	if d.client == nil || !d.client.IsConnectionOpen() {
+		return nil // onConnect picks them up
+	}
+	for _, topic := range pending {
+		if err := d.subscribe(d.client, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseTopics unsubscribes from topics that no remaining device listens on.
+func (d *Driver) releaseTopics(e *deviceEntry) {
+	var unused []string
+	d.mu.Lock()
+	for topic := range e.bindings {
+		inUse := false
+		for _, other := range d.devices {
+			if _, ok := other.bindings[topic]; ok {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			delete(d.subscribed, topic)
+			unused = append(unused, topic)
+		}
+	}
+	d.mu.Unlock()
+
+	if len(unused) > 0 && d.client != nil && d.client.IsConnectionOpen() {
+		d.client.Unsubscribe(unused...).Wait()
+	}
+}
+
+// subscribe registers one handler per topic filter; paho keeps a single
+// callback per filter, so devices sharing a topic are fanned out in dispatch.
+// The subscription uses the highest QoS of the devices on the topic.
+func (d *Driver) subscribe(c paho.Client, topic string) error {
+	token := c.Subscribe(topic, d.topicQoS(topic), func(_ paho.Client, msg paho.Message) {
+		d.dispatch(topic, msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (d *Driver) topicQoS(topic string) byte {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var qos byte
+	for _, e := range d.devices {
+		if _, ok := e.bindings[topic]; ok && e.cfg.QoS > qos {
+			qos = e.cfg.QoS
+		}
+	}
+	return qos
+}
+
+func (d *Driver) dispatch(topic string, payload []byte) {
+	d.mu.RLock()
+	var targets []*deviceEntry
+	for _, e := range d.devices {
+		if _, ok := e.bindings[topic]; ok {
+			targets = append(targets, e)
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		doc = string(payload)
+	}
+	for _, e := range targets {
+		d.applyState(e, e.bindings[topic], doc)
+	}
+}
+
+func (d *Driver) applyState(e *deviceEntry, bindings []stateBinding, doc interface{}) {
+	deviceID := e.cfg.ID
+	updates := make(store.State)
+	for _, b := range bindings {
+		if b.key == "" {
+			if obj, isObj := doc.(map[string]interface{}); isObj {
+				for k, v := range obj {
+					updates[k] = v
+				}
+			} else {
+				updates["state"] = doc
+			}
+			continue
+		}
+		if v, found := lookupPath(doc, b.path); found {
+			updates[b.key] = v
+		}
+	}
+	for key, raw := range updates {
+		if mapped, ok := e.cfg.StateValues[key][store.FormatValue(raw)]; ok {
+			updates[key] = mapped
+		}
+	}
+	if len(updates) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	state, ok := d.states[deviceID]
+	if !ok {
+		state = make(store.State)
+		d.states[deviceID] = state
+	}
+	for k, v := range updates {
+		state[k] = v
+	}
+	d.mu.Unlock()
+
+	if err := factory.GetDeviceStore().UpdateState(deviceID, updates); err != nil {
+		log.Printf("[MQTT] Failed to update state for %s: %v", deviceID, err)
+	}
+}
+
+func (d *Driver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s, ok := d.states[device.ID]
+	if !ok {
+		return nil, fmt.Errorf("no state for device %s", device.ID)
+	}
+	copied := make(store.State, len(s))
+	for k, v := range s {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (d *Driver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	d.mu.RLock()
+	e, ok := d.devices[device.ID]
+	d.mu.RUnlock()
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "device %s is not configured for MQTT", device.ID)
+	}
+	if d.client == nil || !d.client.IsConnectionOpen() {
+		return iot.Errorf(iot.TransportError, "MQTT broker not connected")
+	}
+
+	// Keys with a template or their own topic are published one by one; the
+	// rest go out together as a flat JSON object, the shape the Zigbee driver sends.
+	untemplated := make(store.State)
+	for _, key := range iot.SortedKeys(updates) {
+		topic := e.cfg.commandTopic(key)
+		if topic == "" {
+			return iot.Errorf(iot.Unsupported, "device %s has no command topic for %q", device.ID, key)
+		}
+
+		tmpl, hasTemplate := e.templates[key]
+		_, hasTopic := e.cfg.CommandTopics[key]
+		switch {
+		case hasTemplate:
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, CommandData{Key: key, Value: store.FormatValue(updates[key]), Typed: updates[key], Device: device, Updates: updates})
+			if err != nil {
+				return iot.Errorf(iot.InvalidValue, "render command %q: %w", key, err)
+			}
+			if err := d.publish(ctx, topic, e.cfg, buf.Bytes()); err != nil {
+				return err
+			}
+		case hasTopic:
+			if err := d.publish(ctx, topic, e.cfg, []byte(store.FormatValue(updates[key]))); err != nil {
+				return err
+			}
+		default:
+			untemplated[key] = updates[key]
+		}
+	}
+
+	if len(untemplated) > 0 {
+		data, _ := json.Marshal(untemplated)
+		return d.publish(ctx, e.cfg.CommandTopic, e.cfg, data)
+	}
+	return nil
+}
+
+func (d *Driver) publish(ctx context.Context, topic string, cfg DeviceConfig, payload []byte) error {
+	if err := Wait(ctx, d.client.Publish(topic, cfg.QoS, cfg.Retain, payload)); err != nil {
+		return fmt.Errorf("publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Wait waits for an MQTT operation until ctx is done. Broker failures are
+// reported as iot.TransportError, an expired ctx as iot.Timeout.
+func Wait(ctx context.Context, token paho.Token) error {
+	select {
+	case <-token.Done():
+		if err := token.Error(); err != nil {
+			return iot.Errorf(iot.TransportError, "%w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return iot.Errorf(iot.Timeout, "%w", ctx.Err())
+	}
+}
@@ -0,0 +1,152 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+func TestStateBindings(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DeviceConfig
+		want map[string][]stateBinding
+	}{
+		{
+			name: "whole payload",
+			cfg:  DeviceConfig{StateTopic: "dev/state"},
+			want: map[string][]stateBinding{"dev/state": {{}}},
+		},
+		{
+			name: "state map",
+			cfg:  DeviceConfig{StateTopic: "dev/state", StateMap: map[string]string{"state": "$.power"}},
+			want: map[string][]stateBinding{"dev/state": {{key: "state", path: "$.power"}}},
+		},
+		{
+			name: "per-key topics",
+			cfg: DeviceConfig{
+				StateTopic:  "dev/state",
+				StateMap:    map[string]string{"temperature": "$.value"},
+				StateTopics: map[string]string{"temperature": "dev/temp", "humidity": "dev/hum"},
+			},
+			want: map[string][]stateBinding{
+				"dev/temp": {{key: "temperature", path: "$.value"}},
+				"dev/hum":  {{key: "humidity", path: "$"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stateBindings(tt.cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stateBindings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesFromConfig(t *testing.T) {
+	cfg := DeviceConfig{
+		CommandTopic: "dev/set",
+		StateMap:     map[string]string{"state": "$.state", "temperature": "$.temp"},
+		Commands:     map[string]string{"state": "{{.Value}}"},
+	}
+	caps := capabilitiesFromConfig(cfg)
+	writable := map[string]bool{}
+	var names []string
+	for _, c := range caps {
+		names = append(names, c.Name)
+		writable[c.Name] = c.Writable
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"state", "temperature"}) {
+		t.Fatalf("capabilities = %v", names)
+	}
+	if !writable["state"] || writable["temperature"] {
+		t.Errorf("writable = %v, want state only", writable)
+	}
+}
+
+func TestTopicQoS(t *testing.T) {
+	d := NewDriver(nil)
+	add := func(id, topic string, qos byte) {
+		cfg := DeviceConfig{ID: id, StateTopic: topic, QoS: qos}
+		d.devices[id] = &deviceEntry{cfg: cfg, bindings: stateBindings(cfg)}
+	}
+	add("a", "shared/state", 0)
+	add("b", "shared/state", 2)
+	add("c", "single/state", 1)
+
+	tests := []struct {
+		topic string
+		want  byte
+	}{
+		{"shared/state", 2},
+		{"single/state", 1},
+		{"unknown", 0},
+	}
+	for _, tt := range tests {
+		if got := d.topicQoS(tt.topic); got != tt.want {
+			t.Errorf("topicQoS(%q) = %d, want %d", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestAddDeviceRejectsInvalidQoS(t *testing.T) {
+	d := NewDriver(nil)
+	if err := d.AddDevice(DeviceConfig{ID: "x", StateTopic: "x/state", QoS: 3}); err == nil {
+		t.Error("AddDevice accepted qos 3")
+	}
+}
+
+// pendingToken is an MQTT operation that never completes.
+type pendingToken struct{ done chan struct{} }
+
+func (t pendingToken) Wait() bool                     { <-t.done; return true }
+func (t pendingToken) WaitTimeout(time.Duration) bool { return false }
+func (t pendingToken) Done() <-chan struct{}          { return t.done }
+func (t pendingToken) Error() error                   { return nil }
+
+func TestWaitTimesOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := Wait(ctx, pendingToken{done: make(chan struct{})})
+	var e *iot.Error
+	if !errors.As(err, &e) || e.Kind != iot.Timeout {
+		t.Errorf("Wait() = %v, want an iot.Timeout error", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want it to wrap the context error", err)
+	}
+}
+
+func TestRegisterDeviceKeepsUserEdits(t *testing.T) {
+	testutil.UseMemoryStore()
+	ds := factory.GetDeviceStore()
+
+	cfg := DeviceConfig{ID: "fan", StateTopic: "fan/state", Commands: map[string]string{"state": "{{.Value}}"}}
+	registerDevice(cfg)
+	device, _ := ds.Get("fan")
+	if device.Name != "fan" || device.Room != "unknown" || device.Type != "mqtt" || device.Protocol != "mqtt" {
+		t.Fatalf("device = %+v", device)
+	}
+
+	device.Name, device.Room = "Ceiling fan", "Bedroom"
+	ds.Add(device)
+	cfg.Capabilities = []store.Capability{{Name: "speed", Writable: true}}
+	registerDevice(cfg)
+	device, _ = ds.Get("fan")
+	if device.Name != "Ceiling fan" || device.Room != "Bedroom" {
+		t.Errorf("name, room = %q, %q, want user edits kept", device.Name, device.Room)
+	}
+	if len(device.Capabilities) != 1 || device.Capabilities[0].Name != "speed" {
+		t.Errorf("capabilities = %+v, want the configured ones", device.Capabilities)
+	}
+}
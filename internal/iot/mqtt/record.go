@@ -0,0 +1,118 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Directions of recorded messages.
+const (
+	Inbound  = "in"
+	Outbound = "out"
+)
+
+// RecordedMessage is one line of an MQTT_RECORD_FILE recording. Payload is
+// stored base64 encoded, so binary payloads survive the round trip.
+type RecordedMessage struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Dir      string    `json:"dir"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained,omitempty"`
+	Payload  []byte    `json:"payload"`
+}
+
+// Recorder appends messages to a JSONL file. It is shared by every client, so
+// a recording keeps the order in which messages crossed all of them.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenRecorder opens path for appending.
+func OpenRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends m. It fails with os.ErrClosed once the recorder is closed.
+func (r *Recorder) Record(m RecordedMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	return r.enc.Encode(m)
+}
+
+// Close flushes the recording to disk and closes the file. Closing twice is a
+// no-op.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	f := r.file
+	r.file, r.enc = nil, nil
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordingClient records what goes through a client: publishes as outbound
+// messages, and whatever its subscriptions deliver as inbound ones.
+type recordingClient struct {
+	paho.Client
+	id  string
+	rec *Recorder
+}
+
+func (c *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	c.rec.Record(RecordedMessage{Client: c.id, Dir: Outbound, Topic: topic, QoS: qos, Retained: retained, Payload: recordedPayload(payload)})
+	return c.Client.Publish(topic, qos, retained, payload)
+}
+
+func (c *recordingClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	return c.Client.Subscribe(topic, qos, c.wrap(callback))
+}
+
+func (c *recordingClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	return c.Client.SubscribeMultiple(filters, c.wrap(callback))
+}
+
+func (c *recordingClient) AddRoute(topic string, callback paho.MessageHandler) {
+	c.Client.AddRoute(topic, c.wrap(callback))
+}
+
+func (c *recordingClient) wrap(callback paho.MessageHandler) paho.MessageHandler {
+	return func(_ paho.Client, msg paho.Message) {
+		c.rec.Record(RecordedMessage{Client: c.id, Dir: Inbound, Topic: msg.Topic(), QoS: msg.Qos(), Retained: msg.Retained(), Payload: msg.Payload()})
+		callback(c, msg)
+	}
+}
+
+func recordedPayload(payload interface{}) []byte {
+	switch p := payload.(type) {
+	case string:
+		return []byte(p)
+	case []byte:
+		return append([]byte(nil), p...)
+	}
+	return []byte(fmt.Sprint(payload))
+}
@@ -0,0 +1,259 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// LoadRecording reads a JSONL recording written by a Recorder.
+func LoadRecording(path string) ([]RecordedMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var messages []RecordedMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // bridge/devices can be large
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var m RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		messages = append(messages, m)
+	}
+	return messages, scanner.Err()
+}
+
+type replaySubscription struct {
+	filter  string
+	handler paho.MessageHandler
+}
+
+// ReplayClient stands in for a broker connection: once connected it feeds the
+// recorded inbound messages of its client ID to its subscriptions, spaced as
+// recorded and divided by speed (0 replays without delays). Publishes are
+// accepted and dropped.
+type ReplayClient struct {
+	id        string
+	onConnect paho.OnConnectHandler
+	opts      *paho.ClientOptions
+	messages  []RecordedMessage
+	speed     float64
+
+	mu            sync.Mutex
+	subscriptions []replaySubscription
+	connected     bool
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// NewReplayClient replays the inbound messages recorded for the options'
+// client ID.
+func NewReplayClient(opts *paho.ClientOptions, recording []RecordedMessage, speed float64) *ReplayClient {
+	c := &ReplayClient{
+		id:        opts.ClientID,
+		onConnect: opts.OnConnect,
+		opts:      opts,
+		speed:     speed,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, m := range recording {
+		if m.Dir == Inbound && m.Client == c.id {
+			c.messages = append(c.messages, m)
+		}
+	}
+	return c
+}
+
+// Done is closed when every message has been replayed.
+func (c *ReplayClient) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *ReplayClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *ReplayClient) IsConnectionOpen() bool {
+	return c.IsConnected()
+}
+
+func (c *ReplayClient) Connect() paho.Token {
+	c.mu.Lock()
+	already := c.connected
+	c.connected = true
+	c.mu.Unlock()
+	if !already {
+		go c.run()
+	}
+	return completedToken{}
+}
+
+func (c *ReplayClient) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connected {
+		c.connected = false
+		close(c.stop)
+	}
+}
+
+func (c *ReplayClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	return completedToken{}
+}
+
+func (c *ReplayClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	c.AddRoute(topic, callback)
+	return completedToken{}
+}
+
+func (c *ReplayClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	for topic := range filters {
+		c.AddRoute(topic, callback)
+	}
+	return completedToken{}
+}
+
+func (c *ReplayClient) Unsubscribe(topics ...string) paho.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.subscriptions[:0]
+	for _, sub := range c.subscriptions {
+		if !contains(topics, sub.filter) {
+			kept = append(kept, sub)
+		}
+	}
+	c.subscriptions = kept
+	return completedToken{}
+}
+
+func (c *ReplayClient) AddRoute(topic string, callback paho.MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, sub := range c.subscriptions {
+		if sub.filter == topic {
+			c.subscriptions[i].handler = callback
+			return
+		}
+	}
+	c.subscriptions = append(c.subscriptions, replaySubscription{filter: topic, handler: callback})
+}
+
+func (c *ReplayClient) OptionsReader() paho.ClientOptionsReader {
+	return paho.NewOptionsReader(c.opts)
+}
+
+func (c *ReplayClient) run() {
+	defer close(c.done)
+	if c.onConnect != nil {
+		c.onConnect(c)
+	}
+	log.Printf("[MQTT] Replaying %d messages for %s", len(c.messages), c.id)
+
+	var last time.Time
+	for _, m := range c.messages {
+		if c.speed > 0 && !last.IsZero() && m.Time.After(last) {
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(time.Duration(float64(m.Time.Sub(last)) / c.speed)):
+			}
+		}
+		last = m.Time
+
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+		c.deliver(m)
+	}
+	log.Printf("[MQTT] Replay for %s finished", c.id)
+}
+
+// deliver hands a message to every matching subscription, like the broker
+// would.
+func (c *ReplayClient) deliver(m RecordedMessage) {
+	c.mu.Lock()
+	var handlers []paho.MessageHandler
+	for _, sub := range c.subscriptions {
+		if topicMatches(sub.filter, m.Topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	c.mu.Unlock()
+
+	msg := &replayMessage{m}
+	for _, handler := range handlers {
+		handler(c, msg)
+	}
+}
+
+// topicMatches reports whether topic matches a subscription filter with + and
+// # wildcards.
+func topicMatches(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, part := range f {
+		switch {
+		case part == "#":
+			return true
+		case i >= len(t):
+			return false
+		case part != "+" && part != t[i]:
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type replayMessage struct {
+	m RecordedMessage
+}
+
+func (r *replayMessage) Duplicate() bool   { return false }
+func (r *replayMessage) Qos() byte         { return r.m.QoS }
+func (r *replayMessage) Retained() bool    { return r.m.Retained }
+func (r *replayMessage) Topic() string     { return r.m.Topic }
+func (r *replayMessage) MessageID() uint16 { return 0 }
+func (r *replayMessage) Payload() []byte   { return r.m.Payload }
+func (r *replayMessage) Ack()              {}
+
+// completedToken is the token of an operation that finished immediately.
+type completedToken struct{}
+
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func (completedToken) Wait() bool                     { return true }
+func (completedToken) WaitTimeout(time.Duration) bool { return true }
+func (completedToken) Done() <-chan struct{}          { return closedChan }
+func (completedToken) Error() error                   { return nil }
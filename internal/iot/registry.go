@@ -0,0 +1,200 @@
+package iot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/store"
+)
+
+// Health states reported by drivers.
+const (
+	HealthOK       = "ok"       // connected and serving devices
+	HealthDegraded = "degraded" // enabled but its backend is unreachable
+	HealthDisabled = "disabled" // not enabled or not configured
+	HealthFailed   = "failed"   // Start returned an error
+)
+
+// SimulatorProtocol is the protocol of the simulator driver. In demo mode it
+// serves every device, whatever protocol the device was added with.
+const SimulatorProtocol = "sim"
+
+// Health is a driver's current status with an optional human-readable detail.
+type Health struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Driver is a protocol driver managed by the registry. Start connects to the
+// driver's backend and must not block; Stop releases it again.
+type Driver interface {
+	DeviceDriver
+	Start() error
+	Stop() error
+	Health() Health
+}
+
+// Factory creates a protocol's driver. It is only called for enabled drivers.
+type Factory func() Driver
+
+// DriverInfo describes a registered driver for GET /drivers.
+type DriverInfo struct {
+	Protocol string `json:"protocol"`
+	Enabled  bool   `json:"enabled"`
+	Health   Health `json:"health"`
+}
+
+// UnknownProtocolError is returned for devices whose protocol has no
+// registered driver.
+type UnknownProtocolError struct {
+	Protocol string
+}
+
+func (e *UnknownProtocolError) Error() string {
+	return fmt.Sprintf("no driver for protocol %q", e.Protocol)
+}
+
+// DriverDisabledError is returned for devices whose driver is registered but
+// not enabled in DRIVERS.
+type DriverDisabledError struct {
+	Protocol string
+}
+
+func (e *DriverDisabledError) Error() string {
+	return fmt.Sprintf("driver %q is not enabled", e.Protocol)
+}
+
+type registration struct {
+	factory  Factory
+	optIn    bool // only started when named in DRIVERS
+	driver   Driver
+	startErr error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*registration)
+)
+
+// Register makes a driver available under a protocol name. It is enabled
+// by default, i.e. when DRIVERS is empty. Protocol packages call it from
+// init; registering the same protocol twice panics.
+func Register(protocol string, factory Factory) {
+	register(protocol, factory, false)
+}
+
+// RegisterOptIn is Register for drivers that are only started when DRIVERS
+// names them, such as the simulator.
+func RegisterOptIn(protocol string, factory Factory) {
+	register(protocol, factory, true)
+}
+
+func register(protocol string, factory Factory, optIn bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("iot: Register factory is nil for " + protocol)
+	}
+	if _, dup := registry[protocol]; dup {
+		panic("iot: Register called twice for " + protocol)
+	}
+	registry[protocol] = &registration{factory: factory, optIn: optIn}
+}
+
+// StartDrivers creates and starts the enabled drivers. An empty list enables
+// every driver not registered with RegisterOptIn. Names without a registered
+// driver are logged and skipped, as are drivers whose Start fails.
+func StartDrivers(enabled []string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := enabled
+	if len(names) == 0 {
+		for protocol, reg := range registry {
+			if !reg.optIn {
+				names = append(names, protocol)
+			}
+		}
+		sort.Strings(names)
+	}
+	for _, protocol := range names {
+		reg, ok := registry[protocol]
+		if !ok {
+			log.Printf("[IoT] Unknown driver %q in DRIVERS, skipping", protocol)
+			continue
+		}
+		if reg.driver != nil {
+			continue
+		}
+		reg.driver = reg.factory()
+		if err := reg.driver.Start(); err != nil {
+			reg.startErr = err
+			log.Printf("[IoT] Failed to start %s driver: %v", protocol, err)
+			continue
+		}
+		log.Printf("[IoT] Started %s driver", protocol)
+	}
+}
+
+// StopDrivers stops every started driver.
+func StopDrivers() {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for protocol, reg := range registry {
+		if reg.driver == nil {
+			continue
+		}
+		if err := reg.driver.Stop(); err != nil {
+			log.Printf("[IoT] Failed to stop %s driver: %v", protocol, err)
+		}
+		reg.driver = nil
+		reg.startErr = nil
+	}
+}
+
+// GetDriverFor returns the driver for the device's protocol, or the simulator
+// in demo mode. It fails with an *UnknownProtocolError or *DriverDisabledError.
+func GetDriverFor(device store.Device) (DeviceDriver, error) {
+	if config.DemoMode {
+		return GetDriver(SimulatorProtocol)
+	}
+	return GetDriver(device.Protocol)
+}
+
+// GetDriver returns the started driver registered for protocol.
+func GetDriver(protocol string) (Driver, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	reg, ok := registry[protocol]
+	if !ok {
+		return nil, &UnknownProtocolError{Protocol: protocol}
+	}
+	if reg.driver == nil || reg.startErr != nil {
+		return nil, &DriverDisabledError{Protocol: protocol}
+	}
+	return reg.driver, nil
+}
+
+// Drivers lists every registered driver, sorted by protocol.
+func Drivers() []DriverInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	infos := make([]DriverInfo, 0, len(registry))
+	for protocol, reg := range registry {
+		info := DriverInfo{Protocol: protocol, Enabled: reg.driver != nil}
+		switch {
+		case reg.driver == nil:
+			info.Health = Health{Status: HealthDisabled}
+		case reg.startErr != nil:
+			info.Health = Health{Status: HealthFailed, Detail: reg.startErr.Error()}
+		default:
+			info.Health = reg.driver.Health()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Protocol < infos[j].Protocol })
+	return infos
+}
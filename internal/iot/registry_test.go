@@ -0,0 +1,167 @@
+package iot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/store"
+)
+
+// fakeDriver records its lifecycle and reports a fixed health.
+type fakeDriver struct {
+	startErr error
+	health   Health
+	started  bool
+	stopped  bool
+}
+
+func (d *fakeDriver) Start() error   { d.started = true; return d.startErr }
+func (d *fakeDriver) Stop() error    { d.stopped = true; return nil }
+func (d *fakeDriver) Health() Health { return d.health }
+func (d *fakeDriver) GetState(context.Context, store.Device) (store.State, error) {
+	return store.State{}, nil
+}
+func (d *fakeDriver) SetState(context.Context, store.Device, store.State) error { return nil }
+
+// registerForTest registers a driver for the test and removes it again
+// afterwards, stopping every started driver first.
+func registerForTest(t *testing.T, protocol string, driver Driver, optIn bool) {
+	t.Helper()
+	register(protocol, func() Driver { return driver }, optIn)
+	t.Cleanup(func() {
+		StopDrivers()
+		registryMu.Lock()
+		delete(registry, protocol)
+		registryMu.Unlock()
+	})
+}
+
+func driverInfo(t *testing.T, protocol string) DriverInfo {
+	t.Helper()
+	for _, info := range Drivers() {
+		if info.Protocol == protocol {
+			return info
+		}
+	}
+	t.Fatalf("%s not in Drivers()", protocol)
+	return DriverInfo{}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	registerForTest(t, "dup", &fakeDriver{}, false)
+	for name, register := range map[string]func(){
+		"duplicate":   func() { Register("dup", func() Driver { return &fakeDriver{} }) },
+		"nil factory": func() { Register("nil", nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Register did not panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestStartDriversDefaultSet(t *testing.T) {
+	always, optIn := &fakeDriver{}, &fakeDriver{}
+	registerForTest(t, "always", always, false)
+	registerForTest(t, "optin", optIn, true)
+
+	StartDrivers(nil)
+	if !always.started {
+		t.Error("default driver not started by an empty DRIVERS")
+	}
+	if optIn.started {
+		t.Error("opt-in driver started by an empty DRIVERS")
+	}
+	var disabled *DriverDisabledError
+	if _, err := GetDriver("optin"); !errors.As(err, &disabled) {
+		t.Errorf("GetDriver(optin) err = %v, want *DriverDisabledError", err)
+	}
+
+	StopDrivers()
+	if !always.stopped {
+		t.Error("StopDrivers did not stop the driver")
+	}
+	if _, err := GetDriver("always"); !errors.As(err, &disabled) {
+		t.Errorf("GetDriver after StopDrivers err = %v, want *DriverDisabledError", err)
+	}
+}
+
+func TestStartDriversNamed(t *testing.T) {
+	always, optIn := &fakeDriver{}, &fakeDriver{}
+	registerForTest(t, "always", always, false)
+	registerForTest(t, "optin", optIn, true)
+
+	StartDrivers([]string{"optin", "nonexistent"})
+	if !optIn.started || always.started {
+		t.Errorf("started always=%v optin=%v, want only optin", always.started, optIn.started)
+	}
+	if d, err := GetDriver("optin"); err != nil || d != optIn {
+		t.Errorf("GetDriver(optin) = %v, %v", d, err)
+	}
+	var unknown *UnknownProtocolError
+	if _, err := GetDriver("nonexistent"); !errors.As(err, &unknown) || unknown.Protocol != "nonexistent" {
+		t.Errorf("GetDriver(nonexistent) err = %v, want *UnknownProtocolError", err)
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	registerForTest(t, "broken", &fakeDriver{startErr: errors.New("no backend")}, false)
+	StartDrivers([]string{"broken"})
+
+	var disabled *DriverDisabledError
+	if _, err := GetDriver("broken"); !errors.As(err, &disabled) {
+		t.Errorf("GetDriver(broken) err = %v, want *DriverDisabledError", err)
+	}
+	info := driverInfo(t, "broken")
+	if !info.Enabled || info.Health.Status != HealthFailed || info.Health.Detail != "no backend" {
+		t.Errorf("Drivers() entry = %+v", info)
+	}
+}
+
+func TestGetDriverFor(t *testing.T) {
+	sim, zigbee := &fakeDriver{}, &fakeDriver{}
+	registerForTest(t, SimulatorProtocol, sim, true)
+	registerForTest(t, "zigbee", zigbee, false)
+	StartDrivers([]string{SimulatorProtocol, "zigbee"})
+	device := store.Device{ID: "lamp", Protocol: "zigbee"}
+
+	if d, err := GetDriverFor(device); err != nil || d != zigbee {
+		t.Errorf("GetDriverFor() = %v, %v, want the zigbee driver", d, err)
+	}
+	config.DemoMode = true
+	d, err := GetDriverFor(device)
+	config.DemoMode = false
+	if err != nil || d != sim {
+		t.Errorf("GetDriverFor() in demo mode = %v, %v, want the simulator", d, err)
+	}
+
+	var unknown *UnknownProtocolError
+	if _, err := GetDriverFor(store.Device{ID: "x", Protocol: "x10"}); !errors.As(err, &unknown) {
+		t.Errorf("GetDriverFor(x10) err = %v, want *UnknownProtocolError", err)
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	registerForTest(t, "b-degraded", &fakeDriver{health: Health{Status: HealthDegraded, Detail: "unreachable"}}, false)
+	registerForTest(t, "a-off", &fakeDriver{}, false)
+	StartDrivers([]string{"b-degraded"})
+
+	infos := Drivers()
+	for i := 1; i < len(infos); i++ {
+		if infos[i-1].Protocol > infos[i].Protocol {
+			t.Errorf("Drivers() not sorted: %q before %q", infos[i-1].Protocol, infos[i].Protocol)
+		}
+	}
+	if info := driverInfo(t, "a-off"); info.Enabled || info.Health.Status != HealthDisabled {
+		t.Errorf("not started driver = %+v", info)
+	}
+	if info := driverInfo(t, "b-degraded"); !info.Enabled || info.Health != (Health{Status: HealthDegraded, Detail: "unreachable"}) {
+		t.Errorf("started driver = %+v", info)
+	}
+}
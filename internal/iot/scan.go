@@ -0,0 +1,51 @@
+package iot
+
+import (
+	"fmt"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/store"
+)
+
+// Adopter is implemented by drivers that take over devices found by a
+// network scan, e.g. by connecting to the address the scan reported.
+type Adopter interface {
+	Adopt(found store.DiscoveredDevice) error
+}
+
+// StartScan opens discovery for one protocol for the given duration. Results
+// arrive asynchronously in the scan store while the window is open. In demo
+// mode the simulator offers its devices instead.
+func StartScan(protocol string, duration time.Duration) error {
+	if config.DemoMode {
+		protocol = SimulatorProtocol
+	}
+
+	driver, err := GetDriver(protocol)
+	if err != nil {
+		return err
+	}
+	scanner, ok := driver.(Scanner)
+	if !ok {
+		return fmt.Errorf("scanning is not supported for protocol %q", protocol)
+	}
+	return scanner.Scan(duration)
+}
+
+// Adopt hands a device picked from the scan results to its protocol's
+// driver. Drivers that aren't running, and those that find their devices
+// themselves like zigbee, are left out.
+func Adopt(found store.DiscoveredDevice) error {
+	if config.DemoMode {
+		return nil
+	}
+	driver, err := GetDriver(found.Protocol)
+	if err != nil {
+		return nil
+	}
+	if adopter, ok := driver.(Adopter); ok {
+		return adopter.Adopt(found)
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// Model simulates one kind of device. Set applies a single requested value to
+// the state; Step advances the state by dt. Both mutate state in place.
+type Model interface {
+	Type() string
+	Capabilities() []store.Capability
+	Initial(r *rand.Rand) store.State
+	Set(state store.State, key string, value interface{}) error
+	Step(state store.State, dt time.Duration, s Settings, r *rand.Rand)
+}
+
+// Settings tune a model. EventRate is the number of spontaneous events per
+// minute for models that have them (door openings, button presses).
+type Settings struct {
+	LatencyMS   int     `json:"latency_ms"`
+	JitterMS    int     `json:"jitter_ms"`
+	FailureRate float64 `json:"failure_rate"`
+	EventRate   float64 `json:"event_rate"`
+}
+
+var defaultSettings = Settings{LatencyMS: 50, JitterMS: 50, EventRate: 0.2}
+
+const genericModel = "generic"
+
+var models = map[string]Model{
+	"color_bulb":         bulb{color: true},
+	"dimmable_bulb":      bulb{},
+	"plug":               plug{metering: true},
+	"switch":             plug{},
+	"temperature_sensor": temperatureSensor{},
+	"thermostat":         thermostat{},
+	"door_sensor":        doorSensor{},
+	"button":             button{},
+	genericModel:         generic{},
+}
+
+// typeModels picks a model for devices that were added by type only, e.g.
+// through POST /devices.
+var typeModels = map[string]string{
+	"bulb":           "color_bulb",
+	"light":          "color_bulb",
+	"dimmer":         "dimmable_bulb",
+	"smart_plug":     "plug",
+	"plug":           "plug",
+	"sensor":         "temperature_sensor",
+	"contact_sensor": "door_sensor",
+}
+
+func modelForType(deviceType string) string {
+	if _, ok := models[deviceType]; ok {
+		return deviceType
+	}
+	if name, ok := typeModels[deviceType]; ok {
+		return name
+	}
+	return genericModel
+}
+
+// bulb is a dimmable light, optionally with RGB colour.
+type bulb struct{ color bool }
+
+func (b bulb) Type() string {
+	if b.color {
+		return "bulb"
+	}
+	return "dimmer"
+}
+
+func (b bulb) Capabilities() []store.Capability {
+	caps := []store.Capability{
+		powerCapability("Turn the bulb on or off"),
+		{
+			Name:        "brightness",
+			Description: "Adjust brightness (0-100)",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"brightness": map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+			},
+			Writable: true,
+		},
+	}
+	if b.color {
+		caps = append(caps, store.Capability{
+			Name:        "color",
+			Description: "Change bulb color using RGB",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"rgb": map[string]interface{}{"type": "array", "length": 3, "range": []int{0, 255}},
+			},
+			Writable: true,
+		})
+	}
+	return caps
+}
+
+func (b bulb) Initial(r *rand.Rand) store.State {
+	state := store.State{"state": "off", "brightness": 100}
+	if b.color {
+		state["rgb"] = []int{255, 255, 255}
+	}
+	return state
+}
+
+func (b bulb) Set(state store.State, key string, value interface{}) error {
+	switch key {
+	case "state":
+		return setPower(state, value)
+	case "brightness", "level":
+		n, ok := iot.Number(value)
+		if !ok || n < 0 || n > 100 {
+			return iot.Errorf(iot.InvalidValue, "invalid brightness %v, expected 0-100", value)
+		}
+		state["brightness"] = int(n)
+		return nil
+	case "rgb":
+		if !b.color {
+			break
+		}
+		rgb, ok := iot.RGBValue(value)
+		if !ok {
+			return iot.Errorf(iot.InvalidValue, "invalid rgb %v, expected [r, g, b] in 0-255", value)
+		}
+		state["rgb"] = rgb
+		return nil
+	}
+	return iot.UnsupportedKey(key)
+}
+
+func (b bulb) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {}
+
+// plug is a relay, with power metering unless it is a plain switch. A metering
+// plug draws around 60 W while on and accumulates energy in kWh.
+type plug struct{ metering bool }
+
+func (p plug) Type() string {
+	if p.metering {
+		return "smart_plug"
+	}
+	return "switch"
+}
+
+func (p plug) Capabilities() []store.Capability {
+	caps := []store.Capability{powerCapability("Turn the device on or off")}
+	if p.metering {
+		caps = append(caps,
+			sensorCapability("power_usage", "Current power draw", "number", "W"),
+			sensorCapability("energy", "Energy used", "number", "kWh"),
+		)
+	}
+	return caps
+}
+
+func (p plug) Initial(r *rand.Rand) store.State {
+	state := store.State{"state": "off"}
+	if p.metering {
+		state["power_usage"] = 0.0
+		state["energy"] = 0.0
+	}
+	return state
+}
+
+func (p plug) Set(state store.State, key string, value interface{}) error {
+	if key == "state" {
+		return setPower(state, value)
+	}
+	return iot.UnsupportedKey(key)
+}
+
+func (p plug) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {
+	if !p.metering {
+		return
+	}
+	watts := 0.0
+	if state["state"] == "on" {
+		watts, _ = iot.Number(state["power_usage"])
+		if watts == 0 {
+			watts = 60
+		}
+		watts = clamp(watts+r.NormFloat64()*2, 40, 80)
+	}
+	energy, _ := iot.Number(state["energy"])
+	state["power_usage"] = round(watts, 1)
+	state["energy"] = energy + watts*dt.Hours()/1000
+}
+
+// temperatureSensor reports a temperature and humidity that drift randomly
+// around a baseline, and a battery that slowly drains.
+type temperatureSensor struct{}
+
+func (temperatureSensor) Type() string { return "temperature_sensor" }
+
+func (temperatureSensor) Capabilities() []store.Capability {
+	return []store.Capability{
+		sensorCapability("temperature", "Current temperature", "number", "°C"),
+		sensorCapability("humidity", "Relative humidity", "number", "%"),
+		sensorCapability("battery", "Battery level", "integer", "%"),
+	}
+}
+
+func (temperatureSensor) Initial(r *rand.Rand) store.State {
+	return store.State{
+		"temperature": round(21+r.NormFloat64(), 2),
+		"humidity":    round(45+r.NormFloat64()*3, 1),
+		"battery":     100,
+	}
+}
+
+func (temperatureSensor) Set(state store.State, key string, value interface{}) error {
+	return readOnly(key)
+}
+
+func (temperatureSensor) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {
+	state["temperature"] = round(drift(state["temperature"], 21, 0.1, dt, r), 2)
+	state["humidity"] = round(clamp(drift(state["humidity"], 45, 0.5, dt, r), 0, 100), 1)
+	drainBattery(state, dt, r)
+}
+
+// thermostat heats a room towards its setpoint while in "heat" mode, with a
+// little hysteresis. The room loses heat towards a 10 °C outside temperature.
+type thermostat struct{}
+
+const (
+	outsideTemperature = 10.0
+	heatingRate        = 0.2  // °C per minute while heating
+	heatLossRate       = 0.01 // fraction of the indoor/outdoor difference lost per minute
+	hysteresis         = 0.3
+)
+
+func (thermostat) Type() string { return "thermostat" }
+
+func (thermostat) Capabilities() []store.Capability {
+	return []store.Capability{
+		{
+			Name:        "thermostat_mode",
+			Description: "Select the thermostat mode",
+			Operations:  []string{"heat", "off"},
+			Parameters: map[string]interface{}{
+				"thermostat_mode": map[string]interface{}{"type": "string", "operations": []string{"heat", "off"}},
+			},
+			Writable: true,
+		},
+		{
+			Name:        "setpoint",
+			Description: "Target temperature",
+			Parameters: map[string]interface{}{
+				"setpoint": map[string]interface{}{"type": "number", "range": []float64{5, 30}, "unit": "°C"},
+			},
+			Writable: true,
+		},
+		sensorCapability("temperature", "Current temperature", "number", "°C"),
+		sensorCapability("heating", "Whether the heating is running", "boolean", ""),
+	}
+}
+
+func (thermostat) Initial(r *rand.Rand) store.State {
+	return store.State{
+		"thermostat_mode": "heat",
+		"setpoint":        21.0,
+		"temperature":     round(18+r.NormFloat64(), 2),
+		"heating":         false,
+	}
+}
+
+func (thermostat) Set(state store.State, key string, value interface{}) error {
+	switch key {
+	case "thermostat_mode":
+		mode := strings.ToLower(fmt.Sprint(value))
+		if mode != "heat" && mode != "off" {
+			return iot.Errorf(iot.InvalidValue, "invalid thermostat mode %v, expected heat/off", value)
+		}
+		state[key] = mode
+		return nil
+	case "setpoint":
+		n, ok := iot.Number(value)
+		if !ok || n < 5 || n > 30 {
+			return iot.Errorf(iot.InvalidValue, "invalid setpoint %v, expected 5-30", value)
+		}
+		state[key] = n
+		return nil
+	}
+	return readOnly(key)
+}
+
+func (thermostat) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {
+	temp, _ := iot.Number(state["temperature"])
+	setpoint, _ := iot.Number(state["setpoint"])
+	heating := state["heating"] == true
+
+	switch {
+	case state["thermostat_mode"] != "heat":
+		heating = false
+	case temp < setpoint-hysteresis:
+		heating = true
+	case temp > setpoint+hysteresis:
+		heating = false
+	}
+
+	minutes := dt.Minutes()
+	temp += (outsideTemperature - temp) * heatLossRate * minutes
+	if heating {
+		temp += heatingRate * minutes
+	}
+	temp += r.NormFloat64() * 0.02 * math.Sqrt(minutes)
+
+	state["temperature"] = round(temp, 2)
+	state["heating"] = heating
+}
+
+// doorSensor reports contact (true while closed). Doors open EventRate times
+// a minute on average and close again after about a minute.
+type doorSensor struct{}
+
+func (doorSensor) Type() string { return "door_sensor" }
+
+func (doorSensor) Capabilities() []store.Capability {
+	return []store.Capability{
+		sensorCapability("contact", "Whether the door is closed", "boolean", ""),
+		sensorCapability("battery", "Battery level", "integer", "%"),
+	}
+}
+
+func (doorSensor) Initial(r *rand.Rand) store.State {
+	return store.State{"contact": true, "battery": 100}
+}
+
+func (doorSensor) Set(state store.State, key string, value interface{}) error {
+	return readOnly(key)
+}
+
+func (doorSensor) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {
+	closed := state["contact"] == true
+	rate := s.EventRate
+	if !closed {
+		rate = 1
+	}
+	if happens(rate, dt, r) {
+		state["contact"] = !closed
+	}
+	drainBattery(state, dt, r)
+}
+
+// button reports its last action for one step, like zigbee2mqtt does, and is
+// pressed EventRate times a minute on average. Presses can also be triggered
+// through its "press" capability.
+type button struct{}
+
+var buttonActions = []string{"single", "double", "hold"}
+
+func (button) Type() string { return "button" }
+
+func (button) Capabilities() []store.Capability {
+	return []store.Capability{
+		{
+			Name:        "press",
+			Description: "Simulate a button press",
+			Operations:  buttonActions,
+			Parameters: map[string]interface{}{
+				"action": map[string]interface{}{"type": "string", "operations": buttonActions},
+			},
+			Writable: true,
+		},
+		sensorCapability("battery", "Battery level", "integer", "%"),
+	}
+}
+
+func (button) Initial(r *rand.Rand) store.State {
+	return store.State{"action": "", "battery": 100}
+}
+
+func (button) Set(state store.State, key string, value interface{}) error {
+	if key != "action" {
+		return readOnly(key)
+	}
+	action := fmt.Sprint(value)
+	for _, a := range buttonActions {
+		if a == action {
+			state[key] = action
+			return nil
+		}
+	}
+	return iot.Errorf(iot.InvalidValue, "invalid action %v, expected one of %s", value, strings.Join(buttonActions, "/"))
+}
+
+func (button) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {
+	switch {
+	case state["action"] != "":
+		state["action"] = ""
+	case happens(s.EventRate, dt, r):
+		state["action"] = buttonActions[r.Intn(len(buttonActions))]
+	}
+	drainBattery(state, dt, r)
+}
+
+// generic stands in for device types without a model: it accepts and keeps
+// whatever is written to it.
+type generic struct{}
+
+func (generic) Type() string                                                       { return genericModel }
+func (generic) Capabilities() []store.Capability                                   { return nil }
+func (generic) Initial(r *rand.Rand) store.State                                   { return store.State{} }
+func (generic) Step(state store.State, dt time.Duration, s Settings, r *rand.Rand) {}
+
+func (generic) Set(state store.State, key string, value interface{}) error {
+	state[key] = value
+	return nil
+}
+
+func powerCapability(description string) store.Capability {
+	return store.Capability{
+		Name:        "power",
+		Description: description,
+		Operations:  []string{"on", "off"},
+		Parameters: map[string]interface{}{
+			"state": map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+		},
+		Writable: true,
+	}
+}
+
+func sensorCapability(name, description, typ, unit string) store.Capability {
+	spec := map[string]interface{}{"type": typ}
+	if unit != "" {
+		spec["unit"] = unit
+	}
+	return store.Capability{
+		Name:        name,
+		Description: description,
+		Parameters:  map[string]interface{}{name: spec},
+	}
+}
+
+func setPower(state store.State, value interface{}) error {
+	switch v := strings.ToLower(store.FormatValue(value)); v {
+	case "on", "true":
+		state["state"] = "on"
+	case "off", "false":
+		state["state"] = "off"
+	case "toggle":
+		if state["state"] == "on" {
+			state["state"] = "off"
+		} else {
+			state["state"] = "on"
+		}
+	default:
+		return iot.Errorf(iot.InvalidValue, "invalid power value %v, expected on/off/toggle", value)
+	}
+	return nil
+}
+
+func readOnly(key string) error {
+	return iot.Errorf(iot.Unsupported, "state key %q is read-only or unsupported", key)
+}
+
+// drift moves v randomly, pulled back towards baseline so it stays plausible.
+func drift(v interface{}, baseline, noise float64, dt time.Duration, r *rand.Rand) float64 {
+	f, ok := iot.Number(v)
+	if !ok {
+		f = baseline
+	}
+	minutes := dt.Minutes()
+	return f + (baseline-f)*0.05*minutes + r.NormFloat64()*noise*math.Sqrt(minutes)
+}
+
+// drainBattery takes a percent off roughly once a day.
+func drainBattery(state store.State, dt time.Duration, r *rand.Rand) {
+	battery, ok := iot.Number(state["battery"])
+	if ok && battery > 0 && happens(1.0/(24*60), dt, r) {
+		state["battery"] = int(battery) - 1
+	}
+}
+
+// happens reports whether an event with the given rate per minute occurs
+// within dt.
+func happens(ratePerMinute float64, dt time.Duration, r *rand.Rand) bool {
+	if ratePerMinute <= 0 {
+		return false
+	}
+	return r.Float64() < 1-math.Exp(-ratePerMinute*dt.Minutes())
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
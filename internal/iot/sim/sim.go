@@ -0,0 +1,436 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// Config is the layout of SIM_DEVICES_FILE. Models tunes latency, failure and
+// event rates by model name, with "default" applying to every model; Devices
+// are added to the device store on start.
+type Config struct {
+	Models  map[string]json.RawMessage `json:"models"`
+	Devices []DeviceConfig             `json:"devices"`
+}
+
+// DeviceConfig is a simulated device to create on start.
+type DeviceConfig struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	Room  string `json:"room"`
+}
+
+// defaultDevices are created when there is no SIM_DEVICES_FILE.
+var defaultDevices = []DeviceConfig{
+	{ID: "sim-bulb-1", Name: "Living Room Bulb", Model: "color_bulb", Room: "Living Room"},
+	{ID: "sim-dimmer-1", Name: "Hallway Light", Model: "dimmable_bulb", Room: "Hallway"},
+	{ID: "sim-plug-1", Name: "Coffee Machine Plug", Model: "plug", Room: "Kitchen"},
+	{ID: "sim-sensor-1", Name: "Bedroom Sensor", Model: "temperature_sensor", Room: "Bedroom"},
+	{ID: "sim-thermostat-1", Name: "Living Room Thermostat", Model: "thermostat", Room: "Living Room"},
+	{ID: "sim-door-1", Name: "Front Door", Model: "door_sensor", Room: "Hallway"},
+	{ID: "sim-button-1", Name: "Bedside Button", Model: "button", Room: "Bedroom"},
+}
+
+type simDevice struct {
+	model string
+	state store.State
+	rng   *rand.Rand
+}
+
+// Driver simulates devices in memory. Every device draws from its own random
+// source, seeded from SIM_SEED and its ID, so with a fixed seed a device's
+// latencies, failures and state depend only on the calls made to it, not on
+// how they interleave with calls to other devices.
+type Driver struct {
+	mu       sync.Mutex
+	seed     int64
+	settings map[string]Settings   // model name -> settings
+	assigned map[string]string     // device ID -> model name, for configured and scanned devices
+	devices  map[string]*simDevice // device ID -> simulated device
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var driver *Driver
+
+// The simulator adds its devices to the store, so it only runs when DRIVERS
+// names it, as demo mode does by default.
+func init() {
+	iot.RegisterOptIn(iot.SimulatorProtocol, func() iot.Driver {
+		driver = NewDriver(config.SimSeed)
+		return driver
+	})
+}
+
+// NewDriver creates a simulator with default settings. A seed of 0 picks a
+// random one.
+func NewDriver(seed int64) *Driver {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("[Sim] Using seed %d", seed)
+	d := &Driver{
+		seed:     seed,
+		settings: make(map[string]Settings),
+		assigned: make(map[string]string),
+		devices:  make(map[string]*simDevice),
+		stop:     make(chan struct{}),
+	}
+	for name := range models {
+		d.settings[name] = defaultSettings
+	}
+	return d
+}
+
+func GetDriver() *Driver {
+	return driver
+}
+
+// Start loads SIM_DEVICES_FILE, adds its devices to the store and starts
+// advancing simulated state every SIM_TICK_INTERVAL.
+func (d *Driver) Start() error {
+	var cfg Config
+	found, err := config.LoadJSONFile(config.SimDevicesFile, &cfg)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", config.SimDevicesFile, err)
+	}
+	if !found {
+		cfg = Config{Devices: defaultDevices}
+	}
+	if err := d.Configure(cfg); err != nil {
+		return err
+	}
+
+	go d.run(config.SimTickInterval)
+	return nil
+}
+
+func (d *Driver) Stop() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	return nil
+}
+
+func (d *Driver) Health() iot.Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return iot.Health{Status: iot.HealthOK, Detail: fmt.Sprintf("%d simulated devices", len(d.devices))}
+}
+
+// Configure applies model settings and adds the configured devices to the
+// device store. Devices that are already stored keep their name, room and
+// state.
+func (d *Driver) Configure(cfg Config) error {
+	d.mu.Lock()
+	if raw, ok := cfg.Models["default"]; ok {
+		for name, s := range d.settings {
+			if err := json.Unmarshal(raw, &s); err != nil {
+				d.mu.Unlock()
+				return fmt.Errorf("default settings: %w", err)
+			}
+			d.settings[name] = s
+		}
+	}
+	for name, raw := range cfg.Models {
+		if name == "default" {
+			continue
+		}
+		s, ok := d.settings[name]
+		if !ok {
+			d.mu.Unlock()
+			return fmt.Errorf("unknown model %q", name)
+		}
+		if err := json.Unmarshal(raw, &s); err != nil {
+			d.mu.Unlock()
+			return fmt.Errorf("settings for %s: %w", name, err)
+		}
+		d.settings[name] = s
+	}
+	d.mu.Unlock()
+
+	ds := factory.GetDeviceStore()
+	for _, dc := range cfg.Devices {
+		model, ok := models[dc.Model]
+		if dc.ID == "" || !ok {
+			log.Printf("[Sim] Skipping device %q with model %q", dc.ID, dc.Model)
+			continue
+		}
+		d.mu.Lock()
+		d.assigned[dc.ID] = dc.Model
+		d.mu.Unlock()
+
+		if _, found := ds.Get(dc.ID); found {
+			continue
+		}
+		device := store.Device{
+			ID:           dc.ID,
+			Name:         dc.Name,
+			Type:         model.Type(),
+			Protocol:     iot.SimulatorProtocol,
+			Room:         dc.Room,
+			State:        store.State{},
+			Capabilities: model.Capabilities(),
+		}
+		if device.Name == "" {
+			device.Name = dc.ID
+		}
+		if device.Room == "" {
+			device.Room = "unknown"
+		}
+		if err := ds.Add(device); err != nil {
+			log.Printf("[Sim] Failed to add device %s: %v", dc.ID, err)
+			continue
+		}
+		d.attach(device)
+	}
+	return nil
+}
+
+func (d *Driver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	sd := d.attach(device)
+	if err := d.respond(ctx, device.ID, sd); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return copyState(sd.state), nil
+}
+
+func (d *Driver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	_, err := d.set(ctx, device, updates)
+	return err
+}
+
+// SetStateConfirmed reports the simulated state of the updated keys once the
+// device has applied them.
+func (d *Driver) SetStateConfirmed(ctx context.Context, device store.Device, updates store.State) (store.State, error) {
+	state, err := d.set(ctx, device, updates)
+	if err != nil {
+		return nil, err
+	}
+	applied := store.State{}
+	for key := range updates {
+		if v, ok := state[key]; ok {
+			applied[key] = v
+		}
+	}
+	return applied, nil
+}
+
+// set applies updates all-or-nothing and returns the resulting state. Values
+// the update changed besides the requested ones, e.g. a plug's power draw,
+// are written to the device store.
+func (d *Driver) set(ctx context.Context, device store.Device, updates store.State) (store.State, error) {
+	sd := d.attach(device)
+	if err := d.respond(ctx, device.ID, sd); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	model := models[sd.model]
+	next := copyState(sd.state)
+	for key, value := range updates {
+		if err := model.Set(next, key, value); err != nil {
+			d.mu.Unlock()
+			return nil, err
+		}
+	}
+	changes := diffState(sd.state, next)
+	sd.state = next
+	state := copyState(next)
+	d.mu.Unlock()
+
+	if len(changes) > 0 {
+		factory.GetDeviceStore().UpdateState(device.ID, changes)
+	}
+	return state, nil
+}
+
+// Scan offers one new device of every model.
+func (d *Driver) Scan(duration time.Duration) error {
+	names := make([]string, 0, len(models))
+	for name := range models {
+		if name != genericModel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ds := factory.GetDeviceStore()
+	for _, name := range names {
+		var id string
+		for n := 1; ; n++ {
+			id = fmt.Sprintf("sim-%s-%d", name, n)
+			if _, found := ds.Get(id); !found {
+				break
+			}
+		}
+		d.mu.Lock()
+		d.assigned[id] = name
+		d.mu.Unlock()
+		signal := -40 - d.newRand(id).Intn(50)
+
+		factory.GetScanStore().AddDiscoveredDevice(store.DiscoveredDevice{
+			ID:       id,
+			Name:     "Simulated " + name,
+			Type:     models[name].Type(),
+			Protocol: iot.SimulatorProtocol,
+			Signal:   signal,
+		})
+	}
+	return nil
+}
+
+// Step advances every simulated device in the store by dt, in ID order, and
+// writes the changes to the store. It is what the tick loop runs; tests can
+// call it directly for deterministic timing.
+func (d *Driver) Step(dt time.Duration) {
+	ds := factory.GetDeviceStore()
+	devices := ds.GetAll()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+	for _, device := range devices {
+		if !serves(device) {
+			continue
+		}
+		sd := d.attach(device)
+
+		d.mu.Lock()
+		next := copyState(sd.state)
+		models[sd.model].Step(next, dt, d.settings[sd.model], sd.rng)
+		changes := diffState(sd.state, next)
+		sd.state = next
+		d.mu.Unlock()
+
+		if len(changes) > 0 {
+			ds.UpdateState(device.ID, changes)
+		}
+	}
+}
+
+func (d *Driver) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.Step(interval)
+		}
+	}
+}
+
+// serves reports whether the simulator runs a stored device: its own devices,
+// and every device in demo mode.
+func serves(device store.Device) bool {
+	return config.DemoMode || device.Protocol == iot.SimulatorProtocol
+}
+
+// attach returns the simulated device for a stored one, creating it on first
+// use. A new simulated device starts from the model's initial state overlaid
+// with the stored state, gets the model's capabilities if it has none, and
+// reports its full state to the store.
+func (d *Driver) attach(device store.Device) *simDevice {
+	d.mu.Lock()
+	if sd, ok := d.devices[device.ID]; ok {
+		d.mu.Unlock()
+		return sd
+	}
+	name, ok := d.assigned[device.ID]
+	if !ok {
+		name = modelForType(device.Type)
+	}
+	model := models[name]
+	rng := d.newRand(device.ID)
+	state := model.Initial(rng)
+	for key, value := range device.State {
+		if _, known := state[key]; known || name == genericModel {
+			state[key] = value
+		}
+	}
+	sd := &simDevice{model: name, state: state, rng: rng}
+	d.devices[device.ID] = sd
+	initial := copyState(state)
+	d.mu.Unlock()
+
+	ds := factory.GetDeviceStore()
+	if caps := model.Capabilities(); len(device.Capabilities) == 0 && len(caps) > 0 {
+		if stored, found := ds.Get(device.ID); found {
+			stored.Capabilities = caps
+			if err := ds.Add(stored); err != nil {
+				log.Printf("[Sim] Failed to set capabilities for %s: %v", device.ID, err)
+			}
+		}
+	}
+	if len(initial) > 0 {
+		ds.UpdateState(device.ID, initial)
+	}
+	log.Printf("[Sim] Simulating %s as %s", device.ID, name)
+	return sd
+}
+
+// respond waits for the model's latency and rolls for a failure.
+func (d *Driver) respond(ctx context.Context, id string, sd *simDevice) error {
+	d.mu.Lock()
+	s := d.settings[sd.model]
+	delay := time.Duration(s.LatencyMS) * time.Millisecond
+	if s.JitterMS > 0 {
+		delay += time.Duration(sd.rng.Intn(s.JitterMS+1)) * time.Millisecond
+	}
+	fail := s.FailureRate > 0 && sd.rng.Float64() < s.FailureRate
+	d.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("simulated device %s: %w", id, ctx.Err())
+	case <-timer.C:
+	}
+	if fail {
+		return iot.Errorf(iot.TransportError, "simulated failure talking to %s", id)
+	}
+	return nil
+}
+
+// newRand returns a random source for one device, derived from the seed and
+// the device ID.
+func (d *Driver) newRand(id string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return rand.New(rand.NewSource(d.seed ^ int64(h.Sum64())))
+}
+
+func copyState(s store.State) store.State {
+	c := make(store.State, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+func diffState(before, after store.State) store.State {
+	changes := store.State{}
+	for k, v := range after {
+		old, ok := before[k]
+		if !ok || store.FormatValue(old) != store.FormatValue(v) {
+			changes[k] = v
+		}
+	}
+	return changes
+}
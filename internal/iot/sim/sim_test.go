@@ -0,0 +1,110 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+// untouched are the default devices the scenario below never calls directly.
+var untouched = []string{"sim-button-1", "sim-door-1", "sim-sensor-1", "sim-thermostat-1"}
+
+// simulate runs ten simulated minutes with a fixed seed and returns the
+// simulated state of every device. With busy set, other devices are read
+// concurrently between steps.
+func simulate(t *testing.T, seed int64, busy bool) map[string]store.State {
+	t.Helper()
+	testutil.UseMemoryStore()
+
+	d := NewDriver(seed)
+	err := d.Configure(Config{
+		Models: map[string]json.RawMessage{
+			"default": json.RawMessage(`{"latency_ms": 0, "jitter_ms": 1, "failure_rate": 0.3, "event_rate": 2}`),
+		},
+		Devices: defaultDevices,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := factory.GetDeviceStore()
+	for i := 0; i < 10; i++ {
+		if busy {
+			var wg sync.WaitGroup
+			for _, id := range []string{"sim-plug-1", "sim-bulb-1", "sim-dimmer-1"} {
+				device, _ := ds.Get(id)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d.GetState(context.Background(), device)
+				}()
+			}
+			wg.Wait()
+		}
+		d.Step(time.Minute)
+	}
+
+	states := map[string]store.State{}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, sd := range d.devices {
+		states[id] = copyState(sd.state)
+	}
+	return states
+}
+
+func TestSeededRunsAreReproducible(t *testing.T) {
+	first := simulate(t, 42, false)
+	second := simulate(t, 42, false)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed gave different states:\n%v\n%v", first, second)
+	}
+
+	other := simulate(t, 7, false)
+	if reflect.DeepEqual(first["sim-sensor-1"], other["sim-sensor-1"]) {
+		t.Error("different seeds gave the same sensor readings")
+	}
+}
+
+func TestDevicesDoNotShareRandomness(t *testing.T) {
+	quiet := simulate(t, 42, false)
+	busy := simulate(t, 42, true)
+	for _, id := range untouched {
+		if !reflect.DeepEqual(quiet[id], busy[id]) {
+			t.Errorf("%s changed when other devices were used:\n%v\n%v", id, quiet[id], busy[id])
+		}
+	}
+}
+
+func TestDefaultStartAddsNoDevices(t *testing.T) {
+	testutil.UseMemoryStore()
+	savedFile, savedTick := config.SimDevicesFile, config.SimTickInterval
+	config.SimDevicesFile = filepath.Join(t.TempDir(), "sim-devices.json")
+	config.SimTickInterval = time.Hour
+	defer func() { config.SimDevicesFile, config.SimTickInterval = savedFile, savedTick }()
+
+	// DRIVERS unset outside demo mode.
+	iot.StartDrivers(nil)
+	defer iot.StopDrivers()
+	if devices := factory.GetDeviceStore().GetAll(); len(devices) != 0 {
+		t.Errorf("default start added %d devices, want none", len(devices))
+	}
+	if _, err := iot.GetDriver(iot.SimulatorProtocol); err == nil {
+		t.Error("simulator started without being named in DRIVERS")
+	}
+
+	iot.StartDrivers([]string{iot.SimulatorProtocol})
+	if devices := factory.GetDeviceStore().GetAll(); len(devices) != len(defaultDevices) {
+		t.Errorf("naming the simulator added %d devices, want %d", len(devices), len(defaultDevices))
+	}
+}
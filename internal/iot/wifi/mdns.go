@@ -0,0 +1,385 @@
+package wifi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// MDNSGroup is the IPv4 multicast DNS group and port.
+var MDNSGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// maxQueryInterval caps the growing interval between repeated queries.
+const maxQueryInterval = 8 * time.Second
+
+// Service is a DNS-SD service instance resolved from mDNS answers.
+type Service struct {
+	Instance string // e.g. "kitchen-plug"
+	Type     string // e.g. "_esphomelib._tcp"
+	Host     string // target host name, e.g. "kitchen-plug.local."
+	Addrs    []net.IP
+	Port     int
+	TXT      map[string]string
+}
+
+// Browser sends DNS-SD queries over multicast DNS and resolves the service
+// instances that answer. Queries go out from an ephemeral port, so
+// responders reply to it directly (RFC 6762 legacy unicast); answers
+// multicast to the group are read too where the group port can be joined.
+type Browser struct {
+	group *net.UDPAddr
+	conn  *net.UDPConn // ephemeral port, sends queries
+	mconn *net.UDPConn // joined to the group, nil if that failed
+}
+
+// NewBrowser opens the sockets for browsing on ifi, or on the system's
+// default multicast interface if ifi is nil. group is normally MDNSGroup.
+func NewBrowser(ifi *net.Interface, group *net.UDPAddr) (*Browser, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	pc := ipv4.NewPacketConn(conn)
+	if ifi != nil {
+		if err := pc.SetMulticastInterface(ifi); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	pc.SetMulticastTTL(255)
+	pc.SetMulticastLoopback(true)
+
+	b := &Browser{group: group, conn: conn}
+	if b.mconn, err = net.ListenMulticastUDP("udp4", ifi, group); err != nil {
+		log.Printf("[mDNS] Not listening on %s, relying on unicast answers: %v", group, err)
+		b.mconn = nil
+	}
+	return b, nil
+}
+
+// Browse queries for the service types (e.g. "_hue._tcp") until ctx ends,
+// repeating the queries at growing intervals, and calls found whenever an
+// instance is resolved or its records change. It closes the browser when it
+// returns.
+func (b *Browser) Browse(ctx context.Context, types []string, found func(Service)) {
+	packets := make(chan packet, 16)
+	var wg sync.WaitGroup
+	for _, conn := range []*net.UDPConn{b.conn, b.mconn} {
+		if conn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			readPackets(ctx, conn, packets)
+		}(conn)
+	}
+	defer func() {
+		b.conn.Close()
+		if b.mconn != nil {
+			b.mconn.Close()
+		}
+		wg.Wait()
+	}()
+
+	r := newResolver(types)
+	interval := time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			b.query(append(r.browseQuestions(), r.missingQuestions()...))
+			timer.Reset(interval)
+			if interval < maxQueryInterval {
+				interval *= 2
+			}
+		case p := <-packets:
+			if !r.add(p) {
+				continue
+			}
+			for _, s := range r.changed() {
+				found(s)
+			}
+			// Ask for what answers left out, e.g. SRV records from
+			// responders that don't send additional records.
+			if questions := r.missingQuestions(); len(questions) > 0 {
+				b.query(questions)
+			}
+		}
+	}
+}
+
+func (b *Browser) query(questions []dnsmessage.Question) {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	builder.EnableCompression()
+	builder.StartQuestions()
+	for _, q := range questions {
+		if err := builder.Question(q); err != nil {
+			log.Printf("[mDNS] Bad question %s: %v", q.Name, err)
+			return
+		}
+	}
+	msg, err := builder.Finish()
+	if err != nil {
+		log.Printf("[mDNS] Failed to build query: %v", err)
+		return
+	}
+	if _, err := b.conn.WriteToUDP(msg, b.group); err != nil {
+		log.Printf("[mDNS] Failed to send query: %v", err)
+	}
+}
+
+type packet struct {
+	msg  dnsmessage.Message
+	from net.IP
+}
+
+// readPackets decodes responses until the connection is closed.
+func readPackets(ctx context.Context, conn *net.UDPConn, packets chan<- packet) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[mDNS] Read failed: %v", err)
+			}
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Header.Response {
+			continue
+		}
+		select {
+		case packets <- packet{msg: msg, from: from.IP}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolver collects PTR, SRV, TXT and address records and assembles them
+// into services. Names are compared lowercased, as DNS names are
+// case-insensitive.
+type resolver struct {
+	types     map[string]string // lowercased "<type>.local." -> service type
+	instances map[string]*instance
+	addrs     map[string][]net.IP // host name -> addresses
+	reported  map[string]string   // instance -> last reported records
+}
+
+type instance struct {
+	name    string // full name as announced
+	typ     string
+	host    string
+	port    int
+	txt     map[string]string
+	hasSRV  bool
+	hasTXT  bool
+	from    net.IP // sender of the SRV record, the fallback address
+	queried time.Time
+}
+
+func newResolver(types []string) *resolver {
+	r := &resolver{
+		types:     make(map[string]string),
+		instances: make(map[string]*instance),
+		addrs:     make(map[string][]net.IP),
+		reported:  make(map[string]string),
+	}
+	for _, t := range types {
+		r.types[strings.ToLower(t+".local.")] = t
+	}
+	return r
+}
+
+func (r *resolver) browseQuestions() []dnsmessage.Question {
+	var questions []dnsmessage.Question
+	for name := range r.types {
+		questions = append(questions, question(name, dnsmessage.TypePTR))
+	}
+	sort.Slice(questions, func(i, j int) bool { return questions[i].Name.String() < questions[j].Name.String() })
+	return questions
+}
+
+// add records the answers of a response and reports whether it held
+// anything relevant.
+func (r *resolver) add(p packet) bool {
+	relevant := false
+	var records []dnsmessage.Resource
+	records = append(records, p.msg.Answers...)
+	records = append(records, p.msg.Authorities...)
+	records = append(records, p.msg.Additionals...)
+	// PTR records first, so the instances they name are known when their
+	// SRV and TXT records come earlier in the same response.
+	for _, rr := range records {
+		body, ok := rr.Body.(*dnsmessage.PTRResource)
+		if !ok || rr.Header.TTL == 0 {
+			continue
+		}
+		typ, ok := r.types[strings.ToLower(rr.Header.Name.String())]
+		if !ok {
+			continue
+		}
+		target := body.PTR.String()
+		if _, known := r.instances[strings.ToLower(target)]; !known {
+			r.instances[strings.ToLower(target)] = &instance{name: target, typ: typ}
+		}
+		relevant = true
+	}
+	for _, rr := range records {
+		if rr.Header.TTL == 0 {
+			continue // goodbye packets
+		}
+		key := strings.ToLower(rr.Header.Name.String())
+		switch body := rr.Body.(type) {
+		case *dnsmessage.SRVResource:
+			if inst := r.instances[key]; inst != nil {
+				inst.host = strings.ToLower(body.Target.String())
+				inst.port = int(body.Port)
+				inst.hasSRV = true
+				inst.from = p.from
+				relevant = true
+			}
+		case *dnsmessage.TXTResource:
+			if inst := r.instances[key]; inst != nil {
+				inst.txt = parseTXT(body.TXT)
+				inst.hasTXT = true
+				relevant = true
+			}
+		case *dnsmessage.AResource:
+			r.addAddr(key, net.IP(body.A[:]))
+			relevant = true
+		case *dnsmessage.AAAAResource:
+			r.addAddr(key, net.IP(body.AAAA[:]))
+			relevant = true
+		}
+	}
+	return relevant
+}
+
+func (r *resolver) addAddr(host string, ip net.IP) {
+	for _, known := range r.addrs[host] {
+		if known.Equal(ip) {
+			return
+		}
+	}
+	r.addrs[host] = append(r.addrs[host], ip)
+}
+
+// changed returns the services that resolved since the last call or whose
+// records changed. An instance is resolved once its SRV record is known.
+func (r *resolver) changed() []Service {
+	var services []Service
+	for key, inst := range r.instances {
+		if !inst.hasSRV {
+			continue
+		}
+		s := r.service(inst)
+		summary := s.Host + "|" + formatAddrs(s.Addrs) + "|" + formatTXT(s.TXT)
+		if r.reported[key] == summary {
+			continue
+		}
+		r.reported[key] = summary
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Instance < services[j].Instance })
+	return services
+}
+
+func (r *resolver) service(inst *instance) Service {
+	name := inst.name
+	if suffix := "." + inst.typ + ".local."; len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		name = name[:len(name)-len(suffix)]
+	}
+	s := Service{
+		Instance: name,
+		Type:     inst.typ,
+		Host:     inst.host,
+		Addrs:    r.addrs[inst.host],
+		Port:     inst.port,
+		TXT:      inst.txt,
+	}
+	if len(s.Addrs) == 0 && inst.from != nil {
+		s.Addrs = []net.IP{inst.from}
+	}
+	return s
+}
+
+// missingQuestions asks for the SRV, TXT and address records of instances
+// that answers left incomplete, at most once a second per instance.
+func (r *resolver) missingQuestions() []dnsmessage.Question {
+	var questions []dnsmessage.Question
+	now := time.Now()
+	for _, inst := range r.instances {
+		var q []dnsmessage.Question
+		if !inst.hasSRV {
+			q = append(q, question(inst.name, dnsmessage.TypeSRV))
+		}
+		if !inst.hasTXT {
+			q = append(q, question(inst.name, dnsmessage.TypeTXT))
+		}
+		if inst.hasSRV && len(r.addrs[inst.host]) == 0 {
+			q = append(q, question(inst.host, dnsmessage.TypeA))
+		}
+		if len(q) == 0 || now.Sub(inst.queried) < time.Second {
+			continue
+		}
+		inst.queried = now
+		questions = append(questions, q...)
+	}
+	return questions
+}
+
+func question(name string, typ dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}
+}
+
+// parseTXT splits "key=value" strings. Keys are case-insensitive and the
+// first occurrence wins (RFC 6763 section 6.4); keys without "=" are
+// boolean attributes with an empty value.
+func parseTXT(entries []string) map[string]string {
+	txt := make(map[string]string)
+	for _, entry := range entries {
+		key, value, _ := strings.Cut(entry, "=")
+		key = strings.ToLower(key)
+		if key == "" {
+			continue
+		}
+		if _, dup := txt[key]; !dup {
+			txt[key] = value
+		}
+	}
+	return txt
+}
+
+func formatTXT(txt map[string]string) string {
+	keys := make([]string, 0, len(txt))
+	for k := range txt {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + txt[k] + ";")
+	}
+	return b.String()
+}
+
+func formatAddrs(addrs []net.IP) string {
+	var s []string
+	for _, ip := range addrs {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, ",")
+}
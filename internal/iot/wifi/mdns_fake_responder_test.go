@@ -0,0 +1,176 @@
+package wifi
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// FakeResponder answers mDNS queries for a fixed set of services, standing
+// in for devices on the network when developing or testing the wifi scan.
+// Queries from the mDNS port are answered to the group, others directly to
+// the sender like a real responder would.
+type FakeResponder struct {
+	group    *net.UDPAddr
+	conn     *net.UDPConn
+	services []Service
+
+	mu         sync.Mutex
+	answerOnly bool
+	queries    int
+}
+
+// NewFakeResponder joins group on ifi (nil for the default interface) and
+// answers for services. Each service needs Instance, Type, Host, Port and
+// at least one IPv4 address.
+func NewFakeResponder(ifi *net.Interface, group *net.UDPAddr, services ...Service) (*FakeResponder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeResponder{group: group, conn: conn, services: services}
+	go f.serve()
+	return f, nil
+}
+
+// SetAnswerOnly makes the responder leave out the SRV, TXT and address
+// records a PTR answer normally carries, like some embedded responders, so
+// browsers have to ask for them.
+func (f *FakeResponder) SetAnswerOnly(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answerOnly = on
+}
+
+// Queries returns how many queries the responder has seen.
+func (f *FakeResponder) Queries() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+// Close stops answering.
+func (f *FakeResponder) Close() {
+	f.conn.Close()
+}
+
+func (f *FakeResponder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || query.Header.Response {
+			continue
+		}
+		f.mu.Lock()
+		f.queries++
+		answerOnly := f.answerOnly
+		f.mu.Unlock()
+
+		legacy := from.Port != f.group.Port
+		reply, ok := f.answer(query, legacy, answerOnly)
+		if !ok {
+			continue
+		}
+		to := f.group
+		if legacy {
+			to = from
+		}
+		f.conn.WriteToUDP(reply, to)
+	}
+}
+
+// answer builds the response to a query, or reports false if no question
+// is about one of the services.
+func (f *FakeResponder) answer(query dnsmessage.Message, legacy, answerOnly bool) ([]byte, bool) {
+	var answers, additionals []dnsmessage.Resource
+	for _, q := range query.Questions {
+		name := strings.ToLower(q.Name.String())
+		for _, s := range f.services {
+			instance := s.Instance + "." + s.Type + ".local."
+			switch {
+			case q.Type == dnsmessage.TypePTR && name == strings.ToLower(s.Type+".local."):
+				answers = append(answers, ptrRecord(s.Type+".local.", instance))
+				if !answerOnly {
+					additionals = append(additionals, srvRecord(instance, s), txtRecord(instance, s))
+					additionals = append(additionals, addrRecords(s)...)
+				}
+			case q.Type == dnsmessage.TypeSRV && name == strings.ToLower(instance):
+				answers = append(answers, srvRecord(instance, s))
+				if !answerOnly {
+					additionals = append(additionals, addrRecords(s)...)
+				}
+			case q.Type == dnsmessage.TypeTXT && name == strings.ToLower(instance):
+				answers = append(answers, txtRecord(instance, s))
+			case q.Type == dnsmessage.TypeA && name == strings.ToLower(s.Host):
+				answers = append(answers, addrRecords(s)...)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return nil, false
+	}
+
+	reply := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	if legacy {
+		// Legacy unicast replies echo the query ID and questions.
+		reply.Header.ID = query.Header.ID
+		reply.Questions = query.Questions
+	}
+	msg, err := reply.Pack()
+	return msg, err == nil
+}
+
+func recordHeader(name string, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+}
+
+func ptrRecord(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: recordHeader(name, dnsmessage.TypePTR),
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)},
+	}
+}
+
+func srvRecord(instance string, s Service) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: recordHeader(instance, dnsmessage.TypeSRV),
+		Body:   &dnsmessage.SRVResource{Target: dnsmessage.MustNewName(s.Host), Port: uint16(s.Port)},
+	}
+}
+
+func txtRecord(instance string, s Service) dnsmessage.Resource {
+	var entries []string
+	for k, v := range s.TXT {
+		entries = append(entries, k+"="+v)
+	}
+	if len(entries) == 0 {
+		entries = []string{""} // a TXT record holds at least one string
+	}
+	return dnsmessage.Resource{
+		Header: recordHeader(instance, dnsmessage.TypeTXT),
+		Body:   &dnsmessage.TXTResource{TXT: entries},
+	}
+}
+
+func addrRecords(s Service) []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	for _, ip := range s.Addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			records = append(records, dnsmessage.Resource{
+				Header: recordHeader(s.Host, dnsmessage.TypeA),
+				Body:   &dnsmessage.AResource{A: [4]byte(ip4)},
+			})
+		}
+	}
+	return records
+}
@@ -0,0 +1,157 @@
+package wifi
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var testService = Service{
+	Instance: "kitchen-plug",
+	Type:     "_esphomelib._tcp",
+	Host:     "kitchen-plug.local.",
+	Addrs:    []net.IP{net.IPv4(192, 168, 1, 40).To4()},
+	Port:     6053,
+	TXT:      map[string]string{"version": "2024.6.0", "mac": "aabbccddeeff"},
+}
+
+const testInstance = "kitchen-plug._esphomelib._tcp.local."
+
+func response(from net.IP, answers []dnsmessage.Resource, additionals ...dnsmessage.Resource) packet {
+	return packet{
+		msg:  dnsmessage.Message{Header: dnsmessage.Header{Response: true}, Answers: answers, Additionals: additionals},
+		from: from,
+	}
+}
+
+func questionTypes(questions []dnsmessage.Question) map[dnsmessage.Type]string {
+	types := map[dnsmessage.Type]string{}
+	for _, q := range questions {
+		types[q.Type] = q.Name.String()
+	}
+	return types
+}
+
+func TestResolverFullAnswer(t *testing.T) {
+	r := newResolver([]string{"_esphomelib._tcp"})
+	ptr := ptrRecord("_ESPHomeLib._tcp.local.", testInstance) // names are case-insensitive
+	additionals := append([]dnsmessage.Resource{srvRecord(testInstance, testService), txtRecord(testInstance, testService)}, addrRecords(testService)...)
+
+	if !r.add(response(nil, []dnsmessage.Resource{ptr}, additionals...)) {
+		t.Fatal("response was not relevant")
+	}
+	services := r.changed()
+	if len(services) != 1 || !reflect.DeepEqual(services[0], testService) {
+		t.Fatalf("changed() = %+v, want %+v", services, testService)
+	}
+	if q := r.missingQuestions(); len(q) != 0 {
+		t.Errorf("missingQuestions() = %v for a resolved instance", q)
+	}
+
+	r.add(response(nil, []dnsmessage.Resource{ptr}, additionals...))
+	if services := r.changed(); len(services) != 0 {
+		t.Errorf("unchanged records reported again: %+v", services)
+	}
+
+	updated := testService
+	updated.TXT = map[string]string{"version": "2024.7.0"}
+	r.add(response(nil, []dnsmessage.Resource{txtRecord(testInstance, updated)}))
+	if services := r.changed(); len(services) != 1 || services[0].TXT["version"] != "2024.7.0" {
+		t.Errorf("TXT change reported as %+v", services)
+	}
+}
+
+func TestResolverAsksForMissingRecords(t *testing.T) {
+	r := newResolver([]string{"_esphomelib._tcp"})
+	r.add(response(nil, []dnsmessage.Resource{ptrRecord("_esphomelib._tcp.local.", testInstance)}))
+	if services := r.changed(); len(services) != 0 {
+		t.Fatalf("instance without SRV reported: %+v", services)
+	}
+
+	asked := questionTypes(r.missingQuestions())
+	if asked[dnsmessage.TypeSRV] != testInstance || asked[dnsmessage.TypeTXT] != testInstance {
+		t.Errorf("missingQuestions() asked %v, want SRV and TXT for %s", asked, testInstance)
+	}
+	if q := r.missingQuestions(); len(q) != 0 {
+		t.Errorf("asked again within a second: %v", q)
+	}
+
+	// An SRV answer without addresses resolves to the sender.
+	sender := net.IPv4(192, 168, 1, 41).To4()
+	r.add(response(sender, []dnsmessage.Resource{srvRecord(testInstance, testService)}))
+	services := r.changed()
+	if len(services) != 1 || !reflect.DeepEqual(services[0].Addrs, []net.IP{sender}) || services[0].Port != 6053 {
+		t.Fatalf("changed() = %+v, want the sender's address", services)
+	}
+
+	r.instances[testInstance].queried = time.Time{}
+	asked = questionTypes(r.missingQuestions())
+	if asked[dnsmessage.TypeA] != "kitchen-plug.local." {
+		t.Errorf("missingQuestions() asked %v, want A for the host", asked)
+	}
+}
+
+func TestResolverIgnoresGoodbyes(t *testing.T) {
+	r := newResolver([]string{"_esphomelib._tcp"})
+	goodbye := ptrRecord("_esphomelib._tcp.local.", testInstance)
+	goodbye.Header.TTL = 0
+	if r.add(response(nil, []dnsmessage.Resource{goodbye})) {
+		t.Error("goodbye packet was relevant")
+	}
+	if r.add(response(nil, []dnsmessage.Resource{ptrRecord("_hue._tcp.local.", "bridge._hue._tcp.local.")})) {
+		t.Error("unrequested service type was relevant")
+	}
+	if len(r.instances) != 0 {
+		t.Errorf("instances = %v", r.instances)
+	}
+}
+
+func TestParseTXT(t *testing.T) {
+	got := parseTXT([]string{"Version=1.0", "version=2.0", "flag", "=orphan", "path=/a=b"})
+	want := map[string]string{"version": "1.0", "flag": "", "path": "/a=b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTXT() = %v, want %v", got, want)
+	}
+}
+
+// TestBrowse resolves a service from a FakeResponder over loopback
+// multicast, including one that only answers what it is asked.
+func TestBrowse(t *testing.T) {
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 15353}
+	for _, answerOnly := range []bool{false, true} {
+		responder, err := NewFakeResponder(nil, group, testService)
+		if err != nil {
+			t.Skipf("multicast unavailable: %v", err)
+		}
+		responder.SetAnswerOnly(answerOnly)
+		browser, err := NewBrowser(nil, group)
+		if err != nil {
+			responder.Close()
+			t.Skipf("multicast unavailable: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		found := make(chan Service, 4)
+		go browser.Browse(ctx, []string{"_esphomelib._tcp"}, func(s Service) { found <- s })
+		select {
+		case s := <-found:
+			if s.Instance != "kitchen-plug" || s.Port != 6053 || s.TXT["version"] != "2024.6.0" {
+				t.Errorf("answerOnly=%v: found %+v", answerOnly, s)
+			}
+		case <-ctx.Done():
+			if responder.Queries() == 0 {
+				t.Logf("answerOnly=%v: queries never reached the responder", answerOnly)
+				cancel()
+				responder.Close()
+				t.Skip("multicast loopback unavailable")
+			}
+			t.Errorf("answerOnly=%v: service not resolved after %d queries", answerOnly, responder.Queries())
+		}
+		cancel()
+		responder.Close()
+	}
+}
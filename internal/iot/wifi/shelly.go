@@ -0,0 +1,365 @@
+package wifi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+const shellyCallTimeout = 10 * time.Second
+
+// ShellyDeviceConfig is an entry of SHELLY_DEVICES_FILE. Without an id the
+// device's own id (e.g. "shellyplus1pm-a8032ab12345") is used.
+type ShellyDeviceConfig struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Room string `json:"room"`
+	Host string `json:"host"`
+}
+
+// shellyDeviceInfo is the result of Shelly.GetDeviceInfo.
+type shellyDeviceInfo struct {
+	ID    string  `json:"id"`
+	MAC   string  `json:"mac"`
+	Model string  `json:"model"`
+	Gen   int     `json:"gen"`
+	App   string  `json:"app"`
+	Ver   string  `json:"ver"`
+	Name  *string `json:"name"`
+}
+
+type shellyDevice struct {
+	cfg ShellyDeviceConfig
+	id  string // set once the device answered GetDeviceInfo
+
+	layout shellyLayout
+	state  store.State
+	conn   *rpcConn
+}
+
+// ShellyDriver talks to Shelly Gen2/Gen3 devices over their local JSON-RPC
+// API. Each device gets a websocket to /rpc that delivers NotifyStatus
+// events; commands use the websocket and fall back to HTTP while it is down.
+type ShellyDriver struct {
+	mu      sync.Mutex
+	devices []*shellyDevice
+	byID    map[string]*shellyDevice
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var shelly *ShellyDriver
+
+func init() {
+	iot.Register("shelly", func() iot.Driver {
+		shelly = NewShellyDriver()
+		return shelly
+	})
+}
+
+func NewShellyDriver() *ShellyDriver {
+	return &ShellyDriver{
+		byID: make(map[string]*shellyDevice),
+		stop: make(chan struct{}),
+	}
+}
+
+func GetShellyDriver() *ShellyDriver {
+	return shelly
+}
+
+// Start connects to every device in SHELLY_DEVICES_FILE in the background.
+func (s *ShellyDriver) Start() error {
+	devices, err := config.LoadJSONList[ShellyDeviceConfig](config.ShellyDevicesFile)
+	if err != nil {
+		log.Printf("[Shelly] Failed to load %s: %v", config.ShellyDevicesFile, err)
+	}
+	for _, cfg := range devices {
+		s.AddDevice(cfg)
+	}
+	return nil
+}
+
+// AddDevice starts keeping a connection to a device.
+func (s *ShellyDriver) AddDevice(cfg ShellyDeviceConfig) {
+	if cfg.Host == "" {
+		log.Printf("[Shelly] Skipping device %q without host", cfg.ID)
+		return
+	}
+	d := &shellyDevice{cfg: cfg, id: cfg.ID, state: store.State{}}
+	s.mu.Lock()
+	s.devices = append(s.devices, d)
+	s.mu.Unlock()
+	go s.run(d)
+}
+
+// Adopt connects to a device found by a wifi scan, unless it is already
+// configured.
+func (s *ShellyDriver) Adopt(found store.DiscoveredDevice) error {
+	if found.Host == "" {
+		return iot.Errorf(iot.InvalidValue, "no address for %s", found.ID)
+	}
+	host := found.Host
+	if found.Port != 0 && found.Port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(found.Port))
+	}
+	s.mu.Lock()
+	for _, d := range s.devices {
+		if d.cfg.Host == host || d.id == found.ID {
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	s.mu.Unlock()
+	s.AddDevice(ShellyDeviceConfig{ID: found.ID, Host: host})
+	return nil
+}
+
+// Stop ends the reconnect loops, which close the connections.
+func (s *ShellyDriver) Stop() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+func (s *ShellyDriver) Health() iot.Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.devices) == 0 {
+		return iot.Health{Status: iot.HealthDisabled, Detail: "no Shelly devices configured"}
+	}
+	var down []string
+	for _, d := range s.devices {
+		if d.conn == nil {
+			down = append(down, d.cfg.Host)
+		}
+	}
+	if len(down) > 0 {
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + strings.Join(down, ", ")}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+// run keeps a websocket to the device open, reconnecting with backoff.
+func (s *ShellyDriver) run(d *shellyDevice) {
+	backoff := time.Second
+	for {
+		conn, err := s.connect(d)
+		if err != nil {
+			log.Printf("[Shelly] Connection to %s failed: %v (retrying in %s)", d.cfg.Host, err, backoff)
+			s.setAvailability(d, store.Offline)
+			select {
+			case <-time.After(backoff):
+			case <-s.stop:
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+		select {
+		case <-conn.Done():
+			log.Printf("[Shelly] Connection to %s lost", d.cfg.Host)
+			s.mu.Lock()
+			d.conn = nil
+			s.mu.Unlock()
+			s.setAvailability(d, store.Offline)
+		case <-s.stop:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// connect opens the websocket, reads the device's info and full status and
+// registers it.
+func (s *ShellyDriver) connect(d *shellyDevice) (*rpcConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shellyCallTimeout)
+	defer cancel()
+
+	conn, err := dialRPC(ctx, d.cfg.Host, func(method string, params json.RawMessage) {
+		s.handleNotify(d, method, params)
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := conn.Call(ctx, "Shelly.GetDeviceInfo", nil)
+	var info shellyDeviceInfo
+	if err == nil {
+		err = json.Unmarshal(raw, &info)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Shelly.GetDeviceInfo: %w", err)
+	}
+	raw, err = conn.Call(ctx, "Shelly.GetStatus", nil)
+	var status map[string]map[string]interface{}
+	if err == nil {
+		err = json.Unmarshal(raw, &status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Shelly.GetStatus: %w", err)
+	}
+
+	s.mu.Lock()
+	if d.id == "" {
+		d.id = info.ID
+	}
+	d.layout = newShellyLayout(status)
+	d.conn = conn
+	s.byID[d.id] = d
+	s.mu.Unlock()
+
+	log.Printf("[Shelly] Connected to %s (%s %s)", d.cfg.Host, info.App, info.ID)
+	s.register(d, info)
+	s.apply(d, status)
+	return conn, nil
+}
+
+// register adds the device to the store, or refreshes the stored one.
+func (s *ShellyDriver) register(d *shellyDevice, info shellyDeviceInfo) {
+	s.mu.Lock()
+	id, layout := d.id, d.layout
+	s.mu.Unlock()
+
+	name := d.cfg.Name
+	if name == "" && info.Name != nil {
+		name = *info.Name
+	}
+	if name == "" {
+		name = strings.TrimSpace("Shelly " + info.App)
+	}
+	added, err := iot.RegisterDevice(store.Device{
+		ID:           id,
+		Name:         name,
+		Type:         layout.deviceType(),
+		Protocol:     "shelly",
+		Room:         d.cfg.Room,
+		Capabilities: layout.capabilities(),
+	})
+	if err != nil {
+		log.Printf("[Shelly] Failed to register device %s: %v", id, err)
+	} else if added {
+		log.Printf("[Shelly] Registered %s (%s)", name, id)
+	}
+}
+
+// handleNotify applies NotifyStatus and NotifyFullStatus frames.
+func (s *ShellyDriver) handleNotify(d *shellyDevice, method string, params json.RawMessage) {
+	if method != "NotifyStatus" && method != "NotifyFullStatus" {
+		return
+	}
+	var status map[string]json.RawMessage
+	if err := json.Unmarshal(params, &status); err != nil {
+		return
+	}
+	components := make(map[string]map[string]interface{})
+	for name, raw := range status {
+		var fields map[string]interface{}
+		if json.Unmarshal(raw, &fields) == nil {
+			components[name] = fields // skips "ts"
+		}
+	}
+	s.apply(d, components)
+}
+
+func (s *ShellyDriver) apply(d *shellyDevice, status map[string]map[string]interface{}) {
+	s.mu.Lock()
+	id := d.id
+	updates, rssi := d.layout.state(status)
+	for k, v := range updates {
+		d.state[k] = v
+	}
+	s.mu.Unlock()
+	if id == "" {
+		return
+	}
+
+	ds := factory.GetDeviceStore()
+	if len(updates) > 0 {
+		if err := ds.UpdateState(id, updates); err != nil {
+			log.Printf("[Shelly] Failed to update state for %s: %v", id, err)
+		}
+	}
+	now := time.Now()
+	ds.UpdateStatus(id, store.DeviceStatus{Availability: store.Online, LastSeen: &now, LinkQuality: rssi})
+}
+
+func (s *ShellyDriver) setAvailability(d *shellyDevice, availability string) {
+	s.mu.Lock()
+	id := d.id
+	s.mu.Unlock()
+	if id != "" {
+		factory.GetDeviceStore().UpdateStatus(id, store.DeviceStatus{Availability: availability})
+	}
+}
+
+func (s *ShellyDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.byID[device.ID]
+	if !ok {
+		return nil, iot.Errorf(iot.Unsupported, "unknown Shelly device %s", device.ID)
+	}
+	state := make(store.State, len(d.state))
+	for k, v := range d.state {
+		state[k] = v
+	}
+	return state, nil
+}
+
+// SetState makes one RPC call per update. The resulting state arrives as
+// NotifyStatus events.
+func (s *ShellyDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	s.mu.Lock()
+	d, ok := s.byID[device.ID]
+	var layout shellyLayout
+	var conn *rpcConn
+	if ok {
+		layout, conn = d.layout, d.conn
+	}
+	s.mu.Unlock()
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "unknown Shelly device %s", device.ID)
+	}
+
+	type call struct {
+		method string
+		params map[string]interface{}
+	}
+	var calls []call
+	for _, key := range iot.SortedKeys(updates) {
+		method, params, err := layout.command(key, updates[key])
+		if err != nil {
+			return err
+		}
+		calls = append(calls, call{method, params})
+	}
+
+	for _, c := range calls {
+		var err error
+		if conn != nil {
+			_, err = conn.Call(ctx, c.method, c.params)
+		} else {
+			_, err = httpRPC(ctx, d.cfg.Host, c.method, c.params)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
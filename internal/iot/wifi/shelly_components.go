@@ -0,0 +1,330 @@
+package wifi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// shellyComponent is a component instance from Shelly.GetStatus, e.g.
+// "switch:1" is {kind: "switch", id: 1}.
+type shellyComponent struct {
+	kind string
+	id   int
+}
+
+func parseComponent(name string) (shellyComponent, bool) {
+	kind, idText, ok := strings.Cut(name, ":")
+	if !ok {
+		return shellyComponent{}, false
+	}
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return shellyComponent{}, false
+	}
+	return shellyComponent{kind: kind, id: id}, true
+}
+
+// shellyLayout is what a device consists of, read once from its full status.
+// State keys follow the repo's multi-endpoint convention: "state" for a
+// single switch, "state_1", "state_2"... (Shelly id + 1) when there are
+// several of a kind.
+type shellyLayout struct {
+	list     []shellyComponent          // mapped components in capability order
+	counts   map[string]int             // component kind -> instances
+	metering map[shellyComponent]bool   // components reporting apower
+	position map[shellyComponent]bool   // covers with position control
+	bindings map[string]shellyComponent // writable state key -> component
+}
+
+// controlled lists the component kinds the driver maps, in capability order.
+var controlled = []string{"switch", "light", "cover", "temperature", "humidity", "devicepower"}
+
+func newShellyLayout(status map[string]map[string]interface{}) shellyLayout {
+	l := shellyLayout{
+		counts:   make(map[string]int),
+		metering: make(map[shellyComponent]bool),
+		position: make(map[shellyComponent]bool),
+		bindings: make(map[string]shellyComponent),
+	}
+	for name, fields := range status {
+		c, ok := parseComponent(name)
+		if !ok || kindOrder(c.kind) < 0 {
+			continue
+		}
+		l.list = append(l.list, c)
+		l.counts[c.kind]++
+		if _, ok := fields["apower"]; ok {
+			l.metering[c] = true
+		}
+		if _, ok := fields["current_pos"]; ok && c.kind == "cover" {
+			l.position[c] = true
+		}
+	}
+	sort.Slice(l.list, func(i, j int) bool {
+		a, b := l.list[i], l.list[j]
+		if a.kind != b.kind {
+			return kindOrder(a.kind) < kindOrder(b.kind)
+		}
+		return a.id < b.id
+	})
+	for _, c := range l.list {
+		switch c.kind {
+		case "switch":
+			l.bindings[l.key("state", c)] = c
+		case "light":
+			l.bindings[l.key("state", c)] = c
+			l.bindings[l.key("brightness", c)] = c
+		case "cover":
+			l.bindings[l.key("command", c)] = c
+			if l.position[c] {
+				l.bindings[l.key("position", c)] = c
+			}
+		}
+	}
+	return l
+}
+
+func kindOrder(kind string) int {
+	for i, k := range controlled {
+		if k == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l shellyLayout) key(base string, c shellyComponent) string {
+	if l.counts[c.kind] <= 1 {
+		return base
+	}
+	return base + "_" + strconv.Itoa(c.id+1)
+}
+
+// state turns component statuses into state values. Only fields present are
+// read, so it serves both full statuses and NotifyStatus deltas.
+func (l shellyLayout) state(status map[string]map[string]interface{}) (store.State, *int) {
+	updates := store.State{}
+	var rssi *int
+	for name, fields := range status {
+		if name == "wifi" {
+			if n, ok := iot.Number(fields["rssi"]); ok {
+				q := int(n)
+				rssi = &q
+			}
+			continue
+		}
+		c, ok := parseComponent(name)
+		if !ok {
+			continue
+		}
+		set := func(base string, v interface{}) { updates[l.key(base, c)] = v }
+
+		switch c.kind {
+		case "switch", "light":
+			if on, ok := fields["output"].(bool); ok {
+				set("state", onOff(on))
+			}
+			if n, ok := iot.Number(fields["brightness"]); ok && c.kind == "light" {
+				set("brightness", int(n))
+			}
+		case "cover":
+			if s, ok := fields["state"].(string); ok {
+				set("state", s)
+			}
+			if n, ok := iot.Number(fields["current_pos"]); ok {
+				set("position", int(n))
+			}
+		case "temperature":
+			if n, ok := iot.Number(fields["tC"]); ok {
+				set("temperature", n)
+			}
+		case "humidity":
+			if n, ok := iot.Number(fields["rh"]); ok {
+				set("humidity", n)
+			}
+		case "devicepower":
+			if battery, ok := fields["battery"].(map[string]interface{}); ok {
+				if n, ok := iot.Number(battery["percent"]); ok {
+					set("battery", int(n))
+				}
+			}
+		}
+
+		if c.kind == "switch" || c.kind == "light" || c.kind == "cover" {
+			for field, base := range map[string]string{"apower": "power_usage", "voltage": "voltage", "current": "current"} {
+				if n, ok := iot.Number(fields[field]); ok {
+					set(base, n)
+				}
+			}
+			if energy, ok := fields["aenergy"].(map[string]interface{}); ok {
+				if n, ok := iot.Number(energy["total"]); ok {
+					set("energy", n/1000) // Wh -> kWh
+				}
+			}
+		}
+	}
+	return updates, rssi
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// capabilities describes the mapped components.
+func (l shellyLayout) capabilities() []store.Capability {
+	var caps []store.Capability
+	for _, c := range l.list {
+		label := strings.ToUpper(c.kind[:1]) + c.kind[1:]
+		if l.counts[c.kind] > 1 {
+			label = fmt.Sprintf("%s %d", label, c.id+1)
+		}
+
+		switch c.kind {
+		case "switch", "light":
+			key := l.key("state", c)
+			caps = append(caps, store.Capability{
+				Name:        strings.Replace(key, "state", "power", 1),
+				Description: label + ": turn on or off",
+				Operations:  []string{"on", "off"},
+				Parameters: map[string]interface{}{
+					key: map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+				},
+				Writable: true,
+			})
+			if c.kind == "light" {
+				key := l.key("brightness", c)
+				caps = append(caps, store.Capability{
+					Name:        key,
+					Description: label + ": adjust brightness (0-100)",
+					Operations:  []string{"set"},
+					Parameters: map[string]interface{}{
+						key: map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+					},
+					Writable: true,
+				})
+			}
+		case "cover":
+			key := l.key("command", c)
+			options := []string{"open", "close", "stop"}
+			caps = append(caps, store.Capability{
+				Name:        l.key("cover", c),
+				Description: label + ": open, close or stop",
+				Operations:  options,
+				Parameters: map[string]interface{}{
+					key: map[string]interface{}{"type": "string", "operations": options},
+				},
+				Writable: true,
+			})
+			if l.position[c] {
+				key := l.key("position", c)
+				caps = append(caps, store.Capability{
+					Name:        key,
+					Description: label + ": move to a position (0-100)",
+					Operations:  []string{"set"},
+					Parameters: map[string]interface{}{
+						key: map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+					},
+					Writable: true,
+				})
+			}
+		case "temperature":
+			caps = append(caps, sensorCapability(l.key("temperature", c), label, "°C"))
+		case "humidity":
+			caps = append(caps, sensorCapability(l.key("humidity", c), label, "%"))
+		case "devicepower":
+			caps = append(caps, sensorCapability(l.key("battery", c), "Battery", "%"))
+		}
+
+		if l.metering[c] {
+			for _, m := range []struct{ base, unit string }{
+				{"power_usage", "W"}, {"energy", "kWh"}, {"voltage", "V"}, {"current", "A"},
+			} {
+				caps = append(caps, sensorCapability(l.key(m.base, c), label, m.unit))
+			}
+		}
+	}
+	return caps
+}
+
+func sensorCapability(key, label, unit string) store.Capability {
+	return store.Capability{
+		Name:        key,
+		Description: label + ": " + strings.ReplaceAll(key, "_", " "),
+		Parameters: map[string]interface{}{
+			key: map[string]interface{}{"type": "number", "unit": unit},
+		},
+	}
+}
+
+// command maps one state update to an RPC call.
+func (l shellyLayout) command(key string, value interface{}) (string, map[string]interface{}, error) {
+	c, ok := l.bindings[key]
+	if !ok {
+		return "", nil, iot.UnsupportedKey(key)
+	}
+	params := map[string]interface{}{"id": c.id}
+	method := strings.ToUpper(c.kind[:1]) + c.kind[1:]
+
+	switch base := strings.TrimRight(strings.TrimRight(key, "0123456789"), "_"); base {
+	case "state":
+		switch p, err := powerPayload(value); {
+		case err != nil:
+			return "", nil, err
+		case p == "TOGGLE":
+			return method + ".Toggle", params, nil
+		default:
+			params["on"] = p == "ON"
+		}
+		return method + ".Set", params, nil
+	case "brightness":
+		n, ok := iot.Number(value)
+		if !ok || n < 0 || n > 100 {
+			return "", nil, iot.Errorf(iot.InvalidValue, "invalid brightness %v, expected 0-100", value)
+		}
+		params["brightness"] = int(n)
+		return "Light.Set", params, nil
+	case "command":
+		switch strings.ToLower(store.FormatValue(value)) {
+		case "open":
+			return "Cover.Open", params, nil
+		case "close":
+			return "Cover.Close", params, nil
+		case "stop":
+			return "Cover.Stop", params, nil
+		}
+		return "", nil, iot.Errorf(iot.InvalidValue, "invalid cover command %v, expected open/close/stop", value)
+	case "position":
+		n, ok := iot.Number(value)
+		if !ok || n < 0 || n > 100 {
+			return "", nil, iot.Errorf(iot.InvalidValue, "invalid position %v, expected 0-100", value)
+		}
+		params["pos"] = int(n)
+		return "Cover.GoToPosition", params, nil
+	}
+	return "", nil, iot.UnsupportedKey(key)
+}
+
+// deviceType picks a type from the main component.
+func (l shellyLayout) deviceType() string {
+	switch {
+	case l.counts["cover"] > 0:
+		return "cover"
+	case l.counts["light"] > 0:
+		return "dimmer"
+	case l.counts["switch"] > 0 && len(l.metering) > 0:
+		return "smart_plug"
+	case l.counts["switch"] > 0:
+		return "switch"
+	case l.counts["temperature"] > 0 || l.counts["humidity"] > 0:
+		return "sensor"
+	}
+	return "switch"
+}
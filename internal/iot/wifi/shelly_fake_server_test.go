@@ -0,0 +1,191 @@
+package wifi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// FakeShelly is a scripted Shelly Gen2 device for developing and testing the
+// driver without hardware. It serves /rpc over HTTP and websocket, answers
+// Shelly.GetDeviceInfo, Shelly.GetStatus and the Switch, Light and Cover
+// setters, and reports every change to websocket peers as NotifyStatus the
+// way a real device does.
+type FakeShelly struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	info   map[string]interface{}
+	status map[string]map[string]interface{}
+	conns  map[*websocket.Conn]*sync.Mutex
+	// Calls records the method of every request received.
+	Calls []string
+}
+
+// NewFakeShelly starts a device with the given id and component statuses,
+// e.g. {"switch:0": {"id": 0, "output": false, "apower": 0}}.
+func NewFakeShelly(id, app string, status map[string]map[string]interface{}) *FakeShelly {
+	f := &FakeShelly{
+		info: map[string]interface{}{
+			"id": id, "mac": strings.ToUpper(id[strings.LastIndex(id, "-")+1:]),
+			"model": "FAKE-" + strings.ToUpper(app), "gen": 2, "app": app, "ver": "1.0.0", "name": nil,
+		},
+		status: status,
+		conns:  make(map[*websocket.Conn]*sync.Mutex),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Host returns the host:port to configure the driver with.
+func (f *FakeShelly) Host() string {
+	return strings.TrimPrefix(f.Server.URL, "http://")
+}
+
+// EmitStatus merges fields into a component and pushes them as NotifyStatus,
+// like a device reporting a local change.
+func (f *FakeShelly) EmitStatus(component string, fields map[string]interface{}) {
+	f.mu.Lock()
+	if f.status[component] == nil {
+		f.status[component] = map[string]interface{}{}
+	}
+	for k, v := range fields {
+		f.status[component][k] = v
+	}
+	f.mu.Unlock()
+
+	f.broadcast(map[string]interface{}{
+		"src": f.info["id"], "dst": rpcSource, "method": "NotifyStatus",
+		"params": map[string]interface{}{"ts": float64(time.Now().Unix()), component: fields},
+	})
+}
+
+var shellyUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func (f *FakeShelly) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/rpc" {
+		http.NotFound(w, r)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		var req rpcFrame
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(f.handle(req))
+		return
+	}
+
+	conn, err := shellyUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMu := &sync.Mutex{}
+	f.mu.Lock()
+	f.conns[conn] = writeMu
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var req rpcFrame
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		res := f.handle(req)
+		writeMu.Lock()
+		conn.WriteJSON(res)
+		writeMu.Unlock()
+	}
+}
+
+// handle answers one request and notifies peers of the change it made.
+func (f *FakeShelly) handle(req rpcFrame) map[string]interface{} {
+	var params map[string]interface{}
+	json.Unmarshal(req.Params, &params)
+	id, _ := params["id"].(float64)
+	component := ""
+	if kind, _, ok := strings.Cut(req.Method, "."); ok {
+		component = strings.ToLower(kind) + ":" + strconv.Itoa(int(id))
+	}
+
+	f.mu.Lock()
+	f.Calls = append(f.Calls, req.Method)
+	fields, exists := f.status[component]
+	res := map[string]interface{}{"id": req.ID, "src": f.info["id"], "dst": req.Src}
+	var changed map[string]interface{}
+
+	switch req.Method {
+	case "Shelly.GetDeviceInfo":
+		res["result"] = f.info
+	case "Shelly.GetStatus":
+		snapshot, _ := json.Marshal(f.status) // EmitStatus may change it once unlocked
+		res["result"] = json.RawMessage(snapshot)
+	case "Switch.Set", "Light.Set", "Switch.Toggle", "Light.Toggle",
+		"Cover.Open", "Cover.Close", "Cover.Stop", "Cover.GoToPosition":
+		if !exists {
+			res["error"] = rpcError{Code: rpcNotFound, Message: "No such component"}
+			break
+		}
+		changed = map[string]interface{}{"id": id}
+		switch req.Method {
+		case "Switch.Set", "Light.Set":
+			res["result"] = map[string]interface{}{"was_on": fields["output"]}
+			if on, ok := params["on"].(bool); ok {
+				changed["output"] = on
+			}
+			if b, ok := params["brightness"].(float64); ok {
+				if b < 0 || b > 100 {
+					res = map[string]interface{}{"id": req.ID, "error": rpcError{Code: rpcInvalidArgument, Message: "brightness out of range"}}
+					changed = nil
+					break
+				}
+				changed["brightness"] = b
+			}
+		case "Switch.Toggle", "Light.Toggle":
+			on, _ := fields["output"].(bool)
+			res["result"] = map[string]interface{}{"was_on": on}
+			changed["output"] = !on
+		case "Cover.Open":
+			changed["state"], changed["current_pos"] = "open", float64(100)
+		case "Cover.Close":
+			changed["state"], changed["current_pos"] = "closed", float64(0)
+		case "Cover.Stop":
+			changed["state"] = "stopped"
+		case "Cover.GoToPosition":
+			changed["state"], changed["current_pos"] = "stopped", params["pos"]
+		}
+		if _, ok := res["result"]; !ok && changed != nil {
+			res["result"] = nil
+		}
+	default:
+		res["error"] = rpcError{Code: -32601, Message: "Method " + req.Method + " not found"}
+	}
+	f.mu.Unlock()
+
+	if changed != nil {
+		go f.EmitStatus(component, changed)
+	}
+	return res
+}
+
+func (f *FakeShelly) broadcast(v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn, writeMu := range f.conns {
+		writeMu.Lock()
+		conn.WriteJSON(v)
+		writeMu.Unlock()
+	}
+}
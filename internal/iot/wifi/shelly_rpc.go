@@ -0,0 +1,208 @@
+package wifi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/iot"
+
+	"github.com/gorilla/websocket"
+)
+
+// rpcSource identifies the bridge in RPC frames. Shelly only sends
+// notifications to websocket peers that have sent a frame with a src.
+const rpcSource = "iot-bridge"
+
+// rpcFrame is a Shelly Gen2 JSON-RPC request, response or notification.
+type rpcFrame struct {
+	ID     int             `json:"id,omitempty"`
+	Src    string          `json:"src,omitempty"`
+	Dst    string          `json:"dst,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// Shelly error codes that mean the request itself was wrong.
+const (
+	rpcInvalidArgument = -103
+	rpcNotFound        = -105
+)
+
+// rpcErr maps an RPC error to an iot error kind.
+func rpcErr(method string, e *rpcError) error {
+	switch e.Code {
+	case rpcInvalidArgument:
+		return iot.Errorf(iot.InvalidValue, "%s: %s", method, e.Message)
+	case rpcNotFound, -32601: // component or method not found
+		return iot.Errorf(iot.Unsupported, "%s: %s", method, e.Message)
+	}
+	return fmt.Errorf("%s: %w", method, e)
+}
+
+// rpcConn is a websocket connection to a device's /rpc endpoint: calls are
+// correlated to responses by id, notifications go to onNotify.
+type rpcConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan rpcFrame
+	closed  bool
+	done    chan struct{}
+
+	onNotify func(method string, params json.RawMessage)
+}
+
+func dialRPC(ctx context.Context, host string, onNotify func(string, json.RawMessage)) (*rpcConn, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, "ws://"+host+"/rpc", nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &rpcConn{
+		conn:     conn,
+		pending:  make(map[int]chan rpcFrame),
+		done:     make(chan struct{}),
+		onNotify: onNotify,
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Call sends a request and waits for its result.
+func (c *rpcConn) Call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, iot.Errorf(iot.TransportError, "connection closed")
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan rpcFrame, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	req := map[string]interface{}{"id": id, "src": rpcSource, "method": method}
+	if params != nil {
+		req["params"] = params
+	}
+	c.writeMu.Lock()
+	err := c.conn.WriteJSON(req)
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, iot.Errorf(iot.TransportError, "%w", err)
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return nil, iot.Errorf(iot.TransportError, "connection closed")
+		}
+		if res.Error != nil {
+			return nil, rpcErr(method, res.Error)
+		}
+		return res.Result, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+func (c *rpcConn) readLoop() {
+	defer c.shutdown()
+	for {
+		var frame rpcFrame
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		if frame.Method != "" {
+			if c.onNotify != nil {
+				c.onNotify(frame.Method, frame.Params)
+			}
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[frame.ID]
+		c.mu.Unlock()
+		if ok {
+			ch <- frame
+		}
+	}
+}
+
+func (c *rpcConn) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	close(c.done)
+}
+
+// Done is closed when the connection drops.
+func (c *rpcConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *rpcConn) Close() error {
+	return c.conn.Close()
+}
+
+var rpcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// httpRPC makes a single call through POST http://<host>/rpc, for when the
+// websocket is down.
+func httpRPC(ctx context.Context, host, method string, params interface{}) (json.RawMessage, error) {
+	body, _ := json.Marshal(map[string]interface{}{"id": 1, "src": rpcSource, "method": method, "params": params})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+host+"/rpc", bytes.NewReader(body))
+	if err != nil {
+		return nil, iot.Errorf(iot.TransportError, "%w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := rpcHTTPClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+		}
+		return nil, iot.Errorf(iot.TransportError, "%w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	var frame rpcFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, iot.Errorf(iot.TransportError, "%s: %s answered %s", method, host, strings.TrimSpace(resp.Status))
+	}
+	if frame.Error != nil {
+		return nil, rpcErr(method, frame.Error)
+	}
+	return frame.Result, nil
+}
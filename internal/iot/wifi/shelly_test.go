@@ -0,0 +1,191 @@
+package wifi
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+const shellyID = "shellyplus2pm-a8032ab12345"
+
+func plus2PMStatus() map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{
+		"switch:0": {"id": float64(0), "output": false, "apower": float64(0), "aenergy": map[string]interface{}{"total": float64(1500)}},
+		"switch:1": {"id": float64(1), "output": true, "apower": float64(12.5)},
+		"wifi":     {"rssi": float64(-61)},
+		"sys":      {"uptime": float64(100)},
+	}
+}
+
+// startShelly connects a driver to a fake Plus 2PM and waits for it to be
+// registered.
+func startShelly(t *testing.T, cfg ShellyDeviceConfig) (*ShellyDriver, *FakeShelly) {
+	t.Helper()
+	testutil.UseMemoryStore()
+
+	fake := NewFakeShelly(shellyID, "Plus2PM", plus2PMStatus())
+	t.Cleanup(fake.Close)
+	s := NewShellyDriver()
+	t.Cleanup(func() { s.Stop() })
+	cfg.Host = fake.Host()
+	s.AddDevice(cfg)
+
+	testutil.WaitFor(t, "device registered", func() bool {
+		_, ok := factory.GetDeviceStore().Get(shellyID)
+		return ok
+	})
+	return s, fake
+}
+
+func TestShellyLayout(t *testing.T) {
+	l := newShellyLayout(plus2PMStatus())
+	if dt := l.deviceType(); dt != "smart_plug" {
+		t.Errorf("deviceType() = %q, want smart_plug", dt)
+	}
+
+	state, rssi := l.state(plus2PMStatus())
+	want := store.State{
+		"state_1": "off", "power_usage_1": float64(0), "energy_1": 1.5,
+		"state_2": "on", "power_usage_2": 12.5,
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("state() = %v, want %v", state, want)
+	}
+	if rssi == nil || *rssi != -61 {
+		t.Errorf("rssi = %v, want -61", rssi)
+	}
+
+	var names []string
+	for _, c := range l.capabilities() {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	wantNames := []string{
+		"current_1", "current_2", "energy_1", "energy_2", "power_1", "power_2",
+		"power_usage_1", "power_usage_2", "voltage_1", "voltage_2",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("capabilities = %v, want %v", names, wantNames)
+	}
+
+	tests := []struct {
+		key        string
+		value      interface{}
+		wantMethod string
+		wantParams map[string]interface{}
+		wantKind   iot.ErrorKind
+	}{
+		{key: "state_2", value: "on", wantMethod: "Switch.Set", wantParams: map[string]interface{}{"id": 1, "on": true}},
+		{key: "state_1", value: "toggle", wantMethod: "Switch.Toggle", wantParams: map[string]interface{}{"id": 0}},
+		{key: "state_1", value: "dim", wantKind: iot.InvalidValue},
+		{key: "brightness", value: 50, wantKind: iot.Unsupported},
+		{key: "power_usage_1", value: 3, wantKind: iot.Unsupported},
+	}
+	for _, tt := range tests {
+		method, params, err := l.command(tt.key, tt.value)
+		if tt.wantKind != "" {
+			if kind := iot.KindOf(err); kind != tt.wantKind {
+				t.Errorf("command(%s, %v) error = %v, want %s", tt.key, tt.value, err, tt.wantKind)
+			}
+			continue
+		}
+		if err != nil || method != tt.wantMethod || !reflect.DeepEqual(params, tt.wantParams) {
+			t.Errorf("command(%s, %v) = %s %v, %v", tt.key, tt.value, method, params, err)
+		}
+	}
+}
+
+func TestShellyDiscoveryRegistersDevice(t *testing.T) {
+	startShelly(t, ShellyDeviceConfig{Room: "Hall"})
+
+	device, _ := factory.GetDeviceStore().Get(shellyID)
+	if device.Name != "Shelly Plus2PM" || device.Room != "Hall" || device.Type != "smart_plug" || device.Protocol != "shelly" {
+		t.Errorf("device = %+v", device)
+	}
+	testutil.WaitFor(t, "status applied", func() bool { return testutil.StoredState(shellyID, "state_2") == "on" })
+	device, _ = factory.GetDeviceStore().Get(shellyID)
+	if device.Availability != store.Online || device.LinkQuality == nil || *device.LinkQuality != -61 {
+		t.Errorf("status = %+v", device.DeviceStatus)
+	}
+}
+
+func TestShellyKeepsUserEdits(t *testing.T) {
+	testutil.UseMemoryStore()
+	factory.GetDeviceStore().Add(store.Device{ID: shellyID, Name: "Porch lights", Room: "Outside", Protocol: "shelly"})
+
+	fake := NewFakeShelly(shellyID, "Plus2PM", plus2PMStatus())
+	defer fake.Close()
+	s := NewShellyDriver()
+	defer s.Stop()
+	s.AddDevice(ShellyDeviceConfig{Host: fake.Host(), Room: "Hall"})
+
+	testutil.WaitFor(t, "capabilities refreshed", func() bool {
+		device, _ := factory.GetDeviceStore().Get(shellyID)
+		return len(device.Capabilities) > 0
+	})
+	device, _ := factory.GetDeviceStore().Get(shellyID)
+	if device.Name != "Porch lights" || device.Room != "Outside" {
+		t.Errorf("name, room = %q, %q, want user edits kept", device.Name, device.Room)
+	}
+}
+
+func TestShellySetStateRoundTrip(t *testing.T) {
+	s, fake := startShelly(t, ShellyDeviceConfig{})
+	device, _ := factory.GetDeviceStore().Get(shellyID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.SetState(ctx, device, store.State{"state_1": "on"}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, "state_1 on", func() bool { return testutil.StoredState(shellyID, "state_1") == "on" })
+
+	fake.mu.Lock()
+	calls := append([]string(nil), fake.Calls...)
+	fake.mu.Unlock()
+	if calls[len(calls)-1] != "Switch.Set" {
+		t.Errorf("calls = %v, want Switch.Set last", calls)
+	}
+
+	if err := s.SetState(ctx, device, store.State{"brightness": 10}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("SetState(brightness) error = %v, want unsupported", err)
+	}
+	if err := s.SetState(ctx, store.Device{ID: "shelly-unknown"}, store.State{"state": "on"}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("SetState(unknown device) error = %v, want unsupported", err)
+	}
+}
+
+func TestShellyEventsUpdateStore(t *testing.T) {
+	s, fake := startShelly(t, ShellyDeviceConfig{})
+
+	fake.EmitStatus("switch:1", map[string]interface{}{"apower": float64(42.5)})
+	testutil.WaitFor(t, "power_usage_2 42.5", func() bool { return testutil.StoredState(shellyID, "power_usage_2") == 42.5 })
+
+	device, _ := factory.GetDeviceStore().Get(shellyID)
+	state, err := s.GetState(context.Background(), device)
+	if err != nil || state["power_usage_2"] != 42.5 {
+		t.Errorf("GetState() = %v, %v", state, err)
+	}
+}
+
+func TestShellyHTTPRPC(t *testing.T) {
+	fake := NewFakeShelly(shellyID, "Plus2PM", plus2PMStatus())
+	defer fake.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := httpRPC(ctx, fake.Host(), "Switch.Set", map[string]interface{}{"id": 0, "on": true}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := httpRPC(ctx, fake.Host(), "Switch.Set", map[string]interface{}{"id": 7, "on": true})
+	if err == nil {
+		t.Error("Switch.Set on a missing component succeeded")
+	}
+}
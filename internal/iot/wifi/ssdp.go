@@ -0,0 +1,265 @@
+package wifi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// SSDPGroup is the IPv4 SSDP multicast group and port.
+var SSDPGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+const (
+	// searchTarget limits answers to one per root device.
+	searchTarget = "upnp:rootdevice"
+	// searchMX is how many seconds devices may wait before answering.
+	searchMX = 2
+	// maxDescription bounds a device description document.
+	maxDescription = 1 << 20
+)
+
+// UPnPDevice is a root device that answered an M-SEARCH, with its parsed
+// device description.
+type UPnPDevice struct {
+	Location string
+	USN      string
+	Server   string
+	// Headers holds the whole search response; some vendors add their own,
+	// e.g. hue-bridgeid.
+	Headers     http.Header
+	Description DeviceDescription
+}
+
+// DeviceDescription is the UPnP device description document at Location.
+// The root element is matched by name only, as not every device declares
+// the urn:schemas-upnp-org:device-1-0 namespace.
+type DeviceDescription struct {
+	XMLName xml.Name          `xml:"root"`
+	URLBase string            `xml:"URLBase,omitempty"`
+	Device  DescriptionDevice `xml:"device"`
+}
+
+// DescriptionDevice is a device in a description, with its services and
+// embedded devices.
+type DescriptionDevice struct {
+	DeviceType   string               `xml:"deviceType"`
+	FriendlyName string               `xml:"friendlyName"`
+	Manufacturer string               `xml:"manufacturer"`
+	ModelName    string               `xml:"modelName"`
+	ModelNumber  string               `xml:"modelNumber,omitempty"`
+	SerialNumber string               `xml:"serialNumber,omitempty"`
+	UDN          string               `xml:"UDN"`
+	Services     []DescriptionService `xml:"serviceList>service"`
+	Devices      []DescriptionDevice  `xml:"deviceList>device"`
+}
+
+// DescriptionService is a service a device offers.
+type DescriptionService struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	ControlURL  string `xml:"controlURL,omitempty"`
+	SCPDURL     string `xml:"SCPDURL,omitempty"`
+	EventSubURL string `xml:"eventSubURL,omitempty"`
+}
+
+// ServiceTypes lists the service types of the device and its embedded
+// devices, without duplicates.
+func (d DescriptionDevice) ServiceTypes() []string {
+	seen := map[string]bool{}
+	var types []string
+	var walk func(DescriptionDevice)
+	walk = func(d DescriptionDevice) {
+		for _, s := range d.Services {
+			if !seen[s.ServiceType] {
+				seen[s.ServiceType] = true
+				types = append(types, s.ServiceType)
+			}
+		}
+		for _, embedded := range d.Devices {
+			walk(embedded)
+		}
+	}
+	walk(d)
+	return types
+}
+
+// Searcher sends SSDP M-SEARCH requests and fetches the description of
+// every root device that answers.
+type Searcher struct {
+	group  *net.UDPAddr
+	conn   *net.UDPConn
+	client *http.Client
+}
+
+// NewSearcher opens a socket for searching on ifi, or on the system's
+// default multicast interface if ifi is nil. group is normally SSDPGroup.
+func NewSearcher(ifi *net.Interface, group *net.UDPAddr) (*Searcher, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	pc := ipv4.NewPacketConn(conn)
+	if ifi != nil {
+		if err := pc.SetMulticastInterface(ifi); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	pc.SetMulticastTTL(2)
+	pc.SetMulticastLoopback(true)
+	return &Searcher{group: group, conn: conn, client: &http.Client{Timeout: 5 * time.Second}}, nil
+}
+
+// Search sends M-SEARCH requests until ctx ends, repeating them at growing
+// intervals, and calls found once for every root device whose description
+// could be read. It closes the searcher when it returns.
+func (s *Searcher) Search(ctx context.Context, found func(UPnPDevice)) {
+	responses := make(chan UPnPDevice, 16)
+	go func() {
+		<-ctx.Done()
+		s.conn.Close()
+	}()
+	go s.readResponses(ctx, responses)
+
+	var mu sync.Mutex
+	seen := map[string]bool{} // locations fetched or being fetched
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	interval := time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.search()
+			timer.Reset(interval)
+			if interval < maxQueryInterval {
+				interval *= 2
+			}
+		case dev := <-responses:
+			mu.Lock()
+			if seen[dev.Location] {
+				mu.Unlock()
+				continue
+			}
+			seen[dev.Location] = true
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				desc, err := s.describe(ctx, dev.Location)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("[SSDP] Failed to read description at %s: %v", dev.Location, err)
+					}
+					mu.Lock()
+					delete(seen, dev.Location) // try again on the next answer
+					mu.Unlock()
+					return
+				}
+				dev.Description = desc
+				found(dev)
+			}()
+		}
+	}
+}
+
+func (s *Searcher) search() {
+	req := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: %s\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: %d\r\n"+
+		"ST: %s\r\n"+
+		"USER-AGENT: iot-bridge UPnP/1.1\r\n\r\n", s.group, searchMX, searchTarget)
+	if _, err := s.conn.WriteToUDP([]byte(req), s.group); err != nil {
+		log.Printf("[SSDP] Failed to send M-SEARCH: %v", err)
+	}
+}
+
+// readResponses parses search responses until the connection is closed.
+func (s *Searcher) readResponses(ctx context.Context, responses chan<- UPnPDevice) {
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[SSDP] Read failed: %v", err)
+			}
+			return
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if u, err := url.Parse(location); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		select {
+		case responses <- UPnPDevice{
+			Location: location,
+			USN:      resp.Header.Get("Usn"),
+			Server:   resp.Header.Get("Server"),
+			Headers:  resp.Header,
+		}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// describe fetches and parses a device description.
+func (s *Searcher) describe(ctx context.Context, location string) (DeviceDescription, error) {
+	var desc DeviceDescription
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return desc, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return desc, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return desc, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDescription))
+	if err != nil {
+		return desc, err
+	}
+	if err := xml.Unmarshal(data, &desc); err != nil {
+		return desc, err
+	}
+	if desc.Device.UDN == "" {
+		return desc, errors.New("description has no UDN")
+	}
+	return desc, nil
+}
+
+// deviceTypeName returns the type from a device type URN, e.g. "MediaRenderer"
+// for urn:schemas-upnp-org:device:MediaRenderer:1.
+func deviceTypeName(urn string) string {
+	parts := strings.Split(urn, ":")
+	if len(parts) >= 5 && parts[2] == "device" {
+		return parts[3]
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package wifi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// FakeUPnPDevice answers SSDP M-SEARCH requests and serves a device
+// description over HTTP, standing in for TVs, speakers and other UPnP
+// devices when developing or testing the wifi scan.
+type FakeUPnPDevice struct {
+	*httptest.Server
+
+	conn        *net.UDPConn
+	description DescriptionDevice
+	headers     map[string]string
+
+	mu       sync.Mutex
+	searches int
+}
+
+// NewFakeUPnPDevice joins group on ifi (nil for the default interface) and
+// answers searches for the root device, its UDN or its device type. headers
+// are added to every search response.
+func NewFakeUPnPDevice(ifi *net.Interface, group *net.UDPAddr, device DescriptionDevice, headers map[string]string) (*FakeUPnPDevice, error) {
+	conn, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeUPnPDevice{conn: conn, description: device, headers: headers}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveDescription))
+	go f.serve()
+	return f, nil
+}
+
+// Location returns the URL of the device description.
+func (f *FakeUPnPDevice) Location() string {
+	return f.Server.URL + "/description.xml"
+}
+
+// Searches returns how many matching M-SEARCH requests were answered.
+func (f *FakeUPnPDevice) Searches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.searches
+}
+
+// Close stops answering and shuts the HTTP server down.
+func (f *FakeUPnPDevice) Close() {
+	f.conn.Close()
+	f.Server.Close()
+}
+
+func (f *FakeUPnPDevice) serveDescription(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/description.xml" {
+		http.NotFound(w, r)
+		return
+	}
+	doc := struct {
+		XMLName xml.Name          `xml:"urn:schemas-upnp-org:device-1-0 root"`
+		Major   int               `xml:"specVersion>major"`
+		Minor   int               `xml:"specVersion>minor"`
+		Device  DescriptionDevice `xml:"device"`
+	}{Major: 1, Device: f.description}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (f *FakeUPnPDevice) serve() {
+	buf := make([]byte, 8192)
+	for {
+		n, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("Man") != `"ssdp:discover"` {
+			continue
+		}
+		st := req.Header.Get("St")
+		switch st {
+		case "ssdp:all", searchTarget, f.description.UDN, f.description.DeviceType:
+		default:
+			continue
+		}
+		if st == "ssdp:all" {
+			st = searchTarget
+		}
+		f.mu.Lock()
+		f.searches++
+		f.mu.Unlock()
+
+		var resp strings.Builder
+		resp.WriteString("HTTP/1.1 200 OK\r\n")
+		resp.WriteString("CACHE-CONTROL: max-age=1800\r\n")
+		resp.WriteString("EXT:\r\n")
+		fmt.Fprintf(&resp, "LOCATION: %s\r\n", f.Location())
+		resp.WriteString("SERVER: Linux/5.10 UPnP/1.0 FakeUPnP/1.0\r\n")
+		fmt.Fprintf(&resp, "ST: %s\r\n", st)
+		fmt.Fprintf(&resp, "USN: %s::%s\r\n", f.description.UDN, st)
+		for k, v := range f.headers {
+			fmt.Fprintf(&resp, "%s: %s\r\n", k, v)
+		}
+		resp.WriteString("\r\n")
+		f.conn.WriteToUDP([]byte(resp.String()), from)
+	}
+}
@@ -0,0 +1,119 @@
+package wifi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testRenderer = DescriptionDevice{
+	DeviceType:   "urn:schemas-upnp-org:device:MediaRenderer:1",
+	FriendlyName: "Living Room TV",
+	Manufacturer: "Acme",
+	ModelName:    "Vision 55",
+	UDN:          "uuid:4D454930-0000-1000-8001-A81374A3F1C2",
+	Services: []DescriptionService{
+		{ServiceType: "urn:schemas-upnp-org:service:RenderingControl:1", ServiceID: "urn:upnp-org:serviceId:RenderingControl"},
+	},
+	Devices: []DescriptionDevice{{
+		DeviceType: "urn:schemas-upnp-org:device:MediaServer:1",
+		Services: []DescriptionService{
+			{ServiceType: "urn:schemas-upnp-org:service:ContentDirectory:1"},
+			{ServiceType: "urn:schemas-upnp-org:service:RenderingControl:1"},
+		},
+	}},
+}
+
+func TestDeviceTypeName(t *testing.T) {
+	tests := []struct {
+		urn  string
+		want string
+	}{
+		{"urn:schemas-upnp-org:device:MediaRenderer:1", "MediaRenderer"},
+		{"urn:schemas-upnp-org:device:Basic:1", "Basic"},
+		{"urn:dial-multiscreen-org:device:dial:1", "dial"},
+		{"urn:schemas-upnp-org:service:RenderingControl:1", ""},
+		{"urn:schemas-upnp-org:device", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := deviceTypeName(tt.urn); got != tt.want {
+			t.Errorf("deviceTypeName(%q) = %q, want %q", tt.urn, got, tt.want)
+		}
+	}
+}
+
+func TestServiceTypes(t *testing.T) {
+	want := []string{
+		"urn:schemas-upnp-org:service:RenderingControl:1",
+		"urn:schemas-upnp-org:service:ContentDirectory:1",
+	}
+	if got := testRenderer.ServiceTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ServiceTypes() = %v, want %v", got, want)
+	}
+}
+
+func TestDiscoveredUPnP(t *testing.T) {
+	found := discoveredUPnP(UPnPDevice{
+		Location:    "http://192.168.1.50:49152/description.xml",
+		Server:      "Linux UPnP/1.0",
+		Headers:     http.Header{},
+		Description: DeviceDescription{Device: testRenderer},
+	})
+	if found.ID != "upnp-4d454930-0000-1000-8001-a81374a3f1c2" || found.Name != "Living Room TV" {
+		t.Errorf("id, name = %q, %q", found.ID, found.Name)
+	}
+	if found.Host != "192.168.1.50" || found.Port != 49152 || found.Protocol != "upnp" || found.Type != "media_player" {
+		t.Errorf("host, port, protocol, type = %q, %d, %q, %q", found.Host, found.Port, found.Protocol, found.Type)
+	}
+	if found.Metadata["device_type"] != testRenderer.DeviceType || found.Metadata["server"] != "Linux UPnP/1.0" {
+		t.Errorf("metadata = %v", found.Metadata)
+	}
+
+	hue := discoveredUPnP(UPnPDevice{
+		Location:    "http://192.168.1.2/description.xml",
+		Headers:     http.Header{"Hue-Bridgeid": {"001788FFFE23BFC2"}},
+		Description: DeviceDescription{Device: DescriptionDevice{DeviceType: "urn:schemas-upnp-org:device:Basic:1", UDN: "uuid:2f402f80"}},
+	})
+	if hue.ID != "hue-001788fffe23bfc2" || hue.Protocol != "hue" || hue.Type != "bridge" || hue.Port != 80 {
+		t.Errorf("hue bridge = %+v", hue)
+	}
+}
+
+// TestSearch finds a FakeUPnPDevice over loopback multicast and reads its
+// description.
+func TestSearch(t *testing.T) {
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 11900}
+	device, err := NewFakeUPnPDevice(nil, group, testRenderer, map[string]string{"X-Vendor": "acme"})
+	if err != nil {
+		t.Skipf("multicast unavailable: %v", err)
+	}
+	defer device.Close()
+	searcher, err := NewSearcher(nil, group)
+	if err != nil {
+		t.Skipf("multicast unavailable: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	found := make(chan UPnPDevice, 4)
+	go searcher.Search(ctx, func(dev UPnPDevice) { found <- dev })
+
+	select {
+	case dev := <-found:
+		if dev.Location != device.Location() || dev.Headers.Get("X-Vendor") != "acme" {
+			t.Errorf("found %+v", dev)
+		}
+		if dev.Description.Device.UDN != testRenderer.UDN || dev.Description.Device.FriendlyName != "Living Room TV" {
+			t.Errorf("description = %+v", dev.Description.Device)
+		}
+	case <-ctx.Done():
+		if device.Searches() == 0 {
+			t.Skip("multicast loopback unavailable")
+		}
+		t.Errorf("device not described after %d searches", device.Searches())
+	}
+}
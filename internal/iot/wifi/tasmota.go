@@ -0,0 +1,567 @@
+package wifi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	imqtt "iot-bridge/internal/iot/mqtt"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TasmotaDeviceConfig is an entry of TASMOTA_DEVICES_FILE: a device the
+// driver talks to over HTTP, for installs without MQTT.
+type TasmotaDeviceConfig struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Room     string `json:"room"`
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// tasmotaDiscovery is the retained config Tasmota publishes on
+// tasmota/discovery/<mac>/config with SetOption19 0.
+type tasmotaDiscovery struct {
+	IP            string    `json:"ip"`
+	DeviceName    string    `json:"dn"`
+	FriendlyNames []*string `json:"fn"`
+	Hostname      string    `json:"hn"`
+	MAC           string    `json:"mac"`
+	Model         string    `json:"md"`
+	Online        string    `json:"onln"`
+	Offline       string    `json:"ofln"`
+	StateText     []string  `json:"state"` // OFF, ON, TOGGLE, HOLD
+	Topic         string    `json:"t"`
+	FullTopic     string    `json:"ft"`
+	Prefixes      []string  `json:"tp"` // cmnd, stat, tele
+	Relays        []int     `json:"rl"` // per relay: 0 none, 1 relay, 2 light, 3 shutter
+	LightType     int       `json:"lt_st"`
+}
+
+// Light subtypes in lt_st.
+const (
+	lightDimmer = 1
+	lightCT     = 2
+	lightRGB    = 3
+	lightRGBW   = 4
+	lightRGBCW  = 5
+)
+
+type sensorField struct {
+	source string // sensor name in the payload, e.g. "AM2301" or "ENERGY"
+	unit   string
+}
+
+type tasmotaDevice struct {
+	id   string
+	disc tasmotaDiscovery    // from MQTT discovery; zero for HTTP devices
+	http TasmotaDeviceConfig // Host is also set from the discovered IP
+
+	relays            int
+	dimmer, color, ct bool
+	sensors           map[string]sensorField // state key -> sensor field
+
+	state store.State
+}
+
+// TasmotaDriver controls Tasmota devices. Devices announced through MQTT
+// discovery are controlled over MQTT; devices from TASMOTA_DEVICES_FILE, and
+// discovered ones while the broker is unreachable, through the HTTP
+// /cm?cmnd= endpoint.
+type TasmotaDriver struct {
+	client paho.Client
+	http   *http.Client
+
+	mu      sync.Mutex
+	devices map[string]*tasmotaDevice // device ID -> device
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var tasmota *TasmotaDriver
+
+func init() {
+	iot.Register("tasmota", func() iot.Driver {
+		tasmota = NewTasmotaDriver()
+		return tasmota
+	})
+}
+
+func NewTasmotaDriver() *TasmotaDriver {
+	return &TasmotaDriver{
+		http:    &http.Client{Timeout: 10 * time.Second},
+		devices: make(map[string]*tasmotaDevice),
+		stop:    make(chan struct{}),
+	}
+}
+
+func GetTasmotaDriver() *TasmotaDriver {
+	return tasmota
+}
+
+// Start registers the HTTP devices, starts polling them and, unless
+// TASMOTA_MQTT is off, connects to the broker for discovery.
+func (t *TasmotaDriver) Start() error {
+	devices, err := config.LoadJSONList[TasmotaDeviceConfig](config.TasmotaDevicesFile)
+	if err != nil {
+		log.Printf("[Tasmota] Failed to load %s: %v", config.TasmotaDevicesFile, err)
+	}
+	for _, cfg := range devices {
+		if cfg.ID == "" || cfg.Host == "" {
+			log.Printf("[Tasmota] Skipping device %q without id or host", cfg.ID)
+			continue
+		}
+		d := t.device(cfg.ID)
+		t.mu.Lock()
+		d.http = cfg
+		t.mu.Unlock()
+		t.register(d)
+	}
+	go t.pollLoop(config.TasmotaPollInterval)
+
+	if config.TasmotaMQTT {
+		t.client = imqtt.NewClient("iot-bridge-tasmota", t.onConnect)
+		imqtt.Connect(t.client, "[Tasmota]")
+	}
+	return nil
+}
+
+func (t *TasmotaDriver) Stop() error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	if t.client != nil {
+		t.client.Disconnect(250)
+	}
+	return nil
+}
+
+func (t *TasmotaDriver) Health() iot.Health {
+	switch {
+	case t.client != nil && !t.client.IsConnectionOpen():
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + config.MQTTBroker}
+	case t.client == nil && len(t.httpDevices()) == 0:
+		return iot.Health{Status: iot.HealthDisabled, Detail: "MQTT is off and no HTTP devices are configured"}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+func (t *TasmotaDriver) onConnect(c paho.Client) {
+	log.Println("[Tasmota] Connected to MQTT")
+	prefix := strings.TrimSuffix(config.TasmotaDiscoveryPrefix, "/")
+	filters := map[string]byte{prefix + "/+/config": 0, prefix + "/+/sensors": 0}
+	if token := c.SubscribeMultiple(filters, t.discoveryHandler); token.Wait() && token.Error() != nil {
+		log.Printf("[Tasmota] Failed to subscribe to discovery: %v", token.Error())
+	}
+
+	// Devices discovered before a reconnect need their telemetry again.
+	t.mu.Lock()
+	var known []*tasmotaDevice
+	for _, d := range t.devices {
+		if d.disc.Topic != "" {
+			known = append(known, d)
+		}
+	}
+	t.mu.Unlock()
+	for _, d := range known {
+		t.subscribeDevice(d)
+	}
+}
+
+// discoveryHandler handles tasmota/discovery/<mac>/config and .../sensors.
+func (t *TasmotaDriver) discoveryHandler(_ paho.Client, msg paho.Message) {
+	parts := strings.Split(msg.Topic(), "/")
+	if len(parts) < 3 || len(msg.Payload()) == 0 {
+		return // cleared retained config; keep the device
+	}
+	mac, kind := parts[len(parts)-2], parts[len(parts)-1]
+	d := t.device("tasmota_" + strings.ToLower(mac))
+
+	if kind == "sensors" {
+		var sensors struct {
+			SN map[string]interface{} `json:"sn"`
+		}
+		if err := json.Unmarshal(msg.Payload(), &sensors); err != nil {
+			log.Printf("[Tasmota] Invalid sensors discovery for %s: %v", mac, err)
+			return
+		}
+		t.apply(d, sensors.SN, true)
+		return
+	}
+
+	var disc tasmotaDiscovery
+	if err := json.Unmarshal(msg.Payload(), &disc); err != nil || disc.Topic == "" {
+		log.Printf("[Tasmota] Invalid discovery config for %s: %v", mac, err)
+		return
+	}
+	t.mu.Lock()
+	d.disc = disc
+	if disc.IP != "" {
+		d.http.Host = disc.IP
+	}
+	for i, typ := range disc.Relays {
+		if (typ == 1 || typ == 2) && i+1 > d.relays {
+			d.relays = i + 1
+		}
+	}
+	switch disc.LightType {
+	case lightDimmer:
+		d.dimmer = true
+	case lightCT:
+		d.dimmer, d.ct = true, true
+	case lightRGB, lightRGBW:
+		d.dimmer, d.color = true, true
+	case lightRGBCW:
+		d.dimmer, d.color, d.ct = true, true, true
+	}
+	t.mu.Unlock()
+
+	t.register(d)
+
+	// Waiting for the subscription would block the client's message loop.
+	go func() {
+		t.subscribeDevice(d)
+		// Ask for the current state and sensor readings.
+		t.publish(d, "STATE", "")
+		t.publish(d, "STATUS", "10")
+	}()
+}
+
+func (t *TasmotaDriver) subscribeDevice(d *tasmotaDevice) {
+	if t.client == nil || !t.client.IsConnectionOpen() {
+		return
+	}
+	t.mu.Lock()
+	filters := map[string]byte{
+		d.fullTopic(1) + "+": 0,
+		d.fullTopic(2) + "+": 0,
+	}
+	id := d.id
+	t.mu.Unlock()
+
+	token := t.client.SubscribeMultiple(filters, func(_ paho.Client, msg paho.Message) {
+		t.telemetryHandler(id, msg)
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Printf("[Tasmota] Failed to subscribe for %s: %v", id, token.Error())
+	}
+}
+
+// telemetryHandler handles stat/<topic>/... and tele/<topic>/... messages.
+func (t *TasmotaDriver) telemetryHandler(id string, msg paho.Message) {
+	topic := msg.Topic()
+	suffix := topic[strings.LastIndex(topic, "/")+1:]
+	payload := msg.Payload()
+
+	t.mu.Lock()
+	d := t.devices[id]
+	var disc tasmotaDiscovery
+	if d != nil {
+		disc = d.disc
+	}
+	t.mu.Unlock()
+	if d == nil {
+		return
+	}
+
+	switch {
+	case suffix == "LWT":
+		availability := ""
+		switch string(payload) {
+		case disc.Online, "Online":
+			availability = store.Online
+		case disc.Offline, "Offline":
+			availability = store.Offline
+		}
+		if availability != "" {
+			log.Printf("[Tasmota] %s is %s", id, availability)
+			factory.GetDeviceStore().UpdateStatus(id, store.DeviceStatus{Availability: availability})
+		}
+	case powerKey.MatchString(suffix):
+		// stat/<topic>/POWERn carries plain ON/OFF text.
+		t.apply(d, map[string]interface{}{suffix: string(payload)}, false)
+	case suffix == "STATE", suffix == "RESULT", suffix == "SENSOR", strings.HasPrefix(suffix, "STATUS"):
+		var data map[string]interface{}
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return
+		}
+		t.apply(d, data, suffix == "SENSOR")
+	}
+}
+
+// apply folds a payload into the device's state and the store, refreshing
+// the stored capabilities when new features showed up.
+func (t *TasmotaDriver) apply(d *tasmotaDevice, payload map[string]interface{}, sensors bool) {
+	t.mu.Lock()
+	result := d.parse(payload, sensors)
+	for k, v := range result.updates {
+		d.state[k] = v
+	}
+	registered := d.disc.Topic != "" || d.http.ID != ""
+	t.mu.Unlock()
+
+	if !registered {
+		return // sensors discovery ahead of the config; registered with it
+	}
+	if result.newFeatures {
+		t.register(d)
+	}
+	ds := factory.GetDeviceStore()
+	if len(result.updates) > 0 {
+		ds.UpdateState(d.id, result.updates)
+	}
+	now := time.Now()
+	ds.UpdateStatus(d.id, store.DeviceStatus{Availability: store.Online, LastSeen: &now, LinkQuality: result.rssi})
+}
+
+// register adds the device to the store, or refreshes the stored one.
+func (t *TasmotaDriver) register(d *tasmotaDevice) {
+	t.mu.Lock()
+	caps := d.capabilities()
+	deviceType := d.deviceType()
+	name := d.http.Name
+	if name == "" && len(d.disc.FriendlyNames) > 0 && d.disc.FriendlyNames[0] != nil {
+		name = *d.disc.FriendlyNames[0]
+	}
+	if name == "" {
+		name = d.disc.DeviceName
+	}
+	if name == "" {
+		name = d.id
+	}
+	room := d.http.Room
+	t.mu.Unlock()
+
+	added, err := iot.RegisterDevice(store.Device{
+		ID:           d.id,
+		Name:         name,
+		Type:         deviceType,
+		Protocol:     "tasmota",
+		Room:         room,
+		Capabilities: caps,
+	})
+	if err != nil {
+		log.Printf("[Tasmota] Failed to register device %s: %v", d.id, err)
+	} else if added {
+		log.Printf("[Tasmota] Registered %s (%s)", name, d.id)
+	}
+}
+
+func (t *TasmotaDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	d, ok := t.known(device.ID)
+	if !ok {
+		return nil, iot.Errorf(iot.Unsupported, "unknown Tasmota device %s", device.ID)
+	}
+	if !t.useMQTT(d) {
+		if err := t.poll(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := store.State{}
+	for k, v := range d.state {
+		state[k] = v
+	}
+	return state, nil
+}
+
+// SetState sends one command per update over MQTT, or over HTTP when the
+// device isn't reachable through the broker.
+func (t *TasmotaDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	d, ok := t.known(device.ID)
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "unknown Tasmota device %s", device.ID)
+	}
+	t.mu.Lock()
+	cmds, err := d.commands(updates)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if t.useMQTT(d) {
+		for _, cmd := range cmds {
+			if err := imqtt.Wait(ctx, t.publish(d, cmd[0], cmd[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if d.http.Host == "" {
+		return iot.Errorf(iot.TransportError, "%s is not reachable: no MQTT connection and no host", device.ID)
+	}
+	for _, cmd := range cmds {
+		result, err := t.httpCommand(ctx, d, cmd[0]+" "+cmd[1])
+		if err != nil {
+			return err
+		}
+		t.apply(d, result, false)
+	}
+	return nil
+}
+
+func (t *TasmotaDriver) useMQTT(d *tasmotaDevice) bool {
+	t.mu.Lock()
+	discovered := d.disc.Topic != ""
+	t.mu.Unlock()
+	return discovered && t.client != nil && t.client.IsConnectionOpen()
+}
+
+func (t *TasmotaDriver) publish(d *tasmotaDevice, command, payload string) paho.Token {
+	t.mu.Lock()
+	topic := d.fullTopic(0) + command
+	t.mu.Unlock()
+	return t.client.Publish(topic, 0, false, payload)
+}
+
+// httpCommand runs a command through http://<host>/cm?cmnd= and returns the
+// JSON result.
+func (t *TasmotaDriver) httpCommand(ctx context.Context, d *tasmotaDevice, command string) (map[string]interface{}, error) {
+	t.mu.Lock()
+	cfg := d.http
+	t.mu.Unlock()
+
+	base := cfg.Host
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	query := url.Values{"cmnd": {command}}
+	if cfg.Password != "" {
+		user := cfg.Username
+		if user == "" {
+			user = "admin"
+		}
+		query.Set("user", user)
+		query.Set("password", cfg.Password)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/cm?"+query.Encode(), nil)
+	if err != nil {
+		return nil, iot.Errorf(iot.TransportError, "%w", err)
+	}
+	resp, err := t.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, iot.Errorf(iot.TransportError, "%w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, iot.Errorf(iot.TransportError, "%s rejected the credentials", d.id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, iot.Errorf(iot.TransportError, "%s answered %s", d.id, resp.Status)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, iot.Errorf(iot.TransportError, "invalid response from %s: %v", d.id, err)
+	}
+	if msg, ok := result["WARNING"].(string); ok {
+		return nil, iot.Errorf(iot.TransportError, "%s: %s", d.id, msg)
+	}
+	return result, nil
+}
+
+// poll reads state and sensors over HTTP with "Status 0".
+func (t *TasmotaDriver) poll(ctx context.Context, d *tasmotaDevice) error {
+	result, err := t.httpCommand(ctx, d, "Status 0")
+	if err != nil {
+		factory.GetDeviceStore().UpdateStatus(d.id, store.DeviceStatus{Availability: store.Offline})
+		return err
+	}
+	t.apply(d, result, false)
+	return nil
+}
+
+func (t *TasmotaDriver) pollLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, d := range t.httpDevices() {
+			if t.useMQTT(d) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := t.poll(ctx, d); err != nil {
+				log.Printf("[Tasmota] Failed to poll %s: %v", d.id, err)
+			}
+			cancel()
+		}
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// httpDevices lists devices configured in TASMOTA_DEVICES_FILE.
+func (t *TasmotaDriver) httpDevices() []*tasmotaDevice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var list []*tasmotaDevice
+	for _, d := range t.devices {
+		if d.http.ID != "" {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// device returns the entry for id, creating it if needed.
+func (t *TasmotaDriver) device(id string) *tasmotaDevice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[id]
+	if !ok {
+		d = &tasmotaDevice{id: id, sensors: make(map[string]sensorField), state: store.State{}}
+		t.devices[id] = d
+	}
+	return d
+}
+
+func (t *TasmotaDriver) known(id string) (*tasmotaDevice, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[id]
+	return d, ok
+}
+
+// fullTopic expands the device's FullTopic for a prefix index (0 cmnd,
+// 1 stat, 2 tele), e.g. "cmnd/tasmota_ABCDEF/".
+func (d *tasmotaDevice) fullTopic(prefix int) string {
+	prefixes := []string{"cmnd", "stat", "tele"}
+	for i, p := range d.disc.Prefixes {
+		if i < len(prefixes) && p != "" {
+			prefixes[i] = p
+		}
+	}
+	ft := d.disc.FullTopic
+	if ft == "" {
+		ft = "%prefix%/%topic%/"
+	}
+	id := d.disc.MAC
+	if len(id) > 6 {
+		id = id[len(id)-6:]
+	}
+	r := strings.NewReplacer("%prefix%", prefixes[prefix], "%topic%", d.disc.Topic, "%hostname%", d.disc.Hostname, "%id%", id)
+	topic := r.Replace(ft)
+	if !strings.HasSuffix(topic, "/") {
+		topic += "/"
+	}
+	return topic
+}
@@ -0,0 +1,87 @@
+package wifi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// FakeTasmota is a single relay Tasmota device with an AM2301 sensor,
+// answering commands on /cm?cmnd= the way the firmware does: "Status 0",
+// "POWER1 <ON|OFF|TOGGLE>", and a WARNING for wrong credentials.
+type FakeTasmota struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	password string
+	power    string
+	// Commands records every command received.
+	Commands []string
+}
+
+func NewFakeTasmota(password string) *FakeTasmota {
+	f := &FakeTasmota{password: password, power: "ON"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Host returns the host:port to configure the driver with.
+func (f *FakeTasmota) Host() string {
+	return strings.TrimPrefix(f.Server.URL, "http://")
+}
+
+// SetPassword changes the password the device expects.
+func (f *FakeTasmota) SetPassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.password = password
+}
+
+// ReceivedCommands returns a copy of Commands.
+func (f *FakeTasmota) ReceivedCommands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.Commands...)
+}
+
+func (f *FakeTasmota) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/cm" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if f.password != "" && (q.Get("user") != "admin" || q.Get("password") != f.password) {
+		json.NewEncoder(w).Encode(map[string]string{"WARNING": "Need user=<username>&password=<password>"})
+		return
+	}
+
+	cmnd := q.Get("cmnd")
+	f.Commands = append(f.Commands, cmnd)
+	command, arg, _ := strings.Cut(cmnd, " ")
+	var result map[string]interface{}
+	switch strings.ToUpper(command) {
+	case "STATUS":
+		result = map[string]interface{}{
+			"Status":    map[string]interface{}{"Topic": "tasmota_FAKE", "FriendlyName": []string{"Fake"}},
+			"StatusSTS": map[string]interface{}{"POWER": f.power, "Wifi": map[string]interface{}{"RSSI": 70}},
+			"StatusSNS": map[string]interface{}{"AM2301": map[string]interface{}{"Temperature": 21.5}, "TempUnit": "C"},
+		}
+	case "POWER", "POWER1":
+		switch strings.ToUpper(arg) {
+		case "ON", "OFF":
+			f.power = strings.ToUpper(arg)
+		case "TOGGLE":
+			f.power = map[string]string{"ON": "OFF", "OFF": "ON"}[f.power]
+		}
+		result = map[string]interface{}{"POWER": f.power}
+	default:
+		result = map[string]interface{}{"Command": "Unknown"}
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,403 @@
+package wifi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// sensorUnits gives units for common Tasmota sensor fields. Temperatures use
+// the payload's TempUnit.
+var sensorUnits = map[string]string{
+	"Humidity":      "%",
+	"Pressure":      "hPa",
+	"Illuminance":   "lx",
+	"Distance":      "cm",
+	"CarbonDioxide": "ppm",
+}
+
+// energyKeys names ENERGY readings like the Z-Wave driver names meters.
+var energyKeys = map[string]struct{ key, unit string }{
+	"Power":   {"power_usage", "W"},
+	"Total":   {"energy", "kWh"},
+	"Today":   {"energy_today", "kWh"},
+	"Voltage": {"voltage", "V"},
+	"Current": {"current", "A"},
+}
+
+var (
+	powerKey = regexp.MustCompile(`^POWER(\d*)$`)
+	nonWord  = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func keyify(s string) string {
+	return strings.Trim(nonWord.ReplaceAllString(strings.ToLower(s), "_"), "_")
+}
+
+// telemetry is what a Tasmota payload contributed: state values, the Wi-Fi
+// signal if reported, and whether the device showed features it wasn't known
+// to have, which means its capabilities need refreshing.
+type telemetry struct {
+	updates     store.State
+	rssi        *int
+	newFeatures bool
+}
+
+// parse folds a STATE, RESULT, SENSOR or STATUS payload into state updates.
+// Nested objects are only read as sensors in SENSOR payloads (or StatusSNS),
+// since STATE carries unrelated objects such as Wifi.
+func (d *tasmotaDevice) parse(payload map[string]interface{}, sensors bool) telemetry {
+	t := telemetry{updates: store.State{}}
+
+	for _, wrapped := range []struct {
+		key     string
+		sensors bool
+	}{{"StatusSTS", false}, {"StatusSNS", true}} {
+		if inner, ok := payload[wrapped.key].(map[string]interface{}); ok {
+			sub := d.parse(inner, wrapped.sensors)
+			for k, v := range sub.updates {
+				t.updates[k] = v
+			}
+			if sub.rssi != nil {
+				t.rssi = sub.rssi
+			}
+			t.newFeatures = t.newFeatures || sub.newFeatures
+		}
+	}
+
+	// Count relays first so a newly seen POWER2 renames "state" to "state_1"
+	// before any key is produced.
+	for key := range payload {
+		if m := powerKey.FindStringSubmatch(key); m != nil {
+			n := 1
+			if m[1] != "" {
+				n, _ = strconv.Atoi(m[1])
+			}
+			if n > d.relays {
+				d.relays = n
+				t.newFeatures = true
+			}
+		}
+	}
+
+	for key, value := range payload {
+		if m := powerKey.FindStringSubmatch(key); m != nil {
+			n := 1
+			if m[1] != "" {
+				n, _ = strconv.Atoi(m[1])
+			}
+			if s, ok := value.(string); ok {
+				if on, ok := d.parsePower(s); ok {
+					t.updates[d.relayKey(n)] = on
+				}
+			}
+			continue
+		}
+
+		switch key {
+		case "Dimmer":
+			if n, ok := iot.Number(value); ok {
+				t.updates["brightness"] = int(n)
+				t.newFeatures = t.newFeatures || !d.dimmer
+				d.dimmer = true
+			}
+		case "Color":
+			if rgb, ok := parseColor(fmt.Sprint(value)); ok {
+				t.updates["rgb"] = rgb
+				t.newFeatures = t.newFeatures || !d.color
+				d.color = true
+			}
+		case "CT":
+			if n, ok := iot.Number(value); ok {
+				t.updates["color_temp"] = int(n)
+				t.newFeatures = t.newFeatures || !d.ct
+				d.ct = true
+			}
+		case "Wifi":
+			if wifi, ok := value.(map[string]interface{}); ok {
+				if rssi, ok := iot.Number(wifi["RSSI"]); ok {
+					q := int(rssi)
+					t.rssi = &q
+				}
+			}
+		case "ENERGY":
+			energy, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for field, reading := range energy {
+				ek, known := energyKeys[field]
+				n, isNum := iot.Number(reading)
+				if !known || !isNum {
+					continue
+				}
+				t.updates[ek.key] = n
+				t.newFeatures = d.addSensor(ek.key, "ENERGY", ek.unit) || t.newFeatures
+			}
+		}
+	}
+
+	if sensors {
+		tempUnit := "C"
+		if u, ok := payload["TempUnit"].(string); ok && u != "" {
+			tempUnit = u
+		}
+		names := make([]string, 0, len(payload))
+		for name := range payload {
+			if _, ok := payload[name].(map[string]interface{}); ok && name != "ENERGY" && name != "Wifi" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for field, reading := range payload[name].(map[string]interface{}) {
+				n, ok := iot.Number(reading)
+				if !ok {
+					continue
+				}
+				unit := sensorUnits[field]
+				if field == "Temperature" || field == "DewPoint" {
+					unit = "°" + tempUnit
+				}
+				key := d.sensorKey(name, field)
+				t.updates[key] = n
+				t.newFeatures = d.addSensor(key, name, unit) || t.newFeatures
+			}
+		}
+	}
+	return t
+}
+
+// sensorKey names a sensor field after the field alone ("temperature"), or
+// prefixed with the sensor when another sensor already uses that name.
+func (d *tasmotaDevice) sensorKey(sensor, field string) string {
+	key := keyify(field)
+	if s, ok := d.sensors[key]; ok && s.source != sensor {
+		return keyify(sensor) + "_" + key
+	}
+	return key
+}
+
+func (d *tasmotaDevice) addSensor(key, source, unit string) bool {
+	if _, ok := d.sensors[key]; ok {
+		return false
+	}
+	d.sensors[key] = sensorField{source: source, unit: unit}
+	return true
+}
+
+func (d *tasmotaDevice) relayKey(n int) string {
+	if d.relays <= 1 {
+		return "state"
+	}
+	return "state_" + strconv.Itoa(n)
+}
+
+// relayIndex is the inverse of relayKey.
+func (d *tasmotaDevice) relayIndex(key string) (int, bool) {
+	if key == "state" && d.relays <= 1 {
+		return 1, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(key, "state_"))
+	if err != nil || !strings.HasPrefix(key, "state_") || n < 1 || n > d.relays {
+		return 0, false
+	}
+	return n, true
+}
+
+// parsePower maps Tasmota's power text (ON/OFF, or the device's custom state
+// texts) to "on"/"off".
+func (d *tasmotaDevice) parsePower(s string) (string, bool) {
+	switch {
+	case strings.EqualFold(s, "ON") || (len(d.disc.StateText) > 1 && s == d.disc.StateText[1]):
+		return "on", true
+	case strings.EqualFold(s, "OFF") || (len(d.disc.StateText) > 0 && s == d.disc.StateText[0]):
+		return "off", true
+	}
+	return "", false
+}
+
+// parseColor reads "255,0,0" (SetOption17) or hex "FF0000", ignoring any
+// white channels after the first three.
+func parseColor(s string) ([]int, bool) {
+	if strings.Contains(s, ",") {
+		parts := strings.Split(s, ",")
+		if len(parts) < 3 {
+			return nil, false
+		}
+		rgb := make([]int, 3)
+		for i := range rgb {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+			if err != nil {
+				return nil, false
+			}
+			rgb[i] = n
+		}
+		return rgb, true
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) < 6 {
+		return nil, false
+	}
+	rgb := make([]int, 3)
+	for i := range rgb {
+		n, err := strconv.ParseUint(s[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		rgb[i] = int(n)
+	}
+	return rgb, true
+}
+
+// commands turns updates into Tasmota commands, e.g. state_2=on -> POWER2 ON.
+func (d *tasmotaDevice) commands(updates store.State) ([][2]string, error) {
+	keys := make([]string, 0, len(updates))
+	for k := range updates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var cmds [][2]string
+	for _, key := range keys {
+		value := updates[key]
+		if n, ok := d.relayIndex(key); ok {
+			payload, err := powerPayload(value)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, [2]string{"POWER" + strconv.Itoa(n), payload})
+			continue
+		}
+		switch key {
+		case "brightness":
+			n, ok := iot.Number(value)
+			if !ok || n < 0 || n > 100 {
+				return nil, iot.Errorf(iot.InvalidValue, "invalid brightness %v, expected 0-100", value)
+			}
+			cmds = append(cmds, [2]string{"Dimmer", strconv.Itoa(int(n))})
+		case "rgb":
+			rgb, ok := iot.RGBValue(value)
+			if !ok {
+				return nil, iot.Errorf(iot.InvalidValue, "invalid rgb %v, expected [r, g, b] in 0-255", value)
+			}
+			cmds = append(cmds, [2]string{"Color", fmt.Sprintf("%d,%d,%d", rgb[0], rgb[1], rgb[2])})
+		case "color_temp":
+			n, ok := iot.Number(value)
+			if !ok || n < 153 || n > 500 {
+				return nil, iot.Errorf(iot.InvalidValue, "invalid color_temp %v, expected 153-500", value)
+			}
+			cmds = append(cmds, [2]string{"CT", strconv.Itoa(int(n))})
+		default:
+			return nil, iot.UnsupportedKey(key)
+		}
+	}
+	return cmds, nil
+}
+
+func powerPayload(value interface{}) (string, error) {
+	switch strings.ToLower(store.FormatValue(value)) {
+	case "on", "true", "1":
+		return "ON", nil
+	case "off", "false", "0":
+		return "OFF", nil
+	case "toggle":
+		return "TOGGLE", nil
+	}
+	return "", iot.Errorf(iot.InvalidValue, "invalid power value %v, expected on/off/toggle", value)
+}
+
+// capabilities lists what the device has shown: relays, light controls and
+// read-only sensors.
+func (d *tasmotaDevice) capabilities() []store.Capability {
+	var caps []store.Capability
+	for n := 1; n <= d.relays; n++ {
+		key := d.relayKey(n)
+		description := "Turn the device on or off"
+		if d.relays > 1 {
+			description = fmt.Sprintf("Turn relay %d on or off", n)
+		}
+		caps = append(caps, store.Capability{
+			Name:        strings.Replace(key, "state", "power", 1),
+			Description: description,
+			Operations:  []string{"on", "off"},
+			Parameters: map[string]interface{}{
+				key: map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+			},
+			Writable: true,
+		})
+	}
+	if d.dimmer {
+		caps = append(caps, store.Capability{
+			Name:        "brightness",
+			Description: "Adjust brightness (0-100)",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"brightness": map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+			},
+			Writable: true,
+		})
+	}
+	if d.color {
+		caps = append(caps, store.Capability{
+			Name:        "color",
+			Description: "Change color using RGB",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"rgb": map[string]interface{}{"type": "array", "length": 3, "range": []int{0, 255}},
+			},
+			Writable: true,
+		})
+	}
+	if d.ct {
+		caps = append(caps, store.Capability{
+			Name:        "color_temp",
+			Description: "Set the white color temperature in mireds",
+			Operations:  []string{"set"},
+			Parameters: map[string]interface{}{
+				"color_temp": map[string]interface{}{"type": "integer", "range": []int{153, 500}},
+			},
+			Writable: true,
+		})
+	}
+
+	keys := make([]string, 0, len(d.sensors))
+	for k := range d.sensors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		spec := map[string]interface{}{"type": "number"}
+		if unit := d.sensors[key].unit; unit != "" {
+			spec["unit"] = unit
+		}
+		caps = append(caps, store.Capability{
+			Name:        key,
+			Description: strings.ReplaceAll(key, "_", " "),
+			Parameters:  map[string]interface{}{key: spec},
+		})
+	}
+	return caps
+}
+
+func (d *tasmotaDevice) deviceType() string {
+	_, metering := d.sensors["power_usage"]
+	switch {
+	case d.color:
+		return "bulb"
+	case d.dimmer || d.ct:
+		return "dimmer"
+	case metering:
+		return "smart_plug"
+	case d.relays > 0:
+		return "switch"
+	case len(d.sensors) > 0:
+		return "sensor"
+	}
+	return "switch"
+}
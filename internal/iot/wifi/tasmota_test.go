@@ -0,0 +1,402 @@
+package wifi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+func newTasmotaDevice(relays int) *tasmotaDevice {
+	return &tasmotaDevice{id: "tasmota-test", relays: relays, sensors: map[string]sensorField{}}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   []int
+		wantOK bool
+	}{
+		{"255,0,128", []int{255, 0, 128}, true},
+		{"10, 20, 30, 40", []int{10, 20, 30}, true},
+		{"FF8000", []int{255, 128, 0}, true},
+		{"#00ff00", []int{0, 255, 0}, true},
+		{"FF800000FF", []int{255, 128, 0}, true},
+		{"1,2", nil, false},
+		{"a,b,c", nil, false},
+		{"FFF", nil, false},
+		{"GG0000", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := parseColor(tt.in)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseColor(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		relays       int
+		payload      map[string]interface{}
+		sensors      bool
+		want         store.State
+		wantRSSI     int
+		wantFeatures bool
+	}{
+		{
+			name:    "single relay",
+			relays:  1,
+			payload: map[string]interface{}{"POWER": "ON"},
+			want:    store.State{"state": "on"},
+		},
+		{
+			name:         "second relay appears",
+			relays:       1,
+			payload:      map[string]interface{}{"POWER1": "OFF", "POWER2": "ON"},
+			want:         store.State{"state_1": "off", "state_2": "on"},
+			wantFeatures: true,
+		},
+		{
+			name:         "light",
+			relays:       1,
+			payload:      map[string]interface{}{"POWER": "ON", "Dimmer": float64(40), "Color": "255,0,0", "CT": float64(300)},
+			want:         store.State{"state": "on", "brightness": 40, "rgb": []int{255, 0, 0}, "color_temp": 300},
+			wantFeatures: true,
+		},
+		{
+			name:     "wifi signal",
+			relays:   1,
+			payload:  map[string]interface{}{"POWER": "OFF", "Wifi": map[string]interface{}{"RSSI": float64(-62)}},
+			want:     store.State{"state": "off"},
+			wantRSSI: -62,
+		},
+		{
+			name:   "state ignores nested objects",
+			relays: 1,
+			payload: map[string]interface{}{
+				"AM2301": map[string]interface{}{"Temperature": float64(21.5)},
+			},
+			want: store.State{},
+		},
+		{
+			name:   "sensor payload",
+			relays: 1,
+			payload: map[string]interface{}{
+				"AM2301":   map[string]interface{}{"Temperature": float64(21.5), "Humidity": float64(40)},
+				"ENERGY":   map[string]interface{}{"Power": float64(12), "Total": float64(3.5), "Factor": float64(0.9)},
+				"TempUnit": "C",
+			},
+			sensors:      true,
+			want:         store.State{"temperature": 21.5, "humidity": float64(40), "power_usage": float64(12), "energy": 3.5},
+			wantFeatures: true,
+		},
+		{
+			name:   "wrapped status",
+			relays: 1,
+			payload: map[string]interface{}{
+				"StatusSTS": map[string]interface{}{"POWER": "ON"},
+				"StatusSNS": map[string]interface{}{"DS18B20": map[string]interface{}{"Temperature": float64(19)}},
+			},
+			want:         store.State{"state": "on", "temperature": float64(19)},
+			wantFeatures: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTasmotaDevice(tt.relays)
+			got := d.parse(tt.payload, tt.sensors)
+			if !reflect.DeepEqual(got.updates, tt.want) {
+				t.Errorf("updates = %v, want %v", got.updates, tt.want)
+			}
+			if got.newFeatures != tt.wantFeatures {
+				t.Errorf("newFeatures = %v, want %v", got.newFeatures, tt.wantFeatures)
+			}
+			if tt.wantRSSI != 0 && (got.rssi == nil || *got.rssi != tt.wantRSSI) {
+				t.Errorf("rssi = %v, want %d", got.rssi, tt.wantRSSI)
+			}
+		})
+	}
+}
+
+func TestParseSensorUnitsAndClashes(t *testing.T) {
+	d := newTasmotaDevice(0)
+	d.parse(map[string]interface{}{
+		"AM2301":   map[string]interface{}{"Temperature": float64(21)},
+		"DS18B20":  map[string]interface{}{"Temperature": float64(19)},
+		"TempUnit": "F",
+	}, true)
+
+	want := map[string]sensorField{
+		"temperature":         {source: "AM2301", unit: "°F"},
+		"ds18b20_temperature": {source: "DS18B20", unit: "°F"},
+	}
+	if !reflect.DeepEqual(d.sensors, want) {
+		t.Errorf("sensors = %v, want %v", d.sensors, want)
+	}
+}
+
+func TestParseCustomStateText(t *testing.T) {
+	d := newTasmotaDevice(1)
+	d.disc.StateText = []string{"AUS", "AN", "UMSCHALTEN", "HALTEN"}
+	got := d.parse(map[string]interface{}{"POWER": "AN"}, false)
+	if got.updates["state"] != "on" {
+		t.Errorf("state = %v, want on", got.updates["state"])
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		relays   int
+		updates  store.State
+		want     [][2]string
+		wantKind iot.ErrorKind
+	}{
+		{
+			name:    "single relay",
+			relays:  1,
+			updates: store.State{"state": "on"},
+			want:    [][2]string{{"POWER1", "ON"}},
+		},
+		{
+			name:    "relay by index",
+			relays:  2,
+			updates: store.State{"state_2": false, "state_1": "toggle"},
+			want:    [][2]string{{"POWER1", "TOGGLE"}, {"POWER2", "OFF"}},
+		},
+		{
+			name:    "light",
+			relays:  1,
+			updates: store.State{"brightness": float64(55), "rgb": []interface{}{float64(1), float64(2), float64(3)}, "color_temp": 250},
+			want:    [][2]string{{"Dimmer", "55"}, {"CT", "250"}, {"Color", "1,2,3"}},
+		},
+		{
+			name:     "brightness out of range",
+			relays:   1,
+			updates:  store.State{"brightness": float64(101)},
+			wantKind: iot.InvalidValue,
+		},
+		{
+			name:     "bad rgb",
+			relays:   1,
+			updates:  store.State{"rgb": []int{1, 2}},
+			wantKind: iot.InvalidValue,
+		},
+		{
+			name:     "bad power",
+			relays:   1,
+			updates:  store.State{"state": "dim"},
+			wantKind: iot.InvalidValue,
+		},
+		{
+			name:     "unknown relay",
+			relays:   2,
+			updates:  store.State{"state_3": "on"},
+			wantKind: iot.Unsupported,
+		},
+		{
+			name:     "unknown key",
+			relays:   1,
+			updates:  store.State{"speed": 3},
+			wantKind: iot.Unsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTasmotaDevice(tt.relays)
+			got, err := d.commands(tt.updates)
+			if tt.wantKind != "" {
+				if err == nil || iot.KindOf(err) != tt.wantKind {
+					t.Fatalf("commands() error = %v, want %s", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("commands() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// configureTasmota sets the driver's settings for the test and restores them
+// afterwards.
+func configureTasmota(t *testing.T, mqtt bool, devices []TasmotaDeviceConfig) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "tasmota-devices.json")
+	data, _ := json.Marshal(devices)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	savedMQTT, savedFile, savedPrefix, savedInterval := config.TasmotaMQTT, config.TasmotaDevicesFile, config.TasmotaDiscoveryPrefix, config.TasmotaPollInterval
+	config.TasmotaMQTT, config.TasmotaDevicesFile = mqtt, file
+	config.TasmotaDiscoveryPrefix, config.TasmotaPollInterval = "tasmota/discovery", time.Hour
+	t.Cleanup(func() {
+		config.TasmotaMQTT, config.TasmotaDevicesFile = savedMQTT, savedFile
+		config.TasmotaDiscoveryPrefix, config.TasmotaPollInterval = savedPrefix, savedInterval
+	})
+}
+
+// startHTTPTasmota polls a fake device over HTTP and waits for its state.
+func startHTTPTasmota(t *testing.T) (*TasmotaDriver, *FakeTasmota) {
+	t.Helper()
+	testutil.UseMemoryStore()
+	fake := NewFakeTasmota("secret")
+	t.Cleanup(fake.Close)
+	configureTasmota(t, false, []TasmotaDeviceConfig{
+		{ID: "desk", Name: "Desk plug", Room: "Office", Host: fake.Host(), Password: "secret"},
+	})
+
+	d := NewTasmotaDriver()
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Stop() })
+	testutil.WaitFor(t, "first poll", func() bool { return testutil.StoredState("desk", "state") == "on" })
+	return d, fake
+}
+
+func TestTasmotaHTTPPoll(t *testing.T) {
+	startHTTPTasmota(t)
+
+	device, _ := factory.GetDeviceStore().Get("desk")
+	if device.Name != "Desk plug" || device.Room != "Office" || device.Protocol != "tasmota" {
+		t.Errorf("device = %+v", device)
+	}
+	if device.State["temperature"] != 21.5 || device.Availability != store.Online {
+		t.Errorf("state = %v, availability = %q", device.State, device.Availability)
+	}
+}
+
+func TestTasmotaHTTPSetState(t *testing.T) {
+	d, fake := startHTTPTasmota(t)
+	device, _ := factory.GetDeviceStore().Get("desk")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := d.SetState(ctx, device, store.State{"state": "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.ReceivedCommands(); got[len(got)-1] != "POWER1 OFF" {
+		t.Errorf("commands = %q, want POWER1 OFF last", got)
+	}
+	if got := testutil.StoredState("desk", "state"); got != "off" {
+		t.Errorf("stored state = %v, want the device's answer", got)
+	}
+
+	state, err := d.GetState(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["state"] != "off" {
+		t.Errorf("GetState() = %v", state)
+	}
+}
+
+func TestTasmotaHTTPErrors(t *testing.T) {
+	d, fake := startHTTPTasmota(t)
+	device, _ := factory.GetDeviceStore().Get("desk")
+	ctx := context.Background()
+
+	fake.SetPassword("changed")
+	if _, err := d.GetState(ctx, device); iot.KindOf(err) != iot.TransportError {
+		t.Errorf("wrong password: err = %v, want transport error", err)
+	}
+	if device, _ := factory.GetDeviceStore().Get("desk"); device.Availability != store.Offline {
+		t.Errorf("availability = %q after a failed poll, want offline", device.Availability)
+	}
+	if err := d.SetState(ctx, device, store.State{"speed": 3}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("unknown key: err = %v, want unsupported", err)
+	}
+	if err := d.SetState(ctx, store.Device{ID: "other"}, store.State{"state": "on"}); iot.KindOf(err) != iot.Unsupported {
+		t.Errorf("unknown device: err = %v, want unsupported", err)
+	}
+}
+
+const tasmotaPlugDiscovery = `{"ip": "", "dn": "Tasmota", "fn": ["Desk plug", null], "hn": "tasmota-DDEEFF",
+	"mac": "AABBCCDDEEFF", "md": "Sonoff Basic", "onln": "Online", "ofln": "Offline",
+	"state": ["OFF", "ON", "TOGGLE", "HOLD"], "t": "tasmota_DDEEFF", "ft": "%prefix%/%topic%/",
+	"tp": ["cmnd", "stat", "tele"], "rl": [1, 0, 0, 0], "lt_st": 0}`
+
+func TestTasmotaMQTT(t *testing.T) {
+	testutil.UseMemoryStore()
+	testutil.StartBroker(t)
+	configureTasmota(t, true, nil)
+
+	// The test plays the device: it publishes discovery and telemetry and
+	// collects the commands the driver sends.
+	plug := testutil.MQTTClient(t, "tasmota-plug")
+	commands := make(chan string, 10)
+	plug.Subscribe("cmnd/tasmota_DDEEFF/+", 0, func(_ paho.Client, msg paho.Message) {
+		commands <- strings.TrimPrefix(msg.Topic(), "cmnd/tasmota_DDEEFF/") + " " + string(msg.Payload())
+	}).Wait()
+	expectCommand := func(want string) {
+		t.Helper()
+		for {
+			select {
+			case got := <-commands:
+				if got == want {
+					return
+				}
+			case <-time.After(testutil.Timeout):
+				t.Fatalf("command %q not sent", want)
+			}
+		}
+	}
+	publish := func(topic, payload string) {
+		plug.Publish(topic, 0, false, payload).Wait()
+	}
+
+	d := NewTasmotaDriver()
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Stop() })
+	testutil.WaitFor(t, "driver connected", func() bool { return d.Health().Status == iot.HealthOK })
+
+	plug.Publish("tasmota/discovery/AABBCCDDEEFF/config", 0, true, tasmotaPlugDiscovery).Wait()
+	// The driver asks for the state once it listens to the device's topics.
+	expectCommand("STATE ")
+	const id = "tasmota_aabbccddeeff"
+	device, ok := factory.GetDeviceStore().Get(id)
+	if !ok || device.Name != "Desk plug" || device.Protocol != "tasmota" {
+		t.Fatalf("discovered device = %+v, %v", device, ok)
+	}
+
+	publish("stat/tasmota_DDEEFF/POWER", "ON")
+	testutil.WaitFor(t, "state on", func() bool { return testutil.StoredState(id, "state") == "on" })
+	publish("tele/tasmota_DDEEFF/SENSOR", `{"AM2301": {"Temperature": 21.5, "Humidity": 40}, "TempUnit": "C"}`)
+	testutil.WaitFor(t, "temperature", func() bool { return testutil.StoredState(id, "temperature") == 21.5 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	device, _ = factory.GetDeviceStore().Get(id)
+	if err := d.SetState(ctx, device, store.State{"state": "off"}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommand("POWER1 OFF")
+
+	publish("tele/tasmota_DDEEFF/LWT", "Offline")
+	testutil.WaitFor(t, "device offline", func() bool {
+		device, _ := factory.GetDeviceStore().Get(id)
+		return device.Availability == store.Offline
+	})
+}
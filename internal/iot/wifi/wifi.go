@@ -1 +1,297 @@
 package wifi
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+// mdnsServices maps the DNS-SD service types a wifi scan browses to the
+// protocol of the devices announcing them.
+var mdnsServices = map[string]string{
+	"_esphomelib._tcp": "esphome",
+	"_shelly._tcp":     "shelly",
+	"_hue._tcp":        "hue",
+	"_matter._tcp":     "matter",
+	"_hap._tcp":        "homekit",
+}
+
+// upnpTypes maps UPnP device types to device types.
+var upnpTypes = map[string]string{
+	"MediaRenderer":         "media_player",
+	"MediaServer":           "media_server",
+	"BinaryLight":           "light",
+	"DimmableLight":         "light",
+	"InternetGatewayDevice": "router",
+}
+
+// WifiDriver scans the local network for devices. What it finds is served
+// by the driver of each device's protocol once adopted, so it has no
+// devices of its own.
+type WifiDriver struct {
+	ifi *net.Interface // nil for the default multicast interface
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // ends the running scan
+
+	resultsMu sync.Mutex // serializes merging results into the scan store
+}
+
+var wifi *WifiDriver
+
+func init() {
+	iot.Register("wifi", func() iot.Driver {
+		wifi = NewWifiDriver()
+		return wifi
+	})
+}
+
+func NewWifiDriver() *WifiDriver {
+	return &WifiDriver{}
+}
+
+func GetWifiDriver() *WifiDriver {
+	return wifi
+}
+
+// Start picks the interface scans run on.
+func (d *WifiDriver) Start() error {
+	if config.MDNSInterface == "" {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(config.MDNSInterface)
+	if err != nil {
+		return err
+	}
+	d.ifi = ifi
+	return nil
+}
+
+// Stop ends a running scan.
+func (d *WifiDriver) Stop() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		d.cancel()
+		d.cancel = nil
+	}
+	return nil
+}
+
+func (d *WifiDriver) Health() iot.Health {
+	return iot.Health{Status: iot.HealthOK}
+}
+
+func (d *WifiDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	return nil, iot.Errorf(iot.Unsupported, "wifi devices are served by their protocol's driver")
+}
+
+func (d *WifiDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	return iot.Errorf(iot.Unsupported, "wifi devices are served by their protocol's driver")
+}
+
+// Scan browses mDNS and sends SSDP searches for the duration, adding every
+// device that answers to the scan store. A new scan replaces a running one.
+func (d *WifiDriver) Scan(duration time.Duration) error {
+	browser, berr := NewBrowser(d.ifi, MDNSGroup)
+	searcher, serr := NewSearcher(d.ifi, SSDPGroup)
+	switch {
+	case berr != nil && serr != nil:
+		return berr
+	case berr != nil:
+		log.Printf("[WiFi] mDNS unavailable, scanning with SSDP only: %v", berr)
+	case serr != nil:
+		log.Printf("[WiFi] SSDP unavailable, scanning with mDNS only: %v", serr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	d.mu.Lock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.cancel = cancel
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	if browser != nil {
+		types := make([]string, 0, len(mdnsServices))
+		for t := range mdnsServices {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		log.Printf("[mDNS] Browsing %s for %s", strings.Join(types, ", "), duration)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			browser.Browse(ctx, types, func(s Service) {
+				found := discoveredService(s)
+				log.Printf("[mDNS] Found %s %q at %s:%d", found.Protocol, s.Instance, found.Host, found.Port)
+				d.addDiscovered(found)
+			})
+		}()
+	}
+	if searcher != nil {
+		log.Printf("[SSDP] Searching for %s", duration)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			searcher.Search(ctx, func(dev UPnPDevice) {
+				found := discoveredUPnP(dev)
+				log.Printf("[SSDP] Found %s %q at %s", found.Protocol, found.Name, dev.Location)
+				d.addDiscovered(found)
+			})
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		log.Println("[WiFi] Scan finished")
+	}()
+	return nil
+}
+
+// addDiscovered adds a scan result. A device answering both mDNS and SSDP
+// ends up as one entry: fields the new result leaves empty keep their
+// values and metadata is merged.
+func (d *WifiDriver) addDiscovered(found store.DiscoveredDevice) {
+	d.resultsMu.Lock()
+	defer d.resultsMu.Unlock()
+
+	scanStore := factory.GetScanStore()
+	existing, ok := scanStore.FindDiscoveredDevice(found.ID)
+	if ok && existing.Protocol == found.Protocol {
+		if found.Host == "" {
+			found.Host, found.Port = existing.Host, existing.Port
+		}
+		if found.Manufacturer == "" {
+			found.Manufacturer = existing.Manufacturer
+		}
+		if found.Model == "" {
+			found.Model = existing.Model
+		}
+		metadata := make(map[string]string, len(existing.Metadata)+len(found.Metadata))
+		for k, v := range existing.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range found.Metadata {
+			metadata[k] = v
+		}
+		found.Metadata = metadata
+	}
+	scanStore.AddDiscoveredDevice(found)
+}
+
+// discoveredService turns an mDNS answer into a scan result. IDs match the
+// ones the protocol's driver registers the device under, where it has one,
+// so adopting the device and the driver connecting to it end up on the
+// same entry.
+func discoveredService(s Service) store.DiscoveredDevice {
+	protocol := mdnsServices[s.Type]
+	found := store.DiscoveredDevice{
+		ID:       protocol + "-" + strings.ToLower(s.Instance),
+		Name:     s.Instance,
+		Type:     protocol,
+		Protocol: protocol,
+		Host:     strings.TrimSuffix(s.Host, "."),
+		Port:     s.Port,
+		Metadata: s.TXT,
+	}
+	for _, ip := range s.Addrs {
+		if ip.To4() != nil {
+			found.Host = ip.String()
+			break
+		}
+	}
+
+	switch protocol {
+	case "esphome":
+		// The instance is the node name, as in the driver's default ID.
+		found.ID = "esphome-" + s.Instance
+		if name := s.TXT["friendly_name"]; name != "" {
+			found.Name = name
+		}
+		found.Model = s.TXT["board"]
+	case "shelly":
+		// Gen2+ devices announce their device ID, e.g. shellyplus1pm-a8032ab12345.
+		found.ID = strings.ToLower(s.Instance)
+		found.Manufacturer = "Shelly"
+		found.Model = s.TXT["app"]
+	case "hue":
+		if id := s.TXT["bridgeid"]; id != "" {
+			found.ID = "hue-" + strings.ToLower(id)
+		}
+		found.Type = "bridge"
+		found.Manufacturer = "Signify"
+		found.Model = s.TXT["modelid"]
+	case "homekit":
+		if id := s.TXT["id"]; id != "" {
+			found.ID = "homekit-" + strings.ToLower(strings.ReplaceAll(id, ":", ""))
+		}
+		found.Model = s.TXT["md"]
+	}
+	return found
+}
+
+// discoveredUPnP turns a UPnP root device into a scan result. Hue bridges
+// announce their bridge ID, so they match the entry mDNS reports for them;
+// other devices have no driver and are identified by their UDN.
+func discoveredUPnP(dev UPnPDevice) store.DiscoveredDevice {
+	desc := dev.Description.Device
+	found := store.DiscoveredDevice{
+		ID:           "upnp-" + strings.ToLower(strings.TrimPrefix(desc.UDN, "uuid:")),
+		Name:         desc.FriendlyName,
+		Type:         "upnp",
+		Protocol:     "upnp",
+		Manufacturer: desc.Manufacturer,
+		Model:        desc.ModelName,
+		Metadata: map[string]string{
+			"device_type": desc.DeviceType,
+			"location":    dev.Location,
+			"udn":         desc.UDN,
+		},
+	}
+	if t, ok := upnpTypes[deviceTypeName(desc.DeviceType)]; ok {
+		found.Type = t
+	}
+	if found.Name == "" {
+		found.Name = desc.ModelName
+	}
+	if u, err := url.Parse(dev.Location); err == nil {
+		found.Host = u.Hostname()
+		found.Port, _ = strconv.Atoi(u.Port())
+		if found.Port == 0 && u.Scheme == "https" {
+			found.Port = 443
+		} else if found.Port == 0 {
+			found.Port = 80
+		}
+	}
+	for k, v := range map[string]string{
+		"model_number":  desc.ModelNumber,
+		"serial_number": desc.SerialNumber,
+		"server":        dev.Server,
+		"services":      strings.Join(desc.ServiceTypes(), ","),
+	} {
+		if v != "" {
+			found.Metadata[k] = v
+		}
+	}
+
+	if id := dev.Headers.Get("Hue-Bridgeid"); id != "" {
+		found.ID = "hue-" + strings.ToLower(id)
+		found.Type = "bridge"
+		found.Protocol = "hue"
+	}
+	return found
+}
@@ -0,0 +1,193 @@
+// Package wsrpc is the websocket client shared by drivers whose servers answer
+// commands by message ID and push events in between, such as zwave-js-server
+// and python-matter-server.
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/iot"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reply is a server's answer to a command.
+type Reply struct {
+	ID     string
+	Result json.RawMessage
+	Err    error // set when the server reports the command failed
+}
+
+// Codec describes a server's message format.
+type Codec interface {
+	// Request builds the message sending command under message ID id.
+	Request(id, command string, args map[string]interface{}) interface{}
+	// Reply decodes msg if it answers a command; ok is false for events.
+	Reply(msg []byte) (r Reply, ok bool)
+}
+
+// Client correlates commands with their replies and hands every other
+// message to the event handler, in the order received.
+type Client struct {
+	conn    *websocket.Conn
+	codec   Codec
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan Reply
+	closed  bool
+	done    chan struct{}
+
+	onEvent func(msg []byte)
+}
+
+// New takes over conn, once the server's greeting has been read, and starts
+// reading from it.
+func New(conn *websocket.Conn, codec Codec, onEvent func(msg []byte)) *Client {
+	c := &Client{
+		conn:    conn,
+		codec:   codec,
+		pending: make(map[string]chan Reply),
+		done:    make(chan struct{}),
+		onEvent: onEvent,
+	}
+	go c.readLoop()
+	return c
+}
+
+// Command sends a command with optional args and waits for its result.
+func (c *Client) Command(ctx context.Context, command string, args map[string]interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, iot.Errorf(iot.TransportError, "connection closed")
+	}
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	ch := make(chan Reply, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	c.writeMu.Lock()
+	err := c.conn.WriteJSON(c.codec.Request(id, command, args))
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, iot.Errorf(iot.TransportError, "%w", err)
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return nil, iot.Errorf(iot.TransportError, "connection closed")
+		}
+		if res.Err != nil {
+			return nil, fmt.Errorf("%s failed: %w", command, res.Err)
+		}
+		return res.Result, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s: %w", command, ctx.Err())
+	}
+}
+
+// readLoop hands replies to the waiting Command call and events to onEvent.
+func (c *Client) readLoop() {
+	defer c.shutdown()
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		reply, ok := c.codec.Reply(msg)
+		if !ok {
+			if c.onEvent != nil {
+				c.onEvent(msg)
+			}
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[reply.ID]
+		c.mu.Unlock()
+		if ok {
+			ch <- reply
+		}
+	}
+}
+
+func (c *Client) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	close(c.done)
+}
+
+// Done is closed when the connection drops.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Connected reports whether c is non-nil and its connection is still up.
+func (c *Client) Connected() bool {
+	if c == nil {
+		return false
+	}
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Reconnect keeps a connection open until stop is closed: it calls connect,
+// waits for the connection to drop and connects again, backing off up to 30s
+// while connecting fails. logPrefix and url are used in log messages.
+func Reconnect(stop <-chan struct{}, logPrefix, url string, connect func() (*Client, error)) {
+	backoff := time.Second
+	for {
+		client, err := connect()
+		if err != nil {
+			log.Printf("%s Connection to %s failed: %v (retrying in %s)", logPrefix, url, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+		select {
+		case <-client.Done():
+			log.Printf("%s Connection lost", logPrefix)
+		case <-stop:
+			client.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package zigbee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/iot"
+	imqtt "iot-bridge/internal/iot/mqtt"
+	"iot-bridge/internal/store"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// bridgeResponse is the reply zigbee2mqtt publishes on bridge/response/<request>.
+type bridgeResponse struct {
+	Data        json.RawMessage `json:"data"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error"`
+	Transaction string          `json:"transaction"`
+}
+
+var (
+	pendingMu     sync.Mutex
+	nextTxn       int
+	pendingByTxn  = make(map[string]chan bridgeResponse)
+	bridgeTxnBase = strconv.FormatInt(time.Now().UnixNano(), 36)
+)
+
+// bridgeRequest publishes to <base topic>/bridge/request/<path> and waits for
+// the matching bridge/response, correlated by transaction ID, until ctx is
+// done.
+func bridgeRequest(ctx context.Context, path string, payload map[string]interface{}) (json.RawMessage, error) {
+	if mqttClient == nil || !mqttClient.IsConnected() {
+		return nil, iot.Errorf(iot.TransportError, "not connected to MQTT broker")
+	}
+
+	pendingMu.Lock()
+	nextTxn++
+	txn := fmt.Sprintf("%s-%d", bridgeTxnBase, nextTxn)
+	ch := make(chan bridgeResponse, 1)
+	pendingByTxn[txn] = ch
+	pendingMu.Unlock()
+	defer func() {
+		pendingMu.Lock()
+		delete(pendingByTxn, txn)
+		pendingMu.Unlock()
+	}()
+
+	payload["transaction"] = txn
+	data, _ := json.Marshal(payload)
+	if err := imqtt.Wait(ctx, mqttClient.Publish(topic("bridge/request/"+path), qos, false, data)); err != nil {
+		return nil, fmt.Errorf("publish %s request: %w", path, err)
+	}
+
+	select {
+	case res := <-ch:
+		if res.Status != "ok" {
+			return nil, fmt.Errorf("zigbee2mqtt %s failed: %s", path, res.Error)
+		}
+		return res.Data, nil
+	case <-ctx.Done():
+		return nil, iot.Errorf(iot.Timeout, "no response from zigbee2mqtt to %s: %w", path, ctx.Err())
+	}
+}
+
+func bridgeResponseHandler(client mqtt.Client, msg mqtt.Message) {
+	var res bridgeResponse
+	if err := json.Unmarshal(msg.Payload(), &res); err != nil || res.Transaction == "" {
+		return
+	}
+	pendingMu.Lock()
+	ch, ok := pendingByTxn[res.Transaction]
+	pendingMu.Unlock()
+	// A duplicate response must not block the MQTT client's delivery; the
+	// waiting request only needs the first one.
+	if ok {
+		select {
+		case ch <- res:
+		default:
+		}
+	}
+}
+
+// friendlyNameFor returns the zigbee2mqtt name used in topics for a device,
+// and whether zigbee2mqtt knows the device at all.
+func friendlyNameFor(deviceID string) (string, bool) {
+	bridgeMu.RLock()
+	defer bridgeMu.RUnlock()
+	if entry, ok := bridgeDevices[deviceID]; ok {
+		return entry.FriendlyName, true
+	}
+	return deviceID, false
+}
+
+// RenameDevice changes the device's friendly name in zigbee2mqtt. Devices
+// zigbee2mqtt doesn't list are left alone.
+func (z *ZigbeeDriver) RenameDevice(ctx context.Context, device store.Device, name string) error {
+	from, known := friendlyNameFor(device.ID)
+	if !known || from == name {
+		return nil
+	}
+	if _, err := bridgeRequest(ctx, "device/rename", map[string]interface{}{"from": from, "to": name}); err != nil {
+		return err
+	}
+
+	bridgeMu.Lock()
+	if ieee, ok := friendlyToIEEE[from]; ok {
+		delete(friendlyToIEEE, from)
+		friendlyToIEEE[name] = ieee
+		entry := bridgeDevices[ieee]
+		entry.FriendlyName = name
+		bridgeDevices[ieee] = entry
+	}
+	bridgeMu.Unlock()
+
+	log.Printf("[Zigbee] Renamed %s from %q to %q", device.ID, from, name)
+	return nil
+}
+
+// RemoveDevice removes the device from the zigbee network. With force the
+// device is dropped from zigbee2mqtt's database even if it doesn't respond.
+func (z *ZigbeeDriver) RemoveDevice(ctx context.Context, device store.Device, force bool) error {
+	name, known := friendlyNameFor(device.ID)
+	if !known {
+		return nil
+	}
+	if _, err := bridgeRequest(ctx, "device/remove", map[string]interface{}{"id": name, "force": force}); err != nil {
+		return err
+	}
+
+	bridgeMu.Lock()
+	delete(friendlyToIEEE, name)
+	removedNames[name] = true
+	delete(bridgeDevices, device.ID)
+	delete(deviceFeatures, device.ID)
+	bridgeMu.Unlock()
+
+	stateMu.Lock()
+	delete(deviceStates, device.ID)
+	delete(availability, device.ID)
+	stateMu.Unlock()
+
+	log.Printf("[Zigbee] Removed %s (%s) from the network", device.ID, name)
+	return nil
+}
@@ -0,0 +1,220 @@
+package zigbee
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+const lampIEEE = "0x0017880100000001"
+
+// listLamp has zigbee2mqtt list a single color bulb named "Desk lamp".
+func listLamp(t *testing.T) store.Device {
+	t.Helper()
+	bridgeDevicesHandler(nil, &testMessage{topic: "zigbee2mqtt/bridge/devices", payload: []byte(`[
+		{"ieee_address": "0x00124b0000000000", "type": "Coordinator", "friendly_name": "Coordinator"},
+		{"ieee_address": "` + lampIEEE + `", "type": "Router", "friendly_name": "Desk lamp", "supported": true,
+		 "definition": {"vendor": "Philips", "model": "9290022166", "exposes": ` + lightExposes + `}}]`)})
+	device, ok := factory.GetDeviceStore().Get(lampIEEE)
+	if !ok {
+		t.Fatal("listed device not registered")
+	}
+	return device
+}
+
+func TestBridgeRequest(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+	bridge.respond("device/options", `{"status": "ok", "data": {"id": "Desk lamp", "options": {"transition": 1}}}`)
+
+	data, err := bridgeRequest(context.Background(), "device/options", map[string]interface{}{"id": "Desk lamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"transition":1`) {
+		t.Errorf("data = %s", data)
+	}
+	requests := bridge.requests("device/options")
+	if len(requests) != 1 || requests[0]["id"] != "Desk lamp" || requests[0]["transaction"] == "" {
+		t.Errorf("requests = %v", requests)
+	}
+	if len(pendingByTxn) != 0 {
+		t.Errorf("%d requests still pending", len(pendingByTxn))
+	}
+}
+
+func TestBridgeRequestErrorResponse(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+	bridge.respond("device/options", `{"status": "error", "error": "Device 'Desk lamp' does not exist", "data": {}}`)
+
+	_, err := bridgeRequest(context.Background(), "device/options", map[string]interface{}{"id": "Desk lamp"})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("err = %v, want the bridge's error", err)
+	}
+}
+
+func TestBridgeRequestTimeout(t *testing.T) {
+	resetZigbee(t)
+	newFakeBridge(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := bridgeRequest(ctx, "device/options", map[string]interface{}{}); iot.KindOf(err) != iot.Timeout {
+		t.Errorf("err = %v, want timeout", err)
+	}
+	if len(pendingByTxn) != 0 {
+		t.Errorf("%d requests still pending", len(pendingByTxn))
+	}
+}
+
+func TestBridgeRequestNotConnected(t *testing.T) {
+	resetZigbee(t)
+	if _, err := bridgeRequest(context.Background(), "device/options", map[string]interface{}{}); iot.KindOf(err) != iot.TransportError {
+		t.Errorf("err = %v, want transport error", err)
+	}
+}
+
+func TestBridgeResponseHandlerDoesNotBlock(t *testing.T) {
+	ch := make(chan bridgeResponse, 1)
+	pendingMu.Lock()
+	pendingByTxn["txn-1"] = ch
+	pendingMu.Unlock()
+	defer func() {
+		pendingMu.Lock()
+		delete(pendingByTxn, "txn-1")
+		pendingMu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg := &testMessage{topic: "zigbee2mqtt/bridge/response/device/remove"}
+		for _, payload := range []string{
+			`{"status": "ok", "transaction": "other"}`,
+			`{"status": "ok", "transaction": "txn-1"}`,
+			`{"status": "ok", "transaction": "txn-1"}`, // duplicate
+		} {
+			msg.payload = []byte(payload)
+			bridgeResponseHandler(nil, msg)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("bridgeResponseHandler blocked on a duplicate response")
+	}
+	if res := <-ch; res.Transaction != "txn-1" {
+		t.Errorf("response = %+v", res)
+	}
+}
+
+func TestRenameDevice(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+	device := listLamp(t)
+	bridge.respond("device/rename", `{"status": "ok", "data": {"from": "Desk lamp", "to": "Reading lamp"}}`)
+
+	if err := driver.RenameDevice(context.Background(), device, "Reading lamp"); err != nil {
+		t.Fatal(err)
+	}
+	requests := bridge.requests("device/rename")
+	if len(requests) != 1 || requests[0]["from"] != "Desk lamp" || requests[0]["to"] != "Reading lamp" {
+		t.Errorf("requests = %v", requests)
+	}
+	if name, _ := friendlyNameFor(lampIEEE); name != "Reading lamp" {
+		t.Errorf("friendly name = %q", name)
+	}
+	if id, _, _ := resolveDeviceID("Reading lamp"); id != lampIEEE {
+		t.Errorf("new name resolves to %q", id)
+	}
+	if id, known, _ := resolveDeviceID("Desk lamp"); known {
+		t.Errorf("old name still resolves to %q", id)
+	}
+
+	// Same name and devices zigbee2mqtt doesn't list need no request.
+	if err := driver.RenameDevice(context.Background(), device, "Reading lamp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.RenameDevice(context.Background(), store.Device{ID: "other"}, "Other"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := bridge.requests("device/rename"); len(requests) != 1 {
+		t.Errorf("%d rename requests, want 1", len(requests))
+	}
+}
+
+func TestRenameDeviceError(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+	device := listLamp(t)
+	bridge.respond("device/rename", `{"status": "error", "error": "Friendly name 'Reading lamp' is already in use", "data": {}}`)
+
+	err := driver.RenameDevice(context.Background(), device, "Reading lamp")
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("err = %v, want the bridge's error", err)
+	}
+	if name, _ := friendlyNameFor(lampIEEE); name != "Desk lamp" {
+		t.Errorf("friendly name = %q after a failed rename", name)
+	}
+}
+
+func TestRemoveDevice(t *testing.T) {
+	for _, force := range []bool{false, true} {
+		t.Run(fmt.Sprintf("force=%v", force), func(t *testing.T) {
+			resetZigbee(t)
+			bridge := newFakeBridge(t, nil)
+			device := listLamp(t)
+			messageHandler(nil, &testMessage{topic: "zigbee2mqtt/Desk lamp", payload: []byte(`{"state": "ON"}`)})
+			bridge.respond("device/remove", `{"status": "ok", "data": {"id": "Desk lamp"}}`)
+
+			if err := driver.RemoveDevice(context.Background(), device, force); err != nil {
+				t.Fatal(err)
+			}
+			requests := bridge.requests("device/remove")
+			if len(requests) != 1 || requests[0]["id"] != "Desk lamp" || requests[0]["force"] != force {
+				t.Errorf("requests = %v, want id Desk lamp, force %v", requests, force)
+			}
+			if _, known := friendlyNameFor(lampIEEE); known {
+				t.Error("removed device still listed")
+			}
+			stateMu.RLock()
+			_, hasState := deviceStates[lampIEEE]
+			stateMu.RUnlock()
+			if hasState {
+				t.Error("removed device still has state")
+			}
+
+			// A late state message must not register the device again under
+			// its friendly name.
+			messageHandler(nil, &testMessage{topic: "zigbee2mqtt/Desk lamp", payload: []byte(`{"state": "OFF"}`)})
+			if _, ok := factory.GetDeviceStore().Get("Desk lamp"); ok {
+				t.Error("late state re-registered the removed device")
+			}
+		})
+	}
+}
+
+func TestRemoveDeviceError(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+	device := listLamp(t)
+	bridge.respond("device/remove", `{"status": "error", "error": "Failed to remove device 'Desk lamp' (no response)", "data": {}}`)
+
+	err := driver.RemoveDevice(context.Background(), device, false)
+	if err == nil || !strings.Contains(err.Error(), "no response") {
+		t.Errorf("err = %v, want the bridge's error", err)
+	}
+	if name, known := friendlyNameFor(lampIEEE); !known || name != "Desk lamp" {
+		t.Errorf("device no longer listed after a failed removal")
+	}
+	if _, _, ignored := resolveDeviceID("Desk lamp"); ignored {
+		t.Error("state of a device that wasn't removed is ignored")
+	}
+}
@@ -0,0 +1,125 @@
+package zigbee
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/store"
+)
+
+// confirmTimeout is how long SetStateConfirmed waits for the device's echo
+// when the caller's context allows longer.
+const confirmTimeout = 5 * time.Second
+
+var (
+	waitersMu sync.Mutex
+	waiters   = make(map[string]map[chan store.State]bool) // device ID -> waiting calls
+)
+
+// SetStateConfirmed publishes updates like SetState, then waits for the
+// device to publish its state and checks it against the request. It returns
+// the reported values of the requested keys. If the device reports other
+// values it returns them with an iot.Mismatch error; if it doesn't answer in
+// time the error is an iot.Timeout.
+func (z *ZigbeeDriver) SetStateConfirmed(ctx context.Context, device store.Device, updates store.State) (store.State, error) {
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+
+	ch := make(chan store.State, 8)
+	waitersMu.Lock()
+	if waiters[device.ID] == nil {
+		waiters[device.ID] = make(map[chan store.State]bool)
+	}
+	waiters[device.ID][ch] = true
+	waitersMu.Unlock()
+	defer func() {
+		waitersMu.Lock()
+		delete(waiters[device.ID], ch)
+		if len(waiters[device.ID]) == 0 {
+			delete(waiters, device.ID)
+		}
+		waitersMu.Unlock()
+	}()
+
+	if err := publishState(ctx, device, updates); err != nil {
+		return nil, err
+	}
+
+	bridgeMu.RLock()
+	features := deviceFeatures[device.ID]
+	bridgeMu.RUnlock()
+
+	// Devices may report intermediate states (e.g. during a transition), so
+	// keep reading until one matches or time runs out.
+	var reported store.State
+	for {
+		select {
+		case state := <-ch:
+			reported = appliedValues(updates, state)
+			if confirms(updates, reported, features) {
+				return reported, nil
+			}
+		case <-ctx.Done():
+			if len(reported) > 0 {
+				return reported, iot.Errorf(iot.Mismatch, "%s reported %v, requested %v", device.ID, reported, updates)
+			}
+			return nil, iot.Errorf(iot.Timeout, "%s did not report its state: %w", device.ID, ctx.Err())
+		}
+	}
+}
+
+func notifyStateWaiters(deviceID string, state store.State) {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+	for ch := range waiters[deviceID] {
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+// appliedValues picks the reported values for the requested keys.
+func appliedValues(updates, state store.State) store.State {
+	applied := make(store.State)
+	for key := range updates {
+		if v, ok := state[key]; ok {
+			applied[key] = v
+		}
+	}
+	return applied
+}
+
+// confirms reports whether every requested value was applied. Toggles only
+// need a report; numbers are compared with a small tolerance because devices
+// round (e.g. color coordinates to four decimals).
+func confirms(updates, reported store.State, features map[string]feature) bool {
+	for key, value := range updates {
+		v, ok := reported[key]
+		if !ok {
+			return false
+		}
+		want, got := store.FormatValue(value), store.FormatValue(v)
+		if isToggle(want, features[key]) {
+			continue
+		}
+		if strings.EqualFold(got, want) {
+			continue
+		}
+		w, errW := strconv.ParseFloat(want, 64)
+		g, errG := strconv.ParseFloat(got, 64)
+		if errW != nil || errG != nil || math.Abs(w-g) > 0.001*math.Max(1, math.Abs(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isToggle(value string, f feature) bool {
+	return strings.EqualFold(value, "toggle") || f.ValueToggle != nil && strings.EqualFold(value, stringValue(f.ValueToggle))
+}
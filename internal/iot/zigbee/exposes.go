@@ -0,0 +1,359 @@
+package zigbee
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/store"
+)
+
+// Access bits on a zigbee2mqtt expose.
+const (
+	accessState = 1 // published in the device state
+	accessSet   = 2 // can be written via <device>/set
+	accessGet   = 4 // can be read via <device>/get
+)
+
+// bridgeDevice is one entry of the retained zigbee2mqtt/bridge/devices list.
+type bridgeDevice struct {
+	IEEEAddress        string      `json:"ieee_address"`
+	FriendlyName       string      `json:"friendly_name"`
+	Type               string      `json:"type"`
+	Supported          bool        `json:"supported"`
+	InterviewCompleted bool        `json:"interview_completed"`
+	Manufacturer       string      `json:"manufacturer"`
+	ModelID            string      `json:"model_id"`
+	Definition         *definition `json:"definition"`
+}
+
+type definition struct {
+	Model       string   `json:"model"`
+	Vendor      string   `json:"vendor"`
+	Description string   `json:"description"`
+	Exposes     []expose `json:"exposes"`
+}
+
+// expose is a zigbee2mqtt expose definition. Generic exposes (binary,
+// numeric, enum, text, composite, list) describe a single property; specific
+// ones (light, switch, cover, climate, lock, fan) only group features.
+type expose struct {
+	Type        string        `json:"type"`
+	Name        string        `json:"name"`
+	Label       string        `json:"label"`
+	Property    string        `json:"property"`
+	Description string        `json:"description"`
+	Access      int           `json:"access"`
+	Unit        string        `json:"unit"`
+	ValueMin    *float64      `json:"value_min"`
+	ValueMax    *float64      `json:"value_max"`
+	ValueStep   *float64      `json:"value_step"`
+	ValueOn     interface{}   `json:"value_on"`
+	ValueOff    interface{}   `json:"value_off"`
+	ValueToggle interface{}   `json:"value_toggle"`
+	Values      []interface{} `json:"values"`
+	Features    []expose      `json:"features"`
+}
+
+// feature is a settable or readable property found in a device's exposes.
+// Composite sub-features are addressed as "<parent>.<property>".
+type feature struct {
+	expose
+	parent string
+}
+
+// featuresFromExposes flattens exposes into a map keyed by state key.
+func featuresFromExposes(exposes []expose) map[string]feature {
+	features := make(map[string]feature)
+	var walk func(list []expose, parent string)
+	walk = func(list []expose, parent string) {
+		for _, e := range list {
+			switch e.Type {
+			case "binary", "numeric", "enum", "text":
+				key := e.Property
+				if parent != "" {
+					key = parent + "." + e.Property
+				}
+				if key != "" {
+					features[key] = feature{expose: e, parent: parent}
+				}
+			case "composite":
+				if e.Property != "" && parent == "" {
+					walk(e.Features, e.Property)
+				}
+			case "list":
+				// Lists (schedules, scenes) don't map onto flat parameters.
+			default:
+				walk(e.Features, parent)
+			}
+		}
+	}
+	walk(exposes, "")
+	return features
+}
+
+// capabilitiesFromExposes builds one capability per generic expose, and one
+// per composite with a parameter for each sub-feature. The on/off "state"
+// property is named "power" like every other driver's on/off capability.
+func capabilitiesFromExposes(exposes []expose) []store.Capability {
+	var caps []store.Capability
+	var walk func(list []expose)
+	walk = func(list []expose) {
+		for _, e := range list {
+			switch e.Type {
+			case "binary", "numeric", "enum", "text":
+				if e.Property == "" {
+					continue
+				}
+				spec := paramSpec(e)
+				ops := operationsFor(e)
+				if spec["type"] == "integer" {
+					ops = []string{"set"}
+				}
+				caps = append(caps, store.Capability{
+					Name:        capabilityName(e.Property),
+					Description: describe(e),
+					Operations:  ops,
+					Writable:    e.Access&accessSet != 0,
+					Parameters: map[string]interface{}{
+						e.Property: spec,
+					},
+				})
+			case "composite":
+				if e.Property == "" {
+					continue
+				}
+				cap := store.Capability{
+					Name:        e.Name,
+					Description: describe(e),
+					Writable:    e.Access&accessSet != 0,
+					Parameters:  map[string]interface{}{},
+				}
+				for _, sub := range e.Features {
+					if sub.Property != "" && sub.Type != "composite" && sub.Type != "list" {
+						cap.Parameters[e.Property+"."+sub.Property] = paramSpec(sub)
+					}
+				}
+				caps = append(caps, cap)
+			case "list":
+			default:
+				walk(e.Features)
+			}
+		}
+	}
+	walk(exposes)
+
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Name < caps[j].Name })
+	return caps
+}
+
+func capabilityName(property string) string {
+	if property == "state" {
+		return "power"
+	}
+	if strings.HasPrefix(property, "state_") {
+		return "power_" + strings.TrimPrefix(property, "state_")
+	}
+	return property
+}
+
+func describe(e expose) string {
+	desc := e.Description
+	if desc == "" {
+		desc = e.Label
+	}
+	if desc == "" {
+		desc = strings.ReplaceAll(e.Name, "_", " ")
+	}
+	if e.Type == "numeric" && e.ValueMin != nil && e.ValueMax != nil {
+		desc = fmt.Sprintf("%s (%g-%g)", desc, *e.ValueMin, *e.ValueMax)
+	}
+	if e.Unit != "" {
+		desc = fmt.Sprintf("%s [%s]", desc, e.Unit)
+	}
+	return desc
+}
+
+// operationsFor lists the accepted values of writable binary and enum exposes.
+func operationsFor(e expose) []string {
+	if e.Access&accessSet == 0 {
+		return nil
+	}
+	switch e.Type {
+	case "binary":
+		if isOnOff(e) {
+			ops := []string{"on", "off"}
+			if e.ValueToggle != nil {
+				ops = append(ops, "toggle")
+			}
+			return ops
+		}
+		ops := []string{stringValue(e.ValueOn), stringValue(e.ValueOff)}
+		if e.ValueToggle != nil {
+			ops = append(ops, stringValue(e.ValueToggle))
+		}
+		return ops
+	case "enum":
+		return enumValues(e)
+	}
+	return nil
+}
+
+func paramSpec(e expose) map[string]interface{} {
+	spec := map[string]interface{}{}
+	switch e.Type {
+	case "binary", "enum":
+		spec["type"] = "string"
+		if _, ok := e.ValueOn.(bool); ok && e.Type == "binary" {
+			spec["type"] = "boolean"
+		}
+		if ops := operationsFor(e); ops != nil {
+			spec["operations"] = ops
+		}
+	case "numeric":
+		writable := e.Access&accessSet != 0
+		integral := e.ValueStep == nil || *e.ValueStep == math.Trunc(*e.ValueStep)
+		if writable && integral && e.ValueMin != nil && e.ValueMax != nil {
+			spec["type"] = "integer"
+			spec["range"] = []int{int(*e.ValueMin), int(*e.ValueMax)}
+		} else {
+			spec["type"] = "number"
+			if e.ValueMin != nil && e.ValueMax != nil {
+				spec["range"] = []float64{*e.ValueMin, *e.ValueMax}
+			}
+			if e.ValueStep != nil {
+				spec["step"] = *e.ValueStep
+			}
+		}
+	default:
+		spec["type"] = "string"
+	}
+	if e.Unit != "" {
+		spec["unit"] = e.Unit
+	}
+	return spec
+}
+
+// isOnOff reports whether a binary expose uses ON/OFF style payloads, which
+// are presented as "on"/"off" like the other drivers.
+func isOnOff(e expose) bool {
+	return strings.EqualFold(stringValue(e.ValueOn), "on") && strings.EqualFold(stringValue(e.ValueOff), "off")
+}
+
+func enumValues(e expose) []string {
+	values := make([]string, 0, len(e.Values))
+	for _, v := range e.Values {
+		values = append(values, stringValue(v))
+	}
+	return values
+}
+
+func stringValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// deviceTypeFromExposes picks a bridge device type from the specific exposes.
+func deviceTypeFromExposes(exposes []expose) string {
+	for _, e := range exposes {
+		switch e.Type {
+		case "light":
+			return "bulb"
+		case "switch":
+			return "switch"
+		case "cover":
+			return "cover"
+		case "climate":
+			return "thermostat"
+		case "lock":
+			return "lock"
+		case "fan":
+			return "fan"
+		}
+	}
+	for _, f := range featuresFromExposes(exposes) {
+		if f.Access&accessSet != 0 {
+			return "zigbee"
+		}
+	}
+	return "sensor"
+}
+
+// encodeValue converts a validated value into the JSON type the expose
+// expects. Binaries accept their raw values as well as on/off and true/false
+// words; numbers given as strings are parsed.
+func (f feature) encodeValue(v interface{}) (interface{}, error) {
+	value := store.FormatValue(v)
+	switch f.Type {
+	case "binary":
+		candidates := []struct {
+			raw   interface{}
+			words []string
+		}{
+			{f.ValueOn, []string{"on", "true", "1"}},
+			{f.ValueOff, []string{"off", "false", "0"}},
+			{f.ValueToggle, []string{"toggle"}},
+		}
+		for _, c := range candidates {
+			if c.raw == nil {
+				continue
+			}
+			if strings.EqualFold(value, stringValue(c.raw)) {
+				return c.raw, nil
+			}
+			for _, w := range c.words {
+				if strings.EqualFold(value, w) {
+					return c.raw, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("invalid value %q for %s", value, f.Property)
+	case "numeric":
+		if n, ok := v.(float64); ok {
+			return n, nil
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q for %s", value, f.Property)
+		}
+		return n, nil
+	case "enum", "text":
+		return value, nil
+	}
+	return v, nil
+}
+
+// stateFromPayload converts a device state message into state, keeping the
+// JSON types. On/off binaries become "on"/"off" and composite objects are
+// flattened to "<parent>.<property>" keys matching their capability
+// parameters.
+func stateFromPayload(raw map[string]interface{}, features map[string]feature) store.State {
+	state := make(store.State)
+	for k, v := range raw {
+		if obj, ok := v.(map[string]interface{}); ok && hasChildren(features, k) {
+			for sub, sv := range obj {
+				state[k+"."+sub] = sv
+			}
+			continue
+		}
+		if f, ok := features[k]; ok && f.Type == "binary" && isOnOff(f.expose) {
+			state[k] = strings.ToLower(stringValue(v))
+			continue
+		}
+		state[k] = v
+	}
+	return state
+}
+
+func hasChildren(features map[string]feature, parent string) bool {
+	for _, f := range features {
+		if f.parent == parent {
+			return true
+		}
+	}
+	return false
+}
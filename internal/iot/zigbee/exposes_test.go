@@ -0,0 +1,168 @@
+package zigbee
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"iot-bridge/internal/store"
+)
+
+// lightExposes is a trimmed zigbee2mqtt definition of a color bulb with a
+// power-on behavior setting and an effect list.
+const lightExposes = `[
+	{"type": "light", "features": [
+		{"type": "binary", "name": "state", "property": "state", "access": 7, "value_on": "ON", "value_off": "OFF", "value_toggle": "TOGGLE"},
+		{"type": "numeric", "name": "brightness", "property": "brightness", "access": 7, "value_min": 0, "value_max": 254},
+		{"type": "composite", "name": "color_xy", "property": "color", "access": 7, "features": [
+			{"type": "numeric", "name": "x", "property": "x", "access": 7},
+			{"type": "numeric", "name": "y", "property": "y", "access": 7}
+		]}
+	]},
+	{"type": "enum", "name": "power_on_behavior", "property": "power_on_behavior", "access": 7, "values": ["off", "on", "previous"]},
+	{"type": "binary", "name": "child_lock", "property": "child_lock", "access": 3, "value_on": true, "value_off": false},
+	{"type": "numeric", "name": "linkquality", "property": "linkquality", "access": 1, "unit": "lqi"},
+	{"type": "list", "name": "schedule", "property": "schedule", "access": 2},
+	{"type": "text", "name": "effect", "property": "effect", "access": 2}
+]`
+
+func testFeatures(t *testing.T) map[string]feature {
+	t.Helper()
+	var exposes []expose
+	if err := json.Unmarshal([]byte(lightExposes), &exposes); err != nil {
+		t.Fatal(err)
+	}
+	return featuresFromExposes(exposes)
+}
+
+func TestFeaturesFromExposes(t *testing.T) {
+	features := testFeatures(t)
+
+	keys := make([]string, 0, len(features))
+	for k := range features {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	want := []string{"brightness", "child_lock", "color.x", "color.y", "effect", "linkquality", "power_on_behavior", "state"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	if p := features["color.x"].parent; p != "color" {
+		t.Errorf("color.x parent = %q, want color", p)
+	}
+	if p := features["state"].parent; p != "" {
+		t.Errorf("state parent = %q, want none", p)
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	features := testFeatures(t)
+
+	tests := []struct {
+		key     string
+		in      interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{key: "state", in: "on", want: "ON"},
+		{key: "state", in: "OFF", want: "OFF"},
+		{key: "state", in: true, want: "ON"},
+		{key: "state", in: "toggle", want: "TOGGLE"},
+		{key: "state", in: "dim", wantErr: true},
+		{key: "child_lock", in: "on", want: true},
+		{key: "child_lock", in: false, want: false},
+		{key: "brightness", in: float64(120), want: float64(120)},
+		{key: "brightness", in: 80, want: float64(80)},
+		{key: "brightness", in: "42", want: float64(42)},
+		{key: "brightness", in: "bright", wantErr: true},
+		{key: "color.x", in: 0.3, want: 0.3},
+		{key: "power_on_behavior", in: "previous", want: "previous"},
+		{key: "effect", in: "blink", want: "blink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+store.FormatValue(tt.in), func(t *testing.T) {
+			got, err := features[tt.key].encodeValue(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodeValue(%v) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("encodeValue(%v) = %v (%T), want %v (%T)", tt.in, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateFromPayload(t *testing.T) {
+	features := testFeatures(t)
+
+	tests := []struct {
+		name    string
+		payload string
+		want    store.State
+	}{
+		{
+			name:    "on/off binary",
+			payload: `{"state": "ON", "brightness": 200}`,
+			want:    store.State{"state": "on", "brightness": float64(200)},
+		},
+		{
+			name:    "true/false binary keeps its type",
+			payload: `{"child_lock": true}`,
+			want:    store.State{"child_lock": true},
+		},
+		{
+			name:    "composite is flattened",
+			payload: `{"color": {"x": 0.31, "y": 0.32}}`,
+			want:    store.State{"color.x": 0.31, "color.y": 0.32},
+		},
+		{
+			name:    "unknown objects are kept",
+			payload: `{"update": {"state": "idle"}, "linkquality": 120}`,
+			want:    store.State{"update": map[string]interface{}{"state": "idle"}, "linkquality": float64(120)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.payload), &raw); err != nil {
+				t.Fatal(err)
+			}
+			if got := stateFromPayload(raw, features); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stateFromPayload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesFromExposes(t *testing.T) {
+	var exposes []expose
+	if err := json.Unmarshal([]byte(lightExposes), &exposes); err != nil {
+		t.Fatal(err)
+	}
+	caps := map[string]store.Capability{}
+	for _, c := range capabilitiesFromExposes(exposes) {
+		caps[c.Name] = c
+	}
+
+	power, ok := caps["power"]
+	if !ok || !power.Writable || !reflect.DeepEqual(power.Operations, []string{"on", "off", "toggle"}) {
+		t.Errorf("power = %+v", power)
+	}
+	brightness := caps["brightness"].Parameters["brightness"].(map[string]interface{})
+	if brightness["type"] != "integer" || !reflect.DeepEqual(brightness["range"], []int{0, 254}) {
+		t.Errorf("brightness spec = %v", brightness)
+	}
+	if _, ok := caps["color_xy"].Parameters["color.x"]; !ok {
+		t.Errorf("color_xy parameters = %v", caps["color_xy"].Parameters)
+	}
+	if caps["linkquality"].Writable {
+		t.Error("linkquality is writable")
+	}
+	if _, ok := caps["schedule"]; ok {
+		t.Error("list expose became a capability")
+	}
+	if dt := deviceTypeFromExposes(exposes); dt != "bulb" {
+		t.Errorf("deviceTypeFromExposes() = %q, want bulb", dt)
+	}
+}
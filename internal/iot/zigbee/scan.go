@@ -0,0 +1,144 @@
+package zigbee
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MaxPermitJoin is the longest join window zigbee2mqtt accepts.
+const MaxPermitJoin = 254 * time.Second
+
+var (
+	scanMu       sync.Mutex
+	scanDeadline time.Time
+	scanTimer    *time.Timer
+)
+
+// PermitJoin opens the network for new devices for the given duration.
+// Devices that join or are interviewed meanwhile are added to the scan store.
+func PermitJoin(duration time.Duration) error {
+	if mqttClient == nil || !mqttClient.IsConnected() {
+		return errors.New("not connected to MQTT broker")
+	}
+	if duration <= 0 || duration > MaxPermitJoin {
+		duration = MaxPermitJoin
+	}
+	seconds := int((duration + time.Second - 1) / time.Second)
+
+	if err := publishPermitJoin(true, seconds); err != nil {
+		return err
+	}
+
+	scanMu.Lock()
+	scanDeadline = time.Now().Add(duration)
+	if scanTimer != nil {
+		scanTimer.Stop()
+	}
+	scanTimer = time.AfterFunc(duration, closePermitJoin)
+	scanMu.Unlock()
+
+	log.Printf("[Zigbee] Permit join open for %ds", seconds)
+	return nil
+}
+
+// closePermitJoin ends the scan window. zigbee2mqtt closes joining itself when
+// the time expires; the explicit request covers bridges that ignore "time".
+func closePermitJoin() {
+	scanMu.Lock()
+	scanDeadline = time.Time{}
+	scanTimer = nil
+	scanMu.Unlock()
+
+	if err := publishPermitJoin(false, 0); err != nil {
+		log.Printf("[Zigbee] Failed to close permit join: %v", err)
+		return
+	}
+	log.Println("[Zigbee] Permit join closed")
+}
+
+func publishPermitJoin(open bool, seconds int) error {
+	payload, _ := json.Marshal(map[string]interface{}{"value": open, "time": seconds})
+	token := mqttClient.Publish(topic("bridge/request/permit_join"), qos, false, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		return errors.New("timed out publishing permit_join")
+	}
+	return token.Error()
+}
+
+func scanning() bool {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+	return time.Now().Before(scanDeadline)
+}
+
+// bridgeEventHandler records devices that join or finish their interview
+// while a scan is running.
+func bridgeEventHandler(client mqtt.Client, msg mqtt.Message) {
+	if !scanning() {
+		return
+	}
+
+	var ev struct {
+		Type string `json:"type"`
+		Data struct {
+			FriendlyName string      `json:"friendly_name"`
+			IEEEAddress  string      `json:"ieee_address"`
+			Status       string      `json:"status"`
+			Supported    bool        `json:"supported"`
+			Definition   *definition `json:"definition"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Payload(), &ev); err != nil || ev.Data.IEEEAddress == "" {
+		return
+	}
+	switch ev.Type {
+	case "device_joined", "device_announce", "device_interview":
+	default:
+		return
+	}
+
+	scanStore := factory.GetScanStore()
+	found, ok := scanStore.FindDiscoveredDevice(ev.Data.IEEEAddress)
+	if !ok {
+		found = store.DiscoveredDevice{
+			ID:       ev.Data.IEEEAddress,
+			Name:     ev.Data.IEEEAddress,
+			Type:     "zigbee",
+			Protocol: "zigbee",
+		}
+		log.Printf("[Zigbee] Device joined: %s", ev.Data.IEEEAddress)
+	}
+	if ev.Data.FriendlyName != "" {
+		found.Name = ev.Data.FriendlyName
+	}
+	if ev.Type == "device_interview" && ev.Data.Status == "successful" && ev.Data.Definition != nil {
+		found.Type = deviceTypeFromExposes(ev.Data.Definition.Exposes)
+		if found.Name == found.ID {
+			found.Name = ev.Data.Definition.Vendor + " " + ev.Data.Definition.Model
+		}
+		log.Printf("[Zigbee] Interview completed for %s (%s %s)", found.ID, ev.Data.Definition.Vendor, ev.Data.Definition.Model)
+	}
+	scanStore.AddDiscoveredDevice(found)
+}
+
+// recordLinkQuality updates the link quality of a device found by the
+// current scan from its state messages.
+func recordLinkQuality(deviceID string, raw map[string]interface{}) {
+	lqi, ok := raw["linkquality"].(float64)
+	if !ok || !scanning() {
+		return
+	}
+	scanStore := factory.GetScanStore()
+	if found, ok := scanStore.FindDiscoveredDevice(deviceID); ok {
+		found.LinkQuality = int(lqi)
+		scanStore.AddDiscoveredDevice(found)
+	}
+}
@@ -0,0 +1,92 @@
+package zigbee
+
+import (
+	"testing"
+	"time"
+
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+func TestPermitJoin(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+
+	if err := PermitJoin(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !scanning() {
+		t.Error("not scanning after PermitJoin")
+	}
+	testutil.WaitFor(t, "permit join closed", func() bool { return len(bridge.requests("permit_join")) == 2 })
+	requests := bridge.requests("permit_join")
+	if requests[0]["value"] != true || requests[0]["time"] != 1.0 {
+		t.Errorf("open request = %v, want value true for 1s", requests[0])
+	}
+	if requests[1]["value"] != false {
+		t.Errorf("close request = %v", requests[1])
+	}
+	if scanning() {
+		t.Error("still scanning after the window closed")
+	}
+}
+
+func TestPermitJoinClampsDuration(t *testing.T) {
+	resetZigbee(t)
+	bridge := newFakeBridge(t, nil)
+
+	if err := PermitJoin(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if requests := bridge.requests("permit_join"); len(requests) != 1 || requests[0]["time"] != 254.0 {
+		t.Errorf("requests = %v, want time 254", requests)
+	}
+}
+
+func TestPermitJoinNotConnected(t *testing.T) {
+	resetZigbee(t)
+	if err := PermitJoin(time.Minute); err == nil {
+		t.Error("PermitJoin succeeded without a broker connection")
+	}
+}
+
+func TestScanRecordsJoinedDevices(t *testing.T) {
+	resetZigbee(t)
+	newFakeBridge(t, nil)
+	const ieee = "0x0017880100000001"
+	event := func(payload string) {
+		bridgeEventHandler(nil, &testMessage{topic: "zigbee2mqtt/bridge/event", payload: []byte(payload)})
+	}
+
+	event(`{"type": "device_joined", "data": {"friendly_name": "` + ieee + `", "ieee_address": "` + ieee + `"}}`)
+	if _, ok := factory.GetScanStore().FindDiscoveredDevice(ieee); ok {
+		t.Error("join recorded outside a scan")
+	}
+
+	if err := PermitJoin(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	event(`{"type": "device_joined", "data": {"friendly_name": "` + ieee + `", "ieee_address": "` + ieee + `"}}`)
+	found, ok := factory.GetScanStore().FindDiscoveredDevice(ieee)
+	if !ok || found.Name != ieee || found.Type != "zigbee" || found.Protocol != "zigbee" {
+		t.Fatalf("joined device = %+v, %v", found, ok)
+	}
+
+	event(`{"type": "device_interview", "data": {"friendly_name": "` + ieee + `", "ieee_address": "` + ieee + `",
+		"status": "successful", "supported": true,
+		"definition": {"vendor": "Philips", "model": "9290022166", "exposes": ` + lightExposes + `}}}`)
+	found, _ = factory.GetScanStore().FindDiscoveredDevice(ieee)
+	if found.Type != "bulb" || found.Name != "Philips 9290022166" {
+		t.Errorf("interviewed device = %+v", found)
+	}
+
+	messageHandler(nil, &testMessage{topic: "zigbee2mqtt/" + ieee, payload: []byte(`{"state": "ON", "linkquality": 87}`)})
+	if found, _ = factory.GetScanStore().FindDiscoveredDevice(ieee); found.LinkQuality != 87 {
+		t.Errorf("link quality = %d, want 87", found.LinkQuality)
+	}
+
+	event(`{"type": "device_leave", "data": {"ieee_address": "0x0017880100000002"}}`)
+	if _, ok := factory.GetScanStore().FindDiscoveredDevice("0x0017880100000002"); ok {
+		t.Error("device_leave recorded as a join")
+	}
+}
@@ -1,13 +1,18 @@
 package zigbee
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	imqtt "iot-bridge/internal/iot/mqtt"
 	"iot-bridge/internal/store"
 	"iot-bridge/internal/store/factory"
 
@@ -15,30 +20,110 @@ import (
 )
 
 var (
-	deviceStates = make(map[string]map[string]string)
+	deviceStates = make(map[string]store.State)
 	stateMu      sync.RWMutex
 	mqttClient   mqtt.Client
+	baseTopic    = "zigbee2mqtt"
+	qos          byte
+
+	// Populated from zigbee2mqtt/bridge/devices. Devices are keyed by IEEE
+	// address so they survive renames; topics still use the friendly name.
+	bridgeMu       sync.RWMutex
+	bridgeDevices  = make(map[string]bridgeDevice)       // ieee -> device
+	friendlyToIEEE = make(map[string]string)             // friendly name -> ieee
+	deviceFeatures = make(map[string]map[string]feature) // ieee -> state key -> feature
+	// Last availability reported on <device>/availability, by device ID.
+	availability = make(map[string]string)
+	// Friendly names removed through the bridge; their retained or late state
+	// messages are ignored until zigbee2mqtt lists them again.
+	removedNames = make(map[string]bool)
 )
 
 type ZigbeeDriver struct{}
 
-func Init() {
-	opts := mqtt.NewClientOptions().AddBroker("tcp://localhost:1883")
-	opts.SetClientID("iot-bridge-zigbee")
-	opts.OnConnect = func(c mqtt.Client) {
-		log.Println("[Zigbee] Connected to MQTT")
-		if token := c.Subscribe("zigbee2mqtt/+", 0, messageHandler); token.Wait() && token.Error() != nil {
-			log.Println("[Zigbee] Failed to subscribe:", token.Error())
-		}
+var driver = &ZigbeeDriver{}
+
+func init() {
+	iot.Register("zigbee", func() iot.Driver { return driver })
+}
+
+// Start connects to the broker in the background; the bridge keeps serving
+// while it is unreachable.
+func (z *ZigbeeDriver) Start() error {
+	baseTopic = strings.TrimSuffix(config.ZigbeeBaseTopic, "/")
+	qos = config.ZigbeeQoS
+
+	opts := imqtt.NewClientOptions(config.ZigbeeClientID, onConnect)
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		log.Printf("[Zigbee] MQTT connection lost: %v (reconnecting)", err)
+	})
+	opts.SetReconnectingHandler(func(c mqtt.Client, o *mqtt.ClientOptions) {
+		log.Println("[Zigbee] Reconnecting to MQTT broker")
+	})
+	mqttClient = imqtt.NewClientFromOptions(opts)
+	imqtt.Connect(mqttClient, "[Zigbee]")
+	return nil
+}
+
+func (z *ZigbeeDriver) Stop() error {
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
 	}
-	mqttClient = mqtt.NewClient(opts)
-	if token := mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal("[Zigbee] MQTT connection error:", token.Error())
+	return nil
+}
+
+func (z *ZigbeeDriver) Health() iot.Health {
+	if !Connected() {
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + config.MQTTBroker}
 	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+// Scan opens the network for joining; see PermitJoin.
+func (z *ZigbeeDriver) Scan(duration time.Duration) error {
+	return PermitJoin(duration)
+}
+
+// onConnect runs on every (re)connect; the session is clean, so all
+// subscriptions are made again.
+func onConnect(c mqtt.Client) {
+	log.Println("[Zigbee] Connected to MQTT")
+	subscriptions := []struct {
+		topic   string
+		handler mqtt.MessageHandler
+	}{
+		// The retained device list comes first so friendly names resolve to
+		// IEEE addresses before retained state and availability arrive.
+		{"bridge/devices", bridgeDevicesHandler},
+		{"bridge/event", bridgeEventHandler},
+		{"bridge/response/#", bridgeResponseHandler},
+		{"+", messageHandler},
+		{"+/availability", availabilityHandler},
+	}
+	for _, sub := range subscriptions {
+		if token := c.Subscribe(topic(sub.topic), qos, sub.handler); token.Wait() && token.Error() != nil {
+			log.Printf("[Zigbee] Failed to subscribe to %s: %v", topic(sub.topic), token.Error())
+		}
+	}
+}
+
+// topic prefixes a path with the configured zigbee2mqtt base topic.
+func topic(path string) string {
+	return baseTopic + "/" + path
+}
+
+// friendlyNameFromTopic strips the base topic and an optional suffix.
+func friendlyNameFromTopic(t, suffix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(t, baseTopic+"/"), suffix)
+}
+
+// Connected reports whether the driver currently has a broker connection.
+func Connected() bool {
+	return mqttClient != nil && mqttClient.IsConnectionOpen()
 }
 
 func GetDriver() *ZigbeeDriver {
-	return &ZigbeeDriver{}
+	return driver
 }
 
 func messageHandler(client mqtt.Client, msg mqtt.Message) {
@@ -49,45 +134,253 @@ func messageHandler(client mqtt.Client, msg mqtt.Message) {
 		return
 	}
 
-	deviceID := strings.TrimPrefix(topic, "zigbee2mqtt/")
+	friendlyName := friendlyNameFromTopic(topic, "")
+	if friendlyName == "bridge" {
+		return
+	}
 	var raw map[string]interface{}
 	if err := json.Unmarshal(payload, &raw); err != nil {
-		log.Printf("[Zigbee] Invalid state for %s: %v", deviceID, err)
+		log.Printf("[Zigbee] Invalid state for %s: %v", friendlyName, err)
 		return
 	}
 
-	stringState := make(map[string]string)
-	for k, v := range raw {
-		stringState[k] = fmt.Sprintf("%v", v)
+	deviceID, known, ignored := resolveDeviceID(friendlyName)
+	if ignored {
+		return
 	}
+	bridgeMu.RLock()
+	features := deviceFeatures[deviceID]
+	entry := bridgeDevices[deviceID]
+	bridgeMu.RUnlock()
+
+	state := stateFromPayload(raw, features)
+	recordLinkQuality(deviceID, raw)
 
 	stateMu.Lock()
-	deviceStates[deviceID] = stringState
+	deviceStates[deviceID] = state
 	stateMu.Unlock()
+	notifyStateWaiters(deviceID, state)
 
 	ds := factory.GetDeviceStore()
 	_, found := ds.Get(deviceID)
+	if !found && known {
+		syncBridgeDevice(entry, bridgeDevice{})
+		_, found = ds.Get(deviceID)
+	}
 	if !found {
 		log.Printf("[Zigbee] Discovered device: %s", deviceID)
 		newDevice := store.Device{
 			ID:           deviceID,
-			Name:         deviceID,
+			Name:         friendlyName,
 			Type:         "zigbee",
 			Protocol:     "zigbee",
 			Room:         "unknown",
-			State:        stringState,
+			State:        state,
 			Capabilities: inferCapabilitiesFromPayload(raw),
 		}
+		stateMu.RLock()
+		newDevice.Availability = availability[deviceID]
+		stateMu.RUnlock()
 		if err := ds.Add(newDevice); err != nil {
 			log.Printf("[Zigbee] Failed to add device %s: %v", deviceID, err)
 		} else {
 			log.Printf("[Zigbee] Registered new device: %s", deviceID)
 		}
 	} else {
-		if err := ds.UpdateState(deviceID, stringState); err != nil {
+		if err := ds.UpdateState(deviceID, state); err != nil {
 			log.Printf("[Zigbee] Failed to update state for %s: %v", deviceID, err)
 		}
 	}
+
+	now := time.Now()
+	status := store.DeviceStatus{LastSeen: &now}
+	if lqi, ok := raw["linkquality"].(float64); ok {
+		q := int(lqi)
+		status.LinkQuality = &q
+	}
+	if err := ds.UpdateStatus(deviceID, status); err != nil {
+		log.Printf("[Zigbee] Failed to update status for %s: %v", deviceID, err)
+	}
+}
+
+// resolveDeviceID maps a topic's friendly name to the device ID. Devices not
+// in bridge/devices (yet) fall back to the friendly name; ignored is set for
+// devices removed through the bridge.
+func resolveDeviceID(friendlyName string) (deviceID string, known, ignored bool) {
+	bridgeMu.RLock()
+	defer bridgeMu.RUnlock()
+	if id, ok := friendlyToIEEE[friendlyName]; ok {
+		return id, true, false
+	}
+	return friendlyName, false, removedNames[friendlyName]
+}
+
+// availabilityHandler tracks zigbee2mqtt's availability reports, which are
+// {"state":"online"} or, with legacy_availability_payload, a plain string.
+func availabilityHandler(client mqtt.Client, msg mqtt.Message) {
+	friendlyName := friendlyNameFromTopic(msg.Topic(), "/availability")
+	deviceID, _, ignored := resolveDeviceID(friendlyName)
+	if ignored {
+		return
+	}
+
+	state := strings.TrimSpace(string(msg.Payload()))
+	var obj struct {
+		State string `json:"state"`
+	}
+	if json.Unmarshal(msg.Payload(), &obj) == nil && obj.State != "" {
+		state = obj.State
+	}
+	if state != store.Online && state != store.Offline {
+		return
+	}
+
+	stateMu.Lock()
+	previous := availability[deviceID]
+	availability[deviceID] = state
+	stateMu.Unlock()
+	if previous != state {
+		log.Printf("[Zigbee] %s is %s", deviceID, state)
+	}
+
+	// Devices not registered yet pick up their availability when they are.
+	ds := factory.GetDeviceStore()
+	if _, found := ds.Get(deviceID); !found {
+		return
+	}
+	if err := ds.UpdateStatus(deviceID, store.DeviceStatus{Availability: state}); err != nil {
+		log.Printf("[Zigbee] Failed to update availability for %s: %v", deviceID, err)
+	}
+}
+
+// bridgeDevicesHandler refreshes the device registry and capabilities from
+// the retained device list zigbee2mqtt publishes on start and after every
+// join, interview, rename or removal.
+func bridgeDevicesHandler(client mqtt.Client, msg mqtt.Message) {
+	var list []bridgeDevice
+	if err := json.Unmarshal(msg.Payload(), &list); err != nil {
+		log.Printf("[Zigbee] Invalid bridge/devices payload: %v", err)
+		return
+	}
+
+	devices := make(map[string]bridgeDevice)
+	friendly := make(map[string]string)
+	features := make(map[string]map[string]feature)
+	for _, d := range list {
+		if d.Type == "Coordinator" || d.IEEEAddress == "" {
+			continue
+		}
+		devices[d.IEEEAddress] = d
+		friendly[d.FriendlyName] = d.IEEEAddress
+		if d.Definition != nil {
+			features[d.IEEEAddress] = featuresFromExposes(d.Definition.Exposes)
+		}
+	}
+
+	bridgeMu.Lock()
+	previous := bridgeDevices
+	bridgeDevices = devices
+	friendlyToIEEE = friendly
+	deviceFeatures = features
+	for name := range friendly {
+		delete(removedNames, name)
+	}
+	bridgeMu.Unlock()
+
+	for id, d := range devices {
+		syncBridgeDevice(d, previous[id])
+	}
+}
+
+// syncBridgeDevice adds or refreshes the store entry for a zigbee2mqtt
+// device. Devices registered under their friendly name before the device
+// list arrived are moved to their IEEE address, keeping room and state.
+func syncBridgeDevice(d bridgeDevice, previous bridgeDevice) {
+	ds := factory.GetDeviceStore()
+	id := d.IEEEAddress
+
+	manufacturer, model := d.Manufacturer, d.ModelID
+	var exposes []expose
+	if d.Definition != nil {
+		manufacturer, model = d.Definition.Vendor, d.Definition.Model
+		exposes = d.Definition.Exposes
+	}
+
+	existing, found := ds.Get(id)
+	if !found {
+		legacy, ok := ds.Get(d.FriendlyName)
+		if ok && legacy.Protocol == "zigbee" {
+			if err := ds.Delete(legacy.ID); err != nil {
+				log.Printf("[Zigbee] Failed to migrate %s to %s: %v", legacy.ID, id, err)
+				return
+			}
+			stateMu.Lock()
+			if s, ok := deviceStates[legacy.ID]; ok {
+				deviceStates[id] = s
+				delete(deviceStates, legacy.ID)
+			}
+			if a, ok := availability[legacy.ID]; ok {
+				availability[id] = a
+				delete(availability, legacy.ID)
+			}
+			stateMu.Unlock()
+			log.Printf("[Zigbee] Migrated device %s to IEEE address %s", legacy.ID, id)
+			existing, found = legacy, true
+		}
+	}
+
+	if !found {
+		if d.Definition == nil {
+			return // unsupported or not interviewed yet; state messages will register it
+		}
+		device := store.Device{
+			ID:           id,
+			Name:         d.FriendlyName,
+			Type:         deviceTypeFromExposes(exposes),
+			Protocol:     "zigbee",
+			Room:         "unknown",
+			Manufacturer: manufacturer,
+			Model:        model,
+			State:        store.State{},
+			Capabilities: capabilitiesFromExposes(exposes),
+		}
+		stateMu.RLock()
+		device.Availability = availability[id]
+		stateMu.RUnlock()
+		if err := ds.Add(device); err != nil {
+			log.Printf("[Zigbee] Failed to add device %s: %v", id, err)
+		} else {
+			log.Printf("[Zigbee] Registered %s (%s %s) as %s", d.FriendlyName, manufacturer, model, id)
+		}
+		return
+	}
+
+	updated := existing
+	updated.ID = id
+	updated.Manufacturer = manufacturer
+	updated.Model = model
+	// Follow zigbee2mqtt renames unless the name was changed on the bridge.
+	if updated.Name == "" || updated.Name == existing.ID || updated.Name == previous.FriendlyName {
+		updated.Name = d.FriendlyName
+	}
+	if d.Definition != nil {
+		updated.Type = deviceTypeFromExposes(exposes)
+		updated.Capabilities = capabilitiesFromExposes(exposes)
+	}
+	if updated.State == nil {
+		updated.State = store.State{}
+	}
+
+	before, _ := json.Marshal(existing)
+	after, _ := json.Marshal(updated)
+	if string(before) == string(after) {
+		return
+	}
+	if err := ds.Add(updated); err != nil {
+		log.Printf("[Zigbee] Failed to refresh device %s: %v", id, err)
+	} else {
+		log.Printf("[Zigbee] Refreshed capabilities for %s", id)
+	}
 }
 
 func inferCapabilitiesFromPayload(payload map[string]interface{}) []store.Capability {
@@ -146,7 +439,7 @@ func inferWritable(key string, value interface{}) bool {
 	return false
 }
 
-func (z *ZigbeeDriver) GetState(device store.Device) (map[string]string, error) {
+func (z *ZigbeeDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
 	stateMu.RLock()
 	defer stateMu.RUnlock()
 	s, ok := deviceStates[device.ID]
@@ -156,10 +449,58 @@ func (z *ZigbeeDriver) GetState(device store.Device) (map[string]string, error)
 	return s, nil
 }
 
-func (z *ZigbeeDriver) SetState(device store.Device, updates map[string]string) error {
-	data, _ := json.Marshal(updates)
-	topic := fmt.Sprintf("zigbee2mqtt/%s/set", device.ID)
-	token := mqttClient.Publish(topic, 0, false, data)
-	token.Wait()
-	return token.Error()
+func (z *ZigbeeDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	return publishState(ctx, device, updates)
+}
+
+// publishState sends updates to <device>/set without waiting for the device.
+func publishState(ctx context.Context, device store.Device, updates store.State) error {
+	stateMu.RLock()
+	offline := availability[device.ID] == store.Offline
+	stateMu.RUnlock()
+	if offline {
+		return iot.Errorf(iot.DeviceOffline, "device %s is offline", device.ID)
+	}
+	if !Connected() {
+		return iot.Errorf(iot.TransportError, "not connected to MQTT broker")
+	}
+
+	friendlyName, _ := friendlyNameFor(device.ID)
+	bridgeMu.RLock()
+	features := deviceFeatures[device.ID]
+	bridgeMu.RUnlock()
+
+	// Send typed values where the exposes describe the property; composite
+	// parameters ("color.x") are nested under their parent property.
+	payload := make(map[string]interface{})
+	for key, value := range updates {
+		f, ok := features[key]
+		if !ok {
+			payload[key] = value
+			continue
+		}
+		if f.Access&accessSet == 0 {
+			return iot.Errorf(iot.Unsupported, "%s is read-only on %s", key, device.ID)
+		}
+		encoded, err := f.encodeValue(value)
+		if err != nil {
+			return iot.Errorf(iot.InvalidValue, "%w", err)
+		}
+		if f.parent == "" {
+			payload[key] = encoded
+			continue
+		}
+		nested, _ := payload[f.parent].(map[string]interface{})
+		if nested == nil {
+			nested = make(map[string]interface{})
+			payload[f.parent] = nested
+		}
+		nested[f.Property] = encoded
+	}
+
+	data, _ := json.Marshal(payload)
+	if err := imqtt.Wait(ctx, mqttClient.Publish(topic(friendlyName+"/set"), qos, false, data)); err != nil {
+		return fmt.Errorf("publish to %s: %w", friendlyName, err)
+	}
+	return nil
 }
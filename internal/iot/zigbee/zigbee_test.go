@@ -0,0 +1,245 @@
+package zigbee
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/broker"
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	imqtt "iot-bridge/internal/iot/mqtt"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const testClientID = "iot-bridge-zigbee"
+
+// resetZigbee gives the test a fresh store and empty driver state, and
+// disconnects whatever client the test leaves behind.
+func resetZigbee(t *testing.T) {
+	t.Helper()
+	testutil.UseMemoryStore()
+	baseTopic = "zigbee2mqtt"
+
+	bridgeMu.Lock()
+	bridgeDevices = make(map[string]bridgeDevice)
+	friendlyToIEEE = make(map[string]string)
+	deviceFeatures = make(map[string]map[string]feature)
+	removedNames = make(map[string]bool)
+	bridgeMu.Unlock()
+	stateMu.Lock()
+	deviceStates = make(map[string]store.State)
+	availability = make(map[string]string)
+	stateMu.Unlock()
+
+	t.Cleanup(func() {
+		scanMu.Lock()
+		if scanTimer != nil {
+			scanTimer.Stop()
+			scanTimer = nil
+		}
+		scanDeadline = time.Time{}
+		scanMu.Unlock()
+		if mqttClient != nil {
+			mqttClient.Disconnect(0)
+			mqttClient = nil
+		}
+	})
+}
+
+// fakeBridge plays zigbee2mqtt behind a replay client. It records what the
+// driver publishes and answers bridge requests with queued responses,
+// stamped with the request's transaction like zigbee2mqtt does.
+type fakeBridge struct {
+	*imqtt.ReplayClient
+
+	mu        sync.Mutex
+	published []imqtt.RecordedMessage
+	responses map[string][]string // request path -> responses, answered in order
+}
+
+// newFakeBridge connects the driver to a fake bridge that replays recording
+// and waits until the replay is done.
+func newFakeBridge(t *testing.T, recording []imqtt.RecordedMessage) *fakeBridge {
+	t.Helper()
+	opts := mqtt.NewClientOptions().SetClientID(testClientID).SetOnConnectHandler(onConnect)
+	f := &fakeBridge{
+		ReplayClient: imqtt.NewReplayClient(opts, recording, 0),
+		responses:    make(map[string][]string),
+	}
+	mqttClient = f
+	f.Connect()
+	select {
+	case <-f.Done():
+	case <-time.After(testutil.Timeout):
+		t.Fatal("timed out replaying the recording")
+	}
+	return f
+}
+
+// respond queues the response to the next request on path.
+func (f *fakeBridge) respond(path, response string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[path] = append(f.responses[path], response)
+}
+
+func (f *fakeBridge) Publish(target string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	data, _ := payload.([]byte)
+	f.mu.Lock()
+	f.published = append(f.published, imqtt.RecordedMessage{Dir: imqtt.Outbound, Topic: target, QoS: qos, Retained: retained, Payload: data})
+	path, isRequest := strings.CutPrefix(target, topic("bridge/request/"))
+	var response string
+	if queue := f.responses[path]; isRequest && len(queue) > 0 {
+		response, f.responses[path] = queue[0], queue[1:]
+	}
+	f.mu.Unlock()
+
+	if response != "" {
+		var request, res map[string]interface{}
+		json.Unmarshal(data, &request)
+		if err := json.Unmarshal([]byte(response), &res); err != nil {
+			panic(err)
+		}
+		res["transaction"] = request["transaction"]
+		answer, _ := json.Marshal(res)
+		bridgeResponseHandler(f, &testMessage{topic: topic("bridge/response/" + path), payload: answer})
+	}
+	return f.ReplayClient.Publish(target, qos, retained, payload)
+}
+
+// respondRecorded queues the bridge responses of a recording, so requests
+// get the answers zigbee2mqtt gave when it was recorded.
+func (f *fakeBridge) respondRecorded(recording []imqtt.RecordedMessage) {
+	for _, m := range recording {
+		if path, ok := strings.CutPrefix(m.Topic, topic("bridge/response/")); ok && m.Dir == imqtt.Inbound {
+			f.respond(path, string(m.Payload))
+		}
+	}
+}
+
+// requests returns the decoded payloads published on bridge/request/<path>.
+func (f *fakeBridge) requests(path string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var requests []map[string]interface{}
+	for _, m := range f.published {
+		if m.Topic == topic("bridge/request/"+path) {
+			var payload map[string]interface{}
+			json.Unmarshal(m.Payload, &payload)
+			requests = append(requests, payload)
+		}
+	}
+	return requests
+}
+
+// testMessage is an MQTT message handed straight to a handler.
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return 0 }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return m.topic }
+func (m *testMessage) MessageID() uint16 { return 0 }
+func (m *testMessage) Payload() []byte   { return m.payload }
+func (m *testMessage) Ack()              {}
+
+func TestReconnectResubscribes(t *testing.T) {
+	testutil.StartBroker(t)
+	resetZigbee(t)
+	savedTopic, savedID, savedInterval := config.ZigbeeBaseTopic, config.ZigbeeClientID, config.MQTTMaxReconnectInterval
+	config.ZigbeeBaseTopic, config.ZigbeeClientID, config.MQTTMaxReconnectInterval = "zigbee2mqtt/", testClientID, 200*time.Millisecond
+	t.Cleanup(func() {
+		config.ZigbeeBaseTopic, config.ZigbeeClientID, config.MQTTMaxReconnectInterval = savedTopic, savedID, savedInterval
+	})
+
+	if err := driver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, "connection", func() bool { return driver.Health().Status == iot.HealthOK })
+	if baseTopic != "zigbee2mqtt" {
+		t.Errorf("base topic = %q, want the trailing slash trimmed", baseTopic)
+	}
+
+	// Retained, so the state reaches the driver whenever it subscribes.
+	publish := func(client mqtt.Client, payload string) {
+		t.Helper()
+		if token := client.Publish("zigbee2mqtt/Kitchen sensor", 0, true, payload); !token.WaitTimeout(testutil.Timeout) || token.Error() != nil {
+			t.Fatalf("publish: %v", token.Error())
+		}
+	}
+	publish(testutil.MQTTClient(t, "zigbee2mqtt"), `{"temperature": 21.5}`)
+	testutil.WaitFor(t, "first state", func() bool { return testutil.StoredState("Kitchen sensor", "temperature") == 21.5 })
+
+	broker.Stop()
+	testutil.WaitFor(t, "connection lost", func() bool { return driver.Health().Status == iot.HealthDegraded })
+	if err := broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitForWithin(t, 5*time.Second, "reconnect", func() bool { return driver.Health().Status == iot.HealthOK })
+
+	// The restarted broker has no sessions, so this only arrives if the
+	// driver subscribed again.
+	publish(testutil.MQTTClient(t, "zigbee2mqtt-restarted"), `{"temperature": 22}`)
+	testutil.WaitFor(t, "state after reconnect", func() bool { return testutil.StoredState("Kitchen sensor", "temperature") == 22.0 })
+}
+
+func TestReplayRecording(t *testing.T) {
+	resetZigbee(t)
+	recording, err := imqtt.LoadRecording("testdata/zigbee2mqtt.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := newFakeBridge(t, recording)
+	bridge.respondRecorded(recording)
+	ds := factory.GetDeviceStore()
+
+	lamp, ok := ds.Get("0x0017880100000001")
+	if !ok {
+		t.Fatal("listed lamp not registered under its IEEE address")
+	}
+	if lamp.Name != "Desk lamp" || lamp.Type != "bulb" || lamp.Manufacturer != "Philips" || lamp.Availability != store.Online {
+		t.Errorf("lamp = %+v", lamp)
+	}
+	want := store.State{"state": "on", "brightness": 200.0, "color.x": 0.3, "color.y": 0.4, "linkquality": 120.0}
+	if !reflect.DeepEqual(lamp.State, want) {
+		t.Errorf("lamp state = %v, want %v", lamp.State, want)
+	}
+
+	sensor, ok := ds.Get("Kitchen sensor")
+	if !ok {
+		t.Fatal("unlisted sensor not registered under its friendly name")
+	}
+	if sensor.State["temperature"] != 21.5 || sensor.Availability != store.Offline {
+		t.Errorf("sensor = %+v", sensor)
+	}
+	if sensor.LinkQuality == nil || *sensor.LinkQuality != 60 {
+		t.Errorf("sensor link quality = %v, want 60", sensor.LinkQuality)
+	}
+
+	if _, ok := ds.Get("0x00158d0000000003"); ok {
+		t.Error("device without a definition registered before sending state")
+	}
+	// Another client's traffic isn't replayed to the driver.
+	if testutil.StoredState("0x0017880100000001", "state") != "on" {
+		t.Error("another client's message reached the driver")
+	}
+
+	if err := driver.RenameDevice(context.Background(), lamp, "Reading lamp"); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := friendlyNameFor(lamp.ID); name != "Reading lamp" {
+		t.Errorf("friendly name = %q after the recorded rename", name)
+	}
+}
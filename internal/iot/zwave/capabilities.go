@@ -0,0 +1,281 @@
+package zwave
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"iot-bridge/internal/store"
+)
+
+// Command classes the driver maps onto capabilities.
+const (
+	ccBinarySwitch       = 37
+	ccMultilevelSwitch   = 38
+	ccSensorMultilevel   = 49
+	ccMeter              = 50
+	ccThermostatMode     = 64
+	ccThermostatSetpoint = 67
+)
+
+// binding ties a bridge state key to the Z-Wave value(s) behind it.
+type binding struct {
+	key      string
+	read     ValueID
+	write    *ValueID
+	kind     string            // "onoff", "number" or "enum"
+	states   map[string]string // enum: raw value -> label
+	metadata ValueMetadata
+}
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+func keyify(s string) string {
+	return strings.Trim(nonWord.ReplaceAllString(strings.ToLower(s), "_"), "_")
+}
+
+// meterKeys names meter readings by unit rather than by the numeric propertyKey.
+var meterKeys = map[string]string{
+	"kWh": "energy",
+	"W":   "power_usage",
+	"V":   "voltage",
+	"A":   "current",
+}
+
+// bindingsForNode picks the values of the supported command classes out of a
+// node and derives state keys for them. Values on endpoints other than 0 get
+// an "_<endpoint>" suffix.
+func bindingsForNode(node Node) map[string]*binding {
+	bindings := make(map[string]*binding)
+
+	suffix := func(endpoint int) string {
+		if endpoint == 0 {
+			return ""
+		}
+		return "_" + strconv.Itoa(endpoint)
+	}
+
+	for _, v := range node.Values {
+		prop := fmt.Sprint(v.Property)
+		switch v.CommandClass {
+		case ccBinarySwitch, ccMultilevelSwitch:
+			if prop != "currentValue" && prop != "targetValue" {
+				continue
+			}
+			key := "state"
+			kind := "onoff"
+			if v.CommandClass == ccMultilevelSwitch {
+				key, kind = "brightness", "number"
+			}
+			key += suffix(v.Endpoint)
+			b := bindings[key]
+			if b == nil {
+				b = &binding{key: key, kind: kind}
+				bindings[key] = b
+			}
+			if prop == "currentValue" {
+				b.read = v.ValueID
+				if b.metadata.Type == "" {
+					b.metadata = v.Metadata
+				}
+			} else {
+				id := v.ValueID
+				b.write = &id
+				b.metadata = v.Metadata
+				if b.read.CommandClass == 0 {
+					b.read = v.ValueID
+				}
+			}
+
+		case ccSensorMultilevel:
+			key := keyify(prop) + suffix(v.Endpoint)
+			bindings[key] = &binding{key: key, read: v.ValueID, kind: "number", metadata: v.Metadata}
+
+		case ccMeter:
+			if prop != "value" {
+				continue // reset, previousValue, ...
+			}
+			key, ok := meterKeys[v.Metadata.Unit]
+			if !ok {
+				key = keyify(v.Metadata.Label)
+			}
+			key += suffix(v.Endpoint)
+			bindings[key] = &binding{key: key, read: v.ValueID, kind: "number", metadata: v.Metadata}
+
+		case ccThermostatSetpoint:
+			if prop != "setpoint" {
+				continue
+			}
+			name := v.PropertyKeyName
+			if name == "" {
+				name = fmt.Sprint(v.PropertyKey)
+			}
+			key := "setpoint_" + keyify(name) + suffix(v.Endpoint)
+			id := v.ValueID
+			bindings[key] = &binding{key: key, read: v.ValueID, write: &id, kind: "number", metadata: v.Metadata}
+
+		case ccThermostatMode:
+			if prop != "mode" {
+				continue
+			}
+			key := "thermostat_mode" + suffix(v.Endpoint)
+			b := &binding{key: key, read: v.ValueID, kind: "enum", metadata: v.Metadata, states: map[string]string{}}
+			for raw, label := range v.Metadata.States {
+				b.states[raw] = strings.ToLower(label)
+			}
+			if v.Metadata.Writeable {
+				id := v.ValueID
+				b.write = &id
+			}
+			bindings[key] = b
+		}
+	}
+
+	for key, b := range bindings {
+		if b.read.CommandClass == 0 {
+			delete(bindings, key)
+		}
+	}
+	return bindings
+}
+
+func capabilitiesFor(bindings map[string]*binding) []store.Capability {
+	keys := make([]string, 0, len(bindings))
+	for k := range bindings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var caps []store.Capability
+	for _, key := range keys {
+		b := bindings[key]
+		cap := store.Capability{Name: key, Writable: b.write != nil}
+		switch b.kind {
+		case "onoff":
+			cap.Name = strings.Replace(key, "state", "power", 1)
+			cap.Description = "Turn the device on or off"
+			cap.Operations = []string{"on", "off"}
+			cap.Parameters = map[string]interface{}{
+				key: map[string]interface{}{"type": "string", "operations": []string{"on", "off"}},
+			}
+		case "enum":
+			options := make([]string, 0, len(b.states))
+			for _, label := range b.states {
+				options = append(options, label)
+			}
+			sort.Strings(options)
+			cap.Description = "Select the thermostat mode"
+			cap.Operations = options
+			cap.Parameters = map[string]interface{}{
+				key: map[string]interface{}{"type": "string", "operations": options},
+			}
+		default:
+			spec := map[string]interface{}{"type": "number"}
+			if b.read.CommandClass == ccMultilevelSwitch {
+				spec["type"] = "integer"
+			}
+			if b.metadata.Min != nil && b.metadata.Max != nil {
+				if spec["type"] == "integer" {
+					spec["range"] = []int{int(*b.metadata.Min), int(*b.metadata.Max)}
+				} else {
+					spec["range"] = []float64{*b.metadata.Min, *b.metadata.Max}
+				}
+			}
+			if b.metadata.Unit != "" {
+				spec["unit"] = b.metadata.Unit
+			}
+			cap.Description = b.metadata.Label
+			if cap.Description == "" {
+				cap.Description = strings.ReplaceAll(key, "_", " ")
+			}
+			cap.Parameters = map[string]interface{}{key: spec}
+		}
+		caps = append(caps, cap)
+	}
+	return caps
+}
+
+// deviceType guesses a bridge device type from the command classes present.
+func deviceType(bindings map[string]*binding) string {
+	has := map[int]bool{}
+	for _, b := range bindings {
+		has[b.read.CommandClass] = true
+	}
+	switch {
+	case has[ccThermostatSetpoint] || has[ccThermostatMode]:
+		return "thermostat"
+	case has[ccMultilevelSwitch]:
+		return "dimmer"
+	case has[ccBinarySwitch] && has[ccMeter]:
+		return "smart_plug"
+	case has[ccBinarySwitch]:
+		return "switch"
+	case has[ccSensorMultilevel]:
+		return "sensor"
+	}
+	return "zwave"
+}
+
+// toState converts a Z-Wave value into the bridge's state value: "on"/"off"
+// for switches, the label for enums and the value itself otherwise.
+func (b *binding) toState(v interface{}) interface{} {
+	switch b.kind {
+	case "onoff":
+		switch val := v.(type) {
+		case bool:
+			if val {
+				return "on"
+			}
+			return "off"
+		case float64:
+			if val > 0 {
+				return "on"
+			}
+			return "off"
+		}
+	case "enum":
+		if label, ok := b.states[fmt.Sprint(v)]; ok {
+			return label
+		}
+	}
+	return v
+}
+
+// fromState converts a requested bridge value into what node.set_value expects.
+func (b *binding) fromState(v interface{}) (interface{}, error) {
+	s := store.FormatValue(v)
+	switch b.kind {
+	case "onoff":
+		switch strings.ToLower(s) {
+		case "on", "true", "1":
+			return true, nil
+		case "off", "false", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid value %q for %s, expected on/off", s, b.key)
+	case "enum":
+		for raw, label := range b.states {
+			if strings.EqualFold(label, s) {
+				return strconv.Atoi(raw)
+			}
+		}
+		return nil, fmt.Errorf("invalid value %q for %s", s, b.key)
+	}
+	if f, ok := v.(float64); ok {
+		return f, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q for %s", s, b.key)
+	}
+	return f, nil
+}
+
+func sameValueID(a, b ValueID) bool {
+	return a.CommandClass == b.CommandClass &&
+		a.Endpoint == b.Endpoint &&
+		fmt.Sprint(a.Property) == fmt.Sprint(b.Property) &&
+		fmt.Sprint(a.PropertyKey) == fmt.Sprint(b.PropertyKey)
+}
@@ -0,0 +1,167 @@
+package zwave
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func float(f float64) *float64 { return &f }
+
+func TestBindingsForNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []Value
+		want     []string // keys
+		writable []string
+	}{
+		{
+			name: "binary switch",
+			values: []Value{
+				{ValueID: ValueID{CommandClass: ccBinarySwitch, Property: "currentValue"}},
+				{ValueID: ValueID{CommandClass: ccBinarySwitch, Property: "targetValue"}, Metadata: ValueMetadata{Writeable: true}},
+			},
+			want:     []string{"state"},
+			writable: []string{"state"},
+		},
+		{
+			name: "dimmer on endpoint 1 with target only",
+			values: []Value{
+				{ValueID: ValueID{CommandClass: ccMultilevelSwitch, Endpoint: 1, Property: "targetValue"}},
+				{ValueID: ValueID{CommandClass: ccMultilevelSwitch, Endpoint: 1, Property: "duration"}},
+			},
+			want:     []string{"brightness_1"},
+			writable: []string{"brightness_1"},
+		},
+		{
+			name: "sensor and meters",
+			values: []Value{
+				{ValueID: ValueID{CommandClass: ccSensorMultilevel, Property: "Air temperature"}},
+				{ValueID: ValueID{CommandClass: ccMeter, Property: "value", PropertyKey: 66049}, Metadata: ValueMetadata{Unit: "W"}},
+				{ValueID: ValueID{CommandClass: ccMeter, Property: "value", PropertyKey: 65537}, Metadata: ValueMetadata{Unit: "kWh"}},
+				{ValueID: ValueID{CommandClass: ccMeter, Property: "value", PropertyKey: 1}, Metadata: ValueMetadata{Label: "Pulse Count"}},
+				{ValueID: ValueID{CommandClass: ccMeter, Property: "reset"}},
+			},
+			want: []string{"air_temperature", "energy", "power_usage", "pulse_count"},
+		},
+		{
+			name: "thermostat",
+			values: []Value{
+				{ValueID: ValueID{CommandClass: ccThermostatSetpoint, Property: "setpoint", PropertyKey: 1}, PropertyKeyName: "Heating"},
+				{ValueID: ValueID{CommandClass: ccThermostatMode, Property: "mode"}, Metadata: ValueMetadata{Writeable: true, States: map[string]string{"0": "Off", "1": "Heat"}}},
+				{ValueID: ValueID{CommandClass: ccThermostatMode, Property: "manufacturerData"}},
+			},
+			want:     []string{"setpoint_heating", "thermostat_mode"},
+			writable: []string{"setpoint_heating", "thermostat_mode"},
+		},
+		{
+			name: "read-only thermostat mode",
+			values: []Value{
+				{ValueID: ValueID{CommandClass: ccThermostatMode, Property: "mode"}, Metadata: ValueMetadata{States: map[string]string{"0": "Off"}}},
+			},
+			want: []string{"thermostat_mode"},
+		},
+		{
+			name:   "unsupported command classes",
+			values: []Value{{ValueID: ValueID{CommandClass: 114, Property: "manufacturerId"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings := bindingsForNode(Node{NodeID: 2, Values: tt.values})
+			var keys, writable []string
+			for key, b := range bindings {
+				keys = append(keys, key)
+				if b.write != nil {
+					writable = append(writable, key)
+				}
+			}
+			sort.Strings(keys)
+			sort.Strings(writable)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys = %v, want %v", keys, tt.want)
+			}
+			if !reflect.DeepEqual(writable, tt.writable) {
+				t.Errorf("writable = %v, want %v", writable, tt.writable)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesForRange(t *testing.T) {
+	bindings := bindingsForNode(Node{Values: []Value{
+		{ValueID: ValueID{CommandClass: ccMultilevelSwitch, Property: "targetValue"}, Metadata: ValueMetadata{Min: float(0), Max: float(99)}},
+	}})
+	caps := capabilitiesFor(bindings)
+	if len(caps) != 1 {
+		t.Fatalf("capabilities = %v", caps)
+	}
+	spec := caps[0].Parameters["brightness"].(map[string]interface{})
+	if spec["type"] != "integer" || !reflect.DeepEqual(spec["range"], []int{0, 99}) {
+		t.Errorf("brightness spec = %v", spec)
+	}
+}
+
+func TestToState(t *testing.T) {
+	onoff := &binding{key: "state", kind: "onoff"}
+	enum := &binding{key: "thermostat_mode", kind: "enum", states: map[string]string{"0": "off", "1": "heat"}}
+	number := &binding{key: "brightness", kind: "number"}
+
+	tests := []struct {
+		name string
+		b    *binding
+		in   interface{}
+		want interface{}
+	}{
+		{"switch true", onoff, true, "on"},
+		{"switch false", onoff, false, "off"},
+		{"multilevel level", onoff, float64(99), "on"},
+		{"multilevel zero", onoff, float64(0), "off"},
+		{"enum label", enum, float64(1), "heat"},
+		{"enum unknown", enum, float64(7), float64(7)},
+		{"number", number, float64(42), float64(42)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.toState(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toState(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromState(t *testing.T) {
+	onoff := &binding{key: "state", kind: "onoff"}
+	enum := &binding{key: "thermostat_mode", kind: "enum", states: map[string]string{"0": "off", "1": "heat"}}
+	number := &binding{key: "setpoint_heating", kind: "number"}
+
+	tests := []struct {
+		name    string
+		b       *binding
+		in      interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"on", onoff, "on", true, false},
+		{"OFF", onoff, "OFF", false, false},
+		{"bool", onoff, true, true, false},
+		{"bad switch value", onoff, "dim", nil, true},
+		{"enum label", enum, "Heat", 1, false},
+		{"unknown enum label", enum, "cool", nil, true},
+		{"float", number, float64(21.5), float64(21.5), false},
+		{"int", number, 20, float64(20), false},
+		{"numeric string", number, "19.5", float64(19.5), false},
+		{"not a number", number, "warm", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.b.fromState(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fromState(%v) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fromState(%v) = %v (%T), want %v (%T)", tt.in, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
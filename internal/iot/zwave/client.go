@@ -0,0 +1,160 @@
+package zwave
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"iot-bridge/internal/iot/wsrpc"
+
+	"github.com/gorilla/websocket"
+)
+
+// schemaVersion is the highest zwave-js-server API schema the driver speaks.
+const schemaVersion = 35
+
+// ValueID addresses a single value on a node.
+type ValueID struct {
+	CommandClass int         `json:"commandClass"`
+	Endpoint     int         `json:"endpoint"`
+	Property     interface{} `json:"property"`
+	PropertyKey  interface{} `json:"propertyKey,omitempty"`
+}
+
+type ValueMetadata struct {
+	Type      string            `json:"type"`
+	Readable  bool              `json:"readable"`
+	Writeable bool              `json:"writeable"`
+	Label     string            `json:"label,omitempty"`
+	Unit      string            `json:"unit,omitempty"`
+	Min       *float64          `json:"min,omitempty"`
+	Max       *float64          `json:"max,omitempty"`
+	States    map[string]string `json:"states,omitempty"`
+}
+
+// Value is a ValueID together with its current value and metadata, as found
+// in the node state returned by start_listening.
+type Value struct {
+	ValueID
+	CommandClassName string        `json:"commandClassName,omitempty"`
+	PropertyName     string        `json:"propertyName,omitempty"`
+	PropertyKeyName  string        `json:"propertyKeyName,omitempty"`
+	Value            interface{}   `json:"value"`
+	Metadata         ValueMetadata `json:"metadata"`
+}
+
+type DeviceConfig struct {
+	Manufacturer string `json:"manufacturer"`
+	Label        string `json:"label"`
+	Description  string `json:"description"`
+}
+
+type Node struct {
+	NodeID       int          `json:"nodeId"`
+	Name         string       `json:"name,omitempty"`
+	Location     string       `json:"location,omitempty"`
+	Ready        bool         `json:"ready"`
+	Status       int          `json:"status"`
+	DeviceConfig DeviceConfig `json:"deviceConfig"`
+	Values       []Value      `json:"values"`
+}
+
+// Event is the payload of a server "event" message.
+type Event struct {
+	Source string          `json:"source"`
+	Event  string          `json:"event"`
+	NodeID int             `json:"nodeId"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Node   *Node           `json:"node,omitempty"`
+}
+
+// ValueUpdatedArgs are the args of "value updated" / "value added" events.
+type ValueUpdatedArgs struct {
+	ValueID
+	NewValue  interface{} `json:"newValue"`
+	PrevValue interface{} `json:"prevValue"`
+}
+
+// ValueNotificationArgs are the args of "value notification" events, sent
+// for stateless values such as Central Scene presses.
+type ValueNotificationArgs struct {
+	ValueID
+	Value interface{} `json:"value"`
+}
+
+type message struct {
+	Type             string          `json:"type"`
+	MessageID        string          `json:"messageId,omitempty"`
+	Success          bool            `json:"success,omitempty"`
+	ErrorCode        string          `json:"errorCode,omitempty"`
+	Message          string          `json:"message,omitempty"`
+	Result           json.RawMessage `json:"result,omitempty"`
+	Event            *Event          `json:"event,omitempty"`
+	ServerVersion    string          `json:"serverVersion,omitempty"`
+	MaxSchemaVersion int             `json:"maxSchemaVersion,omitempty"`
+}
+
+// codec is zwave-js-server's message format: command arguments sit next to
+// the command and results are "result" messages with a success flag.
+type codec struct{}
+
+func (codec) Request(id, command string, args map[string]interface{}) interface{} {
+	req := map[string]interface{}{"messageId": id, "command": command}
+	for k, v := range args {
+		req[k] = v
+	}
+	return req
+}
+
+func (codec) Reply(raw []byte) (wsrpc.Reply, bool) {
+	var msg message
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Type != "result" {
+		return wsrpc.Reply{}, false
+	}
+	reply := wsrpc.Reply{ID: msg.MessageID, Result: msg.Result}
+	if !msg.Success {
+		reply.Err = fmt.Errorf("%s %s", msg.ErrorCode, msg.Message)
+	}
+	return reply, true
+}
+
+// Client is a zwave-js-server websocket client.
+type Client struct {
+	*wsrpc.Client
+}
+
+// Dial connects, waits for the server's version banner and negotiates the API schema.
+func Dial(url string, onEvent func(Event)) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var banner message
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.ReadJSON(&banner); err != nil || banner.Type != "version" {
+		conn.Close()
+		return nil, fmt.Errorf("no version banner from server: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	c := &Client{wsrpc.New(conn, codec{}, func(raw []byte) {
+		var msg message
+		if err := json.Unmarshal(raw, &msg); err == nil && msg.Type == "event" && msg.Event != nil && onEvent != nil {
+			onEvent(*msg.Event)
+		}
+	})}
+
+	schema := schemaVersion
+	if banner.MaxSchemaVersion > 0 && banner.MaxSchemaVersion < schema {
+		schema = banner.MaxSchemaVersion
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.Command(ctx, "set_api_schema", map[string]interface{}{"schemaVersion": schema}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("set_api_schema: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,156 @@
+package zwave
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// FakeServer is a scripted zwave-js-server for developing and testing the
+// driver without a controller. It answers set_api_schema, start_listening
+// and node.set_value, and echoes writes back as "value updated" events the
+// way a real node reports its new currentValue.
+type FakeServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	nodes []Node
+	conns map[*websocket.Conn]*sync.Mutex
+	// SetValues records every node.set_value request received.
+	SetValues []map[string]interface{}
+}
+
+func NewFakeServer(nodes []Node) *FakeServer {
+	f := &FakeServer{nodes: nodes, conns: make(map[*websocket.Conn]*sync.Mutex)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveWS))
+	return f
+}
+
+// URL returns the ws:// address to pass to the driver.
+func (f *FakeServer) URL() string {
+	return "ws" + strings.TrimPrefix(f.Server.URL, "http")
+}
+
+// EmitValueUpdate pushes a "value updated" event to every connected client.
+func (f *FakeServer) EmitValueUpdate(nodeID int, id ValueID, value interface{}) {
+	f.mu.Lock()
+	var prev interface{}
+	for i := range f.nodes {
+		if f.nodes[i].NodeID != nodeID {
+			continue
+		}
+		for j := range f.nodes[i].Values {
+			if sameValueID(f.nodes[i].Values[j].ValueID, id) {
+				prev = f.nodes[i].Values[j].Value
+				f.nodes[i].Values[j].Value = value
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	args, _ := json.Marshal(ValueUpdatedArgs{ValueID: id, NewValue: value, PrevValue: prev})
+	f.broadcast(map[string]interface{}{
+		"type":  "event",
+		"event": Event{Source: "node", Event: "value updated", NodeID: nodeID, Args: args},
+	})
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func (f *FakeServer) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMu := &sync.Mutex{}
+	f.mu.Lock()
+	f.conns[conn] = writeMu
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	send := func(v interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.WriteJSON(v)
+	}
+
+	send(map[string]interface{}{
+		"type":             "version",
+		"driverVersion":    "fake",
+		"serverVersion":    "fake",
+		"homeId":           1,
+		"minSchemaVersion": 0,
+		"maxSchemaVersion": schemaVersion,
+	})
+
+	for {
+		var req map[string]interface{}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		id, _ := req["messageId"].(string)
+		command, _ := req["command"].(string)
+
+		switch command {
+		case "set_api_schema":
+			send(map[string]interface{}{"type": "result", "messageId": id, "success": true, "result": map[string]interface{}{}})
+		case "start_listening":
+			f.mu.Lock()
+			nodes := append([]Node{}, f.nodes...)
+			f.mu.Unlock()
+			send(map[string]interface{}{
+				"type": "result", "messageId": id, "success": true,
+				"result": map[string]interface{}{"state": map[string]interface{}{"nodes": nodes}},
+			})
+		case "node.set_value":
+			f.handleSetValue(req, id, send)
+		default:
+			send(map[string]interface{}{
+				"type": "result", "messageId": id, "success": false,
+				"errorCode": "unknown_command", "message": fmt.Sprintf("unknown command %q", command),
+			})
+		}
+	}
+}
+
+func (f *FakeServer) handleSetValue(req map[string]interface{}, id string, send func(interface{})) {
+	f.mu.Lock()
+	f.SetValues = append(f.SetValues, req)
+	f.mu.Unlock()
+
+	nodeID := int(req["nodeId"].(float64))
+	raw, _ := json.Marshal(req["valueId"])
+	var vid ValueID
+	json.Unmarshal(raw, &vid)
+
+	send(map[string]interface{}{
+		"type": "result", "messageId": id, "success": true,
+		"result": map[string]interface{}{"result": map[string]interface{}{"status": 255}},
+	})
+
+	// Switches report the new level on currentValue; other values echo themselves.
+	if fmt.Sprint(vid.Property) == "targetValue" {
+		vid.Property = "currentValue"
+	}
+	f.EmitValueUpdate(nodeID, vid, req["value"])
+}
+
+func (f *FakeServer) broadcast(v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn, writeMu := range f.conns {
+		writeMu.Lock()
+		conn.WriteJSON(v)
+		writeMu.Unlock()
+	}
+}
@@ -1 +1,310 @@
 package zwave
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/iot/wsrpc"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+)
+
+const commandTimeout = 10 * time.Second
+
+type ZWaveDriver struct {
+	url      string
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.RWMutex
+	client *Client
+	nodes  map[int]*nodeEntry
+	states map[string]store.State
+}
+
+type nodeEntry struct {
+	deviceID string
+	bindings map[string]*binding
+}
+
+var driver *ZWaveDriver
+
+func init() {
+	iot.Register("zwave", func() iot.Driver {
+		driver = NewDriver(config.ZWaveJSURL)
+		return driver
+	})
+}
+
+// Start connects in the background. Without ZWAVE_JS_URL the driver stays idle.
+func (z *ZWaveDriver) Start() error {
+	if z.url == "" {
+		log.Println("[Z-Wave] ZWAVE_JS_URL not set, driver disabled")
+		return nil
+	}
+	go z.Run()
+	return nil
+}
+
+// Stop ends the reconnect loop, which closes the current connection.
+func (z *ZWaveDriver) Stop() error {
+	z.stopOnce.Do(func() { close(z.stop) })
+	return nil
+}
+
+func (z *ZWaveDriver) Health() iot.Health {
+	if z.url == "" {
+		return iot.Health{Status: iot.HealthDisabled, Detail: "ZWAVE_JS_URL not set"}
+	}
+	z.mu.RLock()
+	client := z.client
+	z.mu.RUnlock()
+	if client == nil || !client.Connected() {
+		return iot.Health{Status: iot.HealthDegraded, Detail: "not connected to " + z.url}
+	}
+	return iot.Health{Status: iot.HealthOK}
+}
+
+func GetDriver() *ZWaveDriver {
+	return driver
+}
+
+func NewDriver(url string) *ZWaveDriver {
+	return &ZWaveDriver{
+		url:    url,
+		stop:   make(chan struct{}),
+		nodes:  make(map[int]*nodeEntry),
+		states: make(map[string]store.State),
+	}
+}
+
+// DeviceID is the bridge device ID used for a Z-Wave node.
+func DeviceID(nodeID int) string {
+	return fmt.Sprintf("zwave-%d", nodeID)
+}
+
+// Run keeps a connection to zwave-js-server open, reconnecting with backoff.
+func (z *ZWaveDriver) Run() {
+	wsrpc.Reconnect(z.stop, "[Z-Wave]", z.url, func() (*wsrpc.Client, error) {
+		client, err := z.Connect()
+		if err != nil {
+			return nil, err
+		}
+		return client.Client, nil
+	})
+}
+
+// Connect dials the server, starts listening and registers every node found.
+func (z *ZWaveDriver) Connect() (*Client, error) {
+	client, err := Dial(z.url, z.handleEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	raw, err := client.Command(ctx, "start_listening", nil)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	var result struct {
+		State struct {
+			Nodes []Node `json:"nodes"`
+		} `json:"state"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("decode start_listening result: %w", err)
+	}
+
+	z.mu.Lock()
+	z.client = client
+	z.mu.Unlock()
+
+	log.Printf("[Z-Wave] Connected to %s, %d nodes", z.url, len(result.State.Nodes))
+	for _, node := range result.State.Nodes {
+		z.registerNode(node)
+	}
+	return client, nil
+}
+
+func (z *ZWaveDriver) registerNode(node Node) {
+	bindings := bindingsForNode(node)
+	if len(bindings) == 0 {
+		return // controller or a node without supported command classes
+	}
+
+	deviceID := DeviceID(node.NodeID)
+	state := make(store.State)
+	for _, v := range node.Values {
+		for _, b := range bindings {
+			if sameValueID(b.read, v.ValueID) {
+				state[b.key] = b.toState(v.Value)
+			}
+		}
+	}
+
+	z.mu.Lock()
+	z.nodes[node.NodeID] = &nodeEntry{deviceID: deviceID, bindings: bindings}
+	z.states[deviceID] = state
+	z.mu.Unlock()
+
+	name := node.Name
+	if name == "" {
+		name = strings.TrimSpace(node.DeviceConfig.Manufacturer + " " + node.DeviceConfig.Label)
+	}
+	added, err := iot.RegisterDevice(store.Device{
+		ID:           deviceID,
+		Name:         name,
+		Type:         deviceType(bindings),
+		Protocol:     "zwave",
+		Room:         node.Location,
+		State:        state,
+		Capabilities: capabilitiesFor(bindings),
+	})
+	if err != nil {
+		log.Printf("[Z-Wave] Failed to register device %s: %v", deviceID, err)
+	} else if added {
+		log.Printf("[Z-Wave] Registered node %d as %s", node.NodeID, deviceID)
+	}
+}
+
+func (z *ZWaveDriver) handleEvent(ev Event) {
+	switch ev.Event {
+	case "value updated", "value added":
+		var args ValueUpdatedArgs
+		if err := json.Unmarshal(ev.Args, &args); err != nil {
+			return
+		}
+		z.applyValue(ev.NodeID, args.ValueID, args.NewValue)
+	case "value notification":
+		var args ValueNotificationArgs
+		if err := json.Unmarshal(ev.Args, &args); err != nil {
+			return
+		}
+		z.applyValue(ev.NodeID, args.ValueID, args.Value)
+	case "node added":
+		if ev.Node != nil {
+			z.registerNode(*ev.Node)
+			z.setAvailability(ev.Node.NodeID, store.Online)
+		}
+	case "node removed":
+		if ev.Node != nil {
+			// Keep the device so its name and room survive a re-inclusion,
+			// but report it offline as it can no longer be reached.
+			z.setAvailability(ev.Node.NodeID, store.Offline)
+			z.mu.Lock()
+			delete(z.nodes, ev.Node.NodeID)
+			delete(z.states, DeviceID(ev.Node.NodeID))
+			z.mu.Unlock()
+			log.Printf("[Z-Wave] Node %d removed from network", ev.Node.NodeID)
+		}
+	}
+}
+
+func (z *ZWaveDriver) setAvailability(nodeID int, availability string) {
+	deviceID := DeviceID(nodeID)
+	ds := factory.GetDeviceStore()
+	if _, found := ds.Get(deviceID); !found {
+		return
+	}
+	if err := ds.UpdateStatus(deviceID, store.DeviceStatus{Availability: availability}); err != nil {
+		log.Printf("[Z-Wave] Failed to update status for %s: %v", deviceID, err)
+	}
+}
+
+func (z *ZWaveDriver) applyValue(nodeID int, id ValueID, value interface{}) {
+	z.mu.Lock()
+	entry, ok := z.nodes[nodeID]
+	if !ok {
+		z.mu.Unlock()
+		return
+	}
+	updates := make(store.State)
+	for _, b := range entry.bindings {
+		if sameValueID(b.read, id) {
+			updates[b.key] = b.toState(value)
+		}
+	}
+	if len(updates) == 0 {
+		z.mu.Unlock()
+		return
+	}
+	state := z.states[entry.deviceID]
+	for k, v := range updates {
+		state[k] = v
+	}
+	z.mu.Unlock()
+
+	if err := factory.GetDeviceStore().UpdateState(entry.deviceID, updates); err != nil {
+		log.Printf("[Z-Wave] Failed to update state for %s: %v", entry.deviceID, err)
+	}
+}
+
+func (z *ZWaveDriver) lookup(deviceID string) (int, *nodeEntry, bool) {
+	if !strings.HasPrefix(deviceID, "zwave-") {
+		return 0, nil, false
+	}
+	nodeID, err := strconv.Atoi(strings.TrimPrefix(deviceID, "zwave-"))
+	if err != nil {
+		return 0, nil, false
+	}
+	entry, ok := z.nodes[nodeID]
+	return nodeID, entry, ok
+}
+
+func (z *ZWaveDriver) GetState(ctx context.Context, device store.Device) (store.State, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	s, ok := z.states[device.ID]
+	if !ok {
+		return nil, fmt.Errorf("no state for device %s", device.ID)
+	}
+	copied := make(store.State, len(s))
+	for k, v := range s {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (z *ZWaveDriver) SetState(ctx context.Context, device store.Device, updates store.State) error {
+	z.mu.RLock()
+	client := z.client
+	nodeID, entry, ok := z.lookup(device.ID)
+	z.mu.RUnlock()
+	if !ok {
+		return iot.Errorf(iot.Unsupported, "device %s is not a known Z-Wave node", device.ID)
+	}
+	if client == nil {
+		return iot.Errorf(iot.TransportError, "not connected to zwave-js-server")
+	}
+
+	for key, raw := range updates {
+		b, ok := entry.bindings[key]
+		if !ok || b.write == nil {
+			return iot.Errorf(iot.Unsupported, "%s is not writable on %s", key, device.ID)
+		}
+		value, err := b.fromState(raw)
+		if err != nil {
+			return iot.Errorf(iot.InvalidValue, "%w", err)
+		}
+		_, err = client.Command(ctx, "node.set_value", map[string]interface{}{
+			"nodeId":  nodeID,
+			"valueId": b.write,
+			"value":   value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
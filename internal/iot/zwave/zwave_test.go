@@ -0,0 +1,145 @@
+package zwave
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+)
+
+var (
+	plugSwitch = ValueID{CommandClass: ccBinarySwitch, Property: "currentValue"}
+	plugTarget = ValueID{CommandClass: ccBinarySwitch, Property: "targetValue"}
+	plugPower  = ValueID{CommandClass: ccMeter, Property: "value", PropertyKey: 66049}
+	plugScene  = ValueID{CommandClass: ccSensorMultilevel, Property: "Illuminance"}
+)
+
+func testNodes() []Node {
+	return []Node{
+		{NodeID: 1, Name: "Controller"},
+		{
+			NodeID:       5,
+			Location:     "Kitchen",
+			DeviceConfig: DeviceConfig{Manufacturer: "Aeotec", Label: "ZW096"},
+			Values: []Value{
+				{ValueID: plugSwitch, Value: true},
+				{ValueID: plugTarget, Value: true, Metadata: ValueMetadata{Writeable: true}},
+				{ValueID: plugPower, Value: float64(4.5), Metadata: ValueMetadata{Unit: "W"}},
+				{ValueID: plugScene, Value: float64(120)},
+			},
+		},
+	}
+}
+
+// startDriver connects a driver to a fake server and waits for node 5.
+func startDriver(t *testing.T) (*ZWaveDriver, *FakeServer) {
+	t.Helper()
+	testutil.UseMemoryStore()
+
+	fake := NewFakeServer(testNodes())
+	t.Cleanup(fake.Close)
+	z := NewDriver(fake.URL())
+	go z.Run()
+	t.Cleanup(func() { z.Stop() })
+
+	testutil.WaitFor(t, "node 5 registered", func() bool {
+		_, ok := factory.GetDeviceStore().Get(DeviceID(5))
+		return ok
+	})
+	return z, fake
+}
+
+func TestDiscoveryRegistersNodes(t *testing.T) {
+	startDriver(t)
+
+	device, _ := factory.GetDeviceStore().Get(DeviceID(5))
+	if device.Name != "Aeotec ZW096" || device.Room != "Kitchen" || device.Type != "smart_plug" {
+		t.Errorf("device = %+v", device)
+	}
+	if device.State["state"] != "on" || device.State["power_usage"] != 4.5 {
+		t.Errorf("state = %v", device.State)
+	}
+	if _, ok := factory.GetDeviceStore().Get(DeviceID(1)); ok {
+		t.Error("controller without supported values was registered")
+	}
+}
+
+func TestDiscoveryKeepsUserEdits(t *testing.T) {
+	testutil.UseMemoryStore()
+	factory.GetDeviceStore().Add(store.Device{ID: DeviceID(5), Name: "Kettle", Room: "Office", Protocol: "zwave"})
+
+	fake := NewFakeServer(testNodes())
+	defer fake.Close()
+	z := NewDriver(fake.URL())
+	if _, err := z.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer z.Stop()
+
+	device, _ := factory.GetDeviceStore().Get(DeviceID(5))
+	if device.Name != "Kettle" || device.Room != "Office" {
+		t.Errorf("name, room = %q, %q, want user edits kept", device.Name, device.Room)
+	}
+	if device.Type != "smart_plug" || len(device.Capabilities) == 0 {
+		t.Errorf("type, capabilities not refreshed: %+v", device)
+	}
+}
+
+func TestSetStateRoundTrip(t *testing.T) {
+	z, fake := startDriver(t)
+	device, _ := factory.GetDeviceStore().Get(DeviceID(5))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := z.SetState(ctx, device, store.State{"state": "off"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	sent := fake.SetValues
+	fake.mu.Unlock()
+	if len(sent) != 1 || sent[0]["value"] != false {
+		t.Fatalf("set_value requests = %v", sent)
+	}
+	testutil.WaitFor(t, "state off", func() bool { return testutil.StoredState(DeviceID(5), "state") == "off" })
+
+	if err := z.SetState(ctx, device, store.State{"power_usage": 1}); err == nil {
+		t.Error("writing a read-only value succeeded")
+	}
+}
+
+func TestEventsUpdateStore(t *testing.T) {
+	_, fake := startDriver(t)
+
+	fake.EmitValueUpdate(5, plugPower, float64(60))
+	testutil.WaitFor(t, "power_usage 60", func() bool { return testutil.StoredState(DeviceID(5), "power_usage") == float64(60) })
+
+	args, _ := json.Marshal(ValueNotificationArgs{ValueID: plugScene, Value: float64(300)})
+	fake.broadcast(map[string]interface{}{
+		"type":  "event",
+		"event": Event{Source: "node", Event: "value notification", NodeID: 5, Args: args},
+	})
+	testutil.WaitFor(t, "illuminance 300", func() bool { return testutil.StoredState(DeviceID(5), "illuminance") == float64(300) })
+}
+
+func TestNodeRemovedMarksOffline(t *testing.T) {
+	z, fake := startDriver(t)
+
+	fake.broadcast(map[string]interface{}{
+		"type":  "event",
+		"event": Event{Source: "controller", Event: "node removed", Node: &Node{NodeID: 5}},
+	})
+	testutil.WaitFor(t, "device offline", func() bool {
+		device, _ := factory.GetDeviceStore().Get(DeviceID(5))
+		return device.Availability == store.Offline
+	})
+
+	device, _ := factory.GetDeviceStore().Get(DeviceID(5))
+	if _, err := z.GetState(context.Background(), device); err == nil {
+		t.Error("GetState succeeded for a removed node")
+	}
+}
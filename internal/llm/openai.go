@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"iot-bridge/internal/store/factory"
@@ -47,11 +48,16 @@ Available API endpoints:
 	contextBuilder.WriteString("Known devices and capabilities:\n")
 	for _, d := range devices {
 		contextBuilder.WriteString(fmt.Sprintf("- Name: \"%s\", ID: \"%s\", Type: %s, Room: %s\n", d.Name, d.ID, d.Type, d.Room))
+		if len(d.State) > 0 {
+			stateJSON, _ := json.Marshal(d.State)
+			contextBuilder.WriteString(fmt.Sprintf("  State: %s\n", stateJSON))
+		}
 		for _, cap := range d.Capabilities {
 			var paramList []string
-			for k := range cap.Parameters {
-				paramList = append(paramList, k)
+			for k, spec := range cap.Parameters {
+				paramList = append(paramList, describeParam(k, spec))
 			}
+			sort.Strings(paramList)
 			writability := "read-only"
 			if cap.Writable {
 				writability = "writable"
@@ -73,7 +79,7 @@ Use this API spec:
 Instructions:
 - Use exact device names or IDs from the context above. Do NOT guess or assume.
 - Prefer writable capabilities for POST requests. Read-only capabilities should never be set.
-- Match parameter names and types carefully from context.
+- Match parameter names and types carefully from context. Send numbers and booleans as JSON numbers and booleans, not strings.
 - Output only a JSON object like this:
   {
     "actions": [
@@ -159,3 +165,22 @@ func extractPureJSON(s string) string {
 
 	return s
 }
+
+// describeParam renders a parameter as "name: type" plus its range, e.g.
+// "brightness: integer 0..254".
+func describeParam(name string, raw interface{}) string {
+	spec, ok := raw.(map[string]interface{})
+	if !ok {
+		return name
+	}
+	desc := name
+	if typ, ok := spec["type"].(string); ok {
+		desc += ": " + typ
+	}
+	if r, ok := spec["range"]; ok {
+		if b, err := json.Marshal(r); err == nil {
+			desc += " " + strings.ReplaceAll(strings.Trim(string(b), "[]"), ",", "..")
+		}
+	}
+	return desc
+}
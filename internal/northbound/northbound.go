@@ -0,0 +1,282 @@
+package northbound
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/iot/mqtt"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// commandTimeout bounds a driver call made for an MQTT command, like
+// driverTimeout does for REST requests.
+const commandTimeout = 10 * time.Second
+
+// API mirrors the REST device API over MQTT: every device's state is kept
+// retained on <base>/<room>/<id>/state, and commands published to
+// <base>/<id>/<capability>/set are executed like
+// POST /devices/{id}/capabilities/{capability}, with the outcome published
+// to <base>/<id>/<capability>/result. Commands for one device run one at a
+// time, in the order they arrived. <base>/status is "online" while the
+// bridge is connected and "offline" otherwise, via the last will.
+type API struct {
+	client   paho.Client
+	base     string
+	reserved map[string]bool // topic levels under base that rooms must not take
+
+	mu        sync.Mutex
+	published map[string]string    // device ID -> state topic
+	pending   map[string][]command // device ID -> queued commands, while a worker runs
+}
+
+type command struct {
+	capability string
+	payload    []byte
+}
+
+var api *API
+
+var unsafeChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+func Init() {
+	if !config.MQTTAPI {
+		return
+	}
+
+	api = newAPI(strings.TrimSuffix(config.MQTTAPIBaseTopic, "/"))
+
+	opts := mqtt.NewClientOptions("iot-bridge-api", api.onConnect)
+	opts.SetWill(api.statusTopic(), "offline", 1, true)
+	api.client = mqtt.NewClientFromOptions(opts)
+
+	factory.OnDeviceEvent(api.handleEvent)
+
+	mqtt.Connect(api.client, "[MQTT API]")
+}
+
+func newAPI(base string) *API {
+	return &API{
+		base:      base,
+		reserved:  reservedLevels(base),
+		published: make(map[string]string),
+		pending:   make(map[string][]command),
+	}
+}
+
+// reservedLevels returns the topic levels directly under base that other
+// bridge topics start with: the status topic and, when HASS_BASE_TOPIC lies
+// under base, the Home Assistant publisher's tree.
+func reservedLevels(base string) map[string]bool {
+	reserved := map[string]bool{"status": true}
+	hassBase := strings.TrimSuffix(config.HassBaseTopic, "/")
+	if rest, ok := strings.CutPrefix(hassBase, base+"/"); ok {
+		level, _, _ := strings.Cut(rest, "/")
+		reserved[level] = true
+	}
+	return reserved
+}
+
+// Stop announces that the bridge is going offline; the last will only covers
+// connections that drop.
+func Stop() {
+	if api == nil || !api.client.IsConnectionOpen() {
+		return
+	}
+	api.client.Publish(api.statusTopic(), 1, true, "offline").WaitTimeout(time.Second)
+	api.client.Disconnect(250)
+}
+
+func (a *API) statusTopic() string {
+	return a.base + "/status"
+}
+
+// stateTopic builds <base>/<room>/<id>/state. Rooms are lowercased and
+// stripped of topic syntax; a room that would land in a reserved level, e.g.
+// "Hass" next to iot-bridge/hass, gets a "room_" prefix.
+func (a *API) stateTopic(device store.Device) string {
+	room := unsafeChars.ReplaceAllString(strings.ToLower(device.Room), "_")
+	if room == "" {
+		room = "unknown"
+	}
+	if a.reserved[room] {
+		room = "room_" + room
+	}
+	return fmt.Sprintf("%s/%s/%s/state", a.base, room, device.ID)
+}
+
+func (a *API) resultTopic(deviceID, capability string) string {
+	return fmt.Sprintf("%s/%s/%s/result", a.base, deviceID, capability)
+}
+
+func (a *API) onConnect(c paho.Client) {
+	log.Println("[MQTT API] Connected to MQTT")
+
+	c.Publish(a.statusTopic(), 1, true, "online")
+
+	if token := c.Subscribe(a.base+"/+/+/set", 1, a.handleCommand); token.Wait() && token.Error() != nil {
+		log.Println("[MQTT API] Failed to subscribe to commands:", token.Error())
+	}
+
+	for _, device := range factory.GetDeviceStore().GetAll() {
+		a.publishState(device)
+	}
+}
+
+func (a *API) handleEvent(event store.DeviceEvent) {
+	if !a.client.IsConnectionOpen() {
+		return // everything is republished on (re)connect
+	}
+	switch event.Kind {
+	case store.DeviceAdded, store.DeviceUpdated, store.DeviceStateChanged:
+		a.publishState(event.Device)
+	case store.DeviceRemoved:
+		a.unpublish(event.Device.ID)
+	}
+}
+
+// publishState publishes the device's state retained, and clears the topic it
+// was published on before if the device moved to another room.
+func (a *API) publishState(device store.Device) {
+	state := device.State
+	if state == nil {
+		state = store.State{}
+	}
+	payload, _ := json.Marshal(state)
+	topic := a.stateTopic(device)
+	a.client.Publish(topic, 1, true, payload)
+
+	a.mu.Lock()
+	old := a.published[device.ID]
+	a.published[device.ID] = topic
+	a.mu.Unlock()
+	if old != "" && old != topic {
+		a.client.Publish(old, 1, true, []byte{})
+	}
+}
+
+func (a *API) unpublish(deviceID string) {
+	a.mu.Lock()
+	topic := a.published[deviceID]
+	delete(a.published, deviceID)
+	a.mu.Unlock()
+	if topic != "" {
+		a.client.Publish(topic, 1, true, []byte{})
+	}
+}
+
+// handleCommand executes "<base>/<id>/<capability>/set". The payload is the
+// same JSON object the REST endpoint takes; capabilities with a single
+// parameter also accept its bare value, e.g. "on" or 42.
+func (a *API) handleCommand(_ paho.Client, msg paho.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), a.base+"/"), "/")
+	if len(parts) != 3 {
+		return
+	}
+	a.enqueue(parts[0], command{capability: parts[1], payload: msg.Payload()})
+}
+
+// enqueue queues a command for the device and starts a worker for it if none
+// is running. Driver calls can take a while, so they don't run on the
+// client's message loop; one worker per device keeps its commands in order.
+func (a *API) enqueue(deviceID string, cmd command) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	queue, running := a.pending[deviceID]
+	a.pending[deviceID] = append(queue, cmd)
+	if !running {
+		go a.work(deviceID)
+	}
+}
+
+// work executes the device's queued commands and exits once the queue is
+// empty.
+func (a *API) work(deviceID string) {
+	for {
+		a.mu.Lock()
+		queue := a.pending[deviceID]
+		if len(queue) == 0 {
+			delete(a.pending, deviceID)
+			a.mu.Unlock()
+			return
+		}
+		cmd := queue[0]
+		a.pending[deviceID] = queue[1:]
+		a.mu.Unlock()
+
+		a.execute(deviceID, cmd.capability, cmd.payload)
+	}
+}
+
+func (a *API) execute(deviceID, capability string, payload []byte) {
+	input, err := commandInput(deviceID, capability, payload)
+	if err != nil {
+		a.publishResult(deviceID, capability, map[string]interface{}{
+			"status":  "error",
+			"error":   "invalid_request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	result, err := iot.Invoke(ctx, deviceID, capability, input, config.ConfirmCommands)
+	if err != nil {
+		log.Printf("[MQTT API] %s on %s failed: %v", capability, deviceID, err)
+		body := map[string]interface{}{
+			"status":  "error",
+			"error":   iot.ErrorCode(err),
+			"message": err.Error(),
+		}
+		if len(result.State) > 0 {
+			body["state"] = result.State
+		}
+		a.publishResult(deviceID, capability, body)
+		return
+	}
+	a.publishResult(deviceID, capability, map[string]interface{}{
+		"status":     "success",
+		"capability": capability,
+		"new_state":  result.State,
+		"confirmed":  result.Confirmed,
+	})
+}
+
+func (a *API) publishResult(deviceID, capability string, body map[string]interface{}) {
+	payload, _ := json.Marshal(body)
+	a.client.Publish(a.resultTopic(deviceID, capability), 1, false, payload)
+}
+
+// commandInput decodes a command payload into capability parameters.
+func commandInput(deviceID, capability string, payload []byte) (map[string]interface{}, error) {
+	var input map[string]interface{}
+	if err := json.Unmarshal(payload, &input); err == nil {
+		return input, nil
+	}
+
+	device, ok := factory.GetDeviceStore().Get(deviceID)
+	if !ok {
+		return nil, errors.New("Device not found")
+	}
+	for _, cap := range device.Capabilities {
+		if cap.Name != capability || len(cap.Parameters) != 1 {
+			continue
+		}
+		for param := range cap.Parameters {
+			return map[string]interface{}{param: store.ParseValue(string(payload))}, nil
+		}
+	}
+	return nil, errors.New("Invalid JSON input")
+}
@@ -0,0 +1,133 @@
+package northbound
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/iot"
+	"iot-bridge/internal/iot/mqtt"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/testutil"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// slowDriver records the brightness values it is sent. The first call is the
+// slowest, so commands that don't wait for each other finish out of order.
+type slowDriver struct {
+	mu   sync.Mutex
+	sent []interface{}
+}
+
+func (d *slowDriver) Start() error       { return nil }
+func (d *slowDriver) Stop() error        { return nil }
+func (d *slowDriver) Health() iot.Health { return iot.Health{Status: iot.HealthOK} }
+func (d *slowDriver) GetState(context.Context, store.Device) (store.State, error) {
+	return store.State{}, nil
+}
+func (d *slowDriver) SetState(_ context.Context, _ store.Device, updates store.State) error {
+	d.mu.Lock()
+	delay := time.Duration(5-len(d.sent)) * 10 * time.Millisecond
+	d.mu.Unlock()
+	time.Sleep(delay)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent = append(d.sent, updates["brightness"])
+	return nil
+}
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return 1 }
+func (m *message) Retained() bool    { return false }
+func (m *message) Topic() string     { return m.topic }
+func (m *message) MessageID() uint16 { return 0 }
+func (m *message) Payload() []byte   { return m.payload }
+func (m *message) Ack()              {}
+
+func TestCommandsRunInOrder(t *testing.T) {
+	testutil.UseMemoryStore()
+
+	driver := &slowDriver{}
+	iot.Register("northboundtest", func() iot.Driver { return driver })
+	iot.StartDrivers([]string{"northboundtest"})
+	defer iot.StopDrivers()
+
+	factory.GetDeviceStore().Add(store.Device{
+		ID: "dimmer", Protocol: "northboundtest", State: store.State{},
+		Capabilities: []store.Capability{{
+			Name: "brightness", Writable: true,
+			Parameters: map[string]interface{}{
+				"brightness": map[string]interface{}{"type": "integer", "range": []int{0, 100}},
+			},
+		}},
+	})
+
+	a := newAPI("iot-bridge")
+	a.client = mqtt.NewReplayClient(paho.NewClientOptions(), nil, 0)
+	for i := 1; i <= 5; i++ {
+		a.handleCommand(a.client, &message{topic: "iot-bridge/dimmer/brightness/set", payload: []byte(fmt.Sprint(i * 10))})
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		driver.mu.Lock()
+		n := len(driver.sent)
+		driver.mu.Unlock()
+		if n == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 5 commands executed", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := []interface{}{10, 20, 30, 40, 50}
+	if !reflect.DeepEqual(driver.sent, want) {
+		t.Errorf("driver got %v, want %v", driver.sent, want)
+	}
+	device, _ := factory.GetDeviceStore().Get("dimmer")
+	if device.State["brightness"] != 50 {
+		t.Errorf("stored brightness = %v, want the last command's 50", device.State["brightness"])
+	}
+}
+
+func TestStateTopic(t *testing.T) {
+	hassBase := config.HassBaseTopic
+	config.HassBaseTopic = "iot-bridge/hass"
+	defer func() { config.HassBaseTopic = hassBase }()
+	a := newAPI("iot-bridge")
+
+	tests := []struct {
+		room string
+		want string
+	}{
+		{room: "Living Room", want: "iot-bridge/living_room/lamp/state"},
+		{room: "", want: "iot-bridge/unknown/lamp/state"},
+		{room: "a/b+#", want: "iot-bridge/a_b__/lamp/state"},
+		{room: "Hass", want: "iot-bridge/room_hass/lamp/state"},
+		{room: "status", want: "iot-bridge/room_status/lamp/state"},
+	}
+	for _, tt := range tests {
+		if got := a.stateTopic(store.Device{ID: "lamp", Room: tt.room}); got != tt.want {
+			t.Errorf("stateTopic(room %q) = %q, want %q", tt.room, got, tt.want)
+		}
+	}
+
+	config.HassBaseTopic = "homeassistant/bridge"
+	if got := newAPI("iot-bridge").stateTopic(store.Device{ID: "lamp", Room: "hass"}); got != "iot-bridge/hass/lamp/state" {
+		t.Errorf("stateTopic() = %q, want hass unreserved outside the API tree", got)
+	}
+}
@@ -1,19 +1,59 @@
 package store
 
+import (
+	"errors"
+	"time"
+)
+
 type Device struct {
-	ID           string            `json:"id"`
-	Name         string            `json:"name"`
-	Type         string            `json:"type"`
-	Protocol     string            `json:"protocol"`
-	Room         string            `json:"room"`
-	State        map[string]string `json:"state"`
-	Capabilities []Capability      `json:"capabilities"`
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	Protocol     string       `json:"protocol"`
+	Room         string       `json:"room"`
+	Manufacturer string       `json:"manufacturer,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	State        State        `json:"state"`
+	Capabilities []Capability `json:"capabilities"`
+	DeviceStatus
+}
+
+// Availability values reported by drivers that track connectivity.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// DeviceStatus is connectivity information reported by a device's driver.
+// Zero fields mean the driver doesn't know or doesn't track them.
+type DeviceStatus struct {
+	Availability string     `json:"availability,omitempty"`
+	LastSeen     *time.Time `json:"last_seen,omitempty"`
+	LinkQuality  *int       `json:"link_quality,omitempty"`
 }
 
+// Merge copies the fields set in update into s.
+func (s *DeviceStatus) Merge(update DeviceStatus) {
+	if update.Availability != "" {
+		s.Availability = update.Availability
+	}
+	if update.LastSeen != nil {
+		s.LastSeen = update.LastSeen
+	}
+	if update.LinkQuality != nil {
+		s.LinkQuality = update.LinkQuality
+	}
+}
+
+// ErrDeviceNotFound is returned by UpdateState and UpdateStatus for IDs that
+// aren't stored.
+var ErrDeviceNotFound = errors.New("device not found")
+
 type DeviceStore interface {
 	Add(device Device) error
 	GetAll() []Device
 	Get(id string) (Device, bool)
-	UpdateState(id string, updates map[string]string) error
+	UpdateState(id string, updates State) error
+	UpdateStatus(id string, status DeviceStatus) error
 	Delete(id string) error
 }
@@ -2,11 +2,13 @@ package store
 
 func (d DiscoveredDevice) ToDevice(name, room string) Device {
 	return Device{
-		ID:       d.ID,
-		Name:     name,
-		Room:     room,
-		Type:     d.Type,
-		Protocol: d.Protocol,
-		State:    map[string]string{},
+		ID:           d.ID,
+		Name:         name,
+		Room:         room,
+		Type:         d.Type,
+		Protocol:     d.Protocol,
+		Manufacturer: d.Manufacturer,
+		Model:        d.Model,
+		State:        State{},
 	}
 }
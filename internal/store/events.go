@@ -0,0 +1,92 @@
+package store
+
+import "sync"
+
+type EventKind string
+
+const (
+	DeviceAdded         EventKind = "added"
+	DeviceUpdated       EventKind = "updated"
+	DeviceStateChanged  EventKind = "state_changed"
+	DeviceStatusChanged EventKind = "status_changed"
+	DeviceRemoved       EventKind = "removed"
+)
+
+// DeviceEvent describes a change made through a NotifyingStore. For state
+// changes, Changes holds only the keys that were written.
+type DeviceEvent struct {
+	Kind    EventKind
+	Device  Device
+	Changes State
+}
+
+// NotifyingStore wraps a DeviceStore and tells subscribers about every change.
+// Listeners run synchronously on the writer's goroutine and must not block.
+type NotifyingStore struct {
+	DeviceStore
+	mu        sync.RWMutex
+	listeners []func(DeviceEvent)
+}
+
+func NewNotifyingStore(inner DeviceStore) *NotifyingStore {
+	return &NotifyingStore{DeviceStore: inner}
+}
+
+func (s *NotifyingStore) Subscribe(fn func(DeviceEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *NotifyingStore) Add(device Device) error {
+	_, existed := s.DeviceStore.Get(device.ID)
+	if err := s.DeviceStore.Add(device); err != nil {
+		return err
+	}
+	kind := DeviceAdded
+	if existed {
+		kind = DeviceUpdated
+	}
+	s.notify(DeviceEvent{Kind: kind, Device: device})
+	return nil
+}
+
+func (s *NotifyingStore) UpdateState(id string, updates State) error {
+	if err := s.DeviceStore.UpdateState(id, updates); err != nil {
+		return err
+	}
+	if device, ok := s.DeviceStore.Get(id); ok {
+		s.notify(DeviceEvent{Kind: DeviceStateChanged, Device: device, Changes: updates})
+	}
+	return nil
+}
+
+func (s *NotifyingStore) UpdateStatus(id string, status DeviceStatus) error {
+	if err := s.DeviceStore.UpdateStatus(id, status); err != nil {
+		return err
+	}
+	if device, ok := s.DeviceStore.Get(id); ok {
+		s.notify(DeviceEvent{Kind: DeviceStatusChanged, Device: device})
+	}
+	return nil
+}
+
+func (s *NotifyingStore) Delete(id string) error {
+	device, existed := s.DeviceStore.Get(id)
+	if err := s.DeviceStore.Delete(id); err != nil {
+		return err
+	}
+	if existed {
+		s.notify(DeviceEvent{Kind: DeviceRemoved, Device: device})
+	}
+	return nil
+}
+
+func (s *NotifyingStore) notify(event DeviceEvent) {
+	s.mu.RLock()
+	listeners := append([]func(DeviceEvent){}, s.listeners...)
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
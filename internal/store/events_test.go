@@ -0,0 +1,37 @@
+package store_test
+
+import (
+	"reflect"
+	"testing"
+
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/inmemory"
+)
+
+func TestNotifyingStoreEvents(t *testing.T) {
+	s := store.NewNotifyingStore(inmemory.New())
+	var kinds []store.EventKind
+	var last store.DeviceEvent
+	s.Subscribe(func(ev store.DeviceEvent) {
+		kinds = append(kinds, ev.Kind)
+		last = ev
+	})
+
+	s.Add(store.Device{ID: "lamp", Name: "Lamp"})
+	s.Add(store.Device{ID: "lamp", Name: "Desk lamp"})
+	s.UpdateState("lamp", store.State{"state": "on"})
+	if !reflect.DeepEqual(last.Changes, store.State{"state": "on"}) {
+		t.Errorf("state event changes = %v", last.Changes)
+	}
+	s.UpdateStatus("lamp", store.DeviceStatus{Availability: store.Offline})
+	if last.Device.Availability != store.Offline {
+		t.Errorf("status event device availability = %q, want offline", last.Device.Availability)
+	}
+	s.Delete("lamp")
+	s.Delete("lamp")
+
+	want := []store.EventKind{store.DeviceAdded, store.DeviceUpdated, store.DeviceStateChanged, store.DeviceStatusChanged, store.DeviceRemoved}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+}
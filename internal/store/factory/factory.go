@@ -7,24 +7,35 @@ import (
 	"iot-bridge/internal/store/sqlite"
 )
 
-var activeStore store.DeviceStore
+var activeStore *store.NotifyingStore
 var scanStore store.ScanStore
 
 func Init() {
 	if config.DemoMode {
-		activeStore = inmemory.New()
-		scanStore = inmemory.NewScanStore()
-	} else {
-		sqlStore := sqlite.New()
-		activeStore = sqlStore
-		scanStore = sqlite.NewScanStore(sqlStore.(*sqlite.SQLiteStore).DB())
+		UseStore(inmemory.New())
+		return
 	}
+	sqlStore := sqlite.New()
+	activeStore = store.NewNotifyingStore(sqlStore)
+	scanStore = sqlite.NewScanStore(sqlStore.(*sqlite.SQLiteStore).DB())
+}
+
+// UseStore replaces the device store Init would open, e.g. with
+// inmemory.New() in tests. Scan results are kept in memory.
+func UseStore(ds store.DeviceStore) {
+	activeStore = store.NewNotifyingStore(ds)
+	scanStore = inmemory.NewScanStore()
 }
 
 func GetDeviceStore() store.DeviceStore {
 	return activeStore
 }
 
+// OnDeviceEvent registers fn to be called after every change to the device store.
+func OnDeviceEvent(fn func(store.DeviceEvent)) {
+	activeStore.Subscribe(fn)
+}
+
 func GetScanStore() store.ScanStore {
 	return scanStore
 }
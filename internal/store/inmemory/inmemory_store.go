@@ -40,16 +40,34 @@ func (s *InMemoryStore) Get(id string) (store.Device, bool) {
 	return d, ok
 }
 
-func (s *InMemoryStore) UpdateState(id string, updates map[string]string) error {
+func (s *InMemoryStore) UpdateState(id string, updates store.State) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	d, ok := s.devices[id]
 	if !ok {
-		return nil
+		return store.ErrDeviceNotFound
+	}
+	// Build a new map: devices returned by Get share the old one.
+	state := make(store.State, len(d.State)+len(updates))
+	for k, v := range d.State {
+		state[k] = v
 	}
 	for k, v := range updates {
-		d.State[k] = v
+		state[k] = v
+	}
+	d.State = state
+	s.devices[id] = d
+	return nil
+}
+
+func (s *InMemoryStore) UpdateStatus(id string, status store.DeviceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[id]
+	if !ok {
+		return store.ErrDeviceNotFound
 	}
+	d.DeviceStatus.Merge(status)
 	s.devices[id] = d
 	return nil
 }
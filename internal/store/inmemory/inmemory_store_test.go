@@ -0,0 +1,18 @@
+package inmemory
+
+import (
+	"errors"
+	"testing"
+
+	"iot-bridge/internal/store"
+)
+
+func TestUpdateUnknownDevice(t *testing.T) {
+	s := New()
+	if err := s.UpdateState("missing", store.State{"a": 1}); !errors.Is(err, store.ErrDeviceNotFound) {
+		t.Errorf("UpdateState of an unknown device: err = %v, want ErrDeviceNotFound", err)
+	}
+	if err := s.UpdateStatus("missing", store.DeviceStatus{Availability: store.Online}); !errors.Is(err, store.ErrDeviceNotFound) {
+		t.Errorf("UpdateStatus of an unknown device: err = %v, want ErrDeviceNotFound", err)
+	}
+}
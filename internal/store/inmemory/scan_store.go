@@ -1,8 +1,13 @@
 package inmemory
 
-import "iot-bridge/internal/store"
+import (
+	"sync"
+
+	"iot-bridge/internal/store"
+)
 
 type InMemoryScanStore struct {
+	mu         sync.RWMutex
 	discovered []store.DiscoveredDevice
 }
 
@@ -11,17 +16,32 @@ func NewScanStore() store.ScanStore {
 }
 
 func (s *InMemoryScanStore) StartScan(protocols []string) {
-	s.discovered = []store.DiscoveredDevice{
-		{ID: "bulb1", Name: "Unregistered Bulb", Type: "bulb", Protocol: "zigbee", Signal: -42},
-		{ID: "plug1", Name: "New Plug", Type: "smart_plug", Protocol: "zwave", Signal: -55},
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discovered = nil
+}
+
+func (s *InMemoryScanStore) AddDiscoveredDevice(device store.DiscoveredDevice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, d := range s.discovered {
+		if d.ID == device.ID {
+			s.discovered[i] = device
+			return
+		}
 	}
+	s.discovered = append(s.discovered, device)
 }
 
 func (s *InMemoryScanStore) GetScanResults() []store.DiscoveredDevice {
-	return s.discovered
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]store.DiscoveredDevice{}, s.discovered...)
 }
 
 func (s *InMemoryScanStore) FindDiscoveredDevice(id string) (store.DiscoveredDevice, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	for _, d := range s.discovered {
 		if d.ID == id {
 			return d, true
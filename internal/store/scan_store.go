@@ -1,44 +1,28 @@
 package store
 
-import "log"
-
 type DiscoveredDevice struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Type     string `json:"type"`
-	Protocol string `json:"protocol"`
-	Signal   int    `json:"signal_strength"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Protocol    string `json:"protocol"`
+	Signal      int    `json:"signal_strength"`
+	LinkQuality int    `json:"link_quality,omitempty"`
+	// Network scans report where the device answered and what it announced,
+	// e.g. its mDNS TXT records.
+	Host         string            `json:"host,omitempty"`
+	Port         int               `json:"port,omitempty"`
+	Manufacturer string            `json:"manufacturer,omitempty"`
+	Model        string            `json:"model,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
 }
 
+// ScanStore holds the results of the current scan. Drivers add devices as
+// they are found while the scan window is open.
 type ScanStore interface {
+	// StartScan discards the results of any previous scan.
 	StartScan(protocols []string)
+	// AddDiscoveredDevice adds a device, replacing any entry with the same ID.
+	AddDiscoveredDevice(device DiscoveredDevice)
 	GetScanResults() []DiscoveredDevice
 	FindDiscoveredDevice(id string) (DiscoveredDevice, bool)
 }
-
-var discoveredDevices []DiscoveredDevice
-
-func StartScan(protocols []string) {
-	log.Println("⚙️  StartScan called. Simulating discovery...")
-
-	discoveredDevices = []DiscoveredDevice{
-		{ID: "bulb1", Name: "Unregistered Bulb", Type: "bulb", Protocol: "zigbee", Signal: -42},
-		{ID: "plug1", Name: "New Plug", Type: "smart_plug", Protocol: "zwave", Signal: -55},
-	}
-}
-
-func GetScanResults() []DiscoveredDevice {
-	if discoveredDevices == nil {
-		return []DiscoveredDevice{}
-	}
-	return discoveredDevices
-}
-
-func FindDiscoveredDevice(id string) (DiscoveredDevice, bool) {
-	for _, d := range discoveredDevices {
-		if d.ID == id {
-			return d, true
-		}
-	}
-	return DiscoveredDevice{}, false
-}
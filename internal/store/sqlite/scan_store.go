@@ -2,6 +2,7 @@ package sqlite
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"iot-bridge/internal/store"
 )
@@ -21,18 +22,34 @@ func NewScanStore(db *sql.DB) store.ScanStore {
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create discovered_devices: %v", err))
 	}
+	if err := addColumns(db, "discovered_devices",
+		"link_quality INT NOT NULL DEFAULT 0",
+		"host TEXT NOT NULL DEFAULT ''",
+		"port INT NOT NULL DEFAULT 0",
+		"manufacturer TEXT NOT NULL DEFAULT ''",
+		"model TEXT NOT NULL DEFAULT ''",
+		"metadata TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		panic(fmt.Sprintf("Failed to migrate discovered_devices: %v", err))
+	}
 	return &SQLiteScanStore{db: db}
 }
 
 func (s *SQLiteScanStore) StartScan(protocols []string) {
 	s.db.Exec("DELETE FROM discovered_devices")
-	stmt, _ := s.db.Prepare(`INSERT INTO discovered_devices (id, name, type, protocol, signal) VALUES (?, ?, ?, ?, ?)`)
-	stmt.Exec("bulb1", "Unregistered Bulb", "bulb", "zigbee", -42)
-	stmt.Exec("plug1", "New Plug", "smart_plug", "zwave", -55)
+}
+
+func (s *SQLiteScanStore) AddDiscoveredDevice(d store.DiscoveredDevice) {
+	var metadata []byte
+	if len(d.Metadata) > 0 {
+		metadata, _ = json.Marshal(d.Metadata)
+	}
+	s.db.Exec(`INSERT OR REPLACE INTO discovered_devices (id, name, type, protocol, signal, link_quality, host, port, manufacturer, model, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.Name, d.Type, d.Protocol, d.Signal, d.LinkQuality, d.Host, d.Port, d.Manufacturer, d.Model, string(metadata))
 }
 
 func (s *SQLiteScanStore) GetScanResults() []store.DiscoveredDevice {
-	rows, err := s.db.Query(`SELECT id, name, type, protocol, signal FROM discovered_devices`)
+	rows, err := s.db.Query(`SELECT ` + discoveredColumns + ` FROM discovered_devices`)
 	if err != nil {
 		return nil
 	}
@@ -40,19 +57,33 @@ func (s *SQLiteScanStore) GetScanResults() []store.DiscoveredDevice {
 
 	var devices []store.DiscoveredDevice
 	for rows.Next() {
-		var d store.DiscoveredDevice
-		rows.Scan(&d.ID, &d.Name, &d.Type, &d.Protocol, &d.Signal)
-		devices = append(devices, d)
+		if d, err := scanDiscovered(rows); err == nil {
+			devices = append(devices, d)
+		}
 	}
 	return devices
 }
 
 func (s *SQLiteScanStore) FindDiscoveredDevice(id string) (store.DiscoveredDevice, bool) {
-	row := s.db.QueryRow(`SELECT id, name, type, protocol, signal FROM discovered_devices WHERE id = ?`, id)
-	var d store.DiscoveredDevice
-	err := row.Scan(&d.ID, &d.Name, &d.Type, &d.Protocol, &d.Signal)
+	row := s.db.QueryRow(`SELECT `+discoveredColumns+` FROM discovered_devices WHERE id = ?`, id)
+	d, err := scanDiscovered(row)
 	if err != nil {
 		return store.DiscoveredDevice{}, false
 	}
 	return d, true
 }
+
+const discoveredColumns = `id, name, type, protocol, signal, link_quality, host, port, manufacturer, model, metadata`
+
+func scanDiscovered(row interface{ Scan(...any) error }) (store.DiscoveredDevice, error) {
+	var d store.DiscoveredDevice
+	var metadata string
+	err := row.Scan(&d.ID, &d.Name, &d.Type, &d.Protocol, &d.Signal, &d.LinkQuality, &d.Host, &d.Port, &d.Manufacturer, &d.Model, &metadata)
+	if err != nil {
+		return d, err
+	}
+	if metadata != "" {
+		json.Unmarshal([]byte(metadata), &d.Metadata)
+	}
+	return d, nil
+}
@@ -3,17 +3,22 @@ package sqlite
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"iot-bridge/internal/store"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
 type SQLiteStore struct {
 	db *sql.DB
+	// mu serializes writes so that the read-merge-write of UpdateState and
+	// UpdateStatus can't lose a concurrent update.
+	mu sync.Mutex
 }
 
 func New() store.DeviceStore {
@@ -41,24 +46,148 @@ func New() store.DeviceStore {
 	if _, err := db.Exec(createTable); err != nil {
 		panic(fmt.Sprintf("Failed to initialize schema: %v", err))
 	}
+	if err := addColumns(db, "devices",
+		"manufacturer TEXT NOT NULL DEFAULT ''",
+		"model TEXT NOT NULL DEFAULT ''",
+		"availability TEXT NOT NULL DEFAULT ''",
+		"last_seen TEXT NOT NULL DEFAULT ''",
+		"link_quality INT",
+	); err != nil {
+		panic(fmt.Sprintf("Failed to migrate schema: %v", err))
+	}
+	if err := migrate(db); err != nil {
+		panic(fmt.Sprintf("Failed to migrate data: %v", err))
+	}
 
 	return &SQLiteStore{db: db}
 }
 
+// addColumns adds columns introduced after the original schema to an
+// existing table. Each definition is "<name> <type and constraints>".
+func addColumns(db *sql.DB, table string, definitions ...string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err == nil {
+			existing[name] = true
+		}
+	}
+	rows.Close()
+
+	for _, def := range definitions {
+		name := strings.Fields(def)[0]
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, def)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrations upgrade stored data; migrations[i] brings the database from
+// user_version i to i+1.
+var migrations = []func(tx *sql.Tx) error{
+	typeStateValues,
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeStateValues converts state stored as strings ("21.5", "true",
+// "[255,0,0]") into typed JSON values.
+func typeStateValues(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, state FROM devices`)
+	if err != nil {
+		return err
+	}
+	converted := map[string]string{}
+	for rows.Next() {
+		var id, stateJSON string
+		if err := rows.Scan(&id, &stateJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		var state store.State
+		if json.Unmarshal([]byte(stateJSON), &state) != nil || state == nil {
+			continue
+		}
+		for k, v := range state {
+			if s, ok := v.(string); ok {
+				state[k] = store.ParseValue(s)
+			}
+		}
+		data, _ := json.Marshal(state)
+		converted[id] = string(data)
+	}
+	rows.Close()
+
+	for id, stateJSON := range converted {
+		if _, err := tx.Exec(`UPDATE devices SET state = ? WHERE id = ?`, stateJSON, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *SQLiteStore) Add(device store.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(device)
+}
+
+func (s *SQLiteStore) add(device store.Device) error {
 	stateJSON, _ := json.Marshal(device.State)
 	capsJSON, _ := json.Marshal(device.Capabilities)
+	var lastSeen string
+	if device.LastSeen != nil {
+		lastSeen = device.LastSeen.UTC().Format(time.RFC3339Nano)
+	}
+	var linkQuality sql.NullInt64
+	if device.LinkQuality != nil {
+		linkQuality = sql.NullInt64{Int64: int64(*device.LinkQuality), Valid: true}
+	}
 
 	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO devices (id, name, type, protocol, room, state, capabilities)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		device.ID, device.Name, device.Type, device.Protocol, device.Room, string(stateJSON), string(capsJSON),
+		INSERT OR REPLACE INTO devices (id, name, type, protocol, room, manufacturer, model, state, capabilities, availability, last_seen, link_quality)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		device.ID, device.Name, device.Type, device.Protocol, device.Room, device.Manufacturer, device.Model, string(stateJSON), string(capsJSON),
+		device.Availability, lastSeen, linkQuality,
 	)
 	return err
 }
 
 func (s *SQLiteStore) GetAll() []store.Device {
-	rows, err := s.db.Query(`SELECT id, name, type, protocol, room, state, capabilities FROM devices`)
+	rows, err := s.db.Query(`SELECT id, name, type, protocol, room, manufacturer, model, state, capabilities, availability, last_seen, link_quality FROM devices`)
 	if err != nil {
 		return []store.Device{}
 	}
@@ -66,11 +195,7 @@ func (s *SQLiteStore) GetAll() []store.Device {
 
 	var devices []store.Device
 	for rows.Next() {
-		var d store.Device
-		var stateJSON, capsJSON string
-		if err := rows.Scan(&d.ID, &d.Name, &d.Type, &d.Protocol, &d.Room, &stateJSON, &capsJSON); err == nil {
-			json.Unmarshal([]byte(stateJSON), &d.State)
-			json.Unmarshal([]byte(capsJSON), &d.Capabilities)
+		if d, err := scanDevice(rows); err == nil {
 			devices = append(devices, d)
 		}
 	}
@@ -78,29 +203,63 @@ func (s *SQLiteStore) GetAll() []store.Device {
 }
 
 func (s *SQLiteStore) Get(id string) (store.Device, bool) {
-	row := s.db.QueryRow(`SELECT id, name, type, protocol, room, state, capabilities FROM devices WHERE id = ?`, id)
+	row := s.db.QueryRow(`SELECT id, name, type, protocol, room, manufacturer, model, state, capabilities, availability, last_seen, link_quality FROM devices WHERE id = ?`, id)
 
-	var d store.Device
-	var stateJSON, capsJSON string
-	err := row.Scan(&d.ID, &d.Name, &d.Type, &d.Protocol, &d.Room, &stateJSON, &capsJSON)
+	d, err := scanDevice(row)
 	if err != nil {
 		return store.Device{}, false
 	}
+	return d, true
+}
+
+// scanDevice reads one row selected with the full devices column list.
+func scanDevice(row interface{ Scan(...any) error }) (store.Device, error) {
+	var d store.Device
+	var stateJSON, capsJSON, lastSeen string
+	var linkQuality sql.NullInt64
+	err := row.Scan(&d.ID, &d.Name, &d.Type, &d.Protocol, &d.Room, &d.Manufacturer, &d.Model, &stateJSON, &capsJSON,
+		&d.Availability, &lastSeen, &linkQuality)
+	if err != nil {
+		return store.Device{}, err
+	}
 	json.Unmarshal([]byte(stateJSON), &d.State)
 	json.Unmarshal([]byte(capsJSON), &d.Capabilities)
-	return d, true
+	if t, err := time.Parse(time.RFC3339Nano, lastSeen); err == nil {
+		d.LastSeen = &t
+	}
+	if linkQuality.Valid {
+		lq := int(linkQuality.Int64)
+		d.LinkQuality = &lq
+	}
+	return d, nil
 }
 
-func (s *SQLiteStore) UpdateState(id string, updates map[string]string) error {
+func (s *SQLiteStore) UpdateState(id string, updates store.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	device, found := s.Get(id)
 	if !found {
-		return errors.New("device not found")
+		return store.ErrDeviceNotFound
+	}
+	if device.State == nil {
+		device.State = store.State{}
 	}
 
 	for k, v := range updates {
 		device.State[k] = v
 	}
-	return s.Add(device)
+	return s.add(device)
+}
+
+func (s *SQLiteStore) UpdateStatus(id string, status store.DeviceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, found := s.Get(id)
+	if !found {
+		return store.ErrDeviceNotFound
+	}
+	device.DeviceStatus.Merge(status)
+	return s.add(device)
 }
 
 func (s *SQLiteStore) DB() *sql.DB {
@@ -108,6 +267,8 @@ func (s *SQLiteStore) DB() *sql.DB {
 }
 
 func (s *SQLiteStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	_, err := s.db.Exec(`DELETE FROM devices WHERE id = ?`, id)
 	return err
 }
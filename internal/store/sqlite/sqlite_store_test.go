@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"iot-bridge/internal/store"
+)
+
+// inTempDir changes to a temporary directory for the test, as New always
+// uses devices.db in the working directory.
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// newTestStore opens a store in a temporary directory.
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	inTempDir(t)
+	s := New().(*SQLiteStore)
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Add(store.Device{ID: "sensor", State: store.State{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 50
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if err := s.UpdateState("sensor", store.State{fmt.Sprintf("key_%d", i): float64(i)}); err != nil {
+				t.Error(err)
+			}
+			if i%2 == 0 {
+				lq := i
+				if err := s.UpdateStatus("sensor", store.DeviceStatus{LinkQuality: &lq}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	device, _ := s.Get("sensor")
+	if len(device.State) != writers {
+		t.Errorf("state has %d keys after %d concurrent updates: %v", len(device.State), writers, device.State)
+	}
+	if device.LinkQuality == nil {
+		t.Error("link quality lost")
+	}
+}
+
+func TestUpdateUnknownDevice(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.UpdateState("missing", store.State{"a": 1}); !errors.Is(err, store.ErrDeviceNotFound) {
+		t.Errorf("UpdateState of an unknown device: err = %v, want ErrDeviceNotFound", err)
+	}
+	if err := s.UpdateStatus("missing", store.DeviceStatus{Availability: store.Online}); !errors.Is(err, store.ErrDeviceNotFound) {
+		t.Errorf("UpdateStatus of an unknown device: err = %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestMigrateTypesStringState(t *testing.T) {
+	inTempDir(t)
+	// A database as written before typed state: the original columns,
+	// user_version 0 and every state value a string.
+	db, err := sql.Open("sqlite", "devices.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE devices (id TEXT PRIMARY KEY, name TEXT, type TEXT, protocol TEXT, room TEXT, state TEXT, capabilities TEXT);
+		INSERT INTO devices VALUES ('thermostat', 'Thermostat', 'thermostat', 'sim', 'Hall',
+			'{"temperature": "21.5", "heating": "true", "rgb": "[255,0,0]", "mode": "eco", "setpoint": 20}', '[]');
+		INSERT INTO devices VALUES ('broken', 'Broken', 'sensor', 'sim', 'Hall', 'not json', '[]');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New().(*SQLiteStore)
+	defer s.db.Close()
+
+	device, ok := s.Get("thermostat")
+	if !ok {
+		t.Fatal("device lost in migration")
+	}
+	want := store.State{
+		"temperature": 21.5,
+		"heating":     true,
+		"rgb":         []interface{}{float64(255), float64(0), float64(0)},
+		"mode":        "eco",
+		"setpoint":    float64(20),
+	}
+	if !reflect.DeepEqual(device.State, want) {
+		t.Errorf("state = %#v, want %#v", device.State, want)
+	}
+	if _, ok := s.Get("broken"); !ok {
+		t.Error("device with unreadable state lost in migration")
+	}
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("user_version = %d, want 1", version)
+	}
+}
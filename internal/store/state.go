@@ -0,0 +1,50 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// State holds a device's state values keyed by state key. Values keep their
+// JSON types: float64 (or int) numbers, bools, strings, []interface{} arrays
+// and map[string]interface{} objects.
+type State map[string]interface{}
+
+// ParseValue turns a textual value into its typed form: JSON numbers, bools,
+// arrays and objects are decoded, anything else stays a string. It is used
+// for state written as strings, e.g. before state was typed or when it comes
+// from a plain MQTT payload.
+func ParseValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	switch v.(type) {
+	case float64, bool, []interface{}, map[string]interface{}:
+		return v
+	}
+	return s
+}
+
+// FormatValue renders a state value as text for places that need one, such
+// as MQTT payloads. Strings are returned as they are, whole numbers without a
+// decimal point and composite values as JSON.
+func FormatValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case nil:
+		return ""
+	}
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprintf("%v", v)
+}
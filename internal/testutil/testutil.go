@@ -0,0 +1,93 @@
+// Package testutil holds fixtures shared by the driver tests.
+package testutil
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"iot-bridge/internal/broker"
+	"iot-bridge/internal/config"
+	"iot-bridge/internal/store"
+	"iot-bridge/internal/store/factory"
+	"iot-bridge/internal/store/inmemory"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Timeout is how long WaitFor waits by default.
+const Timeout = 2 * time.Second
+
+// UseMemoryStore points the store factory at a fresh in-memory store and
+// returns it.
+func UseMemoryStore() store.DeviceStore {
+	factory.UseStore(inmemory.New())
+	return factory.GetDeviceStore()
+}
+
+// StoredState returns the stored value of key for a device, or nil.
+func StoredState(deviceID, key string) interface{} {
+	device, _ := factory.GetDeviceStore().Get(deviceID)
+	return device.State[key]
+}
+
+// WaitFor polls cond until it holds, failing the test after Timeout.
+func WaitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	WaitForWithin(t, Timeout, what, cond)
+}
+
+// WaitForWithin is WaitFor with a custom timeout, for drivers that retry on
+// a longer interval.
+func WaitForWithin(t testing.TB, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// StartBroker runs the embedded MQTT broker on a free local port for the
+// rest of the test and points MQTT_BROKER at it. It returns the broker URL.
+func StartBroker(t testing.TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	savedBroker := config.MQTTBroker
+	config.MQTTEmbeddedBroker = true
+	config.MQTTBrokerListen = addr
+	config.MQTTBroker = "tcp://" + addr
+	t.Cleanup(func() {
+		broker.Stop()
+		config.MQTTEmbeddedBroker = false
+		config.MQTTBrokerListen = ""
+		config.MQTTBroker = savedBroker
+	})
+	if err := broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return config.MQTTBroker
+}
+
+// MQTTClient connects a client to MQTT_BROKER for the test, e.g. to play
+// the device side against a driver.
+func MQTTClient(t testing.TB, clientID string) paho.Client {
+	t.Helper()
+	opts := paho.NewClientOptions().AddBroker(config.MQTTBroker).SetClientID(clientID)
+	opts.SetConnectTimeout(time.Second)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(Timeout) || token.Error() != nil {
+		t.Fatalf("connecting %s to %s: %v", clientID, config.MQTTBroker, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client
+}
@@ -2,26 +2,64 @@ package main
 
 import (
 	"iot-bridge/internal/api"
+	"iot-bridge/internal/broker"
 	"iot-bridge/internal/config"
+	"iot-bridge/internal/hass"
 	"iot-bridge/internal/iot"
-	"iot-bridge/internal/iot/zigbee"
+	_ "iot-bridge/internal/iot/esphome"
+	_ "iot-bridge/internal/iot/hue"
+	_ "iot-bridge/internal/iot/matter"
+	_ "iot-bridge/internal/iot/modbus"
+	"iot-bridge/internal/iot/mqtt"
+	_ "iot-bridge/internal/iot/sim"
+	_ "iot-bridge/internal/iot/wifi"
+	_ "iot-bridge/internal/iot/zigbee"
+	_ "iot-bridge/internal/iot/zwave"
 	llmfactory "iot-bridge/internal/llm"
+	"iot-bridge/internal/northbound"
 	"iot-bridge/internal/store/factory"
 
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	config.LoadSettings()
 	factory.Init()
-	zigbee.Init()
-	iot.Init()
+	if err := broker.Start(); err != nil {
+		log.Printf("[Broker] Failed to start embedded broker: %v", err)
+	}
+	iot.StartDrivers(config.Drivers)
+	hass.Init()
+	northbound.Init()
 	llmfactory.Init()
 	router := api.NewRouter()
+	server := &http.Server{Addr: ":8080", Handler: router}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
 	log.Println("Server started on :8080")
-	err := http.ListenAndServe(":8080", router)
-	if err != nil {
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
+	northbound.Stop()
+	iot.StopDrivers()
+	if err := mqtt.CloseRecorder(); err != nil {
+		log.Printf("[MQTT] Failed to close recording: %v", err)
+	}
+	broker.Stop()
 }